@@ -0,0 +1,218 @@
+// Package client talks to a go-trailer site's HTTP API, so Go programs can
+// create pages, add and vote on videos and search without building requests
+// by hand.
+//
+//	c := client.New("https://wiki.example.com")
+//	c.Username, c.Password = "bot", os.Getenv("WIKI_PASSWORD")
+//	page, err := c.CreatePage(ctx, "Cat Videos")
+//	if err != nil { ... }
+//	err = c.AddVideo(ctx, page.Slug, "https://www.youtube.com/watch?v=...")
+//
+// Requests that fail in a way worth trying again (the site is read-only for
+// a backup, overloaded, or unreachable) are retried a few times, backing
+// off in between. Creating pages and adding videos send an Idempotency-Key,
+// so a retry of one that did get through isn't done twice. Errors from the site are *Error, which errors.Is matches
+// against ErrNotFound, ErrUnauthorized and the rest; its Code and Fields say
+// more where the site does.
+package client
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client is a go-trailer site. Set its fields before making requests with it.
+type Client struct {
+	BaseURL    string       // The site's URL, base path and all, e.g. https://example.com/wiki
+	HTTPClient *http.Client // http.DefaultClient if nil
+
+	// Username and Password log in with HTTP basic auth, for sites with an
+	// auth plugin. Admin requests take the admin password, with any username.
+	Username, Password string
+
+	UserAgent    string        // "go-trailer-client" if empty
+	MaxRetries   int           // Tries after the first, for errors worth trying again
+	RetryBackoff time.Duration // Wait before the first retry, doubling each time after
+	MaxRetryWait time.Duration // Longest to wait for a retry, giving up if the site asks for longer
+}
+
+// New is a client for the site at baseURL, retrying 3 times starting at
+// half a second apart.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		MaxRetries:   3,
+		RetryBackoff: 500 * time.Millisecond,
+		MaxRetryWait: 30 * time.Second,
+	}
+}
+
+// Page is a page and its videos, as the site shows them.
+type Page struct {
+	Slug        string    `json:"slug"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Body        string    `json:"body"` // As it was written
+	HTML        string    `json:"html"` // As the page shows it
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags"`
+	Author      string    `json:"author,omitempty"`
+	Date        time.Time `json:"date,omitzero"`
+	Draft       bool      `json:"draft,omitempty"`
+	Archived    bool      `json:"archived,omitempty"`
+	Created     time.Time `json:"created,omitzero"`
+	Updated     time.Time `json:"updated,omitzero"`
+	UpdatedBy   string    `json:"updated_by,omitempty"`
+	Videos      []Video   `json:"videos"` // Most voted first
+}
+
+// Video is one of a page's videos.
+type Video struct {
+	ID       string `json:"id"`
+	WatchURL string `json:"watch_url"`
+	EmbedURL string `json:"embed_url"`
+	Votes    int    `json:"votes"`
+}
+
+// SearchResult is a page a search found.
+type SearchResult struct {
+	Slug    string `json:"slug"`
+	Title   string `json:"title"`
+	Snippet string `json:"snippet"`
+}
+
+// CreatePage creates a page called name, or returns the page of that name
+// if there already is one.
+func (c *Client) CreatePage(ctx context.Context, name string) (*Page, error) {
+	var page Page
+	// An existing page is a redirect to it, which we follow
+	if err := c.do(ctx, http.MethodPost, "/api/v1/pages", map[string]string{"name": name}, &page, true); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// GetPage returns the page at slug. Pages that were renamed are found by
+// their old slug too.
+func (c *Client) GetPage(ctx context.Context, slug string) (*Page, error) {
+	var page Page
+	if err := c.do(ctx, http.MethodGet, "/api/v1/pages/"+url.PathEscape(slug), nil, &page, false); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// AddVideo adds a YouTube video to a page by its link.
+func (c *Client) AddVideo(ctx context.Context, slug, youtubeURL string) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/pages/"+url.PathEscape(slug)+"/videos", map[string]string{"youtube_url": youtubeURL}, nil, true)
+}
+
+// Vote votes one of a page's videos up, or down if up is false.
+func (c *Client) Vote(ctx context.Context, slug, videoID string, up bool) error {
+	action := "downvote"
+	if up {
+		action = "upvote"
+	}
+	return c.do(ctx, http.MethodPost, "/api/v1/votes/"+url.PathEscape(slug)+"/"+url.PathEscape(videoID)+"/"+action, nil, nil, false)
+}
+
+// Search finds the pages whose name or text has every word of query, best
+// matches first.
+func (c *Client) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	var resp struct {
+		Results []SearchResult `json:"results"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v1/search?q="+url.QueryEscape(query), nil, &resp, false); err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
+
+// do makes a request, retrying it if it's worth it, and decodes the JSON
+// answer into out if it's given. Requests sent with an idempotency key can
+// be retried like GETs, as the site won't do them twice.
+func (c *Client) do(ctx context.Context, method, path string, body, out any, idempotent bool) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	var key string
+	if idempotent {
+		key = rand.Text()
+	}
+	wait := c.RetryBackoff
+	for try := 0; ; try++ {
+		err := c.try(ctx, method, path, data, key, out)
+		if err == nil || try >= c.MaxRetries || !retryable(method, key != "", err) {
+			return err
+		}
+		next := wait
+		wait *= 2
+		var siteErr *Error
+		if errors.As(err, &siteErr) && siteErr.RetryAfter > 0 {
+			next = siteErr.RetryAfter
+		}
+		if c.MaxRetryWait > 0 && next > c.MaxRetryWait {
+			return err // Not coming back any time soon
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(next):
+		}
+	}
+}
+
+// try makes a request once.
+func (c *Client) try(ctx context.Context, method, path string, data []byte, key string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", cmp.Or(c.UserAgent, "go-trailer-client"))
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return &networkError{err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return siteError(resp.StatusCode, resp.Header, body)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding the answer from %s %s: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What errors from the site are, for errors.Is.
+var (
+	ErrBadRequest   = errors.New("bad request")     // 400, what was sent isn't right
+	ErrUnauthorized = errors.New("login required")  // 401, set Username and Password
+	ErrForbidden    = errors.New("forbidden")       // 403
+	ErrNotFound     = errors.New("not found")       // 404
+	ErrConflict     = errors.New("conflict")        // 409, e.g. someone else saved first
+	ErrQuota        = errors.New("over quota")      // 413 or 507, the site is out of room
+	ErrRateLimited  = errors.New("rate limited")    // 429
+	ErrUnavailable  = errors.New("unavailable")     // 503, e.g. read-only for a backup
+	ErrServer       = errors.New("the site failed") // Any other 5xx
+)
+
+// Error is an error the site answered with.
+type Error struct {
+	StatusCode int
+	Code       string        // What went wrong for programs, e.g. "reserved_name", if the site said
+	Message    string        // What the site said went wrong
+	Fields     []FieldError  // Which fields of the request were wrong, if it said
+	RetryAfter time.Duration // How long the site asked us to wait, if it did
+}
+
+// FieldError is what was wrong with one field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("go-trailer: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is matches e against the Err* values for its status code.
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return target == ErrBadRequest
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusForbidden:
+		return target == ErrForbidden
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusRequestEntityTooLarge, http.StatusInsufficientStorage:
+		return target == ErrQuota
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	case http.StatusServiceUnavailable:
+		return target == ErrUnavailable
+	}
+	return e.StatusCode >= 500 && target == ErrServer
+}
+
+// problem is how the site describes errors from its page and vote API, as
+// application/problem+json.
+type problem struct {
+	Code   string       `json:"code"`
+	Detail string       `json:"detail"`
+	Errors []FieldError `json:"errors"`
+}
+
+// siteError is the error for an answer with status code and body.
+func siteError(statusCode int, header http.Header, body []byte) *Error {
+	e := &Error{StatusCode: statusCode, Message: strings.TrimSpace(string(body))}
+	var p problem
+	if mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type")); mediaType == "application/problem+json" && json.Unmarshal(body, &p) == nil {
+		e.Code, e.Message, e.Fields = p.Code, p.Detail, p.Errors
+	}
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return e
+}
+
+// networkError is a request that didn't get an answer.
+type networkError struct {
+	err error
+}
+
+func (e *networkError) Error() string { return e.err.Error() }
+func (e *networkError) Unwrap() error { return e.err }
+
+// retryable reports whether a request that failed with err is worth trying
+// again. Anything may be retried when the site said it did nothing (503,
+// 429); only GETs and requests with an idempotency key when it may have,
+// since a vote counted twice is worse than one that failed.
+func retryable(method string, idempotent bool, err error) bool {
+	safe := method == http.MethodGet || idempotent
+	var siteErr *Error
+	if errors.As(err, &siteErr) {
+		switch siteErr.StatusCode {
+		case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+			return true
+		case http.StatusBadGateway, http.StatusGatewayTimeout:
+			return safe
+		}
+		return false
+	}
+	var netErr *networkError
+	return errors.As(err, &netErr) && safe && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,3 @@
+module go-trailer/client
+
+go 1.26.0
@@ -0,0 +1,258 @@
+package main
+
+//Subcommands, for scripts and cron jobs. With none, or `serve`, go-trailer
+//runs the server; the rest work on the pages directory directly and exit:
+//
+//	go-trailer page create [flags] <name>
+//	go-trailer page list [flags]
+//	go-trailer page delete [flags] <slug>
+//	go-trailer export [-markdown] [flags] <file.zip>
+//	go-trailer import [flags] <zip or directory>
+//	go-trailer reindex [flags]
+//	go-trailer check [-fix] [flags]
+//	go-trailer update [-url ...] [-force] [-restart-pid ...]
+//
+//They take the server's flags, for the config and pages directory. Reading
+//is fine while the server runs; what writes is best done while it's
+//stopped, as the server keeps some things (view counts, the links between
+//pages) in memory and won't see the change.
+
+import (
+	"archive/zip"
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-trailer/internal/config"
+	"go-trailer/internal/handlers"
+	"go-trailer/internal/update"
+)
+
+// command is something go-trailer does instead of serving.
+type command struct {
+	run    func(args []string) error
+	failed string // Logged with the error when run fails
+}
+
+// commands by name. go-trailer runs the one its first argument names.
+var commands = map[string]command{
+	"page":    {runPage, "Page command failed"},
+	"export":  {runExport, "Export failed"},
+	"import":  {runImport, "Import failed"},
+	"reindex": {runReindex, "Reindex failed"},
+	"check":   {runCheck, "Check failed"},
+	"fsck":    {runCheck, "Check failed"}, // What check was called first
+	"update":  {update.Run, "Update failed"},
+}
+
+// openOffline gets a subcommand ready to work on the pages directory the
+// server's flags in args point at.
+func openOffline(args []string) (*handlers.Offline, error) {
+	cfg, err := config.Load(args)
+	if err != nil {
+		return nil, err
+	}
+	handlers.SetupLogging(cfg.LogFormat, cfg.LogLevel)
+	return handlers.NewOffline(cfg), nil
+}
+
+// lastArg splits off the argument a subcommand takes after the flags.
+func lastArg(args []string, what string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[len(args)-1], "-") {
+		return "", nil, errors.New("give the " + what + ", after any flags")
+	}
+	return args[len(args)-1], args[:len(args)-1], nil
+}
+
+// cutFlag reports whether a subcommand's own boolean flag is in args, and
+// returns args without it for config.Load, which doesn't know it.
+func cutFlag(args []string, name string) (bool, []string) {
+	is := func(arg string) bool { return arg == "-"+name || arg == "--"+name }
+	return slices.ContainsFunc(args, is), slices.DeleteFunc(slices.Clone(args), is)
+}
+
+// runPage is the entry point for `go-trailer page create|list|delete`.
+func runPage(args []string) error {
+	if len(args) == 0 {
+		return errors.New("page needs create, list or delete")
+	}
+	switch args[0] {
+	case "create":
+		name, args, err := lastArg(args[1:], "page's name")
+		if err != nil {
+			return err
+		}
+		site, err := openOffline(args)
+		if err != nil {
+			return err
+		}
+		slug, err := site.CreatePage(name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created  %s\n", slug)
+	case "list":
+		site, err := openOffline(args[1:])
+		if err != nil {
+			return err
+		}
+		pages, err := site.Pages()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "SLUG\tTITLE\tCREATED\tSTATUS")
+		for _, page := range pages {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", page.Slug, page.Title, page.Created.Format(time.DateOnly), page.Status)
+		}
+		return tw.Flush()
+	case "delete":
+		slug, args, err := lastArg(args[1:], "page's slug")
+		if err != nil {
+			return err
+		}
+		site, err := openOffline(args)
+		if err != nil {
+			return err
+		}
+		if err := site.DeletePage(slug, cmp.Or(os.Getenv("USER"), "command line")); err != nil {
+			return err
+		}
+		fmt.Printf("deleted  %s (it's in the trash)\n", slug)
+	default:
+		return fmt.Errorf("page %s isn't a command, try create, list or delete", args[0])
+	}
+	return nil
+}
+
+// runExport is the entry point for `go-trailer export [-markdown] [flags]
+// <file.zip>`, the same zip as /admin/export.
+func runExport(args []string) error {
+	markdown, args := cutFlag(args, "markdown")
+	to, args, err := lastArg(args, "zip to write")
+	if err != nil {
+		return err
+	}
+	site, err := openOffline(args)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	err = site.Export(f, markdown)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(to) // Half a zip is no use to anyone
+		return err
+	}
+	fmt.Printf("exported %s\n", to)
+	return nil
+}
+
+// runImport is the entry point for `go-trailer import [flags] <zip or
+// directory>`. It writes straight to the pages directory, so it's best run
+// while the server is stopped.
+func runImport(args []string) error {
+	from, args, err := lastArg(args, "zip or directory to import")
+	if err != nil {
+		return err
+	}
+	site, err := openOffline(args)
+	if err != nil {
+		return err
+	}
+
+	var files fs.FS
+	info, err := os.Stat(from)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		files = os.DirFS(from)
+	} else {
+		zr, err := zip.OpenReader(from)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		files = zr
+	}
+
+	results, err := site.Import(files)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, result := range results {
+		switch result.Status {
+		case "created":
+			fmt.Printf("created  %s from %s\n", result.Slug, result.File)
+		case "skipped":
+			fmt.Printf("skipped  %s: %s\n", result.File, result.Reason)
+		default:
+			fmt.Printf("failed   %s: %s\n", result.File, result.Reason)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d pages could not be imported", failed, len(results))
+	}
+	return nil
+}
+
+// runReindex is the entry point for `go-trailer reindex [flags]`, see
+// handlers.Offline.Reindex.
+func runReindex(args []string) error {
+	site, err := openOffline(args)
+	if err != nil {
+		return err
+	}
+	pages, dropped, err := site.Reindex()
+	for _, what := range dropped {
+		fmt.Printf("dropped  %s\n", what)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("reindexed %d pages\n", pages)
+	return nil
+}
+
+// runCheck is the entry point for `go-trailer check [-fix] [flags]`, see
+// /admin/fsck. It fails if anything's wrong that it didn't fix.
+func runCheck(args []string) error {
+	fix, args := cutFlag(args, "fix")
+	site, err := openOffline(args)
+	if err != nil {
+		return err
+	}
+
+	problems, err := site.Check(fix)
+	if err != nil {
+		return err
+	}
+	left := 0
+	for _, problem := range problems {
+		if problem.Fixed {
+			fmt.Printf("fixed    %s: %s\n", problem.File, problem.Problem)
+			continue
+		}
+		fmt.Printf("problem  %s: %s\n", problem.File, problem.Problem)
+		left++
+	}
+	if left > 0 {
+		return fmt.Errorf("%d problems found in %s", left, site.PagesDir())
+	}
+	return nil
+}
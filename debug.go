@@ -0,0 +1,33 @@
+package main
+
+//Go's profiler (net/http/pprof) on a listener of its own, for tracking down
+//memory growth and leaked goroutines. Off unless debug_addr is set, and never
+//on the public listener.
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// startDebugServer serves /debug/pprof/ on addr in the background, giving
+// clients readHeaderTimeout to send their headers.
+func startDebugServer(addr string, readHeaderTimeout time.Duration) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index) // Also serves heap, goroutine, allocs etc.
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: readHeaderTimeout}
+	go func() {
+		slog.Info("Starting debug server", "addr", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Debug server failed", "err", err) // Not worth taking the site down for
+		}
+	}()
+	return server
+}
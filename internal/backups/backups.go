@@ -0,0 +1,424 @@
+// Package backups snapshots the pages directory to
+// pages-{time}.tar.gz in backups.dir every backups.interval, and removes all
+// but the newest backups.keep. The main site's pages are at the top of the
+// archive, each site under sites: in sites/{its first host}/, and every
+// tenant in tenants/{name}/. With backups.s3.bucket set each one is uploaded
+// there too, signed by hand so we need no SDK; S3's own lifecycle rules can
+// expire old ones.
+package backups
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go-trailer/internal/config"
+)
+
+// Uploads can be big, but they shouldn't take forever.
+var client = &http.Client{Timeout: 30 * time.Minute}
+
+// Status is how backing up has been going, for /admin/backups.
+type Status struct {
+	LastAt      time.Time `json:"last_at,omitzero"` // When the last good backup was taken
+	LastFile    string    `json:"last_file,omitempty"`
+	LastBytes   int64     `json:"last_bytes,omitempty"`
+	Uploaded    bool      `json:"uploaded"` // Whether it made it to S3 too
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitzero"`
+	Next        time.Time `json:"next,omitzero"`
+}
+
+// State is a server's backups: how they've been going, and turns taken
+// between scheduled ones and ones asked for by hand.
+type State struct {
+	config  *config.Config
+	mu      sync.Mutex
+	status  Status
+	running sync.Mutex
+}
+
+// New is the backups of the sites in cfg, none taken yet.
+func New(cfg *config.Config) *State {
+	return &State{config: cfg}
+}
+
+// Status says how the backups have been going.
+func (st *State) Status() Status {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.status
+}
+
+// File is a backup kept in backups.dir.
+type File struct {
+	Name    string    `json:"name"`
+	Bytes   int64     `json:"bytes"`
+	Created time.Time `json:"created"`
+}
+
+// fileName is what a backup taken at t is called.
+func fileName(t time.Time) string {
+	return "pages-" + t.UTC().Format("20060102T150405Z") + ".tar.gz"
+}
+
+// List is the backups kept in backups.dir, oldest first.
+func (st *State) List() ([]File, error) {
+	entries, err := os.ReadDir(st.config.Backups.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups []File
+	for _, entry := range entries {
+		name := entry.Name()
+		created, err := time.Parse("20060102T150405Z", strings.TrimSuffix(strings.TrimPrefix(name, "pages-"), ".tar.gz"))
+		if err != nil || !entry.Type().IsRegular() {
+			continue // Not one of ours
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, File{Name: name, Bytes: info.Size(), Created: created})
+	}
+	slices.SortFunc(backups, func(a, b File) int { return a.Created.Compare(b.Created) })
+	return backups, nil
+}
+
+// Run takes a backup every backups.interval until ctx is done. The
+// first is due an interval after the newest one kept, so restarts don't
+// put it off.
+func (st *State) Run(ctx context.Context) {
+	next := time.Now()
+	if backups, err := st.List(); err == nil && len(backups) > 0 {
+		next = backups[len(backups)-1].Created.Add(st.config.Backups.Interval)
+	}
+	for {
+		st.mu.Lock()
+		st.status.Next = next
+		st.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		if _, err := st.Take(ctx); err != nil {
+			slog.Error("Error taking backup", "err", err)
+		}
+		next = time.Now().Add(st.config.Backups.Interval)
+	}
+}
+
+// Take snapshots the pages directory, uploads it if there's somewhere
+// to, and rotates out the old ones. A failed upload leaves the backup kept
+// here, and is reported in err.
+func (st *State) Take(ctx context.Context) (File, error) {
+	st.running.Lock()
+	defer st.running.Unlock()
+
+	backup, err := st.write(time.Now())
+	uploaded := false
+	if err == nil && st.config.Backups.S3.Bucket != "" {
+		err = st.upload(ctx, filepath.Join(st.config.Backups.Dir, backup.Name))
+		uploaded = err == nil
+	}
+
+	st.mu.Lock()
+	status := &st.status
+	if backup.Name != "" {
+		status.LastAt, status.LastFile, status.LastBytes, status.Uploaded = backup.Created, backup.Name, backup.Bytes, uploaded
+	}
+	if err != nil {
+		status.LastError, status.LastErrorAt = err.Error(), time.Now().UTC()
+	} else {
+		status.LastError, status.LastErrorAt = "", time.Time{}
+	}
+	st.mu.Unlock()
+	if backup.Name == "" {
+		return backup, err
+	}
+	slog.Info("Backup taken", "file", backup.Name, "bytes", backup.Bytes, "uploaded", uploaded)
+	if err := st.rotate(); err != nil {
+		slog.Error("Error removing old backups", "err", err)
+	}
+	return backup, err
+}
+
+// write writes a tar.gz of every site's pages, as they are at now, to
+// backups.dir.
+func (st *State) write(now time.Time) (File, error) {
+	backup := File{Name: fileName(now), Created: now.UTC().Truncate(time.Second)}
+	roots, err := st.roots()
+	if err != nil {
+		return File{}, err
+	}
+	size, err := writeTarGzOf(roots, st.config.Backups.Dir, backup.Name)
+	if err != nil {
+		return File{}, err
+	}
+	backup.Bytes = size
+	return backup, nil
+}
+
+// tarRoot is a directory to put in a tar.gz, and where in it.
+type tarRoot struct {
+	src    string
+	prefix string // Put before the name of each file in src, "" or ending in /
+}
+
+// roots is every directory of pages to back up: the main site's, each
+// site's under sites:, and the tenants'. Those of other sites that haven't
+// been made yet are left out.
+func (st *State) roots() ([]tarRoot, error) {
+	config := st.config
+	roots := []tarRoot{{src: config.PagesDir}}
+	var more []tarRoot
+	for _, s := range config.Sites {
+		more = append(more, tarRoot{src: s.PagesDir, prefix: "sites/" + strings.ToLower(s.Hosts[0]) + "/"})
+	}
+	if config.Tenants.Enabled {
+		more = append(more, tarRoot{src: config.Tenants.Dir, prefix: "tenants/"})
+	}
+	for _, root := range more {
+		if _, err := os.Stat(root.src); errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	return roots, nil
+}
+
+// WriteTarGz writes a tar.gz of the files in src to dir/name, answering with
+// its size.
+func WriteTarGz(src, dir, name string) (int64, error) {
+	return writeTarGzOf([]tarRoot{{src: src}}, dir, name)
+}
+
+// writeTarGzOf writes a tar.gz of the files in each root to dir/name,
+// answering with its size. It's written under another name and renamed once
+// it's done, so one that's there is a whole one. A root inside another is
+// only put in once, where it's asked for.
+func writeTarGzOf(roots []tarRoot, dir, name string) (int64, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil { // 0755 = rwxr-xr-x
+		return 0, err
+	}
+	skip := make(map[string]bool)
+	abs, _ := filepath.Abs(dir) // In case it's kept in a root
+	skip[abs] = true
+	for _, root := range roots {
+		abs, _ := filepath.Abs(root.src)
+		skip[abs] = true
+	}
+	tmp, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	gz := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(gz)
+	for _, root := range roots {
+		err = filepath.WalkDir(root.src, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if abs, _ := filepath.Abs(path); skip[abs] && path != root.src {
+				return fs.SkipDir
+			}
+			if strings.HasPrefix(d.Name(), ".") && path != root.src {
+				return nil // Files still being written
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			name, err := filepath.Rel(root.src, path)
+			if err != nil {
+				return err
+			}
+			return addTarFile(tw, root.prefix+filepath.ToSlash(name), path)
+		})
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil { // 0600 = rw-------, there could be drafts in it
+		return 0, err
+	}
+	return info.Size(), os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// addTarFile adds the file at path to the tar as name.
+func addTarFile(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // Removed since we listed it
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, info.Size()) // Just what the header said, if it's grown since
+	return err
+}
+
+// rotate removes all but the newest backups.keep backups.
+func (st *State) rotate() error {
+	backups, err := st.List()
+	if err != nil {
+		return err
+	}
+	for len(backups) > st.config.Backups.Keep {
+		if err := os.Remove(filepath.Join(st.config.Backups.Dir, backups[0].Name)); err != nil {
+			return err
+		}
+		slog.Info("Old backup removed", "file", backups[0].Name)
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// upload PUTs the backup at path to backups.s3.bucket.
+func (st *State) upload(ctx context.Context, path string) error {
+	s := st.config.Backups.S3
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(cmp.Or(s.Endpoint, "https://s3."+s.Region+".amazonaws.com"), "/")
+	key := s3Escape(s.Bucket + "/" + s.Prefix + filepath.Base(path))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/"+key, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/gzip")
+	signS3Request(req, s, hex.EncodeToString(hash.Sum(nil)), time.Now().UTC())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("uploading backup: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("uploading backup: S3 said %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// signS3Request signs req with AWS Signature Version 4, for a body whose
+// SHA-256 is payloadHash.
+func signS3Request(req *http.Request, s config.S3Settings, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"", // No query
+		"content-type:" + req.Header.Get("Content-Type"),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 is the HMAC-SHA256 of data with key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape escapes an object path the way S3 signs it: everything but
+// letters, digits, -._~ and the slashes.
+func s3Escape(path string) string {
+	var b strings.Builder
+	for _, c := range []byte(path) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', strings.IndexByte("-._~/", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
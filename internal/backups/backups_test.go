@@ -0,0 +1,81 @@
+package backups
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go-trailer/internal/config"
+)
+
+// writeFiles writes each file under dir, making directories as needed.
+func writeFiles(t *testing.T, dir string, files ...string) {
+	t.Helper()
+	for _, name := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBackupHasEverySite(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.PagesDir = filepath.Join(dir, "pages")
+	cfg.Backups.Dir = filepath.Join(cfg.PagesDir, "backups") // Kept out of its own backups
+	cfg.Tenants.Enabled = true
+	cfg.Tenants.Dir = filepath.Join(cfg.PagesDir, "tenants") // Put in once, as tenants/
+	cfg.Sites = []config.SiteSettings{
+		{Hosts: []string{"Other.Example.com", "other.test"}, PagesDir: filepath.Join(dir, "other")},
+		{Hosts: []string{"new.test"}, PagesDir: filepath.Join(dir, "new")}, // Not made yet
+	}
+	writeFiles(t, cfg.PagesDir, "home.txt", "home.meta.json")
+	writeFiles(t, cfg.Sites[0].PagesDir, "about.txt")
+	writeFiles(t, cfg.Tenants.Dir, "acme/home.txt", "initech/home.txt")
+
+	backup, err := New(&cfg).write(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(cfg.Backups.Dir, backup.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	slices.Sort(names)
+	want := []string{
+		"home.meta.json",
+		"home.txt",
+		"sites/other.example.com/about.txt",
+		"tenants/acme/home.txt",
+		"tenants/initech/home.txt",
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("backup has %q, want %q", names, want)
+	}
+}
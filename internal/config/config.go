@@ -0,0 +1,470 @@
+// Package config is the site's settings, loaded from flags, environment
+// variables and config.yaml. Every setting has a sane default, so all of them
+// are optional. See config.example.yaml for what there is.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything that can be set in config.yaml.
+type Config struct {
+	Addr          string `yaml:"addr"`           // Where we listen, e.g. ":8080"
+	DebugAddr     string `yaml:"debug_addr"`     // Where pprof listens, off when empty
+	GRPCAddr      string `yaml:"grpc_addr"`      // Where the gRPC API listens, off when empty
+	SiteTitle     string `yaml:"site_title"`     // Shown in page titles, feeds and link previews
+	SiteURL       string `yaml:"site_url"`       // Public URL, e.g. https://wiki.example.com
+	BasePath      string `yaml:"base_path"`      // Path prefix the site lives under, e.g. /wiki
+	PagesDir      string `yaml:"pages_dir"`      // Page, video link, vote and comment files
+	TemplatesDir  string `yaml:"templates_dir"`  // Every *.html in here is parsed at startup
+	StaticDir     string `yaml:"static_dir"`     // Served at /static/
+	PluginsDir    string `yaml:"plugins_dir"`    // Executables started as plugins
+	AdminPassword string `yaml:"admin_password"` // Admin pages are off while this is empty
+	AkismetKey    string `yaml:"akismet_key"`    // Use Akismet to spam check comments
+	Dev           bool   `yaml:"dev"`            // Re-parse templates on every request
+	H2C           bool   `yaml:"h2c"`            // Also speak HTTP/2 without TLS, for proxies that do
+	LogFormat     string `yaml:"log_format"`     // "text" or "json"
+	LogLevel      string `yaml:"log_level"`      // "debug", "info", "warn" or "error"
+
+	Features     Features             `yaml:"features"`
+	Robots       []RobotsGroup        `yaml:"robots"`
+	SearchPings  SearchPingSettings   `yaml:"search_pings"`
+	YouTubeEmbed YouTubeEmbedSettings `yaml:"youtube_embed"`
+	Tracing      TracingSettings      `yaml:"tracing"`
+	CDN          CDNSettings          `yaml:"cdn"`
+	AccessLog    AccessLogSettings    `yaml:"access_log"`
+	TLS          TLSSettings          `yaml:"tls"`
+	Socket       SocketSettings       `yaml:"socket"`
+	Slugs        SlugSettings         `yaml:"slugs"`
+	HTML         HTMLSettings         `yaml:"html"`
+	Attachments  AttachmentSettings   `yaml:"attachments"`
+	Maintenance  MaintenanceSettings  `yaml:"maintenance"`
+	Trash        TrashSettings        `yaml:"trash"`
+	Backups      BackupSettings       `yaml:"backups"`
+	Limits       LimitSettings        `yaml:"limits"`
+	StaticCache  StaticCacheSettings  `yaml:"static_cache"`
+	CORS         CORSSettings         `yaml:"cors"`
+	PageCreation PageCreationSettings `yaml:"page_creation"`
+	Blocklist    BlocklistSettings    `yaml:"blocklist"`
+	Moderation   ModerationSettings   `yaml:"moderation"`
+
+	TrustedProxies []string            `yaml:"trusted_proxies"` // IPs and CIDRs whose X-Forwarded-For we believe
+	Roles          map[string][]string `yaml:"roles"`           // Groups of users, that private pages can name as @role
+	Sites          []SiteSettings      `yaml:"sites"`           // Other sites, picked by Host header
+	Tenants        TenantSettings      `yaml:"tenants"`
+}
+
+// Features switches optional parts of the site on and off.
+type Features struct {
+	Comments bool `yaml:"comments"` // Page comments and their moderation view
+	Feeds    bool `yaml:"feeds"`    // /feed.xml and /sitemap.xml
+	Export   bool `yaml:"export"`   // /page/{slug}/export
+	Plugins  bool `yaml:"plugins"`  // Start whatever is in PluginsDir
+	Search   bool `yaml:"search"`   // /search, /api/search and the search report
+}
+
+// On reports whether the named feature is, for templates.
+func (f Features) On(name string) bool {
+	switch name {
+	case "comments":
+		return f.Comments
+	case "feeds":
+		return f.Feeds
+	case "export":
+		return f.Export
+	case "search":
+		return f.Search
+	}
+	return false
+}
+
+// TLSSettings is the tls: part of config.yaml. The go-trailer command serves
+// HTTPS with it, see its tls.go.
+type TLSSettings struct {
+	Enabled      bool     `yaml:"enabled"`
+	Domains      []string `yaml:"domains"`       // Hosts to get certificates for, nothing else is served
+	Email        string   `yaml:"email"`         // Let's Encrypt writes here about expiring certificates
+	CacheDir     string   `yaml:"cache_dir"`     // Where certificates are kept between restarts
+	RedirectAddr string   `yaml:"redirect_addr"` // Plain HTTP listener for challenges and redirects, empty for none
+}
+
+// SocketSettings is the socket: part of config.yaml, for the go-trailer
+// command to listen on, see its listener.go.
+type SocketSettings struct {
+	Path  string `yaml:"path"`  // Listen here instead of addr, when set
+	Mode  string `yaml:"mode"`  // Permissions of the socket file, in octal
+	Group string `yaml:"group"` // Group to give the socket file to, e.g. www-data
+}
+
+// FileMode is Mode as permissions.
+func (s SocketSettings) FileMode() (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(s.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.New("socket.mode must be octal permissions like 0660")
+	}
+	return fs.FileMode(mode), nil
+}
+
+// Default is how the site runs with no config file at all.
+func Default() Config {
+	return Config{
+		Addr:         ":8080",
+		SiteTitle:    "Go Wiki",
+		PagesDir:     "pages",
+		TemplatesDir: "templates",
+		StaticDir:    "static",
+		PluginsDir:   "plugins",
+		LogFormat:    "text",
+		LogLevel:     "info",
+		Features: Features{
+			Comments: true,
+			Feeds:    true,
+			Export:   true,
+			Plugins:  true,
+			Search:   true,
+		},
+		// Every crawler is welcome on pages but kept away from endpoints that
+		// only make sense for the site's own JavaScript
+		Robots: []RobotsGroup{
+			{UserAgent: "*", Disallow: []string{"/api/", "/create", "/search"}},
+		},
+		Tracing:   TracingSettings{SampleRatio: 1},
+		AccessLog: AccessLogSettings{Format: "combined"},
+		TLS:       TLSSettings{CacheDir: "certs", RedirectAddr: ":80"},
+		Socket:    SocketSettings{Mode: "0660"},
+		Tenants:   TenantSettings{By: "path", Dir: "tenants"},
+		Slugs: SlugSettings{
+			OnCollision: "redirect",
+			Reserved: []string{
+				"admin", "api", "archive", "create", "feed", "healthz", "page",
+				"popular", "readyz", "robots", "search", "share", "sitemap", "static", "t",
+				"*.comments", "*.meta", "*.votes", "*.youtube",
+			},
+		},
+		// Formatting, lists, tables and pictures, but nothing that runs code
+		HTML: HTMLSettings{
+			AllowedTags: []string{
+				"b", "i", "em", "strong", "u", "s", "del", "ins", "mark", "small",
+				"sub", "sup", "code", "kbd", "blockquote", "q", "cite", "abbr",
+				"br", "hr", "ul", "ol", "li", "dl", "dt", "dd", "div", "img",
+				"figure", "figcaption", "table", "caption", "thead", "tbody",
+				"tfoot", "tr", "th", "td",
+			},
+		},
+		Attachments: AttachmentSettings{MaxBytes: 10 << 20, ThumbnailWidths: []int{200, 800}},
+		Trash:       TrashSettings{PurgeAfterDays: 30},
+		Backups:     BackupSettings{Interval: 24 * time.Hour, Keep: 7},
+		StaticCache: StaticCacheSettings{MaxAge: time.Hour, Fingerprinted: 365 * 24 * time.Hour},
+		Limits: LimitSettings{
+			MaxBodyBytes:      64 << 10,
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			HandlerTimeout:    time.Minute,
+		},
+		PageCreation: PageCreationSettings{Honeypot: true, PowBits: 16, Window: time.Hour},
+		Blocklist:    BlocklistSettings{Strikes: 5, Window: 10 * time.Minute, BanFor: 24 * time.Hour},
+		Moderation:   ModerationSettings{BlockedLinks: "reject"},
+		// What the JSON API takes and answers with, once origins are listed
+		CORS: CORSSettings{
+			AllowedMethods: []string{"GET", "POST", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "Idempotency-Key", "If-Match", "If-None-Match", "X-Request-ID"},
+			ExposedHeaders: []string{"Deprecation", "ETag", "Idempotent-Replayed", "Link", "Location", "Retry-After", "X-Request-ID"},
+			MaxAge:         10 * time.Minute,
+		},
+		CDN: CDNSettings{
+			SharedMaxAge:         5 * time.Minute,
+			StaleWhileRevalidate: time.Minute,
+		},
+		SearchPings: SearchPingSettings{
+			IndexNowURL: "https://api.indexnow.org/indexnow",
+			Interval:    time.Minute,
+		},
+	}
+}
+
+// Load works out the settings from, highest precedence first:
+//
+//  1. command-line flags (only the ones actually given)
+//  2. WEBSITE_* environment variables
+//  3. the config file (-config, or $WEBSITE_CONFIG, or config.yaml)
+//  4. the defaults
+func Load(args []string) (Config, error) {
+	flags := flag.NewFlagSet("go-trailer", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to the config file (default $WEBSITE_CONFIG or config.yaml)")
+	addr := flags.String("addr", "", "address to listen on, e.g. :8080")
+	socket := flags.String("socket", "", "unix socket to listen on instead of addr")
+	pagesDir := flags.String("pages-dir", "", "directory holding the pages")
+	templatesDir := flags.String("templates-dir", "", "directory holding the *.html templates")
+	staticDir := flags.String("static-dir", "", "directory served at /static/")
+	debugAddr := flags.String("debug-addr", "", "address for the pprof debug listener, e.g. localhost:6060")
+	grpcAddr := flags.String("grpc-addr", "", "address for the gRPC API listener, e.g. localhost:9090")
+	dev := flags.Bool("dev", false, "development mode: reload templates on every request")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: go-trailer [serve] [flags]\n       go-trailer page create [flags] <name>\n       go-trailer page list [flags]\n       go-trailer page delete [flags] <slug>\n       go-trailer export [-markdown] [flags] <file.zip>\n       go-trailer import [flags] <zip or directory>\n       go-trailer reindex [flags]\n       go-trailer check [-fix] [flags]\n       go-trailer update [flags]\n\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(flags.Output(), "\nSettings come from flags, then WEBSITE_* environment variables, then the\nconfig file, then the built-in defaults, in that order of precedence.\n")
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	path := *configPath
+	if path == "" {
+		path = os.Getenv("WEBSITE_CONFIG")
+	}
+	if path == "" {
+		path = "config.yaml"
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.applyEnv()
+
+	// Only flags that were actually passed override anything
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Addr = *addr
+		case "socket":
+			cfg.Socket.Path = *socket
+		case "pages-dir":
+			cfg.PagesDir = *pagesDir
+		case "templates-dir":
+			cfg.TemplatesDir = *templatesDir
+		case "static-dir":
+			cfg.StaticDir = *staticDir
+		case "debug-addr":
+			cfg.DebugAddr = *debugAddr
+		case "grpc-addr":
+			cfg.GRPCAddr = *grpcAddr
+		case "dev":
+			cfg.Dev = *dev
+		}
+	})
+
+	cfg.SiteURL = strings.TrimSuffix(cfg.SiteURL, "/")
+	cfg.BasePath = strings.TrimSuffix(cfg.BasePath, "/")
+	return cfg, cfg.validate()
+}
+
+// loadConfig reads the config file at path over the defaults. A missing file
+// is fine, but a setting we don't recognise is an error, since it's almost
+// certainly a typo that would otherwise be silently ignored.
+func loadConfig(path string) (Config, error) {
+	cfg := Default()
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides settings with any WEBSITE_* environment variables that are set.
+func (c *Config) applyEnv() {
+	settings := map[string]*string{
+		"WEBSITE_ADDR":                   &c.Addr,
+		"WEBSITE_DEBUG_ADDR":             &c.DebugAddr,
+		"WEBSITE_GRPC_ADDR":              &c.GRPCAddr,
+		"WEBSITE_SOCKET":                 &c.Socket.Path,
+		"WEBSITE_SITE_TITLE":             &c.SiteTitle,
+		"WEBSITE_SITE_URL":               &c.SiteURL,
+		"WEBSITE_BASE_PATH":              &c.BasePath,
+		"WEBSITE_PAGES_DIR":              &c.PagesDir,
+		"WEBSITE_TEMPLATES_DIR":          &c.TemplatesDir,
+		"WEBSITE_STATIC_DIR":             &c.StaticDir,
+		"WEBSITE_PLUGINS_DIR":            &c.PluginsDir,
+		"WEBSITE_ADMIN_PASSWORD":         &c.AdminPassword,
+		"WEBSITE_TENANTS_ADMIN_PASSWORD": &c.Tenants.AdminPassword,
+		"WEBSITE_AKISMET_KEY":            &c.AkismetKey,
+		"WEBSITE_INDEXNOW_KEY":           &c.SearchPings.IndexNowKey,
+		"WEBSITE_LOG_FORMAT":             &c.LogFormat,
+		"WEBSITE_LOG_LEVEL":              &c.LogLevel,
+	}
+	for name, setting := range settings {
+		if v, ok := os.LookupEnv(name); ok {
+			*setting = v
+		}
+	}
+
+	if v, ok := os.LookupEnv("WEBSITE_SITEMAP_PING"); ok {
+		c.SearchPings.SitemapPings = splitList(v)
+	}
+	if v, ok := os.LookupEnv("WEBSITE_TRUSTED_PROXIES"); ok {
+		c.TrustedProxies = splitList(v)
+	}
+}
+
+// splitList splits a comma separated environment variable, dropping blanks.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validate catches settings that would only blow up later on.
+func (c Config) validate() error {
+	if c.Addr == "" {
+		return errors.New("addr must not be empty")
+	}
+	pagesDirs := map[string]bool{filepath.Clean(c.PagesDir): true}
+	hosts := make(map[string]bool)
+	for i, s := range c.Sites {
+		if len(s.Hosts) == 0 {
+			return fmt.Errorf("sites[%d] needs hosts", i)
+		}
+		for _, host := range s.Hosts {
+			if hosts[strings.ToLower(host)] {
+				return fmt.Errorf("sites[%d]: host %s is already used by another site", i, host)
+			}
+			hosts[strings.ToLower(host)] = true
+		}
+		if s.PagesDir == "" || pagesDirs[filepath.Clean(s.PagesDir)] {
+			return fmt.Errorf("sites[%d] needs a pages_dir of its own", i)
+		}
+		pagesDirs[filepath.Clean(s.PagesDir)] = true
+		if s.SiteURL != "" && c.BasePath != "" && !strings.HasSuffix(strings.TrimSuffix(s.SiteURL, "/"), c.BasePath) {
+			return fmt.Errorf("sites[%d]: site_url must include base_path", i)
+		}
+	}
+	if c.Tenants.Enabled {
+		switch {
+		case c.Tenants.By != "path" && c.Tenants.By != "host":
+			return errors.New("tenants.by must be path or host")
+		case c.Tenants.By == "host" && c.Tenants.Domain == "":
+			return errors.New("tenants.domain must be set to pick tenants by host")
+		case c.Tenants.Dir == "" || pagesDirs[filepath.Clean(c.Tenants.Dir)]:
+			return errors.New("tenants.dir needs a directory of its own")
+		case c.Tenants.MaxPages < 0 || c.Tenants.MaxBytes < 0:
+			return errors.New("tenant quotas must not be negative")
+		}
+	}
+	if c.BasePath != "" && (!strings.HasPrefix(c.BasePath, "/") || strings.ContainsAny(c.BasePath, "?#")) {
+		return errors.New("base_path must be a path like /wiki")
+	}
+	if c.BasePath != "" && c.SiteURL != "" && !strings.HasSuffix(c.SiteURL, c.BasePath) {
+		return errors.New("site_url must include base_path, e.g. https://example.com/wiki")
+	}
+	if c.SearchPings.IndexNowKey != "" && !indexNowKeyRegex.MatchString(c.SearchPings.IndexNowKey) {
+		return errors.New("search_pings.indexnow_key must be 8-128 letters, digits or dashes")
+	}
+	if c.DebugAddr != "" && c.DebugAddr == c.Addr {
+		return errors.New("debug_addr must be different from addr")
+	}
+	if c.GRPCAddr != "" && (c.GRPCAddr == c.Addr || c.GRPCAddr == c.DebugAddr) {
+		return errors.New("grpc_addr must be different from addr and debug_addr")
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return errors.New("log_format must be text or json")
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return errors.New("log_level must be debug, info, warn or error")
+	}
+	if c.YouTubeEmbed.CaptionsLang != "" && !CaptionsLangRegex.MatchString(c.YouTubeEmbed.CaptionsLang) {
+		return errors.New("youtube_embed.captions_lang must be a language code like en or pt-BR")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		return errors.New("tracing.sample_ratio must be between 0 and 1")
+	}
+	if c.Slugs.OnCollision != "redirect" && c.Slugs.OnCollision != "suffix" {
+		return errors.New("slugs.on_collision must be redirect or suffix")
+	}
+	if err := c.Slugs.validate(); err != nil {
+		return err
+	}
+	if err := c.HTML.validate(); err != nil {
+		return err
+	}
+	if err := c.Attachments.validate(); err != nil {
+		return err
+	}
+	if err := c.Trash.validate(); err != nil {
+		return err
+	}
+	if err := c.Backups.validate(); err != nil {
+		return err
+	}
+	if err := c.Limits.validate(); err != nil {
+		return err
+	}
+	if err := c.StaticCache.validate(); err != nil {
+		return err
+	}
+	if err := c.CORS.validate(); err != nil {
+		return err
+	}
+	if err := c.PageCreation.validate(); err != nil {
+		return err
+	}
+	if err := c.Blocklist.validate(); err != nil {
+		return err
+	}
+	if err := c.Moderation.validate(); err != nil {
+		return err
+	}
+	if err := validateRoles(c.Roles); err != nil {
+		return err
+	}
+	if c.AccessLog.Format != "combined" && c.AccessLog.Format != "json" {
+		return errors.New("access_log.format must be combined or json")
+	}
+	if _, err := ParseTrustedProxies(c.TrustedProxies); err != nil {
+		return err
+	}
+	if _, err := c.Socket.FileMode(); err != nil {
+		return err
+	}
+	if c.H2C && c.TLS.Enabled {
+		return errors.New("h2c is for plain HTTP, with tls on HTTP/2 is already served")
+	}
+	if c.TLS.Enabled {
+		if len(c.TLS.Domains) == 0 {
+			return errors.New("tls.domains must list the hosts to get certificates for")
+		}
+		if c.TLS.CacheDir == "" {
+			return errors.New("tls.cache_dir must not be empty, or every restart asks for new certificates")
+		}
+		if c.TLS.RedirectAddr != "" && (c.TLS.RedirectAddr == c.Addr || c.TLS.RedirectAddr == c.DebugAddr) {
+			return errors.New("tls.redirect_addr must be different from addr and debug_addr")
+		}
+	}
+	if c.CDN.MaxAge < 0 || c.CDN.SharedMaxAge < 0 || c.CDN.StaleWhileRevalidate < 0 {
+		return errors.New("cdn cache times must not be negative")
+	}
+	if c.CDN.PurgeURL != "" && c.SiteURL == "" {
+		return errors.New("cdn.purge_url needs site_url, purges are for absolute URLs")
+	}
+	if c.SearchPings.Interval <= 0 {
+		return errors.New("search_pings.interval must be positive")
+	}
+	return nil
+}
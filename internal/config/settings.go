@@ -0,0 +1,444 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RobotsGroup is one User-agent block of robots.txt.
+type RobotsGroup struct {
+	UserAgent string   `yaml:"user_agent"`
+	Allow     []string `yaml:"allow"`
+	Disallow  []string `yaml:"disallow"`
+}
+
+// SearchPingSettings controls who we notify and how often. Nothing is sent
+// unless site_url is set along with an IndexNow key or a sitemap ping endpoint.
+type SearchPingSettings struct {
+	IndexNowKey  string        `yaml:"indexnow_key"`  // Our IndexNow key, also served at /{key}.txt
+	IndexNowURL  string        `yaml:"indexnow_url"`  // Where IndexNow submissions go
+	SitemapPings []string      `yaml:"sitemap_pings"` // Endpoints that get GET ?sitemap=<our sitemap URL>
+	Interval     time.Duration `yaml:"interval"`      // How often queued changes are sent
+}
+
+// IndexNow keys are 8-128 characters of letters, digits and dashes.
+var indexNowKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9-]{8,128}$`)
+
+// Enabled reports whether there's anyone to notify about the site at
+// siteURL.
+func (s SearchPingSettings) Enabled(siteURL string) bool {
+	return siteURL != "" && (s.IndexNowKey != "" || len(s.SitemapPings) > 0)
+}
+
+// YouTubeEmbedSettings are the player options we put on embed URLs.
+type YouTubeEmbedSettings struct {
+	Autoplay       bool   `yaml:"autoplay"`        // Browsers only allow this when muted
+	Mute           bool   `yaml:"mute"`            // Start with the sound off
+	ModestBranding bool   `yaml:"modest_branding"` // Less YouTube logo in the player
+	CaptionsLang   string `yaml:"captions_lang"`   // Show captions in this language, e.g. "en"
+}
+
+// CaptionsLangRegex is what captions languages look like: two letter
+// language codes, optionally with a region, like "en" and "pt-BR".
+var CaptionsLangRegex = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})?$`)
+
+// TracingSettings is the tracing: part of config.yaml.
+type TracingSettings struct {
+	Enabled     bool    `yaml:"enabled"`
+	Endpoint    string  `yaml:"endpoint"`     // Collector host:port, empty means $OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318
+	Insecure    bool    `yaml:"insecure"`     // Plain HTTP to the collector
+	SampleRatio float64 `yaml:"sample_ratio"` // Share of requests traced, 0 to 1
+}
+
+// CDNSettings is the cdn: part of config.yaml.
+type CDNSettings struct {
+	Enabled              bool          `yaml:"enabled"`
+	MaxAge               time.Duration `yaml:"max_age"`                // How long browsers may cache pages
+	SharedMaxAge         time.Duration `yaml:"s_maxage"`               // How long the CDN may cache pages
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"` // Serve stale while refetching, for this long
+	PurgeURL             string        `yaml:"purge_url"`              // Gets POST {"urls": [...]} after writes
+	PurgeToken           string        `yaml:"purge_token"`            // Sent as a bearer token with purges
+}
+
+// Purging reports whether writes should purge the CDN.
+func (c CDNSettings) Purging() bool {
+	return c.Enabled && c.PurgeURL != ""
+}
+
+// AccessLogSettings is the access_log: part of config.yaml.
+type AccessLogSettings struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`   // A file to append to, empty or "-" for stdout
+	Format  string `yaml:"format"` // "combined" or "json"
+}
+
+// SlugSettings is the slugs: part of config.yaml.
+type SlugSettings struct {
+	OnCollision string   `yaml:"on_collision"` // "redirect" to the existing page, or "suffix" for my-page-2
+	Reserved    []string `yaml:"reserved"`     // Slugs no page may have, as globs like "*.votes"
+}
+
+func (s SlugSettings) validate() error {
+	for _, pattern := range s.Reserved {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("slugs.reserved: bad pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// HTMLSettings is the html: part of config.yaml.
+type HTMLSettings struct {
+	AllowedTags []string `yaml:"allowed_tags"` // Tags pages may use, e.g. [b, i, img]
+}
+
+// Tag names as html.allowed_tags takes them.
+var tagNameRegex = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// validate checks the allowed tags are tag names, and none that can run code.
+func (s HTMLSettings) validate() error {
+	for _, tag := range s.AllowedTags {
+		if !tagNameRegex.MatchString(tag) {
+			return fmt.Errorf("html.allowed_tags: %q isn't a lowercase tag name", tag)
+		}
+		if DroppedWithContent[tag] {
+			return fmt.Errorf("html.allowed_tags: %s can't be allowed", tag)
+		}
+	}
+	return nil
+}
+
+// DroppedWithContent is the tags that are dropped along with everything in
+// them, as their content is code or otherwise no good as text.
+var DroppedWithContent = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"noscript": true, "template": true, "textarea": true, "title": true,
+	"svg": true, "math": true, "form": true, "select": true, "frame": true,
+	"frameset": true, "noembed": true, "noframes": true, "xmp": true,
+}
+
+// AttachmentSettings is the attachments: part of config.yaml.
+type AttachmentSettings struct {
+	MaxBytes        int64 `yaml:"max_bytes"`        // Largest file that can be uploaded
+	ThumbnailWidths []int `yaml:"thumbnail_widths"` // Widths to make thumbnails of pictures in
+}
+
+// The widest thumbnail we make.
+const maxThumbnailWidth = 4000
+
+// validate checks the sizes make sense.
+func (s AttachmentSettings) validate() error {
+	if s.MaxBytes <= 0 {
+		return errors.New("attachments.max_bytes must be more than 0")
+	}
+	for _, width := range s.ThumbnailWidths {
+		if width < 1 || width > maxThumbnailWidth {
+			return fmt.Errorf("attachments.thumbnail_widths must be between 1 and %d", maxThumbnailWidth)
+		}
+	}
+	return nil
+}
+
+// MaintenanceSettings is the maintenance: part of config.yaml.
+type MaintenanceSettings struct {
+	ReadOnly bool   `yaml:"read_only"` // Start up read-only
+	Message  string `yaml:"message"`   // Shown in the banner, and with each refused write
+}
+
+// TrashSettings is the trash: part of config.yaml.
+type TrashSettings struct {
+	PurgeAfterDays int `yaml:"purge_after_days"` // 0 keeps them until purged by hand
+}
+
+func (s TrashSettings) validate() error {
+	if s.PurgeAfterDays < 0 {
+		return errors.New("trash.purge_after_days can't be negative")
+	}
+	return nil
+}
+
+// BackupSettings is the backups: part of config.yaml.
+type BackupSettings struct {
+	Dir      string        `yaml:"dir"`      // Where backups are kept, "" for no backups
+	Interval time.Duration `yaml:"interval"` // How often one is taken
+	Keep     int           `yaml:"keep"`     // How many are kept in dir
+	S3       S3Settings    `yaml:"s3"`
+}
+
+// S3Settings is where backups are uploaded, if anywhere.
+type S3Settings struct {
+	Bucket          string `yaml:"bucket"` // "" to keep backups local
+	Region          string `yaml:"region"`
+	Endpoint        string `yaml:"endpoint"` // For S3-compatible services, default AWS's for the region
+	Prefix          string `yaml:"prefix"`   // Put before each backup's name, e.g. wiki/
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+// Enabled reports whether backups are taken.
+func (s BackupSettings) Enabled() bool {
+	return s.Dir != ""
+}
+
+// validate checks the backup settings make sense.
+func (s BackupSettings) validate() error {
+	if !s.Enabled() {
+		return nil
+	}
+	switch {
+	case s.Interval < time.Minute:
+		return errors.New("backups.interval must be at least 1m")
+	case s.Keep < 1:
+		return errors.New("backups.keep must be at least 1")
+	case s.S3.Bucket != "" && s.S3.Region == "":
+		return errors.New("backups.s3.region must be set to upload to S3")
+	case s.S3.Bucket != "" && (s.S3.AccessKeyID == "" || s.S3.SecretAccessKey == ""):
+		return errors.New("backups.s3 needs access_key_id and secret_access_key")
+	}
+	return nil
+}
+
+// LimitSettings is the limits: part of config.yaml.
+type LimitSettings struct {
+	MaxBodyBytes      int64         `yaml:"max_body_bytes"`      // Largest JSON body the API takes
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"` // To send a request's headers
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`        // Keep-alive connections are closed after this
+	HandlerTimeout    time.Duration `yaml:"handler_timeout"`     // For writes, from the headers to done, 0 for none
+}
+
+func (s LimitSettings) validate() error {
+	switch {
+	case s.MaxBodyBytes < 1<<10:
+		return errors.New("limits.max_body_bytes must be at least 1024")
+	case s.ReadHeaderTimeout < time.Second:
+		return errors.New("limits.read_header_timeout must be at least 1s")
+	case s.IdleTimeout < 0 || s.HandlerTimeout < 0:
+		return errors.New("limits.idle_timeout and limits.handler_timeout can't be negative")
+	}
+	return nil
+}
+
+// StaticCacheSettings is the static_cache: part of config.yaml.
+type StaticCacheSettings struct {
+	MaxAge        time.Duration            `yaml:"max_age"`       // For files without a fingerprint, 0 to check back every time
+	Extensions    map[string]time.Duration `yaml:"extensions"`    // max_age by extension, e.g. ".woff2": 720h
+	Fingerprinted time.Duration            `yaml:"fingerprinted"` // For links with the current fingerprint, 0 to treat them like the rest
+}
+
+func (s StaticCacheSettings) validate() error {
+	if s.MaxAge < 0 || s.Fingerprinted < 0 {
+		return errors.New("static_cache.max_age and static_cache.fingerprinted can't be negative")
+	}
+	for ext, maxAge := range s.Extensions {
+		if !strings.HasPrefix(ext, ".") || maxAge < 0 {
+			return fmt.Errorf("static_cache.extensions: %q must be an extension like .css with a max age of 0 or more", ext)
+		}
+	}
+	return nil
+}
+
+// CORSSettings is the cors: part of config.yaml.
+type CORSSettings struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`   // e.g. https://app.example.com, or * for any
+	AllowedMethods   []string      `yaml:"allowed_methods"`   // What scripts may call the API with
+	AllowedHeaders   []string      `yaml:"allowed_headers"`   // Request headers scripts may send
+	ExposedHeaders   []string      `yaml:"exposed_headers"`   // Response headers scripts may read
+	AllowCredentials bool          `yaml:"allow_credentials"` // Let browsers send logins along, not with *
+	MaxAge           time.Duration `yaml:"max_age"`           // How long browsers may cache a preflight
+}
+
+func (c CORSSettings) validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return errors.New("cors.allowed_origins can't be * with allow_credentials, list the origins")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Errorf("cors.allowed_origins: %q must be * or a scheme and host like https://app.example.com", origin)
+		}
+	}
+	for _, method := range c.AllowedMethods {
+		if method == "" || method != strings.ToUpper(method) || strings.ContainsAny(method, " ,") {
+			return fmt.Errorf("cors.allowed_methods: %q must be a method like GET", method)
+		}
+	}
+	if c.MaxAge < 0 {
+		return errors.New("cors.max_age can't be negative")
+	}
+	return nil
+}
+
+// AllowsOrigin reports whether scripts from origin may call the API.
+func (c CORSSettings) AllowsOrigin(origin string) bool {
+	origin = strings.TrimSuffix(origin, "/")
+	return slices.ContainsFunc(c.AllowedOrigins, func(allowed string) bool {
+		return allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin)
+	})
+}
+
+// AllowsHeaders reports whether every header in the comma separated list
+// is one scripts may send.
+func (c CORSSettings) AllowsHeaders(list string) bool {
+	for name := range strings.SplitSeq(list, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !slices.ContainsFunc(c.AllowedHeaders, func(allowed string) bool { return strings.EqualFold(allowed, name) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// PageCreationSettings is the page_creation: part of config.yaml.
+type PageCreationSettings struct {
+	Honeypot  bool            `yaml:"honeypot"`  // Quietly drop creates with the hidden website field filled in
+	Challenge string          `yaml:"challenge"` // "", "pow" or "captcha"
+	PowBits   int             `yaml:"pow_bits"`  // Leading zero bits the work needs, each one doubles it
+	Captcha   CaptchaSettings `yaml:"captcha"`
+	PerIP     int             `yaml:"per_ip"` // Pages one address may create per window, 0 for no limit
+	Window    time.Duration   `yaml:"window"`
+}
+
+// CaptchaSettings is the CAPTCHA provider and the keys it gave us.
+type CaptchaSettings struct {
+	Provider string `yaml:"provider"` // "turnstile" or "hcaptcha"
+	SiteKey  string `yaml:"site_key"` // Goes in the page
+	Secret   string `yaml:"secret"`   // Checks the answers, keep it out of the page
+}
+
+func (s PageCreationSettings) validate() error {
+	switch s.Challenge {
+	case "":
+	case "pow":
+		if s.PowBits < 1 || s.PowBits > 32 {
+			return errors.New("page_creation.pow_bits must be between 1 and 32")
+		}
+	case "captcha":
+		if s.Captcha.Provider != "turnstile" && s.Captcha.Provider != "hcaptcha" {
+			return errors.New("page_creation.captcha.provider must be turnstile or hcaptcha")
+		}
+		if s.Captcha.SiteKey == "" || s.Captcha.Secret == "" {
+			return errors.New("page_creation.captcha needs a site_key and a secret")
+		}
+	default:
+		return errors.New("page_creation.challenge must be empty, pow or captcha")
+	}
+	if s.PerIP < 0 {
+		return errors.New("page_creation.per_ip can't be negative")
+	}
+	if s.PerIP > 0 && s.Window <= 0 {
+		return errors.New("page_creation.window must be positive with per_ip set")
+	}
+	return nil
+}
+
+// BlocklistSettings is the blocklist: part of config.yaml.
+type BlocklistSettings struct {
+	Strikes int           `yaml:"strikes"` // Abuse detections that get an address banned, 0 never to ban one automatically
+	Window  time.Duration `yaml:"window"`  // How long each one counts for
+	BanFor  time.Duration `yaml:"ban_for"` // How long an automatic ban lasts
+}
+
+func (b BlocklistSettings) validate() error {
+	if b.Strikes < 0 {
+		return errors.New("blocklist.strikes can't be negative")
+	}
+	if b.Strikes > 0 && (b.Window <= 0 || b.BanFor <= 0) {
+		return errors.New("blocklist.window and blocklist.ban_for must be positive with strikes set")
+	}
+	return nil
+}
+
+// ModerationSettings is the moderation: part of config.yaml.
+type ModerationSettings struct {
+	RejectWords  []string `yaml:"reject_words"`  // Content with any of these is refused
+	ReviewWords  []string `yaml:"review_words"`  // Content with any of these is flagged for an admin
+	CleanWords   []string `yaml:"clean_words"`   // These are starred out
+	BlockedHosts []string `yaml:"blocked_hosts"` // Hosts links mustn't go to, their subdomains included
+	BlockedLinks string   `yaml:"blocked_links"` // What's done with links to them: "reject", "review" or "clean" (the link is taken out)
+}
+
+func (m ModerationSettings) validate() error {
+	for _, list := range [][]string{m.RejectWords, m.ReviewWords, m.CleanWords} {
+		for _, word := range list {
+			if strings.TrimSpace(word) == "" {
+				return errors.New("moderation: the word lists can't have empty words")
+			}
+		}
+	}
+	for _, host := range m.BlockedHosts {
+		if host == "" || strings.ContainsAny(host, "/:@ ") {
+			return fmt.Errorf("moderation.blocked_hosts: %q must be a host name like bit.ly", host)
+		}
+	}
+	switch m.BlockedLinks {
+	case "reject", "review", "clean":
+	default:
+		return errors.New("moderation.blocked_links must be reject, review or clean")
+	}
+	return nil
+}
+
+// SiteSettings is one entry under sites: in config.yaml. Anything left out
+// (except pages_dir) is the same as for the main site.
+type SiteSettings struct {
+	Hosts         []string `yaml:"hosts"`          // Host names this site answers to
+	SiteTitle     string   `yaml:"site_title"`     // Shown in page titles, feeds and link previews
+	SiteURL       string   `yaml:"site_url"`       // Public URL, e.g. https://other.example.com
+	PagesDir      string   `yaml:"pages_dir"`      // Must be a directory of its own
+	TemplatesDir  string   `yaml:"templates_dir"`  // Every *.html in here is parsed at startup
+	StaticDir     string   `yaml:"static_dir"`     // Served at /static/
+	AdminPassword string   `yaml:"admin_password"` // Admin pages are off while this is empty
+}
+
+// TenantSettings is the tenants: part of config.yaml.
+type TenantSettings struct {
+	Enabled  bool   `yaml:"enabled"`
+	By       string `yaml:"by"`        // "path" for /t/{name}/, "host" for {name}.{domain}
+	Domain   string `yaml:"domain"`    // Parent domain of tenant hosts, with by: host
+	Dir      string `yaml:"dir"`       // Holds a directory per tenant
+	MaxPages int    `yaml:"max_pages"` // Pages a tenant may have, 0 for no limit
+	MaxBytes int64  `yaml:"max_bytes"` // Disk space a tenant may use, 0 for no limit
+	// The admin password of every tenant, not the main site's. Admin
+	// pages are off while this is empty.
+	AdminPassword string `yaml:"admin_password"`
+}
+
+// validateRoles checks the roles: part of config.yaml.
+func validateRoles(roles map[string][]string) error {
+	for role, users := range roles {
+		if role == "" || strings.ContainsAny(role, "@ ,") {
+			return fmt.Errorf("roles: %q must be a name without @, spaces or commas", role)
+		}
+		if slices.Contains(users, "") {
+			return fmt.Errorf("roles.%s: users can't be empty", role)
+		}
+	}
+	return nil
+}
+
+// ParseTrustedProxies reads trusted_proxies, a list of IPs and CIDR ranges.
+func ParseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("trusted_proxies: %q is not an IP or CIDR range", entry)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,185 @@
+package handlers
+
+//The access log: one line per request, every route including static files,
+//in Apache's combined format or as JSON. It goes to stdout or a file, and
+//the file is reopened on SIGHUP so logrotate can move it away.
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"go-trailer/internal/config"
+)
+
+// accessLog is where a Server's access log lines go. Writes take turns so
+// lines from concurrent requests don't interleave.
+type accessLog struct {
+	sync.Mutex
+	out  io.Writer
+	file *os.File // Set when logging to a file, so it can be reopened
+}
+
+// open opens the configured destination, closing any previous file.
+func (l *accessLog) open(settings config.AccessLogSettings) error {
+	l.Lock()
+	defer l.Unlock()
+
+	if settings.Path == "" || settings.Path == "-" {
+		l.out = os.Stdout
+		return nil
+	}
+	f, err := os.OpenFile(settings.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.out, l.file = f, f
+	return nil
+}
+
+// close closes the file, if we're logging to one.
+func (l *accessLog) close() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.out, l.file = io.Discard, nil
+	return err
+}
+
+// reopenOnHUP reopens the access log file whenever we get a SIGHUP, until
+// ctx is done.
+func (l *accessLog) reopenOnHUP(ctx context.Context, settings config.AccessLogSettings) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		if err := l.open(settings); err != nil {
+			slog.Error("Error reopening access log", "path", settings.Path, "err", err)
+			continue
+		}
+		slog.Info("Reopened access log", "path", settings.Path)
+	}
+}
+
+// accessRecorder remembers the status and size of a response.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (a *accessRecorder) WriteHeader(code int) {
+	if a.status == 0 {
+		a.status = code
+	}
+	a.ResponseWriter.WriteHeader(code)
+}
+
+func (a *accessRecorder) Write(b []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := a.ResponseWriter.Write(b)
+	a.bytes += int64(n)
+	return n, err
+}
+
+func (a *accessRecorder) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// accessEntry is one access log line, in JSON format.
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	RemoteIP  string    `json:"remote_ip"`
+	User      string    `json:"user,omitempty"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Duration  float64   `json:"duration_ms"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// combined formats the entry like Apache's combined log format.
+func (e accessEntry) combined() string {
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Sprintf("%s - %s [%s] %s %d %s %s %s\n",
+		e.RemoteIP, dash(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(e.Method+" "+e.URI+" "+e.Proto), e.Status, size,
+		strconv.Quote(dash(e.Referer)), strconv.Quote(dash(e.UserAgent)))
+}
+
+// withAccessLog writes an access log line for every request, when enabled.
+func (srv *Server) withAccessLog(next http.Handler) http.Handler {
+	settings := srv.config.AccessLog
+	if !settings.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		entry := accessEntry{
+			Time:      start,
+			RemoteIP:  remoteIP(r),
+			Method:    r.Method,
+			URI:       r.RequestURI,
+			Proto:     r.Proto,
+			Status:    cmp.Or(rec.status, http.StatusOK),
+			Bytes:     rec.bytes,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			Duration:  float64(time.Since(start).Microseconds()) / 1000,
+			RequestID: requestID(r.Context()),
+		}
+		entry.User, _, _ = r.BasicAuth()
+
+		line := entry.combined()
+		if settings.Format == "json" {
+			data, _ := json.Marshal(entry)
+			line = string(data) + "\n"
+		}
+		srv.accessLog.Lock()
+		_, err := io.WriteString(srv.accessLog.out, line)
+		srv.accessLog.Unlock()
+		if err != nil && !errors.Is(err, os.ErrClosed) {
+			slog.Error("Error writing access log", "err", err)
+		}
+	})
+}
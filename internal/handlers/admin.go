@@ -0,0 +1,160 @@
+package handlers
+
+//Admin pages: bulk moderation of comments, and what visitors search for.
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"go-trailer/internal/storage"
+)
+
+// How many comments the moderation view shows at once.
+const adminCommentsPerPage = 50
+
+// moderatedComment is a comment along with the page it's on.
+type moderatedComment struct {
+	Comment
+	Slug string
+}
+
+// Key is what the moderation form sends back to identify a comment.
+func (c moderatedComment) Key() string {
+	return c.Slug + "/" + c.ID
+}
+
+// adminCommentsHandler serves /admin/comments. GET shows comments with a
+// given ?status= (pending by default), POST applies a bulk action to the
+// selected ones.
+func (srv *Server) adminCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		moderateComments(w, r)
+		return
+	}
+
+	statuses := []string{commentPending, commentSpam, commentApproved}
+	status := r.URL.Query().Get("status")
+	if !slices.Contains(statuses, status) {
+		status = commentPending
+	}
+
+	slugs, err := storage.PageSlugs(storeCtx(r.Context()))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading pages directory", "err", err)
+		http.Error(w, "Could not list comments", http.StatusInternalServerError)
+		return
+	}
+	var matches []moderatedComment
+	for _, slug := range slugs {
+		comments, err := loadComments(r.Context(), slug)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading comments", "page", slug, "err", err)
+			continue
+		}
+		for _, c := range comments {
+			if c.Status == status {
+				matches = append(matches, moderatedComment{Comment: c, Slug: slug})
+			}
+		}
+	}
+	// Newest first, that's where the fresh spam is
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].CreatedAt.After(matches[j].CreatedAt)
+	})
+
+	view := buildAdminView(status, statuses, matches, commentPageNumber(r))
+	if err := renderTemplate(r.Context(), w, "admin_comments.html", view); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing admin comments template", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// moderateComments applies the form's action (approve, spam or delete) to
+// every selected comment, then sends the moderator back where they were.
+func moderateComments(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	action := r.PostForm.Get("action")
+	if action != "approve" && action != "spam" && action != "delete" {
+		http.Error(w, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
+	// Group the selected comments by page so each file is rewritten once
+	selected := make(map[string][]string)
+	for _, key := range r.PostForm["id"] {
+		slug, id, ok := strings.Cut(key, "/")
+		if ok {
+			selected[slug] = append(selected[slug], id)
+		}
+	}
+
+	var feedback []moderatedComment // Verdicts to pass on to the spam checker
+	siteOf(r.Context()).commentsMu.Lock()
+	for slug, ids := range selected {
+		comments, err := loadComments(r.Context(), slug)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading comments", "page", slug, "err", err)
+			continue
+		}
+		var kept []Comment
+		for _, c := range comments {
+			if !slices.Contains(ids, c.ID) {
+				kept = append(kept, c)
+				continue
+			}
+			switch action {
+			case "delete":
+				continue
+			case "approve":
+				if c.Status != commentApproved {
+					feedback = append(feedback, moderatedComment{Comment: c, Slug: slug})
+				}
+				c.Status = commentApproved
+			case "spam":
+				if c.Status != commentSpam {
+					feedback = append(feedback, moderatedComment{Comment: c, Slug: slug})
+				}
+				c.Status = commentSpam
+			}
+			kept = append(kept, c)
+		}
+		if err := saveComments(r.Context(), slug, kept); err != nil {
+			slog.ErrorContext(r.Context(), "Error writing comments", "page", slug, "err", err)
+		}
+	}
+	siteOf(r.Context()).commentsMu.Unlock()
+	slog.InfoContext(r.Context(), "Comments moderated", "action", action, "pages", len(selected))
+	for slug := range selected {
+		purgePage(r.Context(), slug)
+	}
+
+	// Tell the spam checker, outside the lock since it's a network call
+	if reporter, ok := serverOf(r.Context()).spamFilter.(spamReporter); ok {
+		for _, c := range feedback {
+			if err := reporter.Report(c.Comment, siteBaseURL(r)+pagePath(c.Slug), action == "spam"); err != nil {
+				slog.ErrorContext(r.Context(), "Error reporting comment to spam checker", "comment", c.ID, "err", err)
+			}
+		}
+	}
+
+	http.Redirect(w, r, sitePath(r.Context(), "/admin/comments?status="+r.PostForm.Get("status")), http.StatusSeeOther)
+}
+
+// adminSearchHandler serves /admin/search, the queries visitors searched for
+// most, starting with the ones that found nothing.
+func (srv *Server) adminSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if !siteOf(r.Context()).main {
+		http.NotFound(w, r) // Search stats are only kept for the main site
+		return
+	}
+	if err := renderTemplate(r.Context(), w, "admin_search.html", buildAdminSearchView(r.Context())); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing admin search template", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,348 @@
+package handlers
+
+//Housekeeping for big sites: /admin/pages lists every page, drafts and all,
+//with what we know about it, and takes bulk actions on a list of pages.
+//Deleted pages go to the trash, see trash.go. Unlike
+//vote batches these aren't all or nothing; each page is done if it can be,
+//and the results say how each one went.
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go-trailer/internal/slugs"
+	"go-trailer/internal/storage"
+)
+
+// Most pages one bulk action can take.
+const maxBulkPages = 500
+
+// adminPageItem is a page in the /admin/pages list.
+type adminPageItem struct {
+	Slug      string    `json:"slug"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	Tags      []string  `json:"tags"`
+	Draft     bool      `json:"draft"`
+	Private   bool      `json:"private"`
+	Archived  bool      `json:"archived"`
+	Flagged   string    `json:"flagged,omitempty"` // Why moderation flagged it
+	Created   time.Time `json:"created,omitzero"`
+	Updated   time.Time `json:"updated"` // When the file last changed
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	Bytes     int       `json:"bytes"`
+}
+
+// bulkRequest is the body of a POST to /admin/pages. Retagging sets the
+// pages' tags to Tags if it's given, or else keeps theirs, then adds Add and
+// takes away Remove.
+type bulkRequest struct {
+	Action string    `json:"action"` // "delete" (to the trash), "retag", "approve" (clearing moderation flags) or "export"
+	Slugs  []string  `json:"slugs"`
+	Tags   *[]string `json:"tags"`
+	Add    []string  `json:"add"`
+	Remove []string  `json:"remove"`
+}
+
+// bulkResult is how the action went for one page.
+type bulkResult struct {
+	Slug   string   `json:"slug"`
+	Status string   `json:"status"`          // "ok", "not_found" or "error"
+	Error  string   `json:"error,omitempty"` // What went wrong
+	Tags   []string `json:"tags,omitempty"`  // The page's tags after a retag
+}
+
+// adminPagesHandler serves /admin/pages. GET lists every page in slug order,
+// paged like /api/pages, or with ?flagged=1 just the ones moderation flagged;
+// POST takes a bulkRequest and answers with
+// {"results": [...]}, in the order the slugs were given, or for an export a
+// zip of the pages' files with results.json in it.
+func (srv *Server) adminPagesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		items, err := listAdminPageItems(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading pages directory", "err", err)
+			http.Error(w, "Could not list pages", http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("flagged") != "" {
+			items = slices.DeleteFunc(items, func(p adminPageItem) bool { return p.Flagged == "" })
+		}
+		writeListPage(w, r, items, func(p adminPageItem) listCursor { return listCursor{Key: p.Slug} })
+	case http.MethodPost:
+		bulkPagesHandler(w, r)
+	default:
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+	}
+}
+
+// listAdminPageItems is every page, in slug order.
+func listAdminPageItems(ctx context.Context) ([]adminPageItem, error) {
+	slugs, err := storage.PageSlugs(storeCtx(ctx))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(slugs)
+	now := time.Now()
+	items := make([]adminPageItem, 0, len(slugs))
+	for _, slug := range slugs {
+		text, err := storeCtx(ctx).ReadFile(slug + ".txt")
+		if err != nil {
+			continue // Removed while we were listing
+		}
+		modTime, err := pageModTime(ctx, slug)
+		if err != nil {
+			continue
+		}
+		meta, err := loadPageMeta(ctx, slug)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error reading page meta", "page", slug, "err", err) // List it anyway
+		}
+		fm, _, _ := splitFrontMatter(string(text))
+		items = append(items, adminPageItem{
+			Slug:      slug,
+			Title:     pageTitle(ctx, slug),
+			URL:       sitePath(ctx, pagePath(slug)),
+			Tags:      tagsOrEmpty(fm.Tags),
+			Draft:     meta.hidden(now),
+			Private:   meta.Private,
+			Archived:  meta.archived(now),
+			Flagged:   meta.Flagged,
+			Created:   meta.Created,
+			Updated:   modTime.UTC(),
+			UpdatedBy: meta.UpdatedBy,
+			Bytes:     len(text),
+		})
+	}
+	return items, nil
+}
+
+// tagsOrEmpty is tags, or [] rather than null.
+func tagsOrEmpty(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// bulkPagesHandler does a bulk action on the pages asked for.
+func bulkPagesHandler(w http.ResponseWriter, r *http.Request) {
+	var req bulkRequest
+	if err := readJSON(w, r, &req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if len(req.Slugs) == 0 {
+		http.Error(w, "No pages given", http.StatusBadRequest)
+		return
+	}
+	if len(req.Slugs) > maxBulkPages {
+		http.Error(w, fmt.Sprintf("Too many pages, send at most %d at a time", maxBulkPages), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var do func(ctx context.Context, slug string) bulkResult
+	switch req.Action {
+	case "delete":
+		editor := editorName(r)
+		do = func(ctx context.Context, slug string) bulkResult { return deletePageResult(ctx, slug, editor) }
+	case "retag":
+		if req.Tags == nil && len(req.Add) == 0 && len(req.Remove) == 0 {
+			http.Error(w, "Give tags, add or remove to retag", http.StatusBadRequest)
+			return
+		}
+		editor := editorName(r)
+		do = func(ctx context.Context, slug string) bulkResult { return retagPage(ctx, slug, req, editor) }
+	case "approve":
+		do = approvePage
+	case "export":
+		exportPages(w, r, req.Slugs)
+		return
+	default:
+		http.Error(w, "Action must be delete, retag, approve or export", http.StatusBadRequest)
+		return
+	}
+
+	results := make([]bulkResult, len(req.Slugs))
+	changed := 0
+	for i, slug := range req.Slugs {
+		if !slugs.Valid(slug) {
+			results[i] = bulkResult{Slug: slug, Status: "error", Error: "invalid page"}
+			continue
+		}
+		results[i] = do(r.Context(), slug)
+		if results[i].Status == "ok" {
+			changed++
+			purgePage(r.Context(), slug)
+		}
+	}
+	if changed > 0 {
+		purgeListings(r.Context())
+	}
+	slog.InfoContext(r.Context(), "Bulk page action done", "action", req.Action, "pages", len(req.Slugs), "changed", changed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"results": results})
+}
+
+// deletePageResult moves a page to the trash for a bulk delete.
+func deletePageResult(ctx context.Context, slug, editor string) bulkResult {
+	siteOf(ctx).createMu.Lock()
+	err := deletePage(ctx, slug, editor)
+	siteOf(ctx).createMu.Unlock()
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return bulkResult{Slug: slug, Status: "not_found"}
+	case err != nil:
+		slog.ErrorContext(ctx, "Error deleting page", "page", slug, "err", err)
+		return bulkResult{Slug: slug, Status: "error", Error: "could not delete page"}
+	}
+	slog.InfoContext(ctx, "Page moved to the trash", "page", slug)
+	return bulkResult{Slug: slug, Status: "ok"}
+}
+
+// approvePage clears a page's moderation flag for a bulk approve.
+func approvePage(ctx context.Context, slug string) bulkResult {
+	if !pageExists(ctx, slug) {
+		return bulkResult{Slug: slug, Status: "not_found"}
+	}
+	siteOf(ctx).pageMetaMu.Lock()
+	defer siteOf(ctx).pageMetaMu.Unlock()
+	meta, err := loadPageMeta(ctx, slug)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading page meta", "page", slug, "err", err)
+		return bulkResult{Slug: slug, Status: "error", Error: "could not read page meta"}
+	}
+	if meta.Flagged == "" {
+		return bulkResult{Slug: slug, Status: "ok"} // Nothing to do
+	}
+	meta.Flagged = ""
+	if err := savePageMeta(ctx, slug, meta); err != nil {
+		slog.ErrorContext(ctx, "Error writing page meta", "page", slug, "err", err)
+		return bulkResult{Slug: slug, Status: "error", Error: "could not save page meta"}
+	}
+	return bulkResult{Slug: slug, Status: "ok"}
+}
+
+// suffixed is slug with each of the suffixes.
+func suffixed(slug string, suffixes []string) []string {
+	names := make([]string, len(suffixes))
+	for i, suffix := range suffixes {
+		names[i] = slug + suffix
+	}
+	return names
+}
+
+// retagPage changes a page's tags for a bulk retag.
+func retagPage(ctx context.Context, slug string, req bulkRequest, editor string) bulkResult {
+	siteOf(ctx).createMu.Lock()
+	defer siteOf(ctx).createMu.Unlock()
+	pages := storeCtx(ctx)
+	text, err := pages.ReadFile(slug + ".txt")
+	if errors.Is(err, fs.ErrNotExist) {
+		return bulkResult{Slug: slug, Status: "not_found"}
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading page", "page", slug, "err", err)
+		return bulkResult{Slug: slug, Status: "error", Error: "could not read page"}
+	}
+	fm, _, err := splitFrontMatter(string(text))
+	if err != nil {
+		return bulkResult{Slug: slug, Status: "error", Error: err.Error()}
+	}
+
+	tags := fm.Tags
+	if req.Tags != nil {
+		tags = *req.Tags
+	}
+	tags = slices.Clone(tags)
+	for _, tag := range req.Add {
+		if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	tags = slices.DeleteFunc(tags, func(tag string) bool {
+		return strings.TrimSpace(tag) == "" || slices.Contains(req.Remove, tag)
+	})
+	if slices.Equal(tags, fm.Tags) {
+		return bulkResult{Slug: slug, Status: "ok", Tags: tagsOrEmpty(tags)} // Nothing to do
+	}
+
+	retagged, err := setFrontMatterTags(string(text), tags)
+	if err != nil {
+		return bulkResult{Slug: slug, Status: "error", Error: err.Error()}
+	}
+	if err := pages.WriteFile(slug+".txt", []byte(retagged)); err != nil {
+		slog.ErrorContext(ctx, "Error writing page", "page", slug, "err", err)
+		return bulkResult{Slug: slug, Status: "error", Error: "could not save page"}
+	}
+	if err := recordPageEdit(ctx, slug, editor); err != nil {
+		slog.ErrorContext(ctx, "Error writing page meta", "page", slug, "err", err) // The tags are saved
+	}
+	return bulkResult{Slug: slug, Status: "ok", Tags: tagsOrEmpty(tags)}
+}
+
+// exportPages sends a zip of the pages' files, each page in a directory of
+// its own, and results.json saying how each page went.
+func exportPages(w http.ResponseWriter, r *http.Request, chosen []string) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="pages.zip"`)
+	zw := zip.NewWriter(w)
+	pages := storeCtx(r.Context())
+
+	results := make([]bulkResult, len(chosen))
+	for i, slug := range chosen {
+		results[i] = bulkResult{Slug: slug, Status: "ok"}
+		if !slugs.Valid(slug) {
+			results[i].Status, results[i].Error = "error", "invalid page"
+			continue
+		}
+		if !pageExists(r.Context(), slug) {
+			results[i].Status = "not_found"
+			continue
+		}
+		attachments, err := attachmentFiles(r.Context(), slug)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error listing attachments", "page", slug, "err", err)
+			results[i].Status, results[i].Error = "error", "could not list attachments"
+			continue
+		}
+		for _, name := range slices.Concat([]string{slug + ".txt"}, suffixed(slug, pageCompanionSuffixes), attachments) {
+			data, err := pages.ReadFile(name)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // Not every page has votes and the like
+			}
+			if err == nil {
+				modTime, _ := pages.ModTime(name)
+				err = addZipFile(zw, slug+"/"+name, data, modTime)
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error exporting page file", "file", name, "err", err)
+				results[i].Status, results[i].Error = "error", "could not export "+name
+				break
+			}
+		}
+	}
+
+	f, err := zw.Create("results.json")
+	if err == nil {
+		err = json.NewEncoder(f).Encode(map[string]any{"results": results})
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error writing export", "err", err) // Too late for a status
+		return
+	}
+	slog.InfoContext(r.Context(), "Pages exported", "pages", len(chosen))
+}
@@ -0,0 +1,98 @@
+package handlers
+
+//The JSON API lives under /api/v1/, named after what it's about:
+//
+//	/api/v1/pages                                GET, every page; POST, create one
+//	/api/v1/pages/{slug}                         GET, the page
+//	/api/v1/pages/{slug}/videos                  POST, add a video
+//	/api/v1/pages/{slug}/{publish,edit,...}      the rest of a page's API
+//	/api/v1/videos?page={slug}                   GET, a page's videos
+//	/api/v1/votes/{slug}/{video}/{up,down}vote   POST
+//	/api/v1/votes/batch                          POST
+//	/api/v1/comments, /api/v1/comments/{slug}    GET a page's, POST one
+//	/api/v1/changes, search, popular, preview, challenge
+//
+//Handlers were written for the paths from before, which still work but
+//answer with Deprecation: true and a Link to their /api/v1/ successor.
+//Requests for /api/v1/ are routed by rewriting them to those paths, so a
+//later /api/v2/ can change what it likes and leave v1 be.
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// apiAlias is a /api/v1/ path and the older one its handler answers to.
+// Prefixes end in /, and are swapped for each other; others are exact.
+type apiAlias struct {
+	v1, old string
+}
+
+// Every /api/v1/ path, most specific first.
+var apiAliases = []apiAlias{
+	{"/api/v1/pages/", "/api/page/"},
+	{"/api/v1/pages", "/api/pages"},
+	{"/api/v1/changes", "/api/changes"},
+	{"/api/v1/videos", "/api/videos"},
+	{"/api/v1/votes/batch", "/api/vote/batch"},
+	{"/api/v1/votes/", "/api/vote/"},
+	{"/api/v1/comments/", "/api/comments/"},
+	{"/api/v1/comments", "/api/comments"},
+	{"/api/v1/search", "/api/search"},
+	{"/api/v1/popular", "/api/popular"},
+	{"/api/v1/preview", "/api/preview"},
+	{"/api/v1/challenge", "/api/challenge"},
+}
+
+// translateAPIPath is path with one kind of API path swapped for the other,
+// old for v1 with toV1 set and v1 for old without.
+func translateAPIPath(path string, toV1 bool) (string, bool) {
+	for _, alias := range apiAliases {
+		from, to := alias.v1, alias.old
+		if toV1 {
+			from, to = alias.old, alias.v1
+		}
+		rest, ok := strings.CutPrefix(path, from)
+		if !ok || (rest != "" && !strings.HasSuffix(from, "/")) {
+			continue
+		}
+		// Adding a video was the page API's catch-all, it's named now
+		if strings.HasSuffix(from, "/page/") || strings.HasSuffix(from, "/pages/") {
+			if slug, action, _ := strings.Cut(rest, "/"); toV1 && action == "save-youtube" {
+				rest = slug + "/videos"
+			} else if !toV1 && action == "videos" {
+				rest = slug + "/save-youtube"
+			}
+		}
+		return to + rest, true
+	}
+	return "", false
+}
+
+// apiV1Handler serves /api/v1/ by rewriting each request to the older path
+// and handing it back to the mux.
+func (srv *Server) apiV1Handler(w http.ResponseWriter, r *http.Request) {
+	old, ok := translateAPIPath(r.URL.Path, false)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	u := *r.URL
+	u.Path, u.RawPath = old, ""
+	r = r.WithContext(r.Context())
+	r.URL = &u
+	srv.mux.ServeHTTP(w, r)
+}
+
+// deprecateOldAPIPaths marks requests for the paths from before /api/v1/
+// deprecated, pointing them at what replaced them.
+func deprecateOldAPIPaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v1, ok := translateAPIPath(r.URL.Path, true); ok {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, sitePath(r.Context(), v1)))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
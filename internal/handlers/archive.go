@@ -0,0 +1,182 @@
+package handlers
+
+//Page expiry: a page can be given an expires_at time, after which it's moved
+//to the archive. Archived pages drop out of the homepage, feeds, search and
+//the sitemap, but can still be read at /archive/{slug}, and /archive lists
+//them all. Like scheduled publishing, pages count as archived the moment
+//they expire and the scheduler makes it stick in the meta file.
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"go-trailer/internal/storage"
+)
+
+// archived reports whether a page is in the archive at the given time.
+func (m PageMeta) archived(now time.Time) bool {
+	return !m.Archived.IsZero() || (!m.ExpiresAt.IsZero() && !now.Before(m.ExpiresAt))
+}
+
+// archivedAt is when the page went into the archive.
+func (m PageMeta) archivedAt() time.Time {
+	if m.Archived.IsZero() {
+		return m.ExpiresAt // Expired, but the scheduler hasn't got to it yet
+	}
+	return m.Archived
+}
+
+// archivePath is where an archived page is served.
+func archivePath(slug string) string {
+	return "/archive/" + pagePath(slug)[len("/page/"):]
+}
+
+// archiveDue moves the site's expired pages into the archive.
+func archiveDue(ctx context.Context) {
+	slugs, err := storage.PageSlugs(storeCtx(ctx))
+	if err != nil {
+		slog.Error("Error listing pages to archive", "err", err)
+		return
+	}
+	now := time.Now()
+	for _, slug := range slugs {
+		meta, err := loadPageMeta(ctx, slug)
+		if err != nil || !meta.Archived.IsZero() || !meta.archived(now) {
+			continue
+		}
+		if err := archiveExpired(ctx, slug); err != nil {
+			slog.Error("Error archiving expired page", "page", slug, "err", err)
+			continue
+		}
+		slog.Info("Expired page archived", "page", slug)
+		purgePage(ctx, slug)
+		purgeListings(ctx)
+	}
+}
+
+// archiveExpired marks an expired page as archived in its meta file.
+func archiveExpired(ctx context.Context, slug string) error {
+	siteOf(ctx).pageMetaMu.Lock()
+	defer siteOf(ctx).pageMetaMu.Unlock()
+	meta, err := loadPageMeta(ctx, slug)
+	if err != nil {
+		return err
+	}
+	if !meta.archived(time.Now()) {
+		return nil // Given more time while we weren't looking
+	}
+	meta.Archived = meta.archivedAt()
+	return savePageMeta(ctx, slug, meta)
+}
+
+// ArchivedPage is a page in the archive listing.
+type ArchivedPage struct {
+	Slug     string
+	Title    string
+	Archived time.Time
+}
+
+// archiveHandler serves /archive, the list of archived pages, and
+// /archive/{slug} for the pages themselves.
+func (srv *Server) archiveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/archive/" {
+		servePage(w, r, "/archive/")
+		return
+	}
+
+	slugs, err := storage.PageSlugs(storeCtx(r.Context()))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading pages directory", "err", err)
+		http.Error(w, "Could not list pages", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	var pages []ArchivedPage
+	for _, slug := range slugs {
+		meta, err := loadPageMeta(r.Context(), slug)
+		if err != nil || !meta.archived(now) || meta.restricted(now) {
+			continue
+		}
+		pages = append(pages, ArchivedPage{Slug: slug, Title: pageTitle(r.Context(), slug), Archived: meta.archivedAt()})
+	}
+	slices.SortFunc(pages, func(a, b ArchivedPage) int { return b.Archived.Compare(a.Archived) })
+
+	if err := renderTemplate(r.Context(), w, "archive.html", buildArchiveView(pages)); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing archive template", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// expireRequest is the body of POST /api/page/{slug}/expire.
+type expireRequest struct {
+	ExpiresAt string `json:"expires_at"`
+}
+
+// expireHandler handles POST /api/page/{slug}/expire with a JSON body of
+// {"expires_at": "2025-01-02T15:04:05Z"}, to archive the page then. An empty
+// expires_at means never, and brings an archived page back out.
+func (srv *Server) expireHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	slug, ok := editablePage(w, r, "expire")
+	if !ok {
+		return
+	}
+
+	var reqBody expireRequest
+	if err := readJSON(w, r, &reqBody); err != nil {
+		badJSON(w, err)
+		return
+	}
+	expiresAt, err := parseExpiresAt(reqBody.ExpiresAt)
+	if err != nil {
+		fieldError(w, "expires_at", "invalid_time", err.Error())
+		return
+	}
+
+	siteOf(r.Context()).pageMetaMu.Lock()
+	defer siteOf(r.Context()).pageMetaMu.Unlock()
+	meta, err := loadPageMeta(r.Context(), slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading page meta", "err", err)
+		apiError(w, "Could not save expiry", http.StatusInternalServerError)
+		return
+	}
+	meta.ExpiresAt, meta.Archived = expiresAt, time.Time{}
+	meta.edited(editorName(r))
+	if err := savePageMeta(r.Context(), slug, meta); err != nil {
+		if quotaError(w, err) {
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error writing page meta", "err", err)
+		apiError(w, "Could not save expiry", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Page expiry set", "expires_at", expiresAt)
+	purgePage(r.Context(), slug)
+	purgeListings(r.Context())
+	w.Write([]byte("Expiry saved!"))
+}
+
+// parseExpiresAt reads an expires_at time, which must be in the future.
+// Empty is fine, and means the page never expires.
+func parseExpiresAt(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.New("expires_at must be a time like 2025-01-02T15:04:05Z")
+	}
+	if !t.After(time.Now()) {
+		return time.Time{}, errors.New("expires_at must be in the future")
+	}
+	return t.UTC(), nil
+}
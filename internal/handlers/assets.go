@@ -0,0 +1,158 @@
+package handlers
+
+//Fingerprinted static files. Every file in a site's static directory is
+//hashed at startup, and templates link to them with {{static "styles.css"}},
+//which is /static/styles.3f2a9c01be.css: the hash changes whenever the file
+//does, so browsers and the CDN can keep a copy forever and still get the new
+//one after a deploy. /static/styles.css keeps working for anything linking
+//to it directly.
+//
+//How long static files may be cached is static_cache: in config.yaml, by
+//extension, with fingerprinted links cached for a year as immutable.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"go-trailer/internal/config"
+)
+
+// staticCacheControl is the Cache-Control header for the static file name,
+// served by its current fingerprint or not.
+func staticCacheControl(s config.StaticCacheSettings, name string, fingerprinted bool) (string, time.Duration) {
+	if fingerprinted && s.Fingerprinted > 0 {
+		return fmt.Sprintf("public, max-age=%d, immutable", int(s.Fingerprinted.Seconds())), s.Fingerprinted
+	}
+	maxAge, ok := s.Extensions[strings.ToLower(path.Ext(name))]
+	if !ok {
+		maxAge = s.MaxAge
+	}
+	if maxAge == 0 {
+		return "public, no-cache", 0
+	}
+	return fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())), maxAge
+}
+
+// fingerprintedRegex splits /static/ paths with a fingerprint into the file's
+// name and the fingerprint.
+var fingerprintedRegex = regexp.MustCompile(`^(.*)\.([0-9a-f]{10})(\.[^./]+)$`)
+
+// hashStatic fingerprints every file under dir, by their slash separated
+// paths from it.
+func hashStatic(dir string) (map[string]string, error) {
+	fingerprints := make(map[string]string)
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		fingerprint, err := fingerprintFile(file)
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(dir, file)
+		fingerprints[filepath.ToSlash(name)] = fingerprint
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return fingerprints, nil // Nothing to serve is fine
+	}
+	return fingerprints, err
+}
+
+// fingerprintFile is the start of the hash of a file's contents.
+func fingerprintFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:10], nil
+}
+
+// fingerprint is the static file name's fingerprint, "" if there's no such
+// file. In -dev mode it's worked out afresh, as the file may have changed.
+func (s *site) fingerprint(name string) string {
+	if s.srv.config.Dev {
+		fingerprint, _ := fingerprintFile(filepath.Join(s.staticDir, filepath.FromSlash(name)))
+		return fingerprint
+	}
+	return s.fingerprints[name]
+}
+
+// staticPath is where the site serves the static file name, with its
+// fingerprint if it has one.
+func (s *site) staticPath(name string) string {
+	fingerprint := s.fingerprint(name)
+	if fingerprint == "" {
+		return s.basePath + "/static/" + name
+	}
+	ext := path.Ext(name)
+	return s.basePath + "/static/" + name[:len(name)-len(ext)] + "." + fingerprint + ext
+}
+
+// staticHandler serves the files in the site's static directory, with or
+// without their fingerprints. One with an old fingerprint gets the file as it
+// is now, for pages cached from before a deploy.
+func (srv *Server) staticHandler(w http.ResponseWriter, r *http.Request) {
+	s := siteOf(r.Context())
+	fingerprinted := false
+	if m := fingerprintedRegex.FindStringSubmatch(r.URL.Path); m != nil {
+		name := m[1] + m[3]
+		if fingerprint := s.fingerprint(name[len("/static/"):]); fingerprint != "" {
+			fingerprinted = fingerprint == m[2]
+			r2 := *r
+			u := *r.URL
+			u.Path, u.RawPath = name, ""
+			r2.URL = &u
+			r = &r2
+		}
+	}
+	cacheControl, maxAge := staticCacheControl(srv.config.StaticCache, r.URL.Path, fingerprinted)
+	s.static.ServeHTTP(&staticCacheWriter{ResponseWriter: w, cacheControl: cacheControl, maxAge: maxAge}, r)
+}
+
+// staticCacheWriter sets Cache-Control and Expires on static files that
+// were found, leaving errors uncached.
+type staticCacheWriter struct {
+	http.ResponseWriter
+	cacheControl string
+	maxAge       time.Duration
+	wroteHeader  bool
+}
+
+func (c *staticCacheWriter) WriteHeader(code int) {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		if code == http.StatusOK || code == http.StatusPartialContent || code == http.StatusNotModified {
+			h := c.ResponseWriter.Header()
+			h.Set("Cache-Control", c.cacheControl)
+			h.Set("Expires", time.Now().Add(c.maxAge).UTC().Format(http.TimeFormat))
+		}
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *staticCacheWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *staticCacheWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
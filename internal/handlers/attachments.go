@@ -0,0 +1,367 @@
+package handlers
+
+//Attachments: files uploaded to a page. They're kept in the store next to the
+//page as {slug}@{name}, and served at /page/{slug}/files/{name}. Pictures get
+//thumbnails made as they're uploaded, one for each of the widths under
+//attachments.thumbnail_widths, kept as {slug}@{width}@{name} and served at
+///page/{slug}/thumbs/{width}/{name}, so pages can show a small picture that
+//links to the big one instead of sending the whole thing every time.
+//Attachments can be listed and deleted through the same API they're uploaded
+//with.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // So thumbnails can be made of GIFs too
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Limits on the pictures thumbnails are made from, so a small file can't
+// unpack into more pixels than we want to go through, and how good the
+// thumbnails look.
+const (
+	maxPicturePixels = 50_000_000
+	thumbnailQuality = 85
+)
+
+// What an attachment may be called: a name and an extension, with nothing
+// that means something in a path, URL or store name (like @).
+var attachmentNameRegex = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} ._-]*\.[\p{L}\p{N}]+$`)
+
+// attachmentFile is where an attachment is kept in the store.
+func attachmentFile(slug, name string) string {
+	return slug + "@" + name
+}
+
+// thumbnailFile is where a thumbnail of an attachment is kept.
+func thumbnailFile(slug, name string, width int) string {
+	return slug + "@" + strconv.Itoa(width) + "@" + name
+}
+
+// attachmentPath is where an attachment is served.
+func attachmentPath(slug, name string) string {
+	return pagePath(slug) + "/files/" + url.PathEscape(name)
+}
+
+// thumbnailPath is where a thumbnail of an attachment is served.
+func thumbnailPath(slug, name string, width int) string {
+	return pagePath(slug) + "/thumbs/" + strconv.Itoa(width) + "/" + url.PathEscape(name)
+}
+
+// Attachment is a file uploaded to a page. Thumbnails are by width, for
+// pictures; widths the picture is already smaller than point at the picture.
+type Attachment struct {
+	Name       string            `json:"name"`
+	URL        string            `json:"url"`
+	Size       int64             `json:"size"`
+	Thumbnails map[string]string `json:"thumbnails,omitempty"`
+}
+
+// makeThumbnails saves a thumbnail of a picture for each configured width,
+// returning their URLs. Files that aren't pictures we can read get none.
+func makeThumbnails(ctx context.Context, slug, name string, data []byte) (map[string]string, error) {
+	config := configOf(ctx)
+	if len(config.Attachments.ThumbnailWidths) == 0 {
+		return nil, nil
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil // Not a picture, or not one we know
+	}
+	if cfg.Width*cfg.Height > maxPicturePixels {
+		return nil, fmt.Errorf("picture is too big to make thumbnails of, at %dx%d", cfg.Width, cfg.Height)
+	}
+	picture, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnails := make(map[string]string)
+	for _, width := range config.Attachments.ThumbnailWidths {
+		if width >= cfg.Width {
+			thumbnails[strconv.Itoa(width)] = sitePath(ctx, attachmentPath(slug, name))
+			continue
+		}
+		var out bytes.Buffer
+		thumbnail := shrinkImage(picture, width)
+		if format == "jpeg" {
+			err = jpeg.Encode(&out, thumbnail, &jpeg.Options{Quality: thumbnailQuality})
+		} else {
+			err = png.Encode(&out, thumbnail) // Keeps any transparency
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := storeCtx(ctx).WriteFile(thumbnailFile(slug, name, width), out.Bytes()); err != nil {
+			return nil, err
+		}
+		thumbnails[strconv.Itoa(width)] = sitePath(ctx, thumbnailPath(slug, name, width))
+	}
+	return thumbnails, nil
+}
+
+// shrinkImage scales a picture down to the given width, keeping its shape.
+// Each new pixel is the average of the ones it covers.
+func shrinkImage(src image.Image, width int) *image.RGBA64 {
+	b := src.Bounds()
+	height := max(1, b.Dy()*width/b.Dx())
+	dst := image.NewRGBA64(image.Rect(0, 0, width, height))
+	for y := range height {
+		y0, y1 := b.Min.Y+y*b.Dy()/height, b.Min.Y+(y+1)*b.Dy()/height
+		for x := range width {
+			x0, x1 := b.Min.X+x*b.Dx()/width, b.Min.X+(x+1)*b.Dx()/width
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < max(y1, y0+1); sy++ {
+				for sx := x0; sx < max(x1, x0+1); sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// attachmentsHandler handles /api/page/{slug}/attachments: GET lists the
+// page's attachments, POST uploads one, and DELETE .../attachments/{name}
+// removes one.
+func (srv *Server) attachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	slugPart, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/page/"), "/")
+	slug := filepath.Base(slugPart)
+	setLogSlug(r, slug)
+	if !pageExists(r.Context(), slug) {
+		apiError(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	if restrictedPageError(w, r, accessMeta(r.Context(), slug)) {
+		return
+	}
+
+	name, named := strings.CutPrefix(rest, "attachments/")
+	switch {
+	case r.Method == http.MethodGet && !named:
+		listAttachments(w, r, slug)
+	case r.Method == http.MethodPost && !named:
+		uploadAttachment(w, r, slug)
+	case r.Method == http.MethodDelete && named:
+		deleteAttachment(w, r, slug, name)
+	default:
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+	}
+}
+
+// listAttachments answers with a page's attachments, by name, as
+// [{"name": "...", "url": "...", "size": 123, "thumbnails": {...}}, ...].
+func listAttachments(w http.ResponseWriter, r *http.Request, slug string) {
+	files, err := attachmentFiles(r.Context(), slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing attachments", "err", err)
+		apiError(w, "Could not list attachments", http.StatusInternalServerError)
+		return
+	}
+	attachments := []Attachment{}
+	widths := make(map[string][]int) // Thumbnails we have, by attachment name
+	for _, file := range files {
+		rest := strings.TrimPrefix(file, slug+"@")
+		if widthPart, name, ok := strings.Cut(rest, "@"); ok {
+			if width, err := strconv.Atoi(widthPart); err == nil {
+				widths[name] = append(widths[name], width)
+			}
+			continue
+		}
+		data, err := storeCtx(r.Context()).ReadFile(file)
+		if err != nil {
+			continue // Removed since we listed it
+		}
+		attachments = append(attachments, Attachment{
+			Name: rest,
+			URL:  sitePath(r.Context(), attachmentPath(slug, rest)),
+			Size: int64(len(data)),
+		})
+	}
+	for i, a := range attachments {
+		if len(widths[a.Name]) == 0 && !slices.Contains(galleryExtensions, strings.ToLower(filepath.Ext(a.Name))) {
+			continue // Not a picture
+		}
+		a.Thumbnails = make(map[string]string)
+		for _, width := range configOf(r.Context()).Attachments.ThumbnailWidths {
+			if slices.Contains(widths[a.Name], width) {
+				a.Thumbnails[strconv.Itoa(width)] = sitePath(r.Context(), thumbnailPath(slug, a.Name, width))
+			} else {
+				a.Thumbnails[strconv.Itoa(width)] = a.URL // Already smaller than that
+			}
+		}
+		attachments[i] = a
+	}
+	slices.SortFunc(attachments, func(a, b Attachment) int { return strings.Compare(a.Name, b.Name) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attachments)
+}
+
+// deleteAttachment removes an attachment and its thumbnails. One the page
+// still links to is only removed with ?force=true, as the link would break.
+func deleteAttachment(w http.ResponseWriter, r *http.Request, slug, name string) {
+	if !attachmentNameRegex.MatchString(name) {
+		apiError(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	if _, err := storeCtx(r.Context()).ModTime(attachmentFile(slug, name)); err != nil {
+		apiError(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("force") != "true" {
+		if _, body, err := readPageText(r.Context(), slug); err == nil && referencesAttachment(r.Context(), body, name) {
+			writeProblem(w, http.StatusConflict, "attachment_in_use", "The page still uses "+name+", delete it with ?force=true if you're sure")
+			return
+		}
+	}
+
+	files := []string{attachmentFile(slug, name)}
+	for _, width := range configOf(r.Context()).Attachments.ThumbnailWidths {
+		files = append(files, thumbnailFile(slug, name, width))
+	}
+	for _, file := range files {
+		if err := storeCtx(r.Context()).Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.ErrorContext(r.Context(), "Error removing attachment", "file", file, "err", err)
+			apiError(w, "Could not delete file", http.StatusInternalServerError)
+			return
+		}
+	}
+	slog.InfoContext(r.Context(), "Attachment deleted", "name", name)
+	w.Write([]byte("File deleted!"))
+}
+
+// referencesAttachment reports whether a page's text links to an attachment
+// or one of its thumbnails.
+func referencesAttachment(ctx context.Context, body, name string) bool {
+	for _, n := range []string{name, url.PathEscape(name)} {
+		if strings.Contains(body, "/files/"+n) {
+			return true
+		}
+		for _, width := range configOf(ctx).Attachments.ThumbnailWidths {
+			if strings.Contains(body, "/thumbs/"+strconv.Itoa(width)+"/"+n) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// uploadAttachment saves a file sent as the "file" field of a multipart
+// form, and answers with where it and its thumbnails can be found:
+// {"name": "...", "url": "...", "size": 123, "thumbnails": {"200": "..."}}.
+func uploadAttachment(w http.ResponseWriter, r *http.Request, slug string) {
+	config := configOf(r.Context())
+	r.Body = http.MaxBytesReader(w, r.Body, config.Attachments.MaxBytes+1<<20) // Room for the rest of the form
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		fieldError(w, "file", "required", "Bad request, send the file as the file field of a multipart form")
+		return
+	}
+	defer file.Close()
+	name := filepath.Base(header.Filename)
+	if !attachmentNameRegex.MatchString(name) {
+		fieldError(w, "file", "invalid_file_name", "Bad file name, use letters, numbers, spaces, dots, dashes and underscores, and an extension")
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(file, config.Attachments.MaxBytes+1))
+	if err != nil {
+		apiError(w, "Could not read the file", http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > config.Attachments.MaxBytes {
+		writeProblem(w, http.StatusRequestEntityTooLarge, "file_too_large", fmt.Sprintf("File is too big, the most is %d bytes", config.Attachments.MaxBytes))
+		return
+	}
+
+	if err := storeCtx(r.Context()).WriteFile(attachmentFile(slug, name), data); err != nil {
+		if quotaError(w, err) {
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error writing attachment", "name", name, "err", err)
+		apiError(w, "Could not save file", http.StatusInternalServerError)
+		return
+	}
+	thumbnails, err := makeThumbnails(r.Context(), slug, name, data)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error making thumbnails", "name", name, "err", err) // The file itself is saved
+	}
+
+	slog.InfoContext(r.Context(), "File attached", "name", name, "size", len(data))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Attachment{
+		Name:       name,
+		URL:        sitePath(r.Context(), attachmentPath(slug, name)),
+		Size:       int64(len(data)),
+		Thumbnails: thumbnails,
+	})
+}
+
+// serveAttachment serves /page/{slug}/files/{name} and the thumbnails at
+// /page/{slug}/thumbs/{width}/{name}; rest is what's after /page/{slug}/.
+func serveAttachment(w http.ResponseWriter, r *http.Request, page *Page, rest string) {
+	kind, name, _ := strings.Cut(rest, "/")
+	file := attachmentFile(page.Slug, name)
+	if kind == "thumbs" {
+		widthPart, thumbName, _ := strings.Cut(name, "/")
+		width, err := strconv.Atoi(widthPart)
+		if err != nil || !slices.Contains(configOf(r.Context()).Attachments.ThumbnailWidths, width) {
+			http.NotFound(w, r)
+			return
+		}
+		name, file = thumbName, thumbnailFile(page.Slug, thumbName, width)
+	}
+	if !attachmentNameRegex.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := storeCtx(r.Context()).ReadFile(file)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	modTime, _ := storeCtx(r.Context()).ModTime(file)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if contentType := http.DetectContentType(data); strings.HasPrefix(contentType, "image/") {
+		w.Header().Set("Content-Type", contentType)
+	} else {
+		// Anything else is downloaded, never shown as a page of ours
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	http.ServeContent(w, r, name, modTime, bytes.NewReader(data))
+}
+
+// attachmentFiles lists the store names of a page's attachments and their
+// thumbnails, for moving or removing them along with it.
+func attachmentFiles(ctx context.Context, slug string) ([]string, error) {
+	names, err := storeCtx(ctx).List()
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, name := range names {
+		if strings.HasPrefix(name, slug+"@") {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,96 @@
+package handlers
+
+//Decides who is allowed to change things on the site
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+)
+
+// requireLogin guards handlers that write to the site. With no auth plugins
+// loaded the site stays open to everyone, like it always has. Once one is
+// loaded, writes need HTTP basic auth credentials that a plugin accepts.
+func requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !serverOf(r.Context()).plugins.HasAuth() {
+			next(w, r)
+			return
+		}
+
+		username, password, ok := r.BasicAuth()
+		if !ok || !serverOf(r.Context()).plugins.Authenticate(username, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+siteOf(r.Context()).title+`", charset="UTF-8"`)
+			apiError(w, "Login required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// editorName is who is making a change, for crediting them on the page. It's
+// the name they logged in with, or empty on an open site where anyone can.
+func editorName(r *http.Request) string {
+	if !serverOf(r.Context()).plugins.HasAuth() {
+		return ""
+	}
+	username, _, _ := r.BasicAuth()
+	return username
+}
+
+// loggedInName is who a request says it's from, if an auth plugin agrees.
+// For pages anyone can see, where requireLogin hasn't checked already.
+func loggedInName(r *http.Request) string {
+	username, password, ok := r.BasicAuth()
+	if !ok || !serverOf(r.Context()).plugins.HasAuth() || !serverOf(r.Context()).plugins.Authenticate(username, password) {
+		return ""
+	}
+	return username
+}
+
+// isAdmin reports whether a request carries the admin password.
+func isAdmin(r *http.Request) bool {
+	site := siteOf(r.Context())
+	_, password, ok := r.BasicAuth()
+	return ok && site.adminPassword != "" && subtle.ConstantTimeCompare([]byte(password), []byte(site.adminPassword)) == 1
+}
+
+// requireAdmin guards the admin pages with HTTP basic auth (any username, the
+// configured admin_password). Form posts from other sites are refused, since the browser
+// would otherwise happily send the saved credentials along with them.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		site := siteOf(r.Context())
+		if site.adminPassword == "" {
+			http.Error(w, "Admin pages are disabled, set admin_password to enable them", http.StatusForbidden)
+			return
+		}
+
+		if !isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+site.title+` admin", charset="UTF-8"`)
+			http.Error(w, "Login required", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method != http.MethodGet && !sameOrigin(r) {
+			http.Error(w, "Cross-site request refused", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// sameOrigin reports whether a request came from one of our own pages, going
+// by the Origin header (or Referer, for older browsers). Requests with neither,
+// like from curl, are let through since they can't carry a victim's cookies.
+func sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Referer()
+	}
+	if source == "" {
+		return true
+	}
+	u, err := url.Parse(source)
+	return err == nil && u.Host == r.Host
+}
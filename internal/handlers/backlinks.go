@@ -0,0 +1,172 @@
+package handlers
+
+//Backlinks, or "what links here". Each site keeps an index of the pages each
+//page links to, from its [[wiki links]] and its href="/page/..." links. Page
+//files can be changed on disk behind our back, so the index is brought up to
+//date whenever it's asked, rereading just the pages whose files changed.
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go-trailer/internal/slugs"
+	"go-trailer/internal/storage"
+)
+
+// An href in a page's text, quoted or not.
+var hrefRegex = regexp.MustCompile(`href\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+// pageLinks is what one page links to, as of when its file was last read.
+type pageLinks struct {
+	modTime time.Time
+	links   []string // Slugs, as written, so may be old names
+}
+
+// linkIndex is a site's pages' links, by slug.
+type linkIndex struct {
+	sync.Mutex
+	bySlug map[string]pageLinks
+}
+
+// Backlink is a page that links to another.
+type Backlink struct {
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// linkedSlugs finds the pages a page's text links to.
+func linkedSlugs(ctx context.Context, body string) []string {
+	var links []string
+	for _, m := range wikiLinkRegex.FindAllStringSubmatch(body, -1) {
+		if name := strings.TrimSpace(m[1]); name != "" {
+			links = append(links, slugs.FromName(name))
+		}
+	}
+	for _, m := range hrefRegex.FindAllStringSubmatch(body, -1) {
+		if slug, ok := hrefSlug(ctx, m[1]+m[2]+m[3]); ok {
+			links = append(links, slug)
+		}
+	}
+	slices.Sort(links)
+	return slices.Compact(links)
+}
+
+// hrefSlug is the page an href points to, if it's one of this site's pages.
+func hrefSlug(ctx context.Context, href string) (string, bool) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", false
+	}
+	if u.Host != "" {
+		own, err := url.Parse(siteOf(ctx).url)
+		if err != nil || !strings.EqualFold(u.Host, own.Host) {
+			return "", false
+		}
+	}
+	p, ok := strings.CutPrefix(u.Path, sitePath(ctx, "/page/"))
+	if !ok {
+		return "", false
+	}
+	slug, _, _ := strings.Cut(p, "/") // /page/{slug}/export is still the page
+	return slug, slug != ""
+}
+
+// refreshLinks brings the site's index up to date with its page files and
+// returns it. Callers must hold its lock.
+func refreshLinks(ctx context.Context) (map[string]pageLinks, error) {
+	slugs, err := storage.PageSlugs(storeCtx(ctx))
+	if err != nil {
+		return nil, err
+	}
+	linkIndex := &siteOf(ctx).linkIndex
+	old := linkIndex.bySlug
+	index := make(map[string]pageLinks, len(slugs))
+	for _, slug := range slugs {
+		modTime, err := storeCtx(ctx).ModTime(slug + ".txt")
+		if err != nil {
+			continue // Removed since we listed it
+		}
+		if cached, ok := old[slug]; ok && cached.modTime.Equal(modTime) {
+			index[slug] = cached
+			continue
+		}
+		_, body, err := readPageText(ctx, slug)
+		if err != nil {
+			continue
+		}
+		index[slug] = pageLinks{modTime: modTime, links: linkedSlugs(ctx, body)}
+	}
+	linkIndex.bySlug = index
+	return index, nil
+}
+
+// backlinks lists the published pages that link to a page, by title. Links
+// to an old name or an alias of the page count too.
+func backlinks(ctx context.Context, slug string) ([]Backlink, error) {
+	linkIndex := &siteOf(ctx).linkIndex
+	linkIndex.Lock()
+	index, err := refreshLinks(ctx)
+	linkIndex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	redirects, err := loadRedirects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var linking []Backlink
+	for from, page := range index {
+		if from == slug {
+			continue // Linking to yourself doesn't count
+		}
+		links := slices.ContainsFunc(page.links, func(to string) bool {
+			return to == slug || redirects[to] == slug
+		})
+		if !links {
+			continue
+		}
+		if meta := accessMeta(ctx, from); meta.restricted(now) || meta.archived(now) {
+			continue // Not for visitors to follow
+		}
+		linking = append(linking, Backlink{Slug: from, Title: pageTitle(ctx, from), URL: sitePath(ctx, pagePath(from))})
+	}
+	slices.SortFunc(linking, func(a, b Backlink) int { return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)) })
+	return linking, nil
+}
+
+// pageBacklinks is the backlinks shown on a page. They're nice to have, so
+// failing to find them only leaves the section out.
+func pageBacklinks(ctx context.Context, slug string) []Backlink {
+	linking, err := backlinks(ctx, slug)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error finding backlinks", "err", err)
+	}
+	return linking
+}
+
+// backlinksHandler serves /page/{slug}/backlinks, the pages linking to it as
+// JSON: [{"slug": "...", "title": "...", "url": "..."}, ...].
+func backlinksHandler(w http.ResponseWriter, r *http.Request, page *Page) {
+	linking, err := backlinks(r.Context(), page.Slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error finding backlinks", "err", err)
+		http.Error(w, "Could not find backlinks", http.StatusInternalServerError)
+		return
+	}
+	if linking == nil {
+		linking = []Backlink{} // [] rather than null
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(linking)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+///admin/backups says how the last backup went and lists what's kept, and a
+//POST there takes one now. Taking them is up to internal/backups.
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"go-trailer/internal/backups"
+)
+
+// adminBackupsHandler serves /admin/backups on the main site. GET says how
+// the last backup went and lists the ones kept, POST takes one now and
+// answers with it. Backups are of every site's pages, so other sites' admins
+// can't.
+func (srv *Server) adminBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	if !siteOf(r.Context()).main {
+		http.NotFound(w, r)
+		return
+	}
+	if !srv.config.Backups.Enabled() {
+		http.Error(w, "Backups are off, set backups.dir", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		kept, err := srv.backups.List()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error listing backups", "err", err)
+			http.Error(w, "Could not list backups", http.StatusInternalServerError)
+			return
+		}
+		if kept == nil {
+			kept = []backups.File{} // [] rather than null
+		}
+		slices.Reverse(kept)
+		status := srv.backups.Status()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]any{"status": status, "backups": kept})
+	case http.MethodPost:
+		backup, err := srv.backups.Take(r.Context())
+		if backup.Name == "" {
+			slog.ErrorContext(r.Context(), "Error taking backup", "err", err)
+			http.Error(w, "Could not take backup", http.StatusInternalServerError)
+			return
+		}
+		result := map[string]any{"backup": backup}
+		if err != nil {
+			result["error"] = err.Error() // Kept here, but not uploaded
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(result)
+	default:
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+	}
+}
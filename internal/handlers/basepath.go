@@ -0,0 +1,34 @@
+package handlers
+
+//Running under a path prefix, e.g. https://example.com/wiki/, so the site
+//can share a domain with other apps. Requests have base_path taken off before
+//routing, so handlers never see it; links, redirects and absolute URLs put
+//it back on.
+
+import (
+	"context"
+	"net/http"
+)
+
+// sitePath is p (which starts with /) under the base path of the site ctx
+// is for, for links and redirects.
+func sitePath(ctx context.Context, p string) string {
+	return siteOf(ctx).basePath + p
+}
+
+// withBasePath strips base_path from requests, sending anything outside it
+// a 404. The bare prefix is redirected to the home page, with the slash.
+func (srv *Server) withBasePath(next http.Handler) http.Handler {
+	basePath := srv.config.BasePath
+	if basePath == "" {
+		return next
+	}
+	strip := http.StripPrefix(basePath, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == basePath {
+			http.Redirect(w, r, basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		strip.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,340 @@
+package handlers
+
+//Addresses that may read the site but not change it. Each site keeps its
+//blocklist in blocklist.json in its store: single IPs and CIDR ranges, for
+//good or until they expire. Admins manage it at /admin/blocklist, and an
+//address that trips the abuse checks (the create honeypot, failed
+//challenges, the page quota, comments scored as spam) blocklist.strikes
+//times within blocklist.window is banned for blocklist.ban_for on its own.
+//
+//Only writes are refused, with a 403: a POST, PUT or DELETE from a blocked
+//address gets no further than the middleware.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const blocklistFile = "blocklist.json"
+
+// blockEntry is an address or range on a site's blocklist.
+type blockEntry struct {
+	CIDR    netip.Prefix `json:"cidr"`
+	Reason  string       `json:"reason,omitempty"`
+	Added   time.Time    `json:"added"`
+	Expires time.Time    `json:"expires_at,omitzero"` // Zero for a ban that doesn't
+	Auto    bool         `json:"auto,omitempty"`      // Banned for tripping the abuse checks, not by an admin
+}
+
+// expired reports whether the ban is over at now.
+func (e blockEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// blocklist is a site's blocklist, read from its store the first time it's
+// needed. Changes are written straight back.
+type blocklist struct {
+	sync.Mutex
+	entries []blockEntry
+	loaded  bool
+}
+
+// parseBlockCIDR reads an IP or CIDR range, an IP being a range of one.
+func parseBlockCIDR(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		if prefix.Addr().Is4In6() {
+			return netip.Prefix{}, fmt.Errorf("%q: write IPv4 ranges as IPv4", s)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%q is not an IP or CIDR range", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// siteBlocklist is the site's blocklist, without any bans that are over.
+// Callers must hold its lock.
+func siteBlocklist(ctx context.Context) ([]blockEntry, error) {
+	list := &siteOf(ctx).blocklist
+	entries := list.entries
+	if !list.loaded {
+		data, err := storeCtx(ctx).ReadFile(blocklistFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &entries); err != nil {
+				return nil, fmt.Errorf("%s: %w", blocklistFile, err)
+			}
+		}
+	}
+	now := time.Now()
+	entries = slices.DeleteFunc(entries, func(e blockEntry) bool { return e.expired(now) })
+	list.entries, list.loaded = entries, true
+	return entries, nil
+}
+
+// saveBlocklist writes the site's blocklist out as entries. Callers must
+// hold its lock.
+func saveBlocklist(ctx context.Context, entries []blockEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storeCtx(ctx).WriteFile(blocklistFile, data); err != nil {
+		return err
+	}
+	siteOf(ctx).blocklist.entries = entries
+	return nil
+}
+
+// blockAddress puts entry on the site's blocklist, in place of any entry for
+// the same range.
+func blockAddress(ctx context.Context, entry blockEntry) error {
+	list := &siteOf(ctx).blocklist
+	list.Lock()
+	defer list.Unlock()
+	entries, err := siteBlocklist(ctx)
+	if err != nil {
+		return err
+	}
+	entries = slices.DeleteFunc(slices.Clone(entries), func(e blockEntry) bool { return e.CIDR == entry.CIDR })
+	return saveBlocklist(ctx, append(entries, entry))
+}
+
+// unblockAddress takes the range off the site's blocklist, reporting whether
+// it was on it.
+func unblockAddress(ctx context.Context, cidr netip.Prefix) (bool, error) {
+	list := &siteOf(ctx).blocklist
+	list.Lock()
+	defer list.Unlock()
+	entries, err := siteBlocklist(ctx)
+	if err != nil {
+		return false, err
+	}
+	kept := slices.DeleteFunc(slices.Clone(entries), func(e blockEntry) bool { return e.CIDR == cidr })
+	if len(kept) == len(entries) {
+		return false, nil
+	}
+	return true, saveBlocklist(ctx, kept)
+}
+
+// blockedEntry is the site's blocklist entry covering ip, if there is one.
+func blockedEntry(ctx context.Context, ip string) (blockEntry, bool, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return blockEntry{}, false, nil // Not an IP, over the unix socket
+	}
+	addr = addr.Unmap()
+	list := &siteOf(ctx).blocklist
+	list.Lock()
+	defer list.Unlock()
+	entries, err := siteBlocklist(ctx)
+	if err != nil {
+		return blockEntry{}, false, err
+	}
+	for _, e := range entries {
+		if e.CIDR.Contains(addr) {
+			return e, true, nil
+		}
+	}
+	return blockEntry{}, false, nil
+}
+
+// rejectBlockedWrites refuses requests that change things from addresses on
+// the site's blocklist, except for the one taking an address off it, so an
+// admin who blocked their own can undo it.
+func rejectBlockedWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == "/admin/blocklist" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		entry, blocked, err := blockedEntry(r.Context(), remoteIP(r))
+		if err != nil {
+			// Better to let a banned address through than refuse everyone
+			slog.ErrorContext(r.Context(), "Error reading blocklist", "err", err)
+		}
+		if !blocked {
+			next.ServeHTTP(w, r)
+			return
+		}
+		slog.InfoContext(r.Context(), "Write from blocked address refused", "ip", remoteIP(r), "cidr", entry.CIDR)
+		detail := "Your address can't make changes to this site"
+		if !entry.Expires.IsZero() {
+			w.Header().Set("Retry-After", fmt.Sprint(int(time.Until(entry.Expires).Seconds())+1))
+			detail += " until " + entry.Expires.UTC().Format(time.RFC3339)
+		}
+		writeProblem(w, http.StatusForbidden, "blocked", detail)
+	})
+}
+
+// abuseStrikes is when each address last tripped a site's abuse checks.
+type abuseStrikes struct {
+	sync.Mutex
+	ips map[string][]time.Time
+}
+
+// reportAbuse counts a strike against the address r came from, for what it
+// did, and bans the address once it has blocklist.strikes within
+// blocklist.window.
+func reportAbuse(r *http.Request, what string) {
+	settings := configOf(r.Context()).Blocklist
+	if settings.Strikes == 0 {
+		return
+	}
+	ctx := r.Context()
+	ip := remoteIP(r)
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return
+	}
+	strikes := &siteOf(ctx).abuseStrikes
+	now := time.Now()
+
+	strikes.Lock()
+	if strikes.ips == nil {
+		strikes.ips = make(map[string][]time.Time)
+	}
+	for other, times := range strikes.ips {
+		for len(times) > 0 && !now.Before(times[0].Add(settings.Window)) {
+			times = times[1:]
+		}
+		if len(times) == 0 {
+			delete(strikes.ips, other)
+		} else {
+			strikes.ips[other] = times
+		}
+	}
+	times := append(strikes.ips[ip], now)
+	banned := len(times) >= settings.Strikes
+	if banned {
+		delete(strikes.ips, ip) // Counting starts afresh when the ban is over
+	} else {
+		strikes.ips[ip] = times
+	}
+	strikes.Unlock()
+
+	slog.InfoContext(ctx, "Abuse detected", "ip", ip, "what", what, "strikes", len(times))
+	if !banned {
+		return
+	}
+	addr = addr.Unmap()
+	entry := blockEntry{
+		CIDR:    netip.PrefixFrom(addr, addr.BitLen()),
+		Reason:  fmt.Sprintf("%d abuse detections in %s, the last for %s", len(times), settings.Window, what),
+		Added:   now.UTC().Truncate(time.Second),
+		Expires: now.Add(settings.BanFor).UTC().Truncate(time.Second),
+		Auto:    true,
+	}
+	if err := blockAddress(ctx, entry); err != nil {
+		slog.ErrorContext(ctx, "Error saving blocklist", "err", err)
+		return
+	}
+	slog.WarnContext(ctx, "Address banned for abuse", "ip", ip, "until", entry.Expires)
+}
+
+// blockRequest is the body of POST /admin/blocklist.
+type blockRequest struct {
+	CIDR      string `json:"cidr"`
+	Reason    string `json:"reason"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// adminBlocklistHandler serves /admin/blocklist. GET lists the site's
+// blocklist, POST adds an address or range to it with a body of
+// {"cidr": "203.0.113.0/24", "reason": "...", "expires_at": "..."}, leaving
+// expires_at out to block it for good, and DELETE ?cidr=203.0.113.0/24 takes
+// one off.
+func (srv *Server) adminBlocklistHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list := &siteOf(r.Context()).blocklist
+		list.Lock()
+		entries, err := siteBlocklist(r.Context())
+		entries = slices.Clone(entries)
+		list.Unlock()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading blocklist", "err", err)
+			apiError(w, "Could not list the blocklist", http.StatusInternalServerError)
+			return
+		}
+		if entries == nil {
+			entries = []blockEntry{} // [] rather than null
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(entries)
+
+	case http.MethodPost:
+		var reqBody blockRequest
+		if err := readJSON(w, r, &reqBody); err != nil {
+			badJSON(w, err)
+			return
+		}
+		cidr, err := parseBlockCIDR(reqBody.CIDR)
+		if err != nil {
+			fieldError(w, "cidr", "invalid_cidr", err.Error())
+			return
+		}
+		expires, err := parseExpiresAt(reqBody.ExpiresAt)
+		if err != nil {
+			fieldError(w, "expires_at", "invalid_time", err.Error())
+			return
+		}
+		entry := blockEntry{CIDR: cidr, Reason: strings.TrimSpace(reqBody.Reason), Added: time.Now().UTC().Truncate(time.Second), Expires: expires}
+		if err := blockAddress(r.Context(), entry); err != nil {
+			if quotaError(w, err) {
+				return
+			}
+			slog.ErrorContext(r.Context(), "Error saving blocklist", "err", err)
+			apiError(w, "Could not save the blocklist", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "Address blocked", "cidr", cidr, "until", expires)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(entry)
+
+	case http.MethodDelete:
+		cidr, err := parseBlockCIDR(r.URL.Query().Get("cidr"))
+		if err != nil {
+			fieldError(w, "cidr", "invalid_cidr", err.Error())
+			return
+		}
+		removed, err := unblockAddress(r.Context(), cidr)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error saving blocklist", "err", err)
+			apiError(w, "Could not save the blocklist", http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			apiError(w, cidr.String()+" isn't on the blocklist", http.StatusNotFound)
+			return
+		}
+		slog.InfoContext(r.Context(), "Address unblocked", "cidr", cidr)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+	}
+}
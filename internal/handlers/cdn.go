@@ -0,0 +1,262 @@
+package handlers
+
+//Running behind a CDN. With cdn.enabled pages are sent with headers that let
+//the CDN cache them (s-maxage, stale-while-revalidate), and every write
+//queues a purge of the pages it changed so visitors don't see old votes or
+//edits for long. Purges go out in batches, like search engine pings.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-trailer/internal/config"
+)
+
+// How often queued purges are sent, and how long to back off when the CDN fails.
+const (
+	cdnPurgeInterval   = 2 * time.Second
+	maxCDNPurgeBackoff = 5 * time.Minute
+)
+
+// Paths that are the same for every visitor and safe for a CDN to cache.
+var cdnCacheablePrefixes = []string{"/page/", "/static/"}
+var cdnCacheablePaths = []string{"/", "/feed.xml", "/sitemap.xml", "/robots.txt"}
+
+// cdnPurgeQueue is the paths waiting to be purged.
+type cdnPurgeQueue struct {
+	sync.Mutex
+	paths map[string]bool
+}
+
+// cdnCacheControl is the Cache-Control header for cacheable responses.
+func cdnCacheControl(c config.CDNSettings) string {
+	value := fmt.Sprintf("public, max-age=%d, s-maxage=%d", int(c.MaxAge.Seconds()), int(c.SharedMaxAge.Seconds()))
+	if c.StaleWhileRevalidate > 0 {
+		value += fmt.Sprintf(", stale-while-revalidate=%d", int(c.StaleWhileRevalidate.Seconds()))
+	}
+	return value
+}
+
+func isCDNCacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	for _, path := range cdnCacheablePaths {
+		if r.URL.Path == path {
+			return true
+		}
+	}
+	for _, prefix := range cdnCacheablePrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// cdnHeaderWriter sets Cache-Control just before the response starts,
+// once we know whether it went well.
+type cdnHeaderWriter struct {
+	http.ResponseWriter
+	cacheable    bool
+	cacheControl string // For when it is
+	wroteHeader  bool
+}
+
+func (c *cdnHeaderWriter) WriteHeader(code int) {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		h := c.ResponseWriter.Header()
+		if h.Get("Cache-Control") == "" {
+			if c.cacheable && (code == http.StatusOK || code == http.StatusNotModified) {
+				h.Set("Cache-Control", c.cacheControl)
+			} else {
+				// Errors, status pages and anything personal must not stick around
+				h.Set("Cache-Control", "private, no-store")
+			}
+		}
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *cdnHeaderWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *cdnHeaderWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// withCDNHeaders adds caching headers for the CDN, when cdn.enabled is on.
+func (srv *Server) withCDNHeaders(next http.Handler) http.Handler {
+	if !srv.config.CDN.Enabled {
+		return next
+	}
+	cacheControl := cdnCacheControl(srv.config.CDN)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&cdnHeaderWriter{ResponseWriter: w, cacheable: isCDNCacheable(r), cacheControl: cacheControl}, r)
+	})
+}
+
+// purgePage queues a purge of everything showing a page's content. Only the
+// main site is purged.
+func purgePage(ctx context.Context, slug string) {
+	if !siteOf(ctx).main {
+		return
+	}
+	serverOf(ctx).queueCDNPurge(pagePath(slug), pagePath(slug)+"/export", pagePath(slug)+"/raw")
+}
+
+// purgeListings queues a purge of the pages listing every page, after one
+// is created or updated.
+func purgeListings(ctx context.Context) {
+	if !siteOf(ctx).main {
+		return
+	}
+	serverOf(ctx).queueCDNPurge("/", "/feed.xml", "/sitemap.xml")
+}
+
+// queueCDNPurge marks paths to be purged with the next batch.
+func (srv *Server) queueCDNPurge(paths ...string) {
+	if !srv.config.CDN.Purging() {
+		return
+	}
+	srv.cdnPurgeQueue.Lock()
+	if srv.cdnPurgeQueue.paths == nil {
+		srv.cdnPurgeQueue.paths = make(map[string]bool)
+	}
+	for _, path := range paths {
+		srv.cdnPurgeQueue.paths[path] = true
+	}
+	srv.cdnPurgeQueue.Unlock()
+}
+
+// runCDNPurger sends queued purges until ctx is cancelled, then sends
+// whatever is left one last time.
+func (srv *Server) runCDNPurger(ctx context.Context) {
+	interval := cdnPurgeInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := srv.sendCDNPurges(); err != nil {
+				slog.Error("Error sending final CDN purges", "err", err)
+			}
+			return
+		case <-timer.C:
+		}
+
+		if err := srv.sendCDNPurges(); err != nil {
+			interval = min(interval*2, maxCDNPurgeBackoff)
+			slog.Error("Error purging CDN", "retry_in", interval, "err", err)
+		} else {
+			interval = cdnPurgeInterval
+		}
+		timer.Reset(interval)
+	}
+}
+
+// sendCDNPurges empties the queue into one purge request. If the CDN fails
+// the paths go back on the queue for next time.
+func (srv *Server) sendCDNPurges() error {
+	srv.cdnPurgeQueue.Lock()
+	var paths []string
+	for path := range srv.cdnPurgeQueue.paths {
+		paths = append(paths, path)
+	}
+	srv.cdnPurgeQueue.paths = nil
+	srv.cdnPurgeQueue.Unlock()
+
+	if len(paths) == 0 {
+		return nil
+	}
+	if err := srv.purgeCDN(paths); err != nil {
+		srv.queueCDNPurge(paths...)
+		return err
+	}
+	slog.Info("Purged CDN", "urls", len(paths))
+	return nil
+}
+
+// purgeCDN asks the CDN to drop its copies of paths.
+func (srv *Server) purgeCDN(paths []string) error {
+	config := &srv.config
+	urls := make([]string, len(paths))
+	for i, path := range paths {
+		urls[i] = config.SiteURL + path
+	}
+	data, err := json.Marshal(map[string][]string{"urls": urls})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, config.CDN.PurgeURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if config.CDN.PurgeToken != "" {
+		req.Header.Set("Authorization", "Bearer "+config.CDN.PurgeToken)
+	}
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("purge returned %s", resp.Status)
+	}
+	return nil
+}
+
+// adminPurgeHandler handles POST /admin/cdn/purge, for purging by hand. The
+// body is {"paths": ["/page/my-page", ...]}, or empty to purge the listings.
+func (srv *Server) adminPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	if !siteOf(r.Context()).main {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	var reqBody struct {
+		Paths []string `json:"paths"`
+	}
+	if r.ContentLength != 0 {
+		if err := readJSON(w, r, &reqBody); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	for _, path := range reqBody.Paths {
+		if !strings.HasPrefix(path, "/") {
+			http.Error(w, "Paths must start with /", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(reqBody.Paths) == 0 {
+		reqBody.Paths = cdnCacheablePaths
+	}
+
+	if err := srv.purgeCDN(reqBody.Paths); err != nil {
+		slog.ErrorContext(r.Context(), "Error purging CDN", "err", err)
+		http.Error(w, "Could not purge CDN", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Purged!"))
+	slog.InfoContext(r.Context(), "Purged CDN by hand", "urls", len(reqBody.Paths))
+}
@@ -0,0 +1,229 @@
+package handlers
+
+//Comments on pages: posting them, spam scoring, and paging through them.
+//Comments for a page live next to it in {slug}.comments.json.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// How many comments we show per page of comments.
+const commentsPerPage = 20
+
+// Limits on what people can post.
+const (
+	maxCommentAuthorLength = 80
+	maxCommentBodyLength   = 5000
+)
+
+// Comment statuses. Only approved comments are shown on the page, the rest
+// wait in the admin moderation view.
+const (
+	commentApproved = "approved"
+	commentPending  = "pending"
+	commentSpam     = "spam"
+)
+
+// Comment is a single comment on a page.
+type Comment struct {
+	ID        string    `json:"id"`
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	Status    string    `json:"status"`
+	SpamScore float64   `json:"spam_score"`
+	Flagged   string    `json:"flagged,omitempty"` // Why moderation held it
+
+	// Kept so the comment can be reported back to the spam checker later
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Referrer  string `json:"referrer,omitempty"`
+}
+
+// CommentList is one page's worth of approved comments, for page.html.
+type CommentList struct {
+	pagination
+	Items []Comment
+}
+
+// loadComments reads every comment on a page, oldest first. A page nobody
+// has commented on yet just has none.
+func loadComments(ctx context.Context, slug string) ([]Comment, error) {
+	data, err := storeCtx(ctx).ReadFile(slug + ".comments.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var comments []Comment
+	if err := json.Unmarshal(data, &comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// saveComments writes a page's comments back. Callers must hold commentsMu.
+func saveComments(ctx context.Context, slug string, comments []Comment) error {
+	data, err := json.Marshal(comments)
+	if err != nil {
+		return err
+	}
+	return storeCtx(ctx).WriteFile(slug+".comments.json", data)
+}
+
+// approvedComments picks out one page of the comments visitors can see.
+func approvedComments(comments []Comment, number int) CommentList {
+	var approved []Comment
+	for _, c := range comments {
+		if c.Status == commentApproved {
+			approved = append(approved, c)
+		}
+	}
+
+	var list CommentList
+	var start, end int
+	list.pagination, start, end = paginate(len(approved), commentsPerPage, number)
+	list.Items = approved[start:end]
+	return list
+}
+
+// commentPageNumber reads ?cpage=N, defaulting to the first page.
+func commentPageNumber(r *http.Request) int {
+	n, err := strconv.Atoi(r.URL.Query().Get("cpage"))
+	if err != nil {
+		return 1
+	}
+	return n
+}
+
+// newCommentID makes a short random ID, unique enough within one page.
+func newCommentID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// commentRequest is the body of a POST adding a comment.
+type commentRequest struct {
+	Author string `json:"author"`
+	Body   string `json:"body"`
+}
+
+// commentPostHandler handles POST /api/comments/{slug} with a JSON body of
+// {"author": "...", "body": "..."}. The comment is spam checked and either
+// published, held for moderation, or filed as spam.
+func (srv *Server) commentPostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := filepath.Base(strings.TrimPrefix(r.URL.Path, "/api/comments/"))
+	setLogSlug(r, slug)
+	if _, err := storeCtx(r.Context()).ModTime(slug + ".txt"); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var reqBody commentRequest
+	if err := readJSON(w, r, &reqBody); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	reqBody.Author = strings.TrimSpace(reqBody.Author)
+	reqBody.Body = strings.TrimSpace(reqBody.Body)
+	if reqBody.Author == "" {
+		reqBody.Author = "Anonymous"
+	}
+	if reqBody.Body == "" {
+		http.Error(w, "Comment is required", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(reqBody.Author) > maxCommentAuthorLength || utf8.RuneCountInString(reqBody.Body) > maxCommentBodyLength {
+		http.Error(w, "Comment is too long", http.StatusBadRequest)
+		return
+	}
+
+	verdict := moderate(r, moderationItem{Kind: moderateComment, Slug: slug, Text: reqBody.Body, Author: reqBody.Author})
+	if verdict.Verdict == moderationReject {
+		http.Error(w, rejectionDetail(verdict), http.StatusUnprocessableEntity)
+		return
+	}
+	reqBody.Body = verdict.Text
+
+	comment := Comment{
+		ID:        newCommentID(),
+		Author:    reqBody.Author,
+		Body:      reqBody.Body,
+		CreatedAt: time.Now().UTC(),
+		IP:        remoteIP(r),
+		UserAgent: r.UserAgent(),
+		Referrer:  r.Referer(),
+	}
+
+	// Score it before taking the lock, the spam checker may be a network call
+	score, err := srv.spamFilter.Score(comment, siteBaseURL(r)+pagePath(slug))
+	if err != nil {
+		slog.WarnContext(r.Context(), "Spam check failed, holding comment for moderation", "err", err)
+		score = spamHoldScore
+	}
+	comment.SpamScore = score
+	switch {
+	case score >= spamRejectScore:
+		comment.Status = commentSpam
+		reportAbuse(r, "a spam comment")
+	case score >= spamHoldScore:
+		comment.Status = commentPending
+	default:
+		comment.Status = commentApproved
+	}
+	if verdict.Verdict == moderationReview {
+		comment.Flagged = verdict.Reason
+		if comment.Status == commentApproved {
+			comment.Status = commentPending
+		}
+	}
+
+	siteOf(r.Context()).commentsMu.Lock()
+	defer siteOf(r.Context()).commentsMu.Unlock()
+	comments, err := loadComments(r.Context(), slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading comments", "err", err)
+		http.Error(w, "Could not save comment", http.StatusInternalServerError)
+		return
+	}
+	if err := saveComments(r.Context(), slug, append(comments, comment)); err != nil {
+		if quotaError(w, err) {
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error writing comments", "err", err)
+		http.Error(w, "Could not save comment", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Comment saved", "comment", comment.ID, "status", comment.Status, "spam_score", score)
+	if comment.Status == commentApproved {
+		purgePage(r.Context(), slug)
+	}
+
+	// Spam gets the same answer as a held comment, no point telling spammers
+	// how they were caught
+	w.Header().Set("Content-Type", "application/json")
+	status := comment.Status
+	if status == commentSpam {
+		status = commentPending
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id": comment.ID, "status": status})
+}
@@ -0,0 +1,39 @@
+package handlers
+
+//Conditional requests for pages, HTML and JSON alike: each answer has an
+//ETag that's a hash of what was sent and a Last-Modified of when any of the
+//page's files last changed, so browsers and API clients asking again with
+//If-None-Match or If-Modified-Since get a 304 and no body if it's the same.
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// pageLastModified is when the page's text, videos, votes, comments or
+// settings last changed, whichever was latest.
+func pageLastModified(ctx context.Context, slug string) time.Time {
+	pages := storeCtx(ctx)
+	latest, _ := pages.ModTime(slug + ".txt")
+	for _, suffix := range pageCompanionSuffixes {
+		if modTime, err := pages.ModTime(slug + suffix); err == nil && modTime.After(latest) {
+			latest = modTime
+		}
+	}
+	return latest
+}
+
+// serveVersioned answers with body, of contentType, or with a 304 if the
+// request already has it. http.ServeContent does the checking, with If-None-Match
+// taking precedence, as a template change can alter a page without any of
+// its files changing.
+func serveVersioned(w http.ResponseWriter, r *http.Request, contentType string, modTime time.Time, body []byte) {
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(body))
+}
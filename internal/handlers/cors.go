@@ -0,0 +1,67 @@
+package handlers
+
+//CORS for the JSON API, so a single page app served from another origin can
+//call /api/. It's off until cors.allowed_origins lists who may. Preflight
+//OPTIONS requests are answered here, before logins and routing, and
+//browsers may keep the answer for cors.max_age.
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// withCORS lets the origins in cors.allowed_origins call /api/, answering
+// their preflights itself.
+func (srv *Server) withCORS(next http.Handler) http.Handler {
+	cors := srv.config.CORS
+	if len(cors.AllowedOrigins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin") // Caches mustn't give one origin's answer to another
+		origin := r.Header.Get("Origin")
+		if origin == "" || !cors.AllowsOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if cors.AllowCredentials || !slices.Contains(cors.AllowedOrigins, "*") {
+			h.Set("Access-Control-Allow-Origin", origin)
+		} else {
+			h.Set("Access-Control-Allow-Origin", "*")
+		}
+		if cors.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		method := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != http.MethodOptions || method == "" {
+			if len(cors.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A preflight: say what's allowed, and the browser works out whether
+		// the request it has in mind is. Anything not allowed is left out.
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		if slices.Contains(cors.AllowedMethods, method) {
+			h.Set("Access-Control-Allow-Methods", strings.Join(cors.AllowedMethods, ", "))
+		}
+		if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" && cors.AllowsHeaders(requested) {
+			h.Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+		}
+		if cors.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
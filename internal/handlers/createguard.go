@@ -0,0 +1,297 @@
+package handlers
+
+//Keeping bots from filling the pages directory through /create, on sites
+//where anyone may create pages. Three things, each set under page_creation:
+//in config.yaml:
+//
+//   - A honeypot: the create form has a website field people never see, so
+//     never fill in. A create with it filled in is answered as if it worked,
+//     and nothing is made.
+//   - A challenge, if asked for: proof of work (GET /api/v1/challenge, then
+//     send back a nonce whose hash with it starts with enough zero bits), or
+//     a Turnstile or hCaptcha CAPTCHA, checked with the provider.
+//   - A quota of pages per address per window, answered with a 429 once
+//     it's used up.
+//
+//Requests from someone logged in through an auth plugin skip all three.
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// captchaProvider is what we need to know about a CAPTCHA service.
+type captchaProvider struct {
+	script      string // Shows the widget
+	widgetClass string // Of the element the widget goes in
+	field       string // Name of the hidden input the widget puts its token in
+	verifyURL   string // Where tokens are checked
+}
+
+var captchaProviders = map[string]captchaProvider{
+	"turnstile": {
+		script:      "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		widgetClass: "cf-turnstile",
+		field:       "cf-turnstile-response",
+		verifyURL:   "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	},
+	"hcaptcha": {
+		script:      "https://js.hcaptcha.com/1/api.js",
+		widgetClass: "h-captcha",
+		field:       "h-captcha-response",
+		verifyURL:   "https://api.hcaptcha.com/siteverify",
+	},
+}
+
+// How long a proof of work challenge can be answered for.
+const challengeTTL = 5 * time.Minute
+
+// challenges is the proof of work challenges a Server hands out.
+type challenges struct {
+	// key signs them, so we needn't keep them. A restart makes a new one,
+	// and any challenges not yet answered have to be asked for again.
+	key []byte
+
+	// answered is the challenges already used, until they'd have expired
+	// anyway, so one piece of work makes one page.
+	sync.Mutex
+	answered map[string]time.Time
+}
+
+// newChallenges makes a key to sign challenges with.
+func newChallenges() challenges {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return challenges{key: key, answered: make(map[string]time.Time)}
+}
+
+// challengeResponse is the answer to GET /api/v1/challenge.
+type challengeResponse struct {
+	Challenge string    `json:"challenge"`
+	Bits      int       `json:"bits"` // sha256(challenge + ":" + nonce) must start with this many zero bits
+	Expires   time.Time `json:"expires"`
+}
+
+// new is a challenge good until expires: when it expires and some
+// randomness, then their signature.
+func (c *challenges) new(expires time.Time) string {
+	payload := make([]byte, 8, 24)
+	binary.BigEndian.PutUint64(payload, uint64(expires.Unix()))
+	payload = append(payload, rand.Text()[:16]...)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + c.signature(payload)
+}
+
+func (c *challenges) signature(payload []byte) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// expiry checks challenge is one of ours and returns when it expires.
+func (c *challenges) expiry(challenge string) (time.Time, bool) {
+	encoded, signature, ok := strings.Cut(challenge, ".")
+	if !ok {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(payload) < 8 || !hmac.Equal([]byte(signature), []byte(c.signature(payload))) {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(binary.BigEndian.Uint64(payload)), 0), true
+}
+
+// zeroBits is how many zero bits sha256(challenge + ":" + nonce) starts with.
+func zeroBits(challenge, nonce string) int {
+	sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+	n := 0
+	for _, b := range sum {
+		n += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return n
+}
+
+// check reports whether nonce answers challenge with at least bits zero
+// bits, using the challenge up if it does.
+func (c *challenges) check(challenge, nonce string, bits int) bool {
+	expires, ok := c.expiry(challenge)
+	now := time.Now()
+	if !ok || !now.Before(expires) || zeroBits(challenge, nonce) < bits {
+		return false
+	}
+	c.Lock()
+	defer c.Unlock()
+	for answered, at := range c.answered {
+		if !now.Before(at) {
+			delete(c.answered, answered)
+		}
+	}
+	if _, used := c.answered[challenge]; used {
+		return false
+	}
+	c.answered[challenge] = expires
+	return true
+}
+
+// challengeHandler serves GET /api/challenge, a proof of work challenge for
+// creating a page.
+func (srv *Server) challengeHandler(w http.ResponseWriter, r *http.Request) {
+	expires := time.Now().Add(challengeTTL).Truncate(time.Second)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(challengeResponse{
+		Challenge: srv.challenges.new(expires),
+		Bits:      srv.config.PageCreation.PowBits,
+		Expires:   expires.UTC(),
+	})
+}
+
+// checkCaptcha asks the provider whether token is a CAPTCHA solved by
+// whoever sent r.
+func checkCaptcha(r *http.Request, token string) (bool, error) {
+	settings := configOf(r.Context()).PageCreation.Captcha
+	form := url.Values{"secret": {settings.Secret}, "response": {token}, "remoteip": {remoteIP(r)}}
+	resp, err := outboundClient.PostForm(captchaProviders[settings.Provider].verifyURL, form)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s answered %s", settings.Provider, resp.Status)
+	}
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Success, nil
+}
+
+// guardCreate answers a create that a bot looks to have sent, and reports
+// whether it did. Callers go on to make the page when it didn't.
+func guardCreate(w http.ResponseWriter, r *http.Request, req createPageRequest) bool {
+	settings := configOf(r.Context()).PageCreation
+	if editorName(r) != "" {
+		return false
+	}
+	if settings.Honeypot && req.Website != "" {
+		slog.InfoContext(r.Context(), "Honeypot filled in, not creating the page", "ip", remoteIP(r))
+		reportAbuse(r, "the create honeypot")
+		http.Redirect(w, r, sitePath(r.Context(), "/"), http.StatusSeeOther) // As if it worked
+		return true
+	}
+	switch settings.Challenge {
+	case "pow":
+		if req.Challenge == "" {
+			writeProblem(w, http.StatusForbidden, "challenge_required", "Get a challenge from /api/v1/challenge and send back a nonce that answers it")
+			return true
+		}
+		if !serverOf(r.Context()).challenges.check(req.Challenge, req.Nonce, settings.PowBits) {
+			reportAbuse(r, "a failed challenge")
+			writeProblem(w, http.StatusForbidden, "challenge_failed", "That nonce doesn't answer the challenge, or the challenge has expired or been used, get a new one")
+			return true
+		}
+	case "captcha":
+		if req.Captcha == "" {
+			writeProblem(w, http.StatusForbidden, "challenge_required", "Solve the CAPTCHA first")
+			return true
+		}
+		ok, err := checkCaptcha(r, req.Captcha)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error checking CAPTCHA", "err", err)
+			writeProblem(w, http.StatusServiceUnavailable, "challenge_unavailable", "Could not check the CAPTCHA, try again shortly")
+			return true
+		}
+		if !ok {
+			reportAbuse(r, "a failed challenge")
+			writeProblem(w, http.StatusForbidden, "challenge_failed", "The CAPTCHA wasn't solved, try it again")
+			return true
+		}
+	}
+	return false
+}
+
+// createQuota is when each address created its recent pages on a site.
+type createQuota struct {
+	sync.Mutex
+	ips map[string][]time.Time
+}
+
+// overCreateQuota answers a create from an address that has made its
+// page_creation.per_ip pages this window, and reports whether it did.
+// Otherwise it counts the page being made. Callers must hold createMu, so
+// creates from one address are counted one at a time.
+func overCreateQuota(w http.ResponseWriter, r *http.Request) bool {
+	settings := configOf(r.Context()).PageCreation
+	if settings.PerIP == 0 || editorName(r) != "" {
+		return false
+	}
+	quota, ip := &siteOf(r.Context()).createQuota, remoteIP(r)
+	now := time.Now()
+	quota.Lock()
+	defer quota.Unlock()
+	if quota.ips == nil {
+		quota.ips = make(map[string][]time.Time)
+	}
+	for addr, times := range quota.ips {
+		for len(times) > 0 && !now.Before(times[0].Add(settings.Window)) {
+			times = times[1:]
+		}
+		if len(times) == 0 {
+			delete(quota.ips, addr)
+		} else {
+			quota.ips[addr] = times
+		}
+	}
+	times := quota.ips[ip]
+	if len(times) >= settings.PerIP {
+		retry := times[0].Add(settings.Window).Sub(now)
+		reportAbuse(r, "the page quota")
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+		writeProblem(w, http.StatusTooManyRequests, "too_many_pages", fmt.Sprintf("You've created %d pages lately, try again later", len(times)))
+		return true
+	}
+	quota.ips[ip] = append(times, now)
+	return false
+}
+
+// pageCreationView is what the create form needs to show the honeypot and
+// challenge, for the create-guard.html template.
+type pageCreationView struct {
+	Honeypot       bool
+	Challenge      string
+	CaptchaScript  string
+	CaptchaClass   string
+	CaptchaField   string
+	CaptchaSiteKey string
+}
+
+func (srv *Server) buildPageCreationView() pageCreationView {
+	settings := srv.config.PageCreation
+	view := pageCreationView{Honeypot: settings.Honeypot, Challenge: settings.Challenge}
+	if settings.Challenge == "captcha" {
+		provider := captchaProviders[settings.Captcha.Provider]
+		view.CaptchaScript = provider.script
+		view.CaptchaClass = provider.widgetClass
+		view.CaptchaField = provider.field
+		view.CaptchaSiteKey = settings.Captcha.SiteKey
+	}
+	return view
+}
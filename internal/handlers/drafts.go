@@ -0,0 +1,115 @@
+package handlers
+
+//Draft pages: created with {"draft": true}, they're only shown to whoever
+//created them and to admins, and are left out of the homepage, feeds, search,
+//the sitemap and the list APIs until they're published, by hand or at their
+//publish_at time (see schedule.go). Private pages are kept out of the same
+//places, see private.go.
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go-trailer/internal/storage"
+)
+
+// publishedSlugs is storage.PageSlugs without the drafts, private pages and
+// archived pages, for everything that lists pages to visitors.
+func publishedSlugs(ctx context.Context) ([]string, error) {
+	slugs, err := storage.PageSlugs(storeCtx(ctx))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	published := slugs[:0]
+	for _, slug := range slugs {
+		if meta := accessMeta(ctx, slug); !meta.restricted(now) && !meta.archived(now) {
+			published = append(published, slug)
+		}
+	}
+	return published, nil
+}
+
+// canSeeDraft reports whether this request may see a draft page: admins can,
+// and so can whoever created it, once they've logged in.
+func canSeeDraft(r *http.Request, meta PageMeta) bool {
+	if isAdmin(r) {
+		return true
+	}
+	name := loggedInName(r)
+	return name != "" && name == meta.CreatedBy
+}
+
+// markDraft marks a page that's about to be created as a draft, to publish
+// itself at publishAt unless that's zero.
+func markDraft(ctx context.Context, slug string, publishAt time.Time) error {
+	siteOf(ctx).pageMetaMu.Lock()
+	defer siteOf(ctx).pageMetaMu.Unlock()
+	meta, err := loadPageMeta(ctx, slug)
+	if err != nil {
+		return err
+	}
+	meta.Draft, meta.PublishAt = true, publishAt
+	return savePageMeta(ctx, slug, meta)
+}
+
+// publishHandler handles POST /api/page/{slug}/publish, and /unpublish to
+// turn a page back into a draft.
+func (srv *Server) publishHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/api/page/")
+	slugPart, action, _ := strings.Cut(rest, "/")
+	slug := filepath.Base(slugPart)
+	setLogSlug(r, slug)
+	if !pageExists(r.Context(), slug) {
+		apiError(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	siteOf(r.Context()).pageMetaMu.Lock()
+	defer siteOf(r.Context()).pageMetaMu.Unlock()
+	meta, err := loadPageMeta(r.Context(), slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading page meta", "err", err)
+		apiError(w, "Could not publish page", http.StatusInternalServerError)
+		return
+	}
+	if !canSeeDraft(r, meta) {
+		apiError(w, "Only whoever created the page, or an admin, can do that", http.StatusForbidden)
+		return
+	}
+	meta.Draft = action == "unpublish"
+	meta.PublishAt = time.Time{}
+	if !meta.Draft {
+		meta.Published = time.Now().UTC()
+	}
+	meta.edited(editorName(r))
+	if err := savePageMeta(r.Context(), slug, meta); err != nil {
+		if quotaError(w, err) {
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error writing page meta", "err", err)
+		apiError(w, "Could not publish page", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Page draft state changed", "draft", meta.Draft)
+	if !meta.Draft && !meta.Private {
+		queueSearchPing(r.Context(), slug)
+		publishPageEvent(r.Context(), "page-created", slug)
+	}
+	purgePage(r.Context(), slug)
+	purgeListings(r.Context())
+	if meta.Draft {
+		w.Write([]byte("Page is a draft again"))
+	} else {
+		w.Write([]byte("Page published!"))
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+//Editing a page's text. An editor GETs /api/page/{slug}/source for the text
+//and its revision, a hash of it, and sends the revision back when saving. If
+//the page changed in the meantime, whether through another edit or on disk,
+//the save is refused with both versions, rather than one quietly replacing
+//the other. /page/{slug}/raw serves the same text as plain text, for
+//scripts and the page's "view source" link.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// The most text a page can be saved with.
+const maxPageBytes = 1 << 20
+
+// pageSource is a page's text as stored, front matter and all.
+type pageSource struct {
+	Body     string `json:"body"`
+	Revision string `json:"revision"`
+}
+
+// editConflict is the answer to a save of a page that changed since the
+// editor loaded it. Saving Yours again with Revision replaces Current.
+type editConflict struct {
+	Error    string `json:"error"`
+	Revision string `json:"revision"`
+	Current  string `json:"current"`
+	Yours    string `json:"yours"`
+}
+
+// pageRevision identifies a version of a page's text.
+func pageRevision(text []byte) string {
+	sum := sha256.Sum256(text)
+	return hex.EncodeToString(sum[:16])
+}
+
+// editablePage reads the slug from /api/page/{slug}/{action} and checks the
+// page is there for this request to edit. Drafts and private pages are only
+// for those who may see them.
+func editablePage(w http.ResponseWriter, r *http.Request, action string) (string, bool) {
+	slug := filepath.Base(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/page/"), "/"+action))
+	setLogSlug(r, slug)
+	if !pageExists(r.Context(), slug) {
+		apiError(w, "Page not found", http.StatusNotFound)
+		return "", false
+	}
+	if restrictedPageError(w, r, accessMeta(r.Context(), slug)) {
+		return "", false
+	}
+	return slug, true
+}
+
+// sourceHandler handles GET /api/page/{slug}/source, answering with
+// {"body": "...", "revision": "..."}.
+func (srv *Server) sourceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	slug, ok := editablePage(w, r, "source")
+	if !ok {
+		return
+	}
+	text, err := storeCtx(r.Context()).ReadFile(slug + ".txt")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading page", "err", err)
+		apiError(w, "Could not read page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(pageSource{Body: string(text), Revision: pageRevision(text)})
+}
+
+// rawPageHandler serves /page/{slug}/raw, the page's text as stored. Its
+// revision is its ETag, so clients can ask again cheaply.
+func rawPageHandler(w http.ResponseWriter, r *http.Request, page *Page) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	pages := storeCtx(r.Context())
+	text, err := pages.ReadFile(page.Slug + ".txt")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading page", "err", err)
+		http.Error(w, "Could not read page", http.StatusInternalServerError)
+		return
+	}
+	modTime, _ := pages.ModTime(page.Slug + ".txt")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+pageRevision(text)+`"`)
+	if w.Header().Get("Cache-Control") == "" && !configOf(r.Context()).CDN.Enabled {
+		w.Header().Set("Cache-Control", "no-cache") // Keep it, but check it's still current
+	}
+	http.ServeContent(w, r, page.Slug+".txt", modTime, bytes.NewReader(text))
+}
+
+// editHandler handles POST /api/page/{slug}/edit with a JSON body of
+// {"body": "the new text", "revision": "..."}, the revision being the one
+// the edit started from. It answers with the new {"revision": "..."}, or a
+// 409 with an editConflict if the page has changed since.
+func (srv *Server) editHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	slug, ok := editablePage(w, r, "edit")
+	if !ok {
+		return
+	}
+	var reqBody pageSource
+	r.Body = http.MaxBytesReader(w, r.Body, maxPageBytes)
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		badJSON(w, err)
+		return
+	}
+	if reqBody.Revision == "" {
+		fieldError(w, "revision", "required", "revision is required, it's the one /source gave you")
+		return
+	}
+	// Moderated before taking the lock, a moderation plugin may be slow
+	verdict := moderate(r, moderationItem{Kind: moderatePage, Slug: slug, Text: reqBody.Body, Author: editorName(r)})
+	if verdict.Verdict == moderationReject {
+		rejectContent(w, "body", verdict)
+		return
+	}
+	reqBody.Body = verdict.Text
+
+	// Checking the revision and writing go together, so two saves of the same
+	// revision can't both win
+	siteOf(r.Context()).createMu.Lock()
+	defer siteOf(r.Context()).createMu.Unlock()
+	pages := storeCtx(r.Context())
+	current, err := pages.ReadFile(slug + ".txt")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading page", "err", err)
+		apiError(w, "Could not save page", http.StatusInternalServerError)
+		return
+	}
+	if revision := pageRevision(current); revision != reqBody.Revision {
+		slog.InfoContext(r.Context(), "Edit conflict", "from", reqBody.Revision, "current", revision)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(editConflict{
+			Error:    "The page was changed while you were editing it",
+			Revision: revision,
+			Current:  string(current),
+			Yours:    reqBody.Body,
+		})
+		return
+	}
+	if err := pages.WriteFile(slug+".txt", []byte(reqBody.Body)); err != nil {
+		if quotaError(w, err) {
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error writing page", "err", err)
+		apiError(w, "Could not save page", http.StatusInternalServerError)
+		return
+	}
+	if err := recordPageEdit(r.Context(), slug, editorName(r)); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing page meta", "err", err) // The edit itself is saved
+	}
+	if verdict.Verdict == moderationReview {
+		if err := flagPage(r.Context(), slug, verdict.Reason); err != nil {
+			slog.ErrorContext(r.Context(), "Error flagging page for review", "err", err)
+		}
+	}
+
+	slog.InfoContext(r.Context(), "Page edited")
+	if !isRestricted(r.Context(), slug) {
+		queueSearchPing(r.Context(), slug)
+	}
+	purgePage(r.Context(), slug)
+	purgeListings(r.Context())
+	publishPageEvent(r.Context(), "page-edited", slug)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"revision": pageRevision([]byte(reqBody.Body))})
+}
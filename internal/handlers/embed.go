@@ -0,0 +1,118 @@
+package handlers
+
+//How YouTube players are embedded. The site sets the defaults in config.yaml
+//(youtube_embed) and a page can override any of them in its meta file.
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"go-trailer/internal/config"
+	"go-trailer/internal/videos"
+)
+
+// pageEmbedSettings is a page's overrides. Anything left out (nil or empty)
+// falls back to the site setting.
+type pageEmbedSettings struct {
+	Autoplay       *bool  `json:"autoplay,omitempty"`
+	Mute           *bool  `json:"mute,omitempty"`
+	ModestBranding *bool  `json:"modest_branding,omitempty"`
+	CaptionsLang   string `json:"captions_lang,omitempty"`
+}
+
+// withPageEmbed applies a page's overrides on top of the site's settings.
+func withPageEmbed(s config.YouTubeEmbedSettings, page *pageEmbedSettings) config.YouTubeEmbedSettings {
+	if page == nil {
+		return s
+	}
+	if page.Autoplay != nil {
+		s.Autoplay = *page.Autoplay
+	}
+	if page.Mute != nil {
+		s.Mute = *page.Mute
+	}
+	if page.ModestBranding != nil {
+		s.ModestBranding = *page.ModestBranding
+	}
+	if page.CaptionsLang != "" {
+		s.CaptionsLang = page.CaptionsLang
+	}
+	return s
+}
+
+// embedURL is the player URL for a video with the settings s applied.
+func embedURL(s config.YouTubeEmbedSettings, videoID string) string {
+	params := url.Values{}
+	if s.Autoplay {
+		params.Set("autoplay", "1")
+	}
+	if s.Mute {
+		params.Set("mute", "1")
+	}
+	if s.ModestBranding {
+		params.Set("modestbranding", "1")
+	}
+	if s.CaptionsLang != "" {
+		params.Set("cc_load_policy", "1")
+		params.Set("cc_lang_pref", s.CaptionsLang)
+	}
+
+	src := videos.EmbedURL(videoID)
+	if len(params) > 0 {
+		src += "?" + params.Encode()
+	}
+	return src
+}
+
+// embedSettingsHandler handles POST /api/page/{slug}/embed with a JSON body of
+// the page's overrides, e.g. {"autoplay": true, "mute": true}. An empty object
+// goes back to the site defaults.
+func (srv *Server) embedSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug, ok := editablePage(w, r, "embed")
+	if !ok {
+		return
+	}
+
+	var settings pageEmbedSettings
+	if err := readJSON(w, r, &settings); err != nil {
+		badJSON(w, err)
+		return
+	}
+	if settings.CaptionsLang != "" && !config.CaptionsLangRegex.MatchString(settings.CaptionsLang) {
+		fieldError(w, "captions_lang", "invalid_captions_lang", "Invalid captions language")
+		return
+	}
+
+	siteOf(r.Context()).pageMetaMu.Lock()
+	defer siteOf(r.Context()).pageMetaMu.Unlock()
+	meta, err := loadPageMeta(r.Context(), slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading page meta", "err", err)
+		apiError(w, "Could not save embed settings", http.StatusInternalServerError)
+		return
+	}
+	meta.Embed = &settings
+	if settings == (pageEmbedSettings{}) {
+		meta.Embed = nil
+	}
+	meta.edited(editorName(r))
+	if err := savePageMeta(r.Context(), slug, meta); err != nil {
+		if quotaError(w, err) {
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error writing page meta", "err", err)
+		apiError(w, "Could not save embed settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Embed settings saved!"))
+	slog.InfoContext(r.Context(), "Embed settings saved")
+	purgePage(r.Context(), slug)
+}
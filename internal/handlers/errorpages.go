@@ -0,0 +1,77 @@
+package handlers
+
+//Error pages for people. Handlers answer with http.NotFound and http.Error
+//as always; when a browser asked, the plain text is swapped for
+//not-found.html or error.html, so a missing page looks like the rest of the
+//site and offers to create it. API clients and anything else not asking for
+//HTML still get the text.
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// withErrorPages renders the error pages for the 404s and 500s next answers
+// browsers with.
+func withErrorPages(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&errorPageWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+// errorPageWriter passes a response through, unless it's a plain text 404
+// or 500, which it answers with a page instead.
+type errorPageWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	wroteHeader bool
+	replaced    bool // The handler's body is being dropped
+}
+
+func (e *errorPageWriter) WriteHeader(code int) {
+	if e.wroteHeader {
+		return
+	}
+	e.wroteHeader = true
+	h := e.ResponseWriter.Header()
+	if (code != http.StatusNotFound && code != http.StatusInternalServerError) || !strings.HasPrefix(h.Get("Content-Type"), "text/plain") {
+		e.ResponseWriter.WriteHeader(code)
+		return
+	}
+	e.replaced = true
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Del("Content-Length")
+	h.Del("X-Content-Type-Options")
+	e.ResponseWriter.WriteHeader(code)
+	if e.r.Method == http.MethodHead {
+		return
+	}
+	var err error
+	if code == http.StatusNotFound {
+		err = renderTemplate(e.r.Context(), e.ResponseWriter, "not-found.html", buildNotFoundView(e.r))
+	} else {
+		err = renderTemplate(e.r.Context(), e.ResponseWriter, "error.html", buildErrorView(e.r, code))
+	}
+	if err != nil {
+		slog.ErrorContext(e.r.Context(), "Error executing error page template", "status", code, "err", err)
+	}
+}
+
+func (e *errorPageWriter) Write(b []byte) (int, error) {
+	if !e.wroteHeader {
+		e.WriteHeader(http.StatusOK)
+	}
+	if e.replaced {
+		return len(b), nil
+	}
+	return e.ResponseWriter.Write(b)
+}
+
+func (e *errorPageWriter) Unwrap() http.ResponseWriter {
+	return e.ResponseWriter
+}
@@ -0,0 +1,188 @@
+package handlers
+
+//The /events stream: server-sent events for what happens on the site, for
+//dashboards and the homepage to keep up with. Events are
+//
+//	page-created  {"slug": "...", "title": "...", "url": "..."}, also when a draft is published
+//	page-edited   the same
+//	video-added   {"slug": "...", "video_id": "..."}
+//	vote          {"slug": "...", "video_id": "...", "votes": 3}
+//
+//Drafts are left out. Each site keeps its last few events, so a client that
+//reconnects with Last-Event-ID gets the ones it missed; if it missed more than
+//that, or the server restarted since, it gets a reset event instead, to say
+//it should fetch everything afresh.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How often a quiet stream gets a comment, so proxies don't hang up on it.
+const streamKeepAlive = 30 * time.Second
+
+// How many of a site's events are kept for clients reconnecting.
+const siteEventBacklog = 100
+
+// Events a client can fall behind by before it's cut off. It reconnects and
+// catches up from the backlog.
+const siteEventBuffer = 64
+
+// siteEvent is one thing that happened, ready to send.
+type siteEvent struct {
+	id   int64
+	kind string
+	data []byte
+}
+
+// siteEvents is one site's recent events and who's following them.
+type siteEvents struct {
+	sync.Mutex
+	lastID  int64
+	backlog []siteEvent // Oldest first
+	subs    map[chan siteEvent]struct{}
+}
+
+// eventsOf is the site's events, locked. Callers unlock them.
+func eventsOf(s *site) *siteEvents {
+	events := &s.events
+	events.Lock()
+	if events.subs == nil {
+		// IDs carry on from the last run's, more or less, so a client's
+		// Last-Event-ID from before a restart is never mistaken for one of ours
+		events.lastID = time.Now().UnixMicro()
+		events.subs = make(map[chan siteEvent]struct{})
+	}
+	return events
+}
+
+// publishSiteEvent sends an event to everyone following the site's events.
+// Anyone too far behind to take it is cut off, and catches up when they
+// reconnect.
+func publishSiteEvent(ctx context.Context, kind string, data any) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error encoding event", "event", kind, "err", err)
+		return
+	}
+	events := eventsOf(siteOf(ctx))
+	defer events.Unlock()
+	events.lastID++
+	event := siteEvent{id: events.lastID, kind: kind, data: encoded}
+	events.backlog = append(events.backlog, event)
+	if len(events.backlog) > siteEventBacklog {
+		events.backlog = events.backlog[len(events.backlog)-siteEventBacklog:]
+	}
+	for ch := range events.subs {
+		select {
+		case ch <- event:
+		default:
+			delete(events.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// publishPageEvent sends page-created or page-edited for a page, unless it's
+// a draft.
+func publishPageEvent(ctx context.Context, kind, slug string) {
+	if isRestricted(ctx, slug) {
+		return
+	}
+	publishSiteEvent(ctx, kind, map[string]string{
+		"slug":  slug,
+		"title": pageTitle(ctx, slug),
+		"url":   sitePath(ctx, pagePath(slug)),
+	})
+}
+
+// watchSiteEvents starts following the site's events, with those since
+// lastID if there was one. When they can't all be had, resetID is the ID to
+// start over from instead. The returned func stops.
+func watchSiteEvents(ctx context.Context, lastID string) (ch chan siteEvent, since []siteEvent, resetID int64, stop func()) {
+	events := eventsOf(siteOf(ctx))
+	defer events.Unlock()
+	if lastID != "" {
+		id, err := strconv.ParseInt(lastID, 10, 64)
+		oldest := events.lastID - int64(len(events.backlog)) // The one before the backlog
+		switch {
+		case err != nil || id > events.lastID || id < oldest:
+			resetID = events.lastID
+		default:
+			since = append(since, events.backlog[len(events.backlog)-int(events.lastID-id):]...)
+		}
+	}
+
+	ch = make(chan siteEvent, siteEventBuffer)
+	events.subs[ch] = struct{}{}
+	return ch, since, resetID, func() {
+		events.Lock()
+		defer events.Unlock()
+		if _, ok := events.subs[ch]; ok {
+			delete(events.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// eventsHandler serves /events, the site's events as a text/event-stream.
+func (srv *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	events, since, resetID, stop := watchSiteEvents(r.Context(), r.Header.Get("Last-Event-ID"))
+	defer stop()
+
+	rc := http.NewResponseController(w)
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Accel-Buffering", "no") // Or nginx holds on to the events
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", 5000) // Milliseconds before reconnecting
+	if resetID != 0 {
+		// Whatever they have is stale; the ID lets them pick up from here
+		fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", resetID)
+	}
+	for _, event := range since {
+		writeSiteEvent(w, event)
+	}
+	if err := rc.Flush(); err != nil {
+		slog.ErrorContext(r.Context(), "Error starting event stream", "err", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return // Fell behind, they'll catch up when they reconnect
+			}
+			writeSiteEvent(w, event)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": still here\n\n")
+		case <-r.Context().Done():
+			return
+		case <-srv.streamsClosed:
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return // They've gone
+		}
+	}
+}
+
+// writeSiteEvent writes one event in event stream format.
+func writeSiteEvent(w http.ResponseWriter, event siteEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.id, event.kind, event.data)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+//Exports a page as a single self-contained HTML file. Stylesheets are inlined
+//and videos become thumbnail images embedded in the file itself, so the export
+//still looks right offline, printed, or saved as a PDF. ?format=md is the
+//page as Markdown with front matter instead, the same as in a site export,
+//and ?format=pdf a plain PDF of it, see pdf.go.
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go-trailer/internal/videos"
+)
+
+// The stylesheets inlined into exports. print.css is wrapped in @media print.
+var exportStylesheets = []string{"styles.css", "print.css"}
+
+// Don't let a misbehaving thumbnail server blow up the export.
+const maxThumbnailSize = 2 << 20 // 2 MiB
+
+// exportVideo is a video swapped out for a link and a local copy of its thumbnail.
+type exportVideo struct {
+	YouTubeVideo
+	WatchURL  string
+	Thumbnail template.URL // A data: URL, empty if we couldn't fetch one
+}
+
+// exportPageHandler serves /page/{slug}/export as a downloadable HTML,
+// Markdown or PDF file.
+func exportPageHandler(w http.ResponseWriter, r *http.Request, page *Page) {
+	switch r.URL.Query().Get("format") {
+	case "", "html":
+	case "md":
+		text, err := markdownPage(r.Context(), page.Slug)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error exporting page as Markdown", "err", err)
+			http.Error(w, "Could not export page", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.md"`, page.Slug))
+		w.Write(text)
+		return
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, page.Slug))
+		w.Write(pagePDF(page))
+		return
+	default:
+		http.Error(w, "format must be html, md or pdf", http.StatusBadRequest)
+		return
+	}
+
+	css, err := inlineStylesheets(siteOf(r.Context()).staticDir)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading stylesheets for export", "err", err)
+		http.Error(w, "Could not export page", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.html"`, page.Slug))
+	if err := renderTemplate(r.Context(), w, "export.html", buildExportView(page, css)); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing export template", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// inlineStylesheets reads the site's CSS from dir so it can go in a <style> tag.
+func inlineStylesheets(dir string) (template.CSS, error) {
+	var b strings.Builder
+	for _, name := range exportStylesheets {
+		css, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		if name == "print.css" {
+			b.WriteString("@media print {\n")
+			b.Write(css)
+			b.WriteString("\n}\n")
+		} else {
+			b.Write(css)
+			b.WriteString("\n")
+		}
+	}
+	return template.CSS(b.String()), nil
+}
+
+// fetchThumbnail downloads a video's thumbnail and returns it as a data: URL.
+// Exports still work without one, the video just shows up as a plain link.
+func fetchThumbnail(videoID string) template.URL {
+	resp, err := outboundClient.Get(videos.ThumbnailURL(videoID))
+	if err != nil {
+		slog.Warn("Error fetching thumbnail", "video", videoID, "err", err)
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		slog.Warn("Thumbnail fetch failed", "video", videoID, "response", resp.Status)
+		return ""
+	}
+
+	image, err := io.ReadAll(io.LimitReader(resp.Body, maxThumbnailSize))
+	if err != nil {
+		slog.Warn("Error reading thumbnail", "video", videoID, "err", err)
+		return ""
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = "image/jpeg"
+	}
+	// This is our own base64 of an image we fetched, so it's safe to mark as a URL
+	return template.URL("data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(image))
+}
@@ -0,0 +1,148 @@
+package handlers
+
+//Builds the Atom feed (/feed.xml) of recently created or updated pages
+
+import (
+	"context"
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// How many pages end up in the feed, newest first.
+const feedEntryLimit = 20
+
+// atomFeed is the root <feed> element of an Atom 1.0 document (RFC 4287).
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomLink is an Atom <link>, used for both the feed and its entries.
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// atomEntry is a single page in the feed.
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+	Summary string     `xml:"summary"`
+}
+
+// pageModTime returns when a page was last touched. Adding a YouTube link
+// counts as an update, so we take the newest of the page and its link file,
+// and of when it was published if it started out as a draft.
+func pageModTime(ctx context.Context, slug string) (time.Time, error) {
+	modTime, err := storeCtx(ctx).ModTime(slug + ".txt")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if linksTime, err := storeCtx(ctx).ModTime(slug + ".youtube.txt"); err == nil && linksTime.After(modTime) {
+		modTime = linksTime
+	}
+	// A draft written a while ago is news the moment it's published
+	if meta, err := loadPageMeta(ctx, slug); err == nil {
+		if published := meta.publishedAt(time.Now()); published.After(modTime) {
+			modTime = published
+		}
+	}
+	return modTime, nil
+}
+
+// siteBaseURL is the absolute URL of the site, since feed readers need absolute
+// links. The configured site URL wins, otherwise we work it out from the request.
+func siteBaseURL(r *http.Request) string {
+	if url := siteOf(r.Context()).url; url != "" {
+		return url
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + siteOf(r.Context()).basePath
+}
+
+// feedHandler serves /feed.xml, an Atom feed of the most recently updated pages.
+func (srv *Server) feedHandler(w http.ResponseWriter, r *http.Request) {
+	slugs, err := publishedSlugs(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading pages directory", "err", err)
+		http.Error(w, "Could not build feed", http.StatusInternalServerError)
+		return
+	}
+
+	type feedPage struct {
+		slug    string
+		modTime time.Time
+	}
+	var pages []feedPage
+	for _, slug := range slugs {
+		modTime, err := pageModTime(r.Context(), slug)
+		if err != nil {
+			continue // Deleted between ReadDir and Stat, just skip it
+		}
+		pages = append(pages, feedPage{slug: slug, modTime: modTime})
+	}
+
+	// Newest first, then keep only the top few
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].modTime.After(pages[j].modTime)
+	})
+	if len(pages) > feedEntryLimit {
+		pages = pages[:feedEntryLimit]
+	}
+
+	base := siteBaseURL(r)
+	feed := atomFeed{
+		Title: siteOf(r.Context()).title,
+		ID:    base + "/",
+		Links: []atomLink{
+			{Href: base + "/feed.xml", Rel: "self", Type: "application/atom+xml"},
+			{Href: base + "/", Rel: "alternate", Type: "text/html"},
+		},
+	}
+
+	// An Atom feed must always have an updated time, even when empty
+	feedUpdated := time.Unix(0, 0)
+	for _, p := range pages {
+		_, body, err := readPageText(r.Context(), p.slug)
+		if err != nil {
+			continue
+		}
+		pageURL := base + pagePath(p.slug)
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   pageTitle(r.Context(), p.slug),
+			ID:      pageURL,
+			Updated: p.modTime.UTC().Format(time.RFC3339),
+			Links:   []atomLink{{Href: pageURL, Rel: "alternate", Type: "text/html"}},
+			Summary: excerpt(string(body), 200),
+		})
+		if p.modTime.After(feedUpdated) {
+			feedUpdated = p.modTime
+		}
+	}
+	feed.Updated = feedUpdated.UTC().Format(time.RFC3339)
+
+	output, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error marshalling feed", "err", err)
+		http.Error(w, "Could not build feed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(output)
+}
@@ -0,0 +1,311 @@
+package handlers
+
+//Front matter: an optional block of settings at the very top of a page file,
+//between --- lines in YAML or +++ lines in TOML, like
+//
+//	---
+//	title: Café Münch
+//	tags: [coffee, berlin]
+//	---
+//
+//It can give the page's title, description, tags, author and date, and turn
+//on math. The page is shown without it.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatter is what a page's front matter can set.
+type frontMatter struct {
+	Title       string   `yaml:"title"`
+	Description string   `yaml:"description"`
+	Tags        []string `yaml:"tags"`
+	Author      string   `yaml:"author"`
+	Date        string   `yaml:"date"` // e.g. 2024-05-01 or 2024-05-01T09:30:00Z
+	Math        bool     `yaml:"math"` // Draw $TeX$ in the page with KaTeX
+}
+
+// Layouts accepted for the date, most precise first.
+var frontMatterDateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// splitFrontMatter separates a page file into its front matter and the rest
+// of its text. A page without front matter is all body. Front matter we can't
+// make sense of is still cut off the body, and reported in err.
+func splitFrontMatter(text string) (fm frontMatter, body string, err error) {
+	delim, source, body, ok := cutFrontMatter(text)
+	if !ok {
+		return fm, text, nil
+	}
+	if delim == "---" {
+		err = yaml.Unmarshal([]byte(source), &fm)
+	} else {
+		err = parseTOMLFrontMatter(source, &fm)
+	}
+	if err != nil {
+		return frontMatter{}, body, fmt.Errorf("front matter: %w", err)
+	}
+	return fm, body, nil
+}
+
+// cutFrontMatter cuts a page file's front matter off its body, reporting
+// whether it has any: YAML between --- lines or TOML between +++ lines.
+func cutFrontMatter(text string) (delim, source, body string, ok bool) {
+	switch {
+	case strings.HasPrefix(text, "---\n"), strings.HasPrefix(text, "---\r\n"):
+		delim = "---"
+	case strings.HasPrefix(text, "+++\n"), strings.HasPrefix(text, "+++\r\n"):
+		delim = "+++"
+	default:
+		return "", "", text, false
+	}
+
+	_, rest, _ := strings.Cut(text, "\n")
+	var block []string
+	for {
+		line, next, more := strings.Cut(rest, "\n")
+		if strings.TrimRight(line, "\r") == delim {
+			return delim, strings.Join(block, "\n"), next, true
+		}
+		if !more {
+			return "", "", text, false // Never closed, so it wasn't front matter after all
+		}
+		block = append(block, line)
+		rest = next
+	}
+}
+
+// readPageText reads a page file and splits off its front matter, for the
+// places that just want the text. Broken front matter is left for loadPage
+// to complain about.
+func readPageText(ctx context.Context, slug string) (frontMatter, string, error) {
+	text, err := storeCtx(ctx).ReadFile(slug + ".txt")
+	if err != nil {
+		return frontMatter{}, "", err
+	}
+	fm, body, _ := splitFrontMatter(string(text))
+	return fm, body, nil
+}
+
+// date is the front matter's date, zero if there isn't one.
+func (fm frontMatter) date() (time.Time, error) {
+	if fm.Date == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range frontMatterDateLayouts {
+		if t, err := time.Parse(layout, fm.Date); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("front matter: can't read date %q", fm.Date)
+}
+
+// parseTOMLFrontMatter reads the little bit of TOML front matter needs:
+// key = value lines where the value is a string, a date, a boolean or an
+// array of strings. Keys we don't know are skipped.
+func parseTOMLFrontMatter(source string, fm *frontMatter) error {
+	for n, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("line %d: expected key = value", n+1)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		var err error
+		switch key {
+		case "title":
+			fm.Title, err = tomlString(value)
+		case "description":
+			fm.Description, err = tomlString(value)
+		case "author":
+			fm.Author, err = tomlString(value)
+		case "tags":
+			fm.Tags, err = tomlStrings(value)
+		case "math":
+			fm.Math, err = tomlBool(value)
+		case "date":
+			fm.Date = value // Dates are bare in TOML, but take a quoted one too
+			if s, qerr := tomlString(value); qerr == nil {
+				fm.Date = s
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %s: %w", n+1, key, err)
+		}
+	}
+	return nil
+}
+
+// tomlString reads a "basic" or 'literal' TOML string.
+func tomlString(value string) (string, error) {
+	switch {
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1], nil
+	case len(value) >= 2 && value[0] == '"':
+		return strconv.Unquote(value)
+	}
+	return "", errors.New("expected a quoted string")
+}
+
+// tomlBool reads a TOML true or false.
+func tomlBool(value string) (bool, error) {
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, errors.New("expected true or false")
+}
+
+// tomlStrings reads a one-line TOML array of strings, like ["a", 'b'].
+func tomlStrings(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return nil, errors.New("expected an array")
+	}
+	var items []string
+	for item := range strings.SplitSeq(value[1:len(value)-1], ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue // Trailing comma
+		}
+		s, err := tomlString(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, s)
+	}
+	return items, nil
+}
+
+// setFrontMatterTags rewrites a page file's tags, leaving the rest of its
+// front matter (and its body) as it was. A page without front matter gets
+// some, in YAML.
+func setFrontMatterTags(text string, tags []string) (string, error) {
+	if _, _, err := splitFrontMatter(text); err != nil {
+		return "", err // Not ours to fix up
+	}
+	delim, newline := "", "\n"
+	switch {
+	case strings.HasPrefix(text, "---\n"), strings.HasPrefix(text, "---\r\n"):
+		delim = "---"
+	case strings.HasPrefix(text, "+++\n"), strings.HasPrefix(text, "+++\r\n"):
+		delim = "+++"
+	}
+	if strings.HasPrefix(text, delim+"\r\n") {
+		newline = "\r\n"
+	}
+
+	var lines, rest []string
+	if delim != "" {
+		all := strings.Split(text, "\n")
+		end := slices.IndexFunc(all[1:], func(line string) bool { return strings.TrimRight(line, "\r") == delim }) + 1
+		if end == 0 {
+			delim = "" // Never closed, so it's all body
+		} else {
+			for _, line := range all[1:end] {
+				lines = append(lines, strings.TrimRight(line, "\r"))
+			}
+			rest = all[end+1:]
+		}
+	}
+	if delim == "" {
+		if len(tags) == 0 {
+			return text, nil
+		}
+		delim, rest = "---", strings.Split(text, "\n")
+	}
+
+	var err error
+	if delim == "---" {
+		lines, err = setYAMLTags(lines, tags)
+	} else {
+		lines = setTOMLTags(lines, tags)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(lines) == 0 {
+		return strings.Join(rest, "\n"), nil // Nothing left worth keeping
+	}
+	block := delim + newline + strings.Join(lines, newline) + newline + delim + "\n"
+	return block + strings.Join(rest, "\n"), nil
+}
+
+// setYAMLTags sets tags: in YAML front matter, keeping the other keys and
+// their comments.
+func setYAMLTags(lines []string, tags []string) ([]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(strings.Join(lines, "\n")), &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("front matter: expected a mapping")
+	}
+	value := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+	for _, tag := range tags {
+		value.Content = append(value.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: tag})
+	}
+
+	i := 0
+	for i < len(root.Content) && root.Content[i].Value != "tags" {
+		i += 2
+	}
+	switch {
+	case i < len(root.Content) && len(tags) == 0:
+		root.Content = slices.Delete(root.Content, i, i+2)
+	case i < len(root.Content):
+		root.Content[i+1] = value
+	case len(tags) > 0:
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "tags"}, value)
+	}
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n"), nil
+}
+
+// setTOMLTags sets tags = in TOML front matter, keeping every other line.
+func setTOMLTags(lines []string, tags []string) []string {
+	quoted := make([]string, len(tags))
+	for i, tag := range tags {
+		quoted[i] = strconv.Quote(tag)
+	}
+	line := "tags = [" + strings.Join(quoted, ", ") + "]"
+
+	i := slices.IndexFunc(lines, func(l string) bool {
+		key, _, ok := strings.Cut(l, "=")
+		return ok && strings.TrimSpace(key) == "tags"
+	})
+	switch {
+	case i >= 0 && len(tags) == 0:
+		lines = slices.Delete(lines, i, i+1)
+	case i >= 0:
+		lines[i] = line
+	case len(tags) > 0:
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,182 @@
+package handlers
+
+//Checks the files in a store hang together: every votes file parses, every
+//line of a link file is a YouTube link, every vote is for a video the page
+//has, and no page's companion files or attachments outlive it. GET
+///admin/fsck reports what's wrong with the site's, POST removes the orphans
+//and the votes for videos that are gone too, and `go-trailer check [-fix]`
+//does the same for the pages directory.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"go-trailer/internal/storage"
+	"go-trailer/internal/videos"
+)
+
+// FsckProblem is something wrong with a file.
+type FsckProblem struct {
+	File    string `json:"file"`
+	Problem string `json:"problem"`
+	Orphan  bool   `json:"orphan,omitempty"` // Belongs to a page that isn't there, so it can go
+	Fixed   bool   `json:"fixed,omitempty"`
+}
+
+// checkStore finds what's wrong with the site's files. With fix set, orphans
+// are removed and votes files compacted as they're found.
+func checkStore(ctx context.Context, fix bool) ([]FsckProblem, error) {
+	if fix {
+		siteOf(ctx).createMu.Lock() // So no page of an orphan's name turns up as it goes
+		defer siteOf(ctx).createMu.Unlock()
+	}
+	pages := storeCtx(ctx)
+	names, err := pages.List()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(names)
+	isPage := make(map[string]bool)
+	for _, name := range names {
+		if storage.IsPageFile(name) {
+			isPage[strings.TrimSuffix(name, ".txt")] = true
+		}
+	}
+
+	problems := []FsckProblem{} // [] rather than null
+	for _, name := range names {
+		if strings.HasPrefix(name, "~") || storage.IsPageFile(name) {
+			continue // Pages, and what's in the trash
+		}
+		slug, ok := companionSlug(name)
+		if !ok {
+			continue // One of the site's own files
+		}
+		if !isPage[slug] {
+			problem := FsckProblem{File: name, Problem: "its page " + slug + " doesn't exist", Orphan: true}
+			if fix {
+				if err := pages.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return problems, err
+				}
+				problem.Fixed = true
+				slog.InfoContext(ctx, "Orphaned file removed", "file", name)
+			}
+			problems = append(problems, problem)
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, ".youtube.txt"):
+			problems = append(problems, checkLinkFile(ctx, name)...)
+		case strings.HasSuffix(name, ".votes.json"):
+			problems = append(problems, checkVotesFile(ctx, slug, fix)...)
+		}
+	}
+	return problems, nil
+}
+
+// companionSlug is the page a file belongs to, if it's a page's: one of its
+// companion files, or an attachment.
+func companionSlug(name string) (string, bool) {
+	if slug, _, ok := strings.Cut(name, "@"); ok {
+		return slug, true
+	}
+	for _, suffix := range pageCompanionSuffixes {
+		if slug, ok := strings.CutSuffix(name, suffix); ok {
+			return slug, true
+		}
+	}
+	return "", false
+}
+
+// checkLinkFile finds lines of a link file that aren't YouTube links.
+func checkLinkFile(ctx context.Context, name string) []FsckProblem {
+	data, err := storeCtx(ctx).ReadFile(name)
+	if err != nil {
+		return []FsckProblem{{File: name, Problem: "can't be read: " + err.Error()}}
+	}
+	var problems []FsckProblem
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		if _, videoID := videos.Parse(line); videoID == "" {
+			problems = append(problems, FsckProblem{File: name, Problem: fmt.Sprintf("line %d isn't a YouTube link: %q", i+1, line)})
+		}
+	}
+	return problems
+}
+
+// checkVotesFile finds whether a page's votes file parses, and votes in it
+// for videos the page doesn't have, dropping them with fix set.
+func checkVotesFile(ctx context.Context, slug string, fix bool) []FsckProblem {
+	name := slug + ".votes.json"
+	votes, err := readVotes(ctx, slug)
+	if err != nil {
+		return []FsckProblem{{File: name, Problem: "doesn't parse: " + err.Error()}}
+	}
+	videos, err := pageVideoIDs(ctx, slug)
+	if err != nil {
+		return []FsckProblem{{File: name, Problem: "its page's links can't be read: " + err.Error()}}
+	}
+	var gone []string
+	for _, videoID := range slices.Sorted(maps.Keys(votes)) {
+		if !videos[videoID] {
+			gone = append(gone, videoID)
+		}
+	}
+	if fix && len(gone) > 0 {
+		if gone, err = compactVotes(ctx, slug); err != nil {
+			return []FsckProblem{{File: name, Problem: "can't be compacted: " + err.Error()}}
+		}
+	}
+	var problems []FsckProblem
+	for _, videoID := range gone {
+		problems = append(problems, FsckProblem{File: name, Problem: "has votes for " + videoID + ", which isn't on the page", Fixed: fix})
+	}
+	return problems
+}
+
+// pageVideoIDs is the IDs of the videos in a page's link file.
+func pageVideoIDs(ctx context.Context, slug string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	data, err := storeCtx(ctx).ReadFile(slug + ".youtube.txt")
+	if errors.Is(err, fs.ErrNotExist) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if _, videoID := videos.Parse(line); videoID != "" {
+			ids[videoID] = true
+		}
+	}
+	return ids, nil
+}
+
+// adminFsckHandler serves /admin/fsck. GET answers with {"problems": [...]},
+// POST the same after removing the orphans.
+func (srv *Server) adminFsckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	problems, err := checkStore(r.Context(), r.Method == http.MethodPost)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error checking pages", "err", err)
+		http.Error(w, "Could not check pages", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Pages checked", "problems", len(problems))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{"problems": problems})
+}
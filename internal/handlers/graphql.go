@@ -0,0 +1,1062 @@
+package handlers
+
+//A GraphQL endpoint at /graphql, so an app can fetch a page, its videos and
+//the pages around it in one round trip instead of one request each. GET
+///graphql?query=... or POST {"query": "...", "variables": {...}} runs a
+//query; GET /graphql on its own answers with the schema below.
+//
+//It's a small GraphQL, written by hand so we need no library: operations,
+//aliases, arguments and variables, but no fragments, directives or
+//introspection beyond __typename. Mutations are handed to the same handlers
+//as the JSON API, so they check logins, fire events and purge the CDN the
+//same way.
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-trailer/internal/videos"
+)
+
+// graphqlSchema is what /graphql has, for people and code generators.
+const graphqlSchema = `type Query {
+  page(slug: String!): Page
+  pages(tag: String, first: Int = 50): [Page!]!
+  search(query: String!): [SearchResult!]!
+  popular(limit: Int = 20): [Page!]!
+}
+
+type Mutation {
+  createPage(name: String!): Page
+  addVideo(slug: String!, url: String!): Video
+  vote(slug: String!, video: String!, up: Boolean!): Video
+}
+
+type Page {
+  slug: String!
+  title: String!
+  url: String!
+  body: String!
+  html: String!
+  description: String
+  tags: [String!]!
+  author: String
+  created: String
+  updated: String
+  updatedBy: String
+  draft: Boolean!
+  private: Boolean!
+  archived: Boolean!
+  videos: [Video!]!
+  backlinks: [Page!]!
+  related(first: Int = 5): [Page!]!
+}
+
+type Video {
+  id: String!
+  watchUrl: String!
+  embedUrl: String!
+  votes: Int!
+  page: Page!
+}
+
+type SearchResult {
+  slug: String!
+  title: String!
+  snippet: String!
+  page: Page
+}
+`
+
+const (
+	maxGraphQLRequest = 64 << 10 // Bytes of POST body
+	maxGraphQLDepth   = 8        // Selections inside selections, as each level can load every page
+)
+
+// graphqlRequest is a GraphQL request, as POSTed or from GET's query string.
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// graphqlResponse is the answer to one.
+type graphqlResponse struct {
+	Data   gqlObject  `json:"data,omitempty"`
+	Errors []gqlError `json:"errors,omitempty"`
+}
+
+// gqlError is something that went wrong, and with which field if it was
+// running one.
+type gqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// gqlObject is an object's fields in the order they were asked for, which
+// a map would lose.
+type gqlObject []gqlEntry
+
+type gqlEntry struct {
+	key   string
+	value any
+}
+
+func (o gqlObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, entry := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(entry.key)
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// --- Parsing ---
+
+// gqlOperation is a query or mutation from a request.
+type gqlOperation struct {
+	kind string // "query" or "mutation"
+	name string
+	vars []gqlVarDef
+	sel  []gqlField
+}
+
+// gqlVarDef is a variable an operation takes.
+type gqlVarDef struct {
+	name     string
+	required bool
+	def      any
+}
+
+// gqlField is a field asked for, and what's asked of it in turn.
+type gqlField struct {
+	alias, name string
+	args        map[string]any // gqlVar for variables
+	sel         []gqlField
+}
+
+// gqlVar is a $variable in an argument.
+type gqlVar string
+
+// gqlParser reads a GraphQL document.
+type gqlParser struct {
+	src string
+	pos int
+}
+
+func parseGraphQL(src string) ([]gqlOperation, error) {
+	p := &gqlParser{src: src}
+	var ops []gqlOperation
+	for p.skip(); p.pos < len(p.src); p.skip() {
+		op, err := p.operation()
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return nil, errors.New("no query in the document")
+	}
+	return ops, nil
+}
+
+// skip moves past whitespace, commas and comments.
+func (p *gqlParser) skip() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// punct consumes c if it's next.
+func (p *gqlParser) punct(c byte) bool {
+	p.skip()
+	if p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expect(c byte) error {
+	if !p.punct(c) {
+		return p.errorf("expected %q", c)
+	}
+	return nil
+}
+
+func (p *gqlParser) errorf(format string, args ...any) error {
+	line := 1 + strings.Count(p.src[:min(p.pos, len(p.src))], "\n")
+	return fmt.Errorf("syntax error on line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *gqlParser) name() (string, error) {
+	p.skip()
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || p.pos > start && '0' <= c && c <= '9' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a name")
+	}
+	return p.src[start:p.pos], nil
+}
+
+func (p *gqlParser) operation() (gqlOperation, error) {
+	op := gqlOperation{kind: "query"}
+	if p.src[p.pos] != '{' {
+		kind, err := p.name()
+		if err != nil {
+			return op, err
+		}
+		switch kind {
+		case "query", "mutation":
+			op.kind = kind
+		case "subscription":
+			return op, errors.New("subscriptions aren't supported, see /events for live updates")
+		case "fragment":
+			return op, errors.New("fragments aren't supported")
+		default:
+			return op, p.errorf("unexpected %q", kind)
+		}
+		if p.skip(); p.pos < len(p.src) && p.src[p.pos] != '{' && p.src[p.pos] != '(' {
+			if op.name, err = p.name(); err != nil {
+				return op, err
+			}
+		}
+		if p.punct('(') {
+			for !p.punct(')') {
+				def, err := p.varDef()
+				if err != nil {
+					return op, err
+				}
+				op.vars = append(op.vars, def)
+			}
+		}
+	}
+	var err error
+	op.sel, err = p.selectionSet(1)
+	return op, err
+}
+
+// varDef reads "$name: Type = default". Types are only checked for !, the
+// arguments they end up in check the rest.
+func (p *gqlParser) varDef() (gqlVarDef, error) {
+	var def gqlVarDef
+	if err := p.expect('$'); err != nil {
+		return def, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return def, err
+	}
+	def.name = name
+	if err := p.expect(':'); err != nil {
+		return def, err
+	}
+	if def.required, err = p.typeRef(); err != nil {
+		return def, err
+	}
+	if p.punct('=') {
+		if def.def, err = p.value(true); err != nil {
+			return def, err
+		}
+	}
+	return def, nil
+}
+
+// typeRef reads a type, reporting whether it's non-null.
+func (p *gqlParser) typeRef() (bool, error) {
+	if p.punct('[') {
+		if _, err := p.typeRef(); err != nil {
+			return false, err
+		}
+		if err := p.expect(']'); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+	return p.punct('!'), nil
+}
+
+func (p *gqlParser) selectionSet(depth int) ([]gqlField, error) {
+	if depth > maxGraphQLDepth {
+		return nil, fmt.Errorf("the query is nested more than %d deep", maxGraphQLDepth)
+	}
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	var fields []gqlField
+	for !p.punct('}') {
+		if p.skip(); strings.HasPrefix(p.src[p.pos:], "...") {
+			return nil, errors.New("fragments aren't supported")
+		}
+		var f gqlField
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		f.name = name
+		if p.punct(':') {
+			f.alias = name
+			if f.name, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if p.punct('(') {
+			f.args = make(map[string]any)
+			for !p.punct(')') {
+				arg, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(':'); err != nil {
+					return nil, err
+				}
+				if f.args[arg], err = p.value(false); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if p.punct('@') {
+			return nil, errors.New("directives aren't supported")
+		}
+		if p.skip(); p.pos < len(p.src) && p.src[p.pos] == '{' {
+			if f.sel, err = p.selectionSet(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil, p.errorf("empty selection")
+	}
+	return fields, nil
+}
+
+// value reads an argument's value. Enum values come out as strings, and
+// variables as gqlVar unless constant is set, when they aren't allowed.
+func (p *gqlParser) value(constant bool) (any, error) {
+	p.skip()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected a value")
+	}
+	switch c := p.src[p.pos]; {
+	case c == '$':
+		if constant {
+			return nil, p.errorf("variables can't be used here")
+		}
+		p.pos++
+		name, err := p.name()
+		return gqlVar(name), err
+	case c == '"':
+		return p.stringValue()
+	case c == '[':
+		p.pos++
+		list := []any{}
+		for !p.punct(']') {
+			v, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case c == '{':
+		p.pos++
+		object := make(map[string]any)
+		for !p.punct('}') {
+			key, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+			if object[key], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return object, nil
+	case c == '-' || '0' <= c && c <= '9':
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		number := p.src[start:p.pos]
+		if n, err := strconv.Atoi(number); err == nil {
+			return n, nil
+		}
+		if f, err := strconv.ParseFloat(number, 64); err == nil {
+			return f, nil
+		}
+		return nil, p.errorf("bad number %q", number)
+	}
+	name, err := p.name()
+	switch name {
+	case "true":
+		return true, err
+	case "false":
+		return false, err
+	case "null":
+		return nil, err
+	}
+	return name, err
+}
+
+// stringValue reads a "quoted string", which escapes like JSON does.
+func (p *gqlParser) stringValue() (string, error) {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.src) && p.src[p.pos] != '"' && p.src[p.pos] != '\n' {
+		if p.src[p.pos] == '\\' && p.pos+1 < len(p.src) {
+			p.pos++ // Whatever's escaped, even a quote
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.src) || p.src[p.pos] != '"' {
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+	var s string
+	if err := json.Unmarshal([]byte(p.src[start:p.pos]), &s); err != nil {
+		return "", p.errorf("bad string %s", p.src[start:p.pos])
+	}
+	return s, nil
+}
+
+// --- Running ---
+
+// gqlFieldDef is a field of one of the schema's types: the names of its
+// arguments, the type of object it is if it isn't a scalar, and how to get
+// it from its parent.
+type gqlFieldDef struct {
+	args    []string
+	typ     string
+	resolve func(e *gqlExec, parent any, args map[string]any) (any, error)
+}
+
+// gqlTypes is the schema's types and their fields. Objects are *Page for
+// Page, gqlVideo for Video and SearchResult for SearchResult; lists of them
+// are []any.
+var gqlTypes = map[string]map[string]gqlFieldDef{
+	"Query": {
+		"page": {args: []string{"slug"}, typ: "Page", resolve: func(e *gqlExec, _ any, args map[string]any) (any, error) {
+			slug, err := gqlString(args, "slug")
+			if err != nil {
+				return nil, err
+			}
+			return nilIfNoPage(e.page(slug))
+		}},
+		"pages": {args: []string{"tag", "first"}, typ: "Page", resolve: func(e *gqlExec, _ any, args map[string]any) (any, error) {
+			tag, _ := args["tag"].(string)
+			first, err := gqlLimit(args, "first", defaultListLimit, maxListLimit)
+			if err != nil {
+				return nil, err
+			}
+			return e.publishedPages(first, func(page *Page) bool { return tag == "" || slices.Contains(page.Tags, tag) })
+		}},
+		"search": {args: []string{"query"}, typ: "SearchResult", resolve: func(e *gqlExec, _ any, args map[string]any) (any, error) {
+			if !configOf(e.r.Context()).Features.Search {
+				return nil, errors.New("search is turned off")
+			}
+			query, err := gqlString(args, "query")
+			if err != nil {
+				return nil, err
+			}
+			results, err := searchPages(e.r.Context(), normalizeSearchQuery(query))
+			if err != nil {
+				slog.ErrorContext(e.r.Context(), "Error searching pages", "err", err)
+				return nil, errors.New("could not search pages")
+			}
+			list := []any{}
+			for _, result := range results {
+				list = append(list, result)
+			}
+			return list, nil
+		}},
+		"popular": {args: []string{"limit"}, typ: "Page", resolve: func(e *gqlExec, _ any, args map[string]any) (any, error) {
+			if !siteOf(e.r.Context()).main {
+				return nil, errors.New("view counts are only kept for the main site")
+			}
+			limit, err := gqlLimit(args, "limit", defaultPopularLimit, maxPopularLimit)
+			if err != nil {
+				return nil, err
+			}
+			list := []any{}
+			for _, popular := range popularPages(e.r, limit) {
+				if page, err := e.page(popular.Slug); err == nil && page != nil {
+					list = append(list, page)
+				}
+			}
+			return list, nil
+		}},
+	},
+	"Mutation": {
+		"createPage": {args: []string{"name"}, typ: "Page", resolve: func(e *gqlExec, _ any, args map[string]any) (any, error) {
+			name, err := gqlString(args, "name")
+			if err != nil {
+				return nil, err
+			}
+			rec, err := e.call("/create", createPageRequest{Name: name})
+			if err != nil {
+				return nil, err
+			}
+			slug, err := url.PathUnescape(path.Base(rec.header.Get("Location")))
+			if err != nil {
+				return nil, err
+			}
+			return nilIfNoPage(e.page(slug))
+		}},
+		"addVideo": {args: []string{"slug", "url"}, typ: "Video", resolve: func(e *gqlExec, _ any, args map[string]any) (any, error) {
+			slug, err := gqlString(args, "slug")
+			if err != nil {
+				return nil, err
+			}
+			link, err := gqlString(args, "url")
+			if err != nil {
+				return nil, err
+			}
+			if _, err := e.call("/api/page/"+filepath.Base(slug)+"/save-youtube", saveVideoRequest{URL: link}); err != nil {
+				return nil, err
+			}
+			_, videoID := videos.Parse(link)
+			return e.video(slug, videoID)
+		}},
+		"vote": {args: []string{"slug", "video", "up"}, typ: "Video", resolve: func(e *gqlExec, _ any, args map[string]any) (any, error) {
+			slug, err := gqlString(args, "slug")
+			if err != nil {
+				return nil, err
+			}
+			videoID, err := gqlString(args, "video")
+			if err != nil {
+				return nil, err
+			}
+			up, ok := args["up"].(bool)
+			if !ok {
+				return nil, errors.New("up must be true or false")
+			}
+			action := "downvote"
+			if up {
+				action = "upvote"
+			}
+			if _, err := e.call("/api/vote/"+filepath.Base(slug)+"/"+videoID+"/"+action, nil); err != nil {
+				return nil, err
+			}
+			return e.video(slug, videoID)
+		}},
+	},
+	"Page": {
+		"slug":        pageField(func(p *Page) any { return p.Slug }),
+		"title":       pageField(func(p *Page) any { return p.Title }),
+		"body":        pageField(func(p *Page) any { return p.Body }),
+		"html":        pageField(func(p *Page) any { return string(p.HTML) }),
+		"description": pageField(func(p *Page) any { return gqlOptional(p.Description) }),
+		"tags":        pageField(func(p *Page) any { return tagsOrEmpty(p.Tags) }),
+		"author":      pageField(func(p *Page) any { return gqlOptional(p.Author) }),
+		"created":     pageField(func(p *Page) any { return gqlTime(p.CreatedAt) }),
+		"updated":     pageField(func(p *Page) any { return gqlTime(p.UpdatedAt) }),
+		"updatedBy":   pageField(func(p *Page) any { return gqlOptional(p.UpdatedBy) }),
+		"draft":       pageField(func(p *Page) any { return p.Draft }),
+		"private":     pageField(func(p *Page) any { return p.Private }),
+		"archived":    pageField(func(p *Page) any { return p.Archived }),
+		"url": {resolve: func(e *gqlExec, parent any, _ map[string]any) (any, error) {
+			return siteBaseURL(e.r) + parent.(*Page).path(), nil
+		}},
+		"videos": {typ: "Video", resolve: func(e *gqlExec, parent any, _ map[string]any) (any, error) {
+			page := parent.(*Page)
+			list := []any{}
+			for _, video := range page.YouTubeEmbed {
+				list = append(list, gqlVideo{YouTubeVideo: video, page: page})
+			}
+			return list, nil
+		}},
+		"backlinks": {typ: "Page", resolve: func(e *gqlExec, parent any, _ map[string]any) (any, error) {
+			linking, err := backlinks(e.r.Context(), parent.(*Page).Slug)
+			if err != nil {
+				slog.ErrorContext(e.r.Context(), "Error finding backlinks", "err", err)
+				return nil, errors.New("could not find backlinks")
+			}
+			list := []any{}
+			for _, link := range linking {
+				if page, err := e.page(link.Slug); err == nil && page != nil {
+					list = append(list, page)
+				}
+			}
+			return list, nil
+		}},
+		"related": {args: []string{"first"}, typ: "Page", resolve: func(e *gqlExec, parent any, args map[string]any) (any, error) {
+			first, err := gqlLimit(args, "first", 5, maxListLimit)
+			if err != nil {
+				return nil, err
+			}
+			return e.relatedPages(parent.(*Page), first)
+		}},
+	},
+	"Video": {
+		"id":       videoField(func(v gqlVideo) any { return v.ID }),
+		"watchUrl": videoField(func(v gqlVideo) any { return "https://www.youtube.com/watch?v=" + v.ID }),
+		"embedUrl": videoField(func(v gqlVideo) any { return v.URL }),
+		"votes":    videoField(func(v gqlVideo) any { return v.Votes }),
+		"page":     {typ: "Page", resolve: func(_ *gqlExec, parent any, _ map[string]any) (any, error) { return parent.(gqlVideo).page, nil }},
+	},
+	"SearchResult": {
+		"slug":    searchField(func(s SearchResult) any { return s.Slug }),
+		"title":   searchField(func(s SearchResult) any { return s.Title }),
+		"snippet": searchField(func(s SearchResult) any { return s.Snippet }),
+		"page": {typ: "Page", resolve: func(e *gqlExec, parent any, _ map[string]any) (any, error) {
+			return nilIfNoPage(e.page(parent.(SearchResult).Slug))
+		}},
+	},
+}
+
+// gqlVideo is a video, and the page it's on.
+type gqlVideo struct {
+	YouTubeVideo
+	page *Page
+}
+
+func pageField(get func(*Page) any) gqlFieldDef {
+	return gqlFieldDef{resolve: func(_ *gqlExec, parent any, _ map[string]any) (any, error) { return get(parent.(*Page)), nil }}
+}
+
+func videoField(get func(gqlVideo) any) gqlFieldDef {
+	return gqlFieldDef{resolve: func(_ *gqlExec, parent any, _ map[string]any) (any, error) { return get(parent.(gqlVideo)), nil }}
+}
+
+func searchField(get func(SearchResult) any) gqlFieldDef {
+	return gqlFieldDef{resolve: func(_ *gqlExec, parent any, _ map[string]any) (any, error) { return get(parent.(SearchResult)), nil }}
+}
+
+// gqlOptional is s, or null if it's empty.
+func gqlOptional(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// gqlTime is t as RFC 3339, or null if it's zero.
+func gqlTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// nilIfNoPage turns a missing page into a plain nil, which a nil *Page in
+// an any isn't.
+func nilIfNoPage(page *Page, err error) (any, error) {
+	if page == nil || err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// gqlString is a required string argument.
+func gqlString(args map[string]any, name string) (string, error) {
+	s, ok := args[name].(string)
+	if !ok || s == "" {
+		return "", fmt.Errorf("%s is required", name)
+	}
+	return s, nil
+}
+
+// gqlLimit is an optional count argument, def if it's missing and at most
+// most. JSON variables come in as float64.
+func gqlLimit(args map[string]any, name string, def, most int) (int, error) {
+	var n int
+	switch v := args[name].(type) {
+	case nil:
+		return def, nil
+	case int:
+		n = v
+	case float64:
+		n = int(v)
+	default:
+		return 0, fmt.Errorf("%s must be a number", name)
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("%s must be at least 1", name)
+	}
+	return min(n, most), nil
+}
+
+// gqlExec runs an operation for a request.
+type gqlExec struct {
+	r      *http.Request
+	mux    http.Handler
+	vars   map[string]any
+	pages  map[string]*Page // Loaded so far, nil for those that can't be seen
+	errors []gqlError
+}
+
+// validate checks the fields asked of typ exist, take the arguments given,
+// and are asked for what's in them if they're objects.
+func validate(typ string, sel []gqlField) error {
+	for _, f := range sel {
+		if f.name == "__typename" {
+			continue
+		}
+		def, ok := gqlTypes[typ][f.name]
+		if !ok {
+			return fmt.Errorf("%s has no field %s", typ, f.name)
+		}
+		for arg := range f.args {
+			if !slices.Contains(def.args, arg) {
+				return fmt.Errorf("%s.%s takes no argument %s", typ, f.name, arg)
+			}
+		}
+		switch {
+		case def.typ == "" && f.sel != nil:
+			return fmt.Errorf("%s.%s has no fields to select", typ, f.name)
+		case def.typ != "" && f.sel == nil:
+			return fmt.Errorf("%s.%s needs fields selected", typ, f.name)
+		case def.typ != "":
+			if err := validate(def.typ, f.sel); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// object runs the fields asked of obj, a typ. Fields that fail are null,
+// with an error saying why.
+func (e *gqlExec) object(typ string, obj any, sel []gqlField, at []any) gqlObject {
+	out := make(gqlObject, 0, len(sel))
+	for _, f := range sel {
+		key := cmp.Or(f.alias, f.name)
+		if f.name == "__typename" {
+			out = append(out, gqlEntry{key, typ})
+			continue
+		}
+		def := gqlTypes[typ][f.name]
+		fieldAt := append(slices.Clip(at), key)
+		value, err := def.resolve(e, obj, e.args(f.args))
+		if err != nil {
+			e.errors = append(e.errors, gqlError{Message: err.Error(), Path: fieldAt})
+			value = nil
+		}
+		out = append(out, gqlEntry{key, e.complete(def.typ, value, f.sel, fieldAt)})
+	}
+	return out
+}
+
+// complete runs the fields asked of a field's value, if it's an object or a
+// list of them.
+func (e *gqlExec) complete(typ string, value any, sel []gqlField, at []any) any {
+	if typ == "" || value == nil {
+		return value
+	}
+	if list, ok := value.([]any); ok {
+		out := make([]any, len(list))
+		for i, item := range list {
+			out[i] = e.complete(typ, item, sel, append(slices.Clip(at), i))
+		}
+		return out
+	}
+	return e.object(typ, value, sel, at)
+}
+
+// args is a field's arguments with the variables filled in.
+func (e *gqlExec) args(args map[string]any) map[string]any {
+	filled := make(map[string]any, len(args))
+	for name, value := range args {
+		filled[name] = e.fill(value)
+	}
+	return filled
+}
+
+func (e *gqlExec) fill(value any) any {
+	switch v := value.(type) {
+	case gqlVar:
+		return e.vars[string(v)]
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = e.fill(item)
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			out[key] = e.fill(item)
+		}
+		return out
+	}
+	return value
+}
+
+// page loads a page by slug, following renames as /page/ does. It's nil
+// if there's no such page, or it's a draft the request can't see.
+func (e *gqlExec) page(slug string) (*Page, error) {
+	slug = filepath.Base(slug)
+	if page, ok := e.pages[slug]; ok {
+		return page, nil
+	}
+	ctx := e.r.Context()
+	page, err := loadPage(ctx, slug)
+	if errors.Is(err, fs.ErrNotExist) {
+		target, ok := redirectTarget(ctx, slug)
+		if !ok {
+			target, ok = canonicalSlug(ctx, slug)
+		}
+		if ok {
+			page, err = loadPage(ctx, target)
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		page, err = nil, nil
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error loading page", "page", slug, "err", err)
+		return nil, errors.New("could not load page " + slug)
+	}
+	if page != nil && (page.Draft || page.Private) {
+		if !canSeePage(e.r, accessMeta(ctx, page.Slug)) {
+			page = nil
+		}
+	}
+	e.pages[slug] = page
+	return page, nil
+}
+
+// publishedPages is up to first of the published pages keep says yes to,
+// in slug order.
+func (e *gqlExec) publishedPages(first int, keep func(*Page) bool) ([]any, error) {
+	slugs, err := publishedSlugs(e.r.Context())
+	if err != nil {
+		slog.ErrorContext(e.r.Context(), "Error listing pages", "err", err)
+		return nil, errors.New("could not list pages")
+	}
+	list := []any{}
+	for _, slug := range slugs {
+		if len(list) == first {
+			break
+		}
+		if page, err := e.page(slug); err == nil && page != nil && keep(page) {
+			list = append(list, page)
+		}
+	}
+	return list, nil
+}
+
+// relatedPages is up to first of the published pages sharing tags with
+// page, those sharing the most first.
+func (e *gqlExec) relatedPages(page *Page, first int) ([]any, error) {
+	if len(page.Tags) == 0 {
+		return []any{}, nil
+	}
+	shared := func(other *Page) int {
+		n := 0
+		for _, tag := range other.Tags {
+			if slices.Contains(page.Tags, tag) {
+				n++
+			}
+		}
+		return n
+	}
+	related, err := e.publishedPages(-1, func(other *Page) bool { return other.Slug != page.Slug && shared(other) > 0 })
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(related, func(a, b any) int {
+		return cmp.Compare(shared(b.(*Page)), shared(a.(*Page)))
+	})
+	return related[:min(first, len(related))], nil
+}
+
+// video is one of a page's videos, fresh from its files. It's nil if
+// either has gone.
+func (e *gqlExec) video(slug, videoID string) (any, error) {
+	delete(e.pages, filepath.Base(slug))
+	page, err := e.page(slug)
+	if page == nil || err != nil {
+		return nil, err
+	}
+	for _, video := range page.YouTubeEmbed {
+		if video.ID == videoID {
+			return gqlVideo{YouTubeVideo: video, page: page}, nil
+		}
+	}
+	return nil, nil
+}
+
+// call POSTs body as JSON to one of our own handlers, as the request
+// running the mutation, and fails with what the handler said if it did.
+// Pages loaded before then may have changed, so they're loaded again.
+func (e *gqlExec) call(target string, body any) (*responseRecorder, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	r := e.r.Clone(e.r.Context())
+	r.Method = http.MethodPost
+	r.URL = &url.URL{Path: target}
+	r.RequestURI = target
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.ContentLength = int64(len(data))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Del("Accept")
+
+	rec := &responseRecorder{header: make(http.Header)}
+	e.mux.ServeHTTP(rec, r)
+	clear(e.pages)
+	if rec.status >= http.StatusBadRequest {
+		return nil, errors.New(strings.TrimSpace(problemDetail(rec.header, rec.body.Bytes())))
+	}
+	return rec, nil
+}
+
+// responseRecorder keeps what one of our handlers answered with, when we
+// call it ourselves.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header { return rec.header }
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
+
+// runGraphQL runs the operation a request asks for, with the status to
+// answer with: 400 if it couldn't be run at all, 405 for a mutation by GET.
+func runGraphQL(r *http.Request, mux http.Handler, req graphqlRequest) (graphqlResponse, int) {
+	fail := func(status int, format string, args ...any) (graphqlResponse, int) {
+		return graphqlResponse{Errors: []gqlError{{Message: fmt.Sprintf(format, args...)}}}, status
+	}
+	ops, err := parseGraphQL(req.Query)
+	if err != nil {
+		return fail(http.StatusBadRequest, "%v", err)
+	}
+	i := slices.IndexFunc(ops, func(op gqlOperation) bool { return op.name == req.OperationName })
+	if req.OperationName == "" && len(ops) > 1 {
+		return fail(http.StatusBadRequest, "operationName is required for a document with more than one operation")
+	}
+	if req.OperationName == "" {
+		i = 0
+	}
+	if i < 0 {
+		return fail(http.StatusBadRequest, "no operation named %s", req.OperationName)
+	}
+	op := ops[i]
+
+	vars := make(map[string]any)
+	for _, def := range op.vars {
+		value, ok := req.Variables[def.name]
+		if !ok {
+			value = def.def
+		}
+		if value == nil && def.required {
+			return fail(http.StatusBadRequest, "variable $%s is required", def.name)
+		}
+		vars[def.name] = value
+	}
+	root := "Query"
+	if op.kind == "mutation" {
+		root = "Mutation"
+		if r.Method == http.MethodGet {
+			return fail(http.StatusMethodNotAllowed, "mutations must be POSTed")
+		}
+		if message := serverOf(r.Context()).readOnlyMessage(); message != "" {
+			return fail(http.StatusServiceUnavailable, "%s", message)
+		}
+	}
+	if err := validate(root, op.sel); err != nil {
+		return fail(http.StatusBadRequest, "%v", err)
+	}
+
+	e := &gqlExec{r: r, mux: mux, vars: vars, pages: make(map[string]*Page)}
+	data := e.object(root, nil, op.sel, nil)
+	return graphqlResponse{Data: data, Errors: e.errors}, http.StatusOK
+}
+
+// graphqlHandler serves /graphql, handing mutations to the mux.
+func (srv *Server) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		if req.Query = query.Get("query"); req.Query == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, graphqlSchema)
+			return
+		}
+		req.OperationName = query.Get("operationName")
+		if vars := query.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				http.Error(w, "Bad variables", http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxGraphQLRequest)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp, status := runGraphQL(r, srv.mux, req)
+	if status == http.StatusMethodNotAllowed {
+		w.Header().Set("Allow", http.MethodPost)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store") // Drafts depend on who's asking
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
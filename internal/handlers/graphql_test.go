@@ -0,0 +1,29 @@
+package handlers
+
+import "testing"
+
+func TestParseGraphQLUnterminatedEscape(t *testing.T) {
+	for _, src := range []string{`{A(A:"0\`, `{A(A:"\`, `"\`, `{page(slug:"a\"`} {
+		if _, err := parseGraphQL(src); err == nil {
+			t.Errorf("parseGraphQL(%q) = nil error, want a syntax error", src)
+		}
+	}
+}
+
+// FuzzParseGraphQL checks that no document, however broken, panics the
+// parser: anyone can send one to /graphql.
+func FuzzParseGraphQL(f *testing.F) {
+	for _, seed := range []string{
+		`{ page(slug: "home") { title videos { id votes } } }`,
+		`query Q($s: String! = "x") { a: page(slug: $s) { related(first: 2) { slug } } }`,
+		`mutation { vote(slug: "a", video: "b", up: true) { votes } }`,
+		`{ pages(tag: "go", first: 1.5) { tags } } # comment`,
+		`{A(A:"0\`,
+		`{ a(b: [1, {c: null}]) }`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		parseGraphQL(src)
+	})
+}
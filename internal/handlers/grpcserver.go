@@ -0,0 +1,264 @@
+package handlers
+
+//The pages and votes API over gRPC, on grpc_addr, for internal services
+//that would rather make calls than HTTP requests. See
+//trailerpb/trailer.proto for what it has. Each call is made into a request
+//to the JSON API and served by the same handler as the site, so logins,
+//sites by Host (:authority here), read-only mode, events and the access log
+//all work the same; only the encoding differs.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-trailer/internal/videos"
+	"go-trailer/trailerpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcPages serves the Pages service by calling srv.
+type grpcPages struct {
+	trailerpb.UnimplementedPagesServer
+	srv *Server
+}
+
+// NewGRPCServer is a gRPC server with the Pages service, which it serves by
+// calling srv.
+func NewGRPCServer(srv *Server) *grpc.Server {
+	server := grpc.NewServer()
+	trailerpb.RegisterPagesServer(server, &grpcPages{srv: srv})
+	return server
+}
+
+func (s *grpcPages) GetPage(ctx context.Context, req *trailerpb.GetPageRequest) (*trailerpb.Page, error) {
+	if req.Slug == "" {
+		return nil, status.Error(codes.InvalidArgument, "slug is required")
+	}
+	return s.getPage(ctx, pagePath(filepath.Base(req.Slug)))
+}
+
+// getPage fetches the page at target as JSON, following renames.
+func (s *grpcPages) getPage(ctx context.Context, target string) (*trailerpb.Page, error) {
+	var page pageJSON
+	for range 3 {
+		rec, err := s.call(ctx, http.MethodGet, target, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		if rec.status < 300 {
+			return pageProto(page), nil
+		}
+		location, err := url.Parse(rec.header.Get("Location"))
+		if err != nil {
+			return nil, status.Error(codes.Internal, "bad redirect")
+		}
+		target = strings.TrimPrefix(location.Path, s.srv.config.BasePath)
+	}
+	return nil, status.Error(codes.Internal, "too many redirects")
+}
+
+func (s *grpcPages) ListPages(ctx context.Context, req *trailerpb.ListPagesRequest) (*trailerpb.ListPagesResponse, error) {
+	query := url.Values{}
+	if req.PageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "page_size can't be negative")
+	}
+	if req.PageSize > 0 {
+		query.Set("limit", strconv.Itoa(int(req.PageSize)))
+	}
+	if req.PageToken != "" {
+		query.Set("cursor", req.PageToken)
+	}
+	var list listPage[pageItem]
+	if _, err := s.call(ctx, http.MethodGet, "/api/v1/pages?"+query.Encode(), nil, &list); err != nil {
+		return nil, err
+	}
+	resp := &trailerpb.ListPagesResponse{NextPageToken: list.NextCursor, HasMore: list.HasMore}
+	for _, item := range list.Items {
+		resp.Pages = append(resp.Pages, &trailerpb.PageSummary{
+			Slug:    item.Slug,
+			Title:   item.Title,
+			Url:     item.URL,
+			Created: timestampOrNil(item.Created),
+			Updated: timestampOrNil(item.Updated),
+			Tags:    item.Tags,
+			Videos:  int32(item.Videos),
+		})
+	}
+	return resp, nil
+}
+
+func (s *grpcPages) CreatePage(ctx context.Context, req *trailerpb.CreatePageRequest) (*trailerpb.Page, error) {
+	rec, err := s.call(ctx, http.MethodPost, "/create", createPageRequest{Name: req.Name}, nil)
+	if err != nil {
+		return nil, err
+	}
+	location, err := url.Parse(rec.header.Get("Location"))
+	if err != nil {
+		return nil, status.Error(codes.Internal, "bad redirect")
+	}
+	return s.getPage(ctx, strings.TrimPrefix(location.Path, s.srv.config.BasePath))
+}
+
+func (s *grpcPages) SaveVideo(ctx context.Context, req *trailerpb.SaveVideoRequest) (*trailerpb.Video, error) {
+	if req.Slug == "" {
+		return nil, status.Error(codes.InvalidArgument, "slug is required")
+	}
+	slug := filepath.Base(req.Slug)
+	if _, err := s.call(ctx, http.MethodPost, "/api/v1/pages/"+slug+"/videos", saveVideoRequest{URL: req.YoutubeUrl}, nil); err != nil {
+		return nil, err
+	}
+	_, videoID := videos.Parse(req.YoutubeUrl)
+	return s.video(ctx, slug, videoID)
+}
+
+func (s *grpcPages) Vote(ctx context.Context, req *trailerpb.VoteRequest) (*trailerpb.Video, error) {
+	if req.Slug == "" || req.VideoId == "" {
+		return nil, status.Error(codes.InvalidArgument, "slug and video_id are required")
+	}
+	slug := filepath.Base(req.Slug)
+	action := "downvote"
+	if req.Up {
+		action = "upvote"
+	}
+	if _, err := s.call(ctx, http.MethodPost, "/api/v1/votes/"+slug+"/"+url.PathEscape(req.VideoId)+"/"+action, nil, nil); err != nil {
+		return nil, err
+	}
+	return s.video(ctx, slug, req.VideoId)
+}
+
+// video is one of a page's videos as it is now.
+func (s *grpcPages) video(ctx context.Context, slug, videoID string) (*trailerpb.Video, error) {
+	page, err := s.getPage(ctx, pagePath(slug))
+	if err != nil {
+		return nil, err
+	}
+	for _, video := range page.Videos {
+		if video.Id == videoID {
+			return video, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "%s has no video %s", slug, videoID)
+}
+
+// call makes a request to the Server for the call ctx is for, decoding the
+// JSON answer into out if it's given. Errors are the handler's, as gRPC
+// statuses. The request starts out on the main site, until withSite picks
+// the one its :authority names.
+func (s *grpcPages) call(ctx context.Context, method, target string, body, out any) (*responseRecorder, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	ctx = context.WithValue(ctx, siteKey{}, s.srv.main)
+	r, err := http.NewRequestWithContext(ctx, method, s.srv.config.BasePath+target, bytes.NewReader(data))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	r.RequestURI = r.URL.RequestURI()
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("User-Agent", "grpc")
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if auth := md.Get("authorization"); len(auth) > 0 {
+			r.Header.Set("Authorization", auth[0])
+		}
+		if authority := md.Get(":authority"); len(authority) > 0 {
+			r.Host = authority[0]
+		}
+		if agent := md.Get("user-agent"); len(agent) > 0 {
+			r.Header.Set("User-Agent", agent[0])
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+
+	rec := &responseRecorder{header: make(http.Header)}
+	s.srv.ServeHTTP(rec, r)
+	if rec.status >= http.StatusBadRequest {
+		return nil, status.Error(grpcCode(rec.status), strings.TrimSpace(problemDetail(rec.header, rec.body.Bytes())))
+	}
+	if out != nil && rec.status < 300 {
+		if err := json.NewDecoder(&rec.body).Decode(out); err != nil && err != io.EOF {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("bad answer from %s: %v", target, err))
+		}
+	}
+	return rec, nil
+}
+
+// grpcCode is the gRPC status code closest to an HTTP one.
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusMethodNotAllowed:
+		return codes.Unimplemented
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusRequestEntityTooLarge, http.StatusInsufficientStorage, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+// pageProto is a page from /page/{slug} as a trailerpb.Page.
+func pageProto(page pageJSON) *trailerpb.Page {
+	p := &trailerpb.Page{
+		Slug:        page.Slug,
+		Title:       page.Title,
+		Url:         page.URL,
+		Body:        page.Body,
+		Html:        string(page.HTML),
+		Description: page.Description,
+		Tags:        page.Tags,
+		Author:      page.Author,
+		Created:     timestampOrNil(page.Created),
+		Updated:     timestampOrNil(page.Updated),
+		UpdatedBy:   page.UpdatedBy,
+		Draft:       page.Draft,
+		Archived:    page.Archived,
+	}
+	for _, video := range page.Videos {
+		p.Videos = append(p.Videos, &trailerpb.Video{
+			Id:       video.ID,
+			WatchUrl: video.WatchURL,
+			EmbedUrl: video.EmbedURL,
+			Votes:    int32(video.Votes),
+		})
+	}
+	return p
+}
+
+// timestampOrNil is t for a message, leaving it unset if it's zero.
+func timestampOrNil(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"testing"
+
+	"go-trailer/trailerpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// startTestGRPC serves srv's Pages service over a real connection, and
+// answers with a client for it.
+func startTestGRPC(t *testing.T, srv *Server) trailerpb.PagesClient {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewGRPCServer(srv)
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return trailerpb.NewPagesClient(conn)
+}
+
+func TestGRPC(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.BasePath = "/wiki" // Left off the paths in redirects
+	client := startTestGRPC(t, newTestServer(t, cfg))
+	ctx := context.Background()
+
+	page, err := client.CreatePage(ctx, &trailerpb.CreatePageRequest{Name: "Go Talks"})
+	if err != nil {
+		t.Fatalf("CreatePage: %v", err)
+	}
+	if page.Slug != "go-talks" || page.Title != "Go Talks" {
+		t.Errorf("created %s %q, want go-talks \"Go Talks\"", page.Slug, page.Title)
+	}
+
+	video, err := client.SaveVideo(ctx, &trailerpb.SaveVideoRequest{Slug: "go-talks", YoutubeUrl: "https://youtu.be/dQw4w9WgXcQ"})
+	if err != nil {
+		t.Fatalf("SaveVideo: %v", err)
+	}
+	if video.Id != "dQw4w9WgXcQ" {
+		t.Errorf("saved video %s, want dQw4w9WgXcQ", video.Id)
+	}
+	if video, err = client.Vote(ctx, &trailerpb.VoteRequest{Slug: "go-talks", VideoId: "dQw4w9WgXcQ", Up: true}); err != nil {
+		t.Fatalf("Vote: %v", err)
+	}
+	if video.Votes != 1 {
+		t.Errorf("votes = %d after an upvote, want 1", video.Votes)
+	}
+
+	if page, err = client.GetPage(ctx, &trailerpb.GetPageRequest{Slug: "go-talks"}); err != nil {
+		t.Fatalf("GetPage: %v", err)
+	}
+	if len(page.Videos) != 1 || page.Videos[0].Votes != 1 {
+		t.Errorf("videos = %v, want dQw4w9WgXcQ with 1 vote", page.Videos)
+	}
+	list, err := client.ListPages(ctx, &trailerpb.ListPagesRequest{})
+	if err != nil {
+		t.Fatalf("ListPages: %v", err)
+	}
+	if len(list.Pages) != 1 || list.Pages[0].Slug != "go-talks" {
+		t.Errorf("pages = %v, want just go-talks", list.Pages)
+	}
+
+	_, err = client.GetPage(ctx, &trailerpb.GetPageRequest{Slug: "no-such-page"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetPage of a missing page = %v, want NotFound", err)
+	}
+}
+
+func TestGRPCPrivatePage(t *testing.T) {
+	srv, ts := startPrivateTestServer(t)
+	client := startTestGRPC(t, srv)
+	ctx := context.Background()
+	wantStatus(t, send(t, ts, "POST", "/api/v1/pages", `{"name": "Secret Plans", "private": true}`), http.StatusCreated)
+	wantStatus(t, sendAsAdmin(t, ts, "POST", "/api/page/secret-plans/save-youtube", `{"youtube_url": "https://youtu.be/dQw4w9WgXcQ"}`), http.StatusOK)
+
+	_, err := client.GetPage(ctx, &trailerpb.GetPageRequest{Slug: "secret-plans"})
+	if status.Code(err) != codes.Unauthenticated { // As /page/ asks a browser to log in
+		t.Errorf("GetPage of a private page = %v, want Unauthenticated", err)
+	}
+	_, err = client.SaveVideo(ctx, &trailerpb.SaveVideoRequest{Slug: "secret-plans", YoutubeUrl: "https://youtu.be/oHg5SJYRHA0"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("SaveVideo to a private page = %v, want PermissionDenied", err)
+	}
+	_, err = client.Vote(ctx, &trailerpb.VoteRequest{Slug: "secret-plans", VideoId: "dQw4w9WgXcQ", Up: true})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Vote on a private page = %v, want PermissionDenied", err)
+	}
+
+	// With the admin password it's there
+	admin := metadata.AppendToOutgoingContext(ctx, "authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:hunter2")))
+	page, err := client.GetPage(admin, &trailerpb.GetPageRequest{Slug: "secret-plans"})
+	if err != nil {
+		t.Fatalf("GetPage as admin: %v", err)
+	}
+	if len(page.Videos) != 1 || page.Videos[0].Votes != 0 {
+		t.Errorf("videos = %v, want just dQw4w9WgXcQ with no votes", page.Videos)
+	}
+}
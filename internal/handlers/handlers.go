@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"go-trailer/internal/videos"
+)
+
+// This struct will hold the data for a single page.
+// Templates get it wrapped in a view, see views.go.
+type Page struct {
+	Title        string        // What the page is called, as it was created
+	Slug         string        // Names its URL and files
+	Body         string        // The content of the page
+	HTML         template.HTML // Body rendered for page.html, see render.go
+	TOC          []TOCEntry    // Its headings, in order
+	Math         bool          // Has TeX for KaTeX to draw
+	Diagrams     bool          // Has Mermaid diagrams to draw
+	inlineTOC    bool          // Has a {{toc}}, so no sidebar one
+	YouTubeEmbed []YouTubeVideo
+
+	// From the page's front matter, if it has any
+	Tags   []string
+	Author string // Or whoever created the page, if they were logged in
+	Date   time.Time
+
+	Draft    bool // Not published yet, so only its creator and admins see it
+	Private  bool // Only for its viewers, see private.go
+	Archived bool // Expired, and served from /archive/ instead
+
+	// Who changed the page and when
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UpdatedBy string // Empty if they weren't logged in
+
+	// SEO and social sharing (Open Graph / Twitter card) details for the <head>
+	Description string
+	ImageURL    string // Thumbnail of the top video, if there is one
+}
+
+// YouTubeVideo holds the data for a single YouTube video, including its vote count.
+type YouTubeVideo struct {
+	ID       string
+	URL      string
+	Votes    int
+	Position int // Where its link is in the page's link file, i.e. the order they were added
+}
+
+// renderTemplate executes one of the cached templates of the site ctx is
+// for. In -dev mode the templates are parsed again first, so edits show up
+// without a restart.
+func renderTemplate(ctx context.Context, w io.Writer, name string, data any) error {
+	s := siteOf(ctx)
+	t := s.templates
+	if s.srv.config.Dev {
+		var err error
+		if t, err = s.parseTemplates(); err != nil {
+			return err
+		}
+	}
+	return t.ExecuteTemplate(w, name, data)
+}
+
+// --- Handler Functions ---
+
+// indexHandler serves the homepage (index.html), one page of pages at a time
+func (srv *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
+	// We need to get a list of all pages to display
+	slugs, err := publishedSlugs(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading pages directory", "err", err)
+		http.Error(w, "Could not list pages", http.StatusInternalServerError)
+		return
+	}
+
+	// Execute the 'index.html' template with this page of the list
+	params := readIndexParams(r)
+	entries := listIndexEntries(r.Context(), slugs, params.Sort)
+	err = renderTemplate(r.Context(), w, "index.html", buildIndexView(r.Context(), entries, params))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error executing index template", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// youtubeVoteHandler handles POST /api/vote/{slug}/{videoID}/{action}, to
+// upvote or downvote a YouTube video.
+func (srv *Server) youtubeVoteHandler(w http.ResponseWriter, r *http.Request) {
+	slug := filepath.Base(r.PathValue("slug"))
+	videoID := r.PathValue("videoID")
+	action := r.PathValue("action")
+	setLogSlug(r, slug)
+
+	if action != "upvote" && action != "downvote" {
+		writeProblem(w, http.StatusBadRequest, "invalid_action", "Invalid action, use upvote or downvote")
+		return
+	}
+	if restrictedPageError(w, r, accessMeta(r.Context(), slug)) {
+		return
+	}
+	// Checked as a vote in a batch is, so there's no votes file for a page
+	// that isn't there or a video that can't be one
+	if err := checkBatchVote(r, batchVote{Slug: slug, VideoID: videoID, Action: action}); errors.Is(err, errVotePageNotFound) {
+		apiError(w, "Page not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeProblem(w, http.StatusBadRequest, "invalid_vote", "Invalid vote, "+err.Error())
+		return
+	}
+
+	siteOf(r.Context()).votesMu.Lock()
+	defer siteOf(r.Context()).votesMu.Unlock()
+
+	// Read the votes file
+	votes, err := readVotes(r.Context(), slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading votes", "err", err)
+		apiError(w, "Could not process votes", http.StatusInternalServerError)
+		return
+	}
+
+	// Update the vote count
+	if action == "upvote" {
+		votes[videoID]++
+	} else {
+		votes[videoID]--
+	}
+
+	// Write the updated votes back to the file
+	if err := writeVotes(r.Context(), slug, votes); err != nil {
+		if quotaError(w, err) {
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error writing votes file", "err", err)
+		apiError(w, "Could not save vote", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Vote saved!"))
+	slog.InfoContext(r.Context(), "Vote saved", "video", videoID, "action", action)
+	publishVotes(r.Context(), slug, map[string]int{videoID: votes[videoID]})
+	purgePage(r.Context(), slug)
+}
+
+// saveVideoRequest is the body of a POST adding a video to a page.
+type saveVideoRequest struct {
+	URL string `json:"youtube_url"`
+}
+
+// youtubeSaveHandler handles POST /api/page/{slug}/save-youtube, to save a
+// YouTube link for a page.
+func (srv *Server) youtubeSaveHandler(w http.ResponseWriter, r *http.Request) {
+	// 1. The page slug is in the URL, /api/page/my-page-slug/save-youtube,
+	// and only those who can see the page may add to it
+	slug, ok := editablePage(w, r, "save-youtube")
+	if !ok {
+		return
+	}
+
+	// 2. Decode the JSON request body: {"youtube_url": "https://..."}
+	var reqBody saveVideoRequest
+	if err := readJSON(w, r, &reqBody); err != nil {
+		badJSON(w, err)
+		return
+	}
+
+	// 3. Basic validation: is it a real YouTube link?
+	// Our regex helper is perfect for this.
+	embedURL, videoID := videos.Parse(reqBody.URL)
+	if embedURL == "" {
+		fieldError(w, "youtube_url", "invalid_youtube_url", "Invalid YouTube URL")
+		return
+	}
+	verdict := moderate(r, moderationItem{Kind: moderateVideo, Slug: slug, Text: reqBody.URL, Author: editorName(r)})
+	if verdict.Verdict == moderationClean {
+		// Whatever was cleaned up must still be a link to the video
+		if embedURL, videoID = videos.Parse(verdict.Text); embedURL == "" {
+			verdict.Verdict = moderationReject
+		}
+	}
+	if verdict.Verdict == moderationReject {
+		rejectContent(w, "youtube_url", verdict)
+		return
+	}
+	reqBody.URL = verdict.Text
+
+	// 4. Append the URL on its own line, creating the file if it doesn't exist.
+	filename := slug + ".youtube.txt"
+	if err := storeCtx(r.Context()).AppendFile(filename, []byte(reqBody.URL+"\n")); err != nil {
+		if quotaError(w, err) {
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error writing to YouTube link file", "err", err)
+		apiError(w, "Could not save link", http.StatusInternalServerError)
+		return
+	}
+
+	// 5. Send a success response
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("YouTube link saved!"))
+	slog.InfoContext(r.Context(), "YouTube link saved")
+	if err := recordPageEdit(r.Context(), slug, editorName(r)); err != nil {
+		slog.WarnContext(r.Context(), "Error saving who changed the page", "err", err)
+	}
+	if verdict.Verdict == moderationReview {
+		if err := flagPage(r.Context(), slug, "video "+videoID+" "+verdict.Reason); err != nil {
+			slog.ErrorContext(r.Context(), "Error flagging page for review", "err", err)
+		}
+	}
+	purgePage(r.Context(), slug)
+	purgeListings(r.Context())
+	if !isRestricted(r.Context(), slug) {
+		queueSearchPing(r.Context(), slug)
+		publishSiteEvent(r.Context(), "video-added", map[string]string{"slug": slug, "video_id": videoID})
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+//Probes for load balancers and orchestrators. /healthz says the process is
+//up, /readyz says it can actually serve pages right now.
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// healthzHandler serves /healthz. If we can answer at all, we're alive.
+func (srv *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// readyzHandler serves /readyz: 200 when the templates are parsed and the
+// pages directory is usable, 503 (saying what's wrong) otherwise.
+func (srv *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"templates": "ok",
+		"pages_dir": "ok",
+	}
+	ready := true
+	fail := func(check, problem string) {
+		checks[check] = problem
+		ready = false
+	}
+
+	if srv.main.templates == nil {
+		fail("templates", "not parsed")
+	} else if srv.config.Dev {
+		// In -dev mode a broken edit only shows up when parsing again
+		if _, err := srv.main.parseTemplates(); err != nil {
+			fail("templates", err.Error())
+		}
+	}
+	if !srv.pagesDirAvailable() {
+		fail("pages_dir", "unavailable")
+	}
+	if srv.shuttingDown.Load() {
+		checks["server"] = "shutting down"
+		ready = false
+	}
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": checks})
+}
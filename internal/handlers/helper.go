@@ -0,0 +1,47 @@
+package handlers
+
+//Meant to have one off stuff
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// excerpt trims a page body down to at most length bytes of text on one line,
+// for feed summaries and meta descriptions.
+func excerpt(body string, length int) string {
+	body = strings.Join(strings.Fields(htmlText(body)), " ") // Collapse newlines and runs of spaces
+	if len(body) <= length {
+		return body
+	}
+	// Cut on a rune boundary so we never emit half a UTF-8 character
+	cut := length
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	return body[:cut] + "…"
+}
+
+// pagination is where we are in a long list split into pages, numbered from 1.
+type pagination struct {
+	Number     int
+	TotalPages int
+	Total      int
+}
+
+// paginate works out which page of a list of total items we're on, and the
+// slice bounds of its items. Out of range page numbers are clamped rather
+// than showing nothing.
+func paginate(total, perPage, number int) (p pagination, start, end int) {
+	p.Total = total
+	p.TotalPages = max(1, (total+perPage-1)/perPage)
+	p.Number = min(max(number, 1), p.TotalPages)
+	start = (p.Number - 1) * perPage
+	end = min(start+perPage, total)
+	return p, start, end
+}
+
+// HasPrev and friends keep the pagination links simple in templates.
+func (p pagination) HasPrev() bool { return p.Number > 1 }
+func (p pagination) HasNext() bool { return p.Number < p.TotalPages }
+func (p pagination) Prev() int     { return p.Number - 1 }
+func (p pagination) Next() int     { return p.Number + 1 }
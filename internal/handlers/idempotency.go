@@ -0,0 +1,183 @@
+package handlers
+
+//Idempotency keys, for clients on flaky networks that retry a POST without
+//knowing whether the first one got through. A request to /create or
+///api/page/ with an Idempotency-Key header has its answer kept for a day;
+//the same request with the same key gets that answer again, with
+//Idempotent-Replayed: true, instead of creating a second page or adding the
+//video twice. Keys are per site and per login, and kept in memory, so a
+//restart forgets them. Each site keeps at most maxIdempotentRequests,
+//making room by forgetting the answers closest to expiring.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyKeyTTL        = 24 * time.Hour  // How long an answer is kept
+	idempotencyInProgressTTL = 5 * time.Minute // How long a request still being handled holds its key
+	maxIdempotencyKeyLen     = 255
+	maxIdempotentRequests    = 10000 // Per site
+)
+
+// idempotentRequest is a request made with an idempotency key, and its
+// answer once there is one.
+type idempotentRequest struct {
+	fingerprint [sha256.Size]byte // Of the method, URL and body, to catch a key used for something else
+	answer      *responseRecorder // nil while it's being handled
+	expires     time.Time         // When it's forgotten, answered or not
+}
+
+// idempotentRequests is every request made to a site with a key, by login
+// and key.
+type idempotentRequests struct {
+	sync.Mutex
+	byKey map[string]*idempotentRequest
+}
+
+// withIdempotency answers a retried request with what next answered the
+// first time, for requests that have an Idempotency-Key.
+func withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeProblem(w, http.StatusBadRequest, "invalid_idempotency_key", "Idempotency-Key is too long, the most is 255 characters")
+			return
+		}
+		// The handlers limit the body themselves, this is only so the whole
+		// of one can be kept to hash
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, configOf(r.Context()).Attachments.MaxBytes+2<<20))
+		if err != nil {
+			badJSON(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n")
+		hash.Write(body)
+		var fingerprint [sha256.Size]byte
+		hash.Sum(fingerprint[:0])
+
+		reqs := &siteOf(r.Context()).idempotentRequests
+		id := editorName(r) + "\x00" + key
+		now := time.Now()
+		reqs.Lock()
+		reqs.forgetExpired(now)
+		req, seen := reqs.byKey[id]
+		var answer *responseRecorder
+		full := false
+		if !seen {
+			req = &idempotentRequest{fingerprint: fingerprint, expires: now.Add(idempotencyInProgressTTL)}
+			if reqs.byKey == nil {
+				reqs.byKey = make(map[string]*idempotentRequest)
+			}
+			if full = !reqs.makeRoom(); !full {
+				reqs.byKey[id] = req
+			}
+		} else {
+			answer = req.answer
+		}
+		reqs.Unlock()
+
+		if full {
+			w.Header().Set("Retry-After", "60")
+			writeProblem(w, http.StatusServiceUnavailable, "too_many_idempotent_requests", "Too many requests with an Idempotency-Key are being handled, try again shortly")
+			return
+		}
+		if seen {
+			switch {
+			case req.fingerprint != fingerprint:
+				writeProblem(w, http.StatusUnprocessableEntity, "idempotency_key_reused", "This Idempotency-Key was used for a different request, use a new one")
+			case answer == nil:
+				writeProblem(w, http.StatusConflict, "request_in_progress", "A request with this Idempotency-Key is still being handled, try again shortly")
+			default:
+				replay(w, answer)
+			}
+			return
+		}
+
+		// Unless it's answered in a way worth giving again, the key is free
+		// for the retry, even if next panics
+		defer func() {
+			reqs.Lock()
+			defer reqs.Unlock()
+			if req.answer == nil && reqs.byKey[id] == req {
+				delete(reqs.byKey, id)
+			}
+		}()
+		rec := &responseRecorder{header: make(http.Header)}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if worthKeeping(rec.status) {
+			reqs.Lock()
+			req.answer = rec
+			req.expires = time.Now().Add(idempotencyKeyTTL)
+			reqs.Unlock()
+		}
+		maps.Copy(w.Header(), rec.header)
+		w.WriteHeader(rec.status)
+		w.Write(rec.body.Bytes())
+	}
+}
+
+// forgetExpired drops the answers that are past keeping, and requests that
+// have been in progress so long they never will be answered. Callers must
+// hold reqs.
+func (reqs *idempotentRequests) forgetExpired(now time.Time) {
+	for id, req := range reqs.byKey {
+		if !now.Before(req.expires) {
+			delete(reqs.byKey, id)
+		}
+	}
+}
+
+// makeRoom makes sure there's room for one more request, forgetting the
+// answer closest to expiring if it's full. It reports false when every
+// request kept is still in progress. Callers must hold reqs.
+func (reqs *idempotentRequests) makeRoom() bool {
+	if len(reqs.byKey) < maxIdempotentRequests {
+		return true
+	}
+	var oldest string
+	var oldestReq *idempotentRequest
+	for id, req := range reqs.byKey {
+		if req.answer != nil && (oldestReq == nil || req.expires.Before(oldestReq.expires)) {
+			oldest, oldestReq = id, req
+		}
+	}
+	if oldestReq == nil {
+		return false
+	}
+	delete(reqs.byKey, oldest)
+	return true
+}
+
+// worthKeeping reports whether an answer with status is the one to give
+// again, rather than a failure the retry should get past.
+func worthKeeping(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return status < http.StatusInternalServerError
+}
+
+// replay answers with what a request was answered with before.
+func replay(w http.ResponseWriter, answer *responseRecorder) {
+	maps.Copy(w.Header(), answer.header)
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(answer.status)
+	w.Write(answer.body.Bytes())
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSite is a site served from a directory of its own, with default
+// settings.
+func testSite(t *testing.T) *site {
+	srv := newServer(testConfig(t))
+	return srv.mainSite()
+}
+
+// idempotentPost sends a POST with key to h on site s.
+func idempotentPost(s *site, h http.HandlerFunc, key string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/api/page/a/save-youtube", strings.NewReader(`{}`))
+	r = r.WithContext(context.WithValue(r.Context(), siteKey{}, s))
+	r.Header.Set("Idempotency-Key", key)
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func TestIdempotencyKeyFreedAfterPanic(t *testing.T) {
+	s := testSite(t)
+	calls := 0
+	h := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	func() {
+		defer func() { recover() }()
+		idempotentPost(s, h, "k")
+	}()
+	if w := idempotentPost(s, h, "k"); w.Code != http.StatusCreated {
+		t.Fatalf("retry after a panic = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if w := idempotentPost(s, h, "k"); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("second retry wasn't replayed")
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+}
+
+func TestIdempotencyInProgressExpires(t *testing.T) {
+	s := testSite(t)
+	h := withIdempotency(func(w http.ResponseWriter, r *http.Request) {})
+	s.idempotentRequests.byKey = map[string]*idempotentRequest{
+		"\x00k": {expires: time.Now().Add(-time.Second)}, // In progress since long ago
+	}
+
+	if w := idempotentPost(s, h, "k"); w.Code != http.StatusOK {
+		t.Errorf("request with a stuck key = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestIdempotencyCap(t *testing.T) {
+	s := testSite(t)
+	h := withIdempotency(func(w http.ResponseWriter, r *http.Request) {})
+	inProgress := make(map[string]*idempotentRequest, maxIdempotentRequests)
+	for i := range maxIdempotentRequests {
+		inProgress[fmt.Sprint("\x00", i)] = &idempotentRequest{expires: time.Now().Add(time.Hour)}
+	}
+	s.idempotentRequests.byKey = inProgress
+
+	if w := idempotentPost(s, h, "new"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("request with every slot in progress = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	// Once one has been answered, it makes way
+	s.idempotentRequests.Lock()
+	inProgress["\x000"].answer = &responseRecorder{status: http.StatusOK}
+	s.idempotentRequests.Unlock()
+	if w := idempotentPost(s, h, "new"); w.Code != http.StatusOK {
+		t.Fatalf("request with an answer to forget = %d, want %d", w.Code, http.StatusOK)
+	}
+	s.idempotentRequests.Lock()
+	defer s.idempotentRequests.Unlock()
+	if n := len(s.idempotentRequests.byKey); n != maxIdempotentRequests {
+		t.Errorf("%d requests kept, want %d", n, maxIdempotentRequests)
+	}
+	if _, ok := s.idempotentRequests.byKey["\x000"]; ok {
+		t.Errorf("the answered request wasn't the one forgotten")
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+//The homepage list of pages: which order it's in, and one page of it at a
+//time. Newest changes come first unless ?sort= asks for something else.
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How many pages the homepage lists at once, unless ?per_page= says otherwise,
+// and the most it will list.
+const (
+	defaultIndexPerPage = 50
+	maxIndexPerPage     = 500
+)
+
+// How much of each page's text the homepage shows.
+const indexExcerptLength = 160
+
+// The orders the homepage can be sorted in with ?sort=, the first is the default.
+var indexSorts = []string{"modified", "name", "created"}
+
+// indexParams are the homepage's query parameters.
+type indexParams struct {
+	Number  int
+	PerPage int
+	Sort    string
+}
+
+// readIndexParams reads ?page=N&per_page=M&sort=S for the homepage.
+func readIndexParams(r *http.Request) indexParams {
+	q := r.URL.Query()
+	p := indexParams{Sort: q.Get("sort")}
+	var err error
+	if p.Number, err = strconv.Atoi(q.Get("page")); err != nil {
+		p.Number = 1
+	}
+	if p.PerPage, err = strconv.Atoi(q.Get("per_page")); err != nil || p.PerPage < 1 {
+		p.PerPage = defaultIndexPerPage
+	}
+	p.PerPage = min(p.PerPage, maxIndexPerPage)
+	if !slices.Contains(indexSorts, p.Sort) {
+		p.Sort = indexSorts[0]
+	}
+	return p
+}
+
+// listIndexEntries is every page with its times, in the order asked for.
+// Pages removed while we're listing are left out.
+func listIndexEntries(ctx context.Context, slugs []string, sortBy string) []IndexEntry {
+	entries := make([]IndexEntry, 0, len(slugs))
+	for _, slug := range slugs {
+		modified, err := pageModTime(ctx, slug)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, IndexEntry{
+			Slug:     slug,
+			Modified: modified,
+			Created:  pageCreated(ctx, slug),
+		})
+	}
+
+	bySlug := func(a, b IndexEntry) int { return strings.Compare(a.Slug, b.Slug) }
+	switch sortBy {
+	case "name":
+		slices.SortFunc(entries, bySlug)
+	case "created":
+		slices.SortFunc(entries, func(a, b IndexEntry) int {
+			return cmp.Or(b.Created.Compare(a.Created), bySlug(a, b))
+		})
+	default:
+		slices.SortFunc(entries, func(a, b IndexEntry) int {
+			return cmp.Or(b.Modified.Compare(a.Modified), bySlug(a, b))
+		})
+	}
+	return entries
+}
+
+// addIndexExcerpts fills in each page's title and the start of its text, for
+// the entries actually shown so the homepage doesn't read every page there is.
+func addIndexExcerpts(ctx context.Context, entries []IndexEntry) {
+	for i := range entries {
+		entries[i].Title = pageTitle(ctx, entries[i].Slug)
+		_, body, err := readPageText(ctx, entries[i].Slug)
+		if err != nil {
+			continue // Removed since we listed it, show it without
+		}
+		entries[i].Excerpt = excerpt(string(body), indexExcerptLength)
+	}
+}
+
+// pageCreated is when a page was created. Pages from before that was kept
+// in the meta file go by when the page text was last written.
+func pageCreated(ctx context.Context, slug string) time.Time {
+	if meta, err := loadPageMeta(ctx, slug); err == nil && !meta.Created.IsZero() {
+		return meta.Created
+	}
+	modTime, _ := storeCtx(ctx).ModTime(slug + ".txt")
+	return modTime
+}
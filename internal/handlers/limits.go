@@ -0,0 +1,39 @@
+package handlers
+
+//Limits on requests, so a slow or huge one can't tie the server up: JSON
+//bodies are cut off at limits.max_body_bytes (page text at maxPageBytes,
+//attachments at attachments.max_bytes), clients get read_header_timeout to
+//send their headers and idle_timeout between requests, and the handlers that
+//write get handler_timeout to finish, reading the body included.
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// readJSON decodes r's JSON body into v, failing with an *http.MaxBytesError
+// if it's over limits.max_body_bytes.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, configOf(r.Context()).Limits.MaxBodyBytes)
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// withTimeout gives next limits.handler_timeout to read the request and
+// finish, cancelling its context after that. A client still sending the body
+// then gets an error reading it.
+func withTimeout(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeout := configOf(r.Context()).Limits.HandlerTimeout
+		if timeout <= 0 {
+			next(w, r)
+			return
+		}
+		// Not there for requests we make ourselves, which have no connection
+		_ = http.NewResponseController(w).SetReadDeadline(time.Now().Add(timeout))
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,293 @@
+package handlers
+
+//JSON list APIs for apps: pages, a page's videos, a page's comments, and
+//recent changes. They all page the same way, with an opaque cursor, so a
+//client can sync a bit at a time and pick up where it left off:
+//
+//	GET /api/pages?limit=50
+//	-> {"items": [...], "next_cursor": "...", "has_more": true}
+//	GET /api/pages?limit=50&cursor=...
+//
+//Lists have a stable order, so items never show up twice or get skipped
+//while paging. /api/changes is ordered by last update, so polling it with
+//the last next_cursor returns only what changed since.
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How many items a list returns when the client doesn't say, and at most.
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// listCursor is the position of the last item a client has seen. Lists are
+// sorted by (Num, Key). Num is a time or a position, for lists sorted by one.
+type listCursor struct {
+	Num int64  `json:"n,omitempty"`
+	Key string `json:"k,omitempty"`
+}
+
+// compare orders cursors the same way lists are sorted.
+func (c listCursor) compare(other listCursor) int {
+	return cmp.Or(cmp.Compare(c.Num, other.Num), strings.Compare(c.Key, other.Key))
+}
+
+func (c listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeListCursor reads a cursor a client sent back. No cursor means the start.
+func decodeListCursor(s string) (*listCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+// listPage is the response of every list API.
+type listPage[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"` // Pass back as ?cursor= to carry on
+	HasMore    bool   `json:"has_more"`              // More items right now, fetch again straight away
+}
+
+// cursorPage picks the items after the cursor from a list sorted by key.
+// next_cursor is always set when there is a position to resume from, so a
+// client that reached the end can poll again later for new items.
+func cursorPage[T any](items []T, key func(T) listCursor, r *http.Request) (listPage[T], error) {
+	page := listPage[T]{Items: []T{}}
+
+	limit := defaultListLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return page, errors.New("invalid limit")
+		}
+		limit = min(n, maxListLimit)
+	}
+	cursor, err := decodeListCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		return page, err
+	}
+
+	start := 0
+	if cursor != nil {
+		start, _ = slices.BinarySearchFunc(items, *cursor, func(item T, c listCursor) int {
+			return key(item).compare(c)
+		})
+		for start < len(items) && key(items[start]).compare(*cursor) == 0 {
+			start++ // Skip the item the cursor points at, the client already has it
+		}
+	}
+	end := min(start+limit, len(items))
+
+	page.Items = append(page.Items, items[start:end]...)
+	page.HasMore = end < len(items)
+	switch {
+	case end > start:
+		page.NextCursor = key(items[end-1]).encode()
+	case cursor != nil:
+		page.NextCursor = cursor.encode() // Nothing new yet, keep the place
+	}
+	return page, nil
+}
+
+// writeListPage pages through items and sends the result as JSON.
+func writeListPage[T any](w http.ResponseWriter, r *http.Request, items []T, key func(T) listCursor) {
+	page, err := cursorPage(items, key, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// --- Pages and changes ---
+
+// pageItem is a page in /api/pages and /api/changes.
+type pageItem struct {
+	Slug    string    `json:"slug"`
+	Title   string    `json:"title"`
+	URL     string    `json:"url"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	Tags    []string  `json:"tags"`
+	Videos  int       `json:"videos"` // How many videos it has
+}
+
+// listPageItems is every page with what an index of them would show.
+func listPageItems(r *http.Request) ([]pageItem, error) {
+	slugs, err := publishedSlugs(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	base := siteBaseURL(r)
+	items := make([]pageItem, 0, len(slugs))
+	for _, slug := range slugs {
+		modTime, err := pageModTime(r.Context(), slug)
+		if err != nil {
+			continue // Removed while we were listing
+		}
+		fm, _, _ := readPageText(r.Context(), slug)
+		meta, _ := loadPageMeta(r.Context(), slug)
+		videos, _ := pageVideoIDs(r.Context(), slug)
+		if fm.Tags == nil {
+			fm.Tags = []string{} // [] rather than null
+		}
+		items = append(items, pageItem{
+			Slug:    slug,
+			Title:   cmp.Or(fm.Title, meta.Title, slug),
+			URL:     base + pagePath(slug),
+			Created: pageCreated(r.Context(), slug).UTC(),
+			Updated: modTime.UTC(),
+			Tags:    fm.Tags,
+			Videos:  len(videos),
+		})
+	}
+	return items, nil
+}
+
+func pageItemBySlug(p pageItem) listCursor { return listCursor{Key: p.Slug} }
+
+func pageItemByUpdate(p pageItem) listCursor {
+	return listCursor{Num: p.Updated.UnixNano(), Key: p.Slug}
+}
+
+// pagesAPIHandler serves GET /api/pages, every page in slug order, with its
+// title, tags and how many videos it has.
+func (srv *Server) pagesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	items, err := listPageItems(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading pages directory", "err", err)
+		http.Error(w, "Could not list pages", http.StatusInternalServerError)
+		return
+	}
+	slices.SortFunc(items, func(a, b pageItem) int { return pageItemBySlug(a).compare(pageItemBySlug(b)) })
+	writeListPage(w, r, items, pageItemBySlug)
+}
+
+// changesAPIHandler serves GET /api/changes, every page in the order it was
+// last updated, oldest first. Keep the last next_cursor to get only newer changes.
+func (srv *Server) changesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	items, err := listPageItems(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading pages directory", "err", err)
+		http.Error(w, "Could not list changes", http.StatusInternalServerError)
+		return
+	}
+	slices.SortFunc(items, func(a, b pageItem) int { return pageItemByUpdate(a).compare(pageItemByUpdate(b)) })
+	writeListPage(w, r, items, pageItemByUpdate)
+}
+
+// --- A page's videos and comments ---
+
+// listedSlug reads and checks ?page=, sending a 404 if there's no such page.
+func listedSlug(w http.ResponseWriter, r *http.Request) (string, bool) {
+	slug := filepath.Base(r.URL.Query().Get("page"))
+	setLogSlug(r, slug)
+	if !pageExists(r.Context(), slug) || isRestricted(r.Context(), slug) {
+		http.NotFound(w, r)
+		return "", false
+	}
+	return slug, true
+}
+
+// videoItem is a video in /api/videos. Position is where it is in the
+// page's list of links, which only ever grows, so it's a stable order.
+type videoItem struct {
+	Position int    `json:"position"`
+	ID       string `json:"id"`
+	EmbedURL string `json:"embed_url"`
+	WatchURL string `json:"watch_url"`
+	Votes    int    `json:"votes"`
+}
+
+func videoItemByPosition(v videoItem) listCursor {
+	return listCursor{Num: int64(v.Position)}
+}
+
+// videosAPIHandler serves GET /api/videos?page={slug}, the page's videos in
+// the order they were added. Vote counts change, so they're not the order.
+func (srv *Server) videosAPIHandler(w http.ResponseWriter, r *http.Request) {
+	slug, ok := listedSlug(w, r)
+	if !ok {
+		return
+	}
+	page, err := loadPage(r.Context(), slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error loading page", "err", err)
+		http.Error(w, "Could not list videos", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]videoItem, 0, len(page.YouTubeEmbed))
+	for _, video := range page.YouTubeEmbed {
+		items = append(items, videoItem{
+			Position: video.Position,
+			ID:       video.ID,
+			EmbedURL: video.URL,
+			WatchURL: "https://www.youtube.com/watch?v=" + video.ID,
+			Votes:    video.Votes,
+		})
+	}
+	slices.SortFunc(items, func(a, b videoItem) int { return cmp.Compare(a.Position, b.Position) })
+	writeListPage(w, r, items, videoItemByPosition)
+}
+
+// commentItem is a comment in /api/comments, without what only moderators see.
+type commentItem struct {
+	ID        string    `json:"id"`
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func commentItemByTime(c commentItem) listCursor {
+	return listCursor{Num: c.CreatedAt.UnixNano(), Key: c.ID}
+}
+
+// commentsAPIHandler serves GET /api/comments?page={slug}, the page's
+// approved comments, oldest first.
+func (srv *Server) commentsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	slug, ok := listedSlug(w, r)
+	if !ok {
+		return
+	}
+	comments, err := loadComments(r.Context(), slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading comments", "err", err)
+		http.Error(w, "Could not list comments", http.StatusInternalServerError)
+		return
+	}
+
+	var items []commentItem
+	for _, c := range comments {
+		if c.Status == commentApproved {
+			items = append(items, commentItem{ID: c.ID, Author: c.Author, Body: c.Body, CreatedAt: c.CreatedAt})
+		}
+	}
+	slices.SortFunc(items, func(a, b commentItem) int { return commentItemByTime(a).compare(commentItemByTime(b)) })
+	writeListPage(w, r, items, commentItemByTime)
+}
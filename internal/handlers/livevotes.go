@@ -0,0 +1,120 @@
+package handlers
+
+//Live vote counts. Anyone looking at a page can follow /page/{slug}/votes,
+//a stream of server-sent events, and gets each video's new score as soon as
+//someone votes on it, rather than having to reload the page to see it.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Scores a slow viewer can fall behind by before they're dropped. They only
+// miss the counts sent meanwhile, the next vote brings them up to date.
+const voteStreamBuffer = 16
+
+// voteUpdate is a video's score after a vote, as sent to viewers.
+type voteUpdate struct {
+	VideoID string `json:"video_id"`
+	Votes   int    `json:"votes"`
+}
+
+// voteWatchers is everyone following a site's pages' votes, by slug.
+type voteWatchers struct {
+	sync.Mutex
+	bySlug map[string]map[chan voteUpdate]struct{}
+}
+
+// watchVotes starts following a page's votes. The returned func stops.
+func watchVotes(ctx context.Context, slug string) (chan voteUpdate, func()) {
+	watchers := &siteOf(ctx).voteWatchers
+	ch := make(chan voteUpdate, voteStreamBuffer)
+	watchers.Lock()
+	defer watchers.Unlock()
+	if watchers.bySlug == nil {
+		watchers.bySlug = make(map[string]map[chan voteUpdate]struct{})
+	}
+	if watchers.bySlug[slug] == nil {
+		watchers.bySlug[slug] = make(map[chan voteUpdate]struct{})
+	}
+	watchers.bySlug[slug][ch] = struct{}{}
+
+	return ch, func() {
+		watchers.Lock()
+		defer watchers.Unlock()
+		delete(watchers.bySlug[slug], ch)
+		if len(watchers.bySlug[slug]) == 0 {
+			delete(watchers.bySlug, slug)
+		}
+	}
+}
+
+// publishVotes sends the new scores of a page's videos to everyone watching
+// it, and to /events. Nobody is waited on: a viewer who is behind misses them.
+func publishVotes(ctx context.Context, slug string, scores map[string]int) {
+	watchers := &siteOf(ctx).voteWatchers
+	watchers.Lock()
+	for ch := range watchers.bySlug[slug] {
+		for videoID, votes := range scores {
+			select {
+			case ch <- voteUpdate{VideoID: videoID, Votes: votes}:
+			default:
+			}
+		}
+	}
+	watchers.Unlock()
+
+	if isRestricted(ctx, slug) {
+		return // Not for /events to give away
+	}
+	for videoID, votes := range scores {
+		publishSiteEvent(ctx, "vote", map[string]any{"slug": slug, "video_id": videoID, "votes": votes})
+	}
+}
+
+// liveVotesHandler serves /page/{slug}/votes, an event stream of
+// {"video_id": "...", "votes": 3} each time one of the page's videos is
+// voted on.
+func liveVotesHandler(w http.ResponseWriter, r *http.Request, page *Page) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	updates, stop := watchVotes(r.Context(), page.Slug)
+	defer stop()
+
+	rc := http.NewResponseController(w)
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Accel-Buffering", "no") // Or nginx holds on to the events
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		slog.ErrorContext(r.Context(), "Error starting vote stream", "err", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case update := <-updates:
+			data, _ := json.Marshal(update)
+			fmt.Fprintf(w, "event: vote\ndata: %s\n\n", data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": still here\n\n")
+		case <-r.Context().Done():
+			return
+		case <-serverOf(r.Context()).streamsClosed:
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return // They've gone
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+//Advisory page locks, so the edit view can say a page is being edited by
+//someone else. Nothing stops an edit to a locked page (edit.go catches edits
+//that cross), but it saves people the trouble. Locks are kept in memory and
+//expire unless the editor keeps renewing them, and admins can break them at
+///admin/locks.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a lock lasts without being renewed.
+const pageLockTTL = 5 * time.Minute
+
+// pageLock is someone editing a page. Token is only given to whoever took it,
+// to renew or release it with.
+type pageLock struct {
+	Slug    string    `json:"slug"`
+	Holder  string    `json:"holder"`
+	Token   string    `json:"token,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// pageLocks is every lock on a site's pages, by slug.
+type pageLocks struct {
+	sync.Mutex
+	bySlug map[string]pageLock
+}
+
+// newLockToken makes the secret a lock is renewed and released with.
+func newLockToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// current is the page's lock, if it has one that hasn't expired. Callers
+// must hold locks.
+func (locks *pageLocks) current(slug string, now time.Time) (pageLock, bool) {
+	lock, ok := locks.bySlug[slug]
+	if ok && !now.Before(lock.Expires) {
+		delete(locks.bySlug, slug)
+		return pageLock{}, false
+	}
+	return lock, ok
+}
+
+// lockRequest is the body of a POST locking or unlocking a page.
+type lockRequest struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+}
+
+// lockHandler handles /api/page/{slug}/lock. GET says who, if anyone, has
+// the page locked. POST takes the lock, with an optional JSON body of
+// {"name": "Sam"} to show on sites without logins, or renews it with
+// {"token": "..."}; it answers with the lock and its token, or a 409 with
+// the lock someone else has. /unlock, with {"token": "..."}, releases it.
+func (srv *Server) lockHandler(w http.ResponseWriter, r *http.Request) {
+	_, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/page/"), "/")
+	slug, ok := editablePage(w, r, action)
+	if !ok {
+		return
+	}
+	locks := &siteOf(r.Context()).pageLocks
+	now := time.Now()
+
+	locks.Lock()
+	defer locks.Unlock()
+	lock, locked := locks.current(slug, now)
+
+	if r.Method == http.MethodGet && action == "lock" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !locked {
+			w.Write([]byte("null\n"))
+			return
+		}
+		lock.Token = ""
+		json.NewEncoder(w).Encode(lock)
+		return
+	}
+	if r.Method != http.MethodPost {
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	var reqBody lockRequest
+	if r.ContentLength != 0 {
+		if err := readJSON(w, r, &reqBody); err != nil {
+			badJSON(w, err)
+			return
+		}
+	}
+	mine := locked && reqBody.Token == lock.Token
+
+	if action == "unlock" {
+		if locked && !mine {
+			writeProblem(w, http.StatusConflict, "locked", "Someone else has the page locked")
+			return
+		}
+		delete(locks.bySlug, slug)
+		slog.InfoContext(r.Context(), "Page unlocked")
+		w.Write([]byte("Page unlocked"))
+		return
+	}
+
+	if locked && !mine {
+		lock.Token = ""
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(lock)
+		return
+	}
+	if !mine {
+		lock = pageLock{Slug: slug, Holder: editorName(r), Token: newLockToken()}
+		if lock.Holder == "" {
+			lock.Holder = strings.TrimSpace(reqBody.Name)
+		}
+		if lock.Holder == "" {
+			lock.Holder = "someone"
+		}
+		slog.InfoContext(r.Context(), "Page locked", "holder", lock.Holder)
+	}
+	lock.Expires = now.Add(pageLockTTL).UTC()
+	if locks.bySlug == nil {
+		locks.bySlug = make(map[string]pageLock)
+	}
+	locks.bySlug[slug] = lock
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lock)
+}
+
+// adminLocksHandler serves /admin/locks. GET lists the site's page locks,
+// and DELETE ?slug=my-page breaks one.
+func (srv *Server) adminLocksHandler(w http.ResponseWriter, r *http.Request) {
+	locks := &siteOf(r.Context()).pageLocks
+	now := time.Now()
+	locks.Lock()
+	defer locks.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		list := []pageLock{}
+		for slug := range locks.bySlug {
+			if lock, ok := locks.current(slug, now); ok {
+				lock.Token = ""
+				list = append(list, lock)
+			}
+		}
+		slices.SortFunc(list, func(a, b pageLock) int { return strings.Compare(a.Slug, b.Slug) })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodDelete:
+		slug := r.URL.Query().Get("slug")
+		if _, ok := locks.current(slug, now); !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(locks.bySlug, slug)
+		slog.InfoContext(r.Context(), "Page lock broken", "page", slug)
+		w.Write([]byte("Lock broken!"))
+
+	default:
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+//Structured logging. Every request gets an ID, and anything logged while
+//handling it (with the request's context) carries the ID, method, path,
+//status so far, time taken and the page it's about.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Request IDs we accept from a proxy in front of us. Anything else gets replaced.
+var requestIDRegex = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+type requestInfoKey struct{}
+
+// requestInfo is what we know about the request being handled, for its log lines.
+type requestInfo struct {
+	mu     sync.Mutex
+	id     string
+	method string
+	path   string
+	start  time.Time
+	status int
+	slug   string
+}
+
+// SetupLogging points slog (and so everything logged) at stderr in the
+// configured format and level, with the ID and page of the request a record
+// was logged for added to it.
+func SetupLogging(format, level string) {
+	var lvl slog.Level
+	lvl.UnmarshalText([]byte(level)) // Checked by config.Load
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if format == "json" {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(requestLogHandler{h}))
+}
+
+// requestLogHandler adds the request's details to records logged with its context.
+type requestLogHandler struct {
+	slog.Handler
+}
+
+func (h requestLogHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		rec.AddAttrs(
+			slog.String("request_id", info.id),
+			slog.String("method", info.method),
+			slog.String("path", info.path),
+			slog.Duration("duration", time.Since(info.start)),
+		)
+		if info.status != 0 {
+			rec.AddAttrs(slog.Int("status", info.status))
+		}
+		if info.slug != "" {
+			rec.AddAttrs(slog.String("slug", info.slug))
+		}
+		info.mu.Unlock()
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		rec.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h requestLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestLogHandler) WithGroup(name string) slog.Handler {
+	return requestLogHandler{h.Handler.WithGroup(name)}
+}
+
+// setLogSlug records which page a request is about, for its log lines.
+func setLogSlug(r *http.Request, slug string) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		info.slug = slug
+		info.mu.Unlock()
+	}
+}
+
+// requestID is the ID of the request ctx belongs to, if any.
+func requestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// newRequestID makes a random ID to tell requests apart in the logs.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder remembers the status a handler responded with.
+type statusRecorder struct {
+	http.ResponseWriter
+	info *requestInfo
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.info.mu.Lock()
+	if s.info.status == 0 {
+		s.info.status = code
+	}
+	s.info.mu.Unlock()
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.info.mu.Lock()
+	if s.info.status == 0 {
+		s.info.status = http.StatusOK
+	}
+	s.info.mu.Unlock()
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController get at Flush and friends.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// withRequestLog gives every request an ID (reusing a sane X-Request-ID from
+// a proxy), sends it back in the response, and logs each request once done.
+func withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRegex.MatchString(id) {
+			id = newRequestID()
+		}
+		info := &requestInfo{id: id, method: r.Method, path: r.URL.Path, start: time.Now()}
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		next.ServeHTTP(&statusRecorder{ResponseWriter: w, info: info}, r.WithContext(ctx))
+
+		info.mu.Lock()
+		if info.status == 0 {
+			info.status = http.StatusOK // Handler didn't write anything
+		}
+		info.mu.Unlock()
+		// Static files and probes would drown out everything else, so they're debug only
+		level := slog.LevelInfo
+		if strings.HasPrefix(r.URL.Path, "/static/") || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			level = slog.LevelDebug
+		}
+		slog.Log(ctx, level, "Request handled")
+	})
+}
@@ -0,0 +1,106 @@
+package handlers
+
+//Read-only mode, for backups and migrations. While it's on, anything that
+//would change a page file (a POST, PUT or DELETE) gets a 503, pages say why
+//in a banner, and the background jobs that write files hold off; reads are
+//served as usual. It starts as maintenance.read_only says, and admins can
+//switch it at /admin/read-only without a restart.
+
+import (
+	"cmp"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// The message when neither the admin nor maintenance.message gave one.
+const defaultReadOnlyMessage = "The site is read-only for maintenance, changes can't be saved right now."
+
+// readOnlyState is whether a Server is read-only now, and why.
+type readOnlyState struct {
+	sync.RWMutex
+	on      bool
+	message string
+}
+
+// readOnlyMode is the read-only state, as JSON for /admin/read-only.
+type readOnlyMode struct {
+	ReadOnly bool   `json:"read_only"`
+	Message  string `json:"message,omitempty"`
+}
+
+// setReadOnly switches read-only mode on or off.
+func (srv *Server) setReadOnly(on bool, message string) {
+	srv.readOnlyState.Lock()
+	defer srv.readOnlyState.Unlock()
+	srv.readOnlyState.on = on
+	srv.readOnlyState.message = strings.TrimSpace(message)
+}
+
+// readOnly reports whether we're read-only.
+func (srv *Server) readOnly() bool {
+	srv.readOnlyState.RLock()
+	defer srv.readOnlyState.RUnlock()
+	return srv.readOnlyState.on
+}
+
+// readOnlyMessage is what to tell people while we're read-only, and ""
+// otherwise. Templates show it as a banner.
+func (srv *Server) readOnlyMessage() string {
+	srv.readOnlyState.RLock()
+	defer srv.readOnlyState.RUnlock()
+	if !srv.readOnlyState.on {
+		return ""
+	}
+	return cmp.Or(srv.readOnlyState.message, srv.config.Maintenance.Message, defaultReadOnlyMessage)
+}
+
+// rejectWritesWhenReadOnly refuses requests that change things while we're
+// read-only, except for the one switching it back off, taking a backup,
+// which only reads pages, and GraphQL, which POSTs queries too and refuses
+// mutations itself.
+func (srv *Server) rejectWritesWhenReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		message := srv.readOnlyMessage()
+		if message == "" || r.URL.Path == "/admin/read-only" || r.URL.Path == "/admin/backups" || r.URL.Path == "/graphql" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", "300")
+		http.Error(w, message, http.StatusServiceUnavailable)
+	})
+}
+
+// adminReadOnlyHandler serves /admin/read-only on the main site. GET says
+// whether we're read-only, POST with {"read_only": true, "message": "..."}
+// switches it. It's for the whole server, so other sites' admins can't.
+func (srv *Server) adminReadOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	if !siteOf(r.Context()).main {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var mode readOnlyMode
+		if err := readJSON(w, r, &mode); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		srv.setReadOnly(mode.ReadOnly, mode.Message)
+		slog.InfoContext(r.Context(), "Read-only mode switched", "read_only", mode.ReadOnly)
+	default:
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(readOnlyMode{ReadOnly: srv.readOnly(), Message: srv.readOnlyMessage()})
+}
@@ -0,0 +1,99 @@
+package handlers
+
+//The migrations the server runs on every site's store at startup, see
+//internal/migrate. To change a format, add one to the end of migrations that
+//brings the old files up to date. Never change or remove one that's shipped.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"time"
+
+	"go-trailer/internal/migrate"
+	"go-trailer/internal/storage"
+	"go-trailer/internal/tenants"
+)
+
+// Every migration, oldest first. A store in format n has had the first n run.
+var migrations = []migrate.Migration{
+	{Name: "created times in meta files", Run: migrateCreatedTimes},
+}
+
+// migrateSites brings the stores of sites, and every tenant's, up to the
+// current format. It's run at startup, before any requests.
+func migrateSites(ctx context.Context, sites []*site) error {
+	config := configOf(ctx)
+	all := slices.Clone(sites)
+	if config.Tenants.Enabled {
+		entries, err := os.ReadDir(config.Tenants.Dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		for _, entry := range entries {
+			s, err := serverOf(ctx).tenantSite(entry.Name())
+			if err != nil {
+				return fmt.Errorf("tenant %s: %w", entry.Name(), err)
+			}
+			if s != nil {
+				all = append(all, s)
+			}
+		}
+	}
+	for _, s := range all {
+		if err := migrateSite(context.WithValue(ctx, siteKey{}, s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSite runs the migrations the site's store hasn't had.
+func migrateSite(ctx context.Context) error {
+	dir, _ := storeDir(siteOf(ctx).store())
+	return migrate.Run(ctx, storeCtx(ctx), dir, migrations)
+}
+
+// storeDir is the directory pages keeps its files in, if it's one of ours.
+func storeDir(pages storage.Storage) (string, bool) {
+	switch pages := pages.(type) {
+	case storage.Dir:
+		return pages.Path, true
+	case tenants.Storage:
+		return pages.Path, true
+	}
+	return "", false
+}
+
+// migrateCreatedTimes records when pages from before meta files were kept
+// were created, going by when their text was last written, so it stops
+// moving every time they're edited.
+func migrateCreatedTimes(ctx context.Context) error {
+	slugs, err := storage.PageSlugs(storeCtx(ctx))
+	if err != nil {
+		return err
+	}
+	siteOf(ctx).pageMetaMu.Lock()
+	defer siteOf(ctx).pageMetaMu.Unlock()
+	for _, slug := range slugs {
+		meta, err := loadPageMeta(ctx, slug)
+		if err != nil {
+			return fmt.Errorf("page %s: %w", slug, err)
+		}
+		if !meta.Created.IsZero() {
+			continue
+		}
+		modTime, err := storeCtx(ctx).ModTime(slug + ".txt")
+		if err != nil {
+			continue // Removed since we listed it
+		}
+		meta.Created = modTime.UTC().Truncate(time.Second)
+		if err := savePageMeta(ctx, slug, meta); err != nil {
+			return fmt.Errorf("page %s: %w", slug, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package handlers
+
+//Moderation of what people send in. Page text, videos and comments go
+//through a pipeline of moderators before they're saved, and each one can
+//let the content through, clean it up, flag it for review or reject it. The
+//pipeline starts with the word lists and blocked link hosts under
+//moderation: in config.yaml, then asks any moderation plugins (see
+//internal/plugins), in the order they were loaded.
+//
+//Cleaned content is saved as cleaned, and the next moderator sees it that
+//way. A flagged comment waits in the moderation queue like one the spam
+//checker held. A flagged page or video is saved, and the page is marked for
+//an admin to look at in /admin/pages?flagged=1. Rejected content isn't saved,
+//and counts as abuse towards a ban (see blocklist.go).
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"go-trailer/internal/plugins"
+)
+
+// What a moderator can make of content, from least to most severe.
+const (
+	moderationAllow  = "allow"
+	moderationClean  = "clean"
+	moderationReview = "review"
+	moderationReject = "reject"
+)
+
+// The kinds of content moderated.
+const (
+	moderatePage    = "page"
+	moderateVideo   = "video"
+	moderateComment = "comment"
+)
+
+// moderationItem is content about to be saved. It's also what moderation
+// plugins get.
+type moderationItem struct {
+	Kind   string `json:"kind"` // "page", "video" or "comment"
+	Slug   string `json:"slug"` // The page it's for
+	Text   string `json:"text"` // The page's text, the video's link or the comment's text
+	Author string `json:"author,omitempty"`
+}
+
+// moderationVerdict is what a moderator made of an item, and what moderation
+// plugins answer with.
+type moderationVerdict struct {
+	Verdict string `json:"verdict"`          // One of allow, clean, review or reject
+	Text    string `json:"text,omitempty"`   // The cleaned up text, with clean
+	Reason  string `json:"reason,omitempty"` // Why it was flagged or rejected, for admins
+}
+
+// moderator is one step of the pipeline.
+type moderator interface {
+	Moderate(item moderationItem) (moderationVerdict, error)
+}
+
+// loadModeration sets the pipeline content goes through up from config.yaml
+// and the moderation plugins. Plugins must be loaded first.
+func (srv *Server) loadModeration() {
+	settings := srv.config.Moderation
+	var moderationPipeline []moderator
+	if len(settings.RejectWords) > 0 {
+		moderationPipeline = append(moderationPipeline, wordFilter{words: wordsRegex(settings.RejectWords), verdict: moderationReject})
+	}
+	if len(settings.ReviewWords) > 0 {
+		moderationPipeline = append(moderationPipeline, wordFilter{words: wordsRegex(settings.ReviewWords), verdict: moderationReview})
+	}
+	if len(settings.CleanWords) > 0 {
+		moderationPipeline = append(moderationPipeline, wordFilter{words: wordsRegex(settings.CleanWords), verdict: moderationClean})
+	}
+	if len(settings.BlockedHosts) > 0 {
+		moderationPipeline = append(moderationPipeline, linkFilter{hosts: settings.BlockedHosts, verdict: settings.BlockedLinks})
+	}
+	for _, p := range srv.plugins.Moderators() {
+		moderationPipeline = append(moderationPipeline, pluginModerator{p})
+	}
+	srv.moderationPipeline = moderationPipeline
+}
+
+// moderate runs item through the pipeline. The verdict is the most severe
+// one given, with the text as cleaned along the way and the reasons it was
+// flagged; the first rejection ends it. A moderator that fails is skipped
+// rather than holding everything up.
+func moderate(r *http.Request, item moderationItem) moderationVerdict {
+	result := moderationVerdict{Verdict: moderationAllow, Text: item.Text}
+	var reasons []string
+	for _, m := range serverOf(r.Context()).moderationPipeline {
+		verdict, err := m.Moderate(item)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Moderator failed", "kind", item.Kind, "err", err)
+			continue
+		}
+		switch verdict.Verdict {
+		case moderationAllow:
+		case moderationClean:
+			item.Text = verdict.Text
+			result.Text = verdict.Text
+			if result.Verdict == moderationAllow {
+				result.Verdict = moderationClean
+			}
+		case moderationReview:
+			result.Verdict = moderationReview
+			reasons = append(reasons, verdict.Reason)
+		case moderationReject:
+			slog.InfoContext(r.Context(), "Content rejected by moderation", "kind", item.Kind, "reason", verdict.Reason)
+			reportAbuse(r, "rejected "+item.Kind+" content")
+			return verdict
+		default:
+			slog.ErrorContext(r.Context(), "Moderator gave an unknown verdict", "kind", item.Kind, "verdict", verdict.Verdict)
+		}
+	}
+	result.Reason = strings.Join(reasons, "; ")
+	if result.Verdict != moderationAllow {
+		slog.InfoContext(r.Context(), "Content moderated", "kind", item.Kind, "verdict", result.Verdict, "reason", result.Reason)
+	}
+	return result
+}
+
+// rejectionDetail is what to tell whoever sent content moderation rejected.
+func rejectionDetail(verdict moderationVerdict) string {
+	detail := "This can't be saved, it goes against the site's rules"
+	if verdict.Reason != "" {
+		detail += ": " + verdict.Reason
+	}
+	return detail
+}
+
+// rejectContent answers a request whose content moderation rejected, field
+// being where the content was.
+func rejectContent(w http.ResponseWriter, field string, verdict moderationVerdict) {
+	detail := rejectionDetail(verdict)
+	writeProblem(w, http.StatusUnprocessableEntity, "content_rejected", detail, fieldProblem{Field: field, Code: "content_rejected", Message: detail})
+}
+
+// flagPage marks a page for an admin to look at, for reason, until they
+// approve it in /admin/pages.
+func flagPage(ctx context.Context, slug, reason string) error {
+	siteOf(ctx).pageMetaMu.Lock()
+	defer siteOf(ctx).pageMetaMu.Unlock()
+	meta, err := loadPageMeta(ctx, slug)
+	if err != nil {
+		return err
+	}
+	switch {
+	case meta.Flagged == "":
+		meta.Flagged = reason
+	case !strings.Contains(meta.Flagged, reason):
+		meta.Flagged += "; " + reason
+	}
+	return savePageMeta(ctx, slug, meta)
+}
+
+// --- Word lists ---
+
+// wordsRegex matches any of words, as whole words in any case.
+func wordsRegex(words []string) *regexp.Regexp {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(strings.TrimSpace(word))
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// wordFilter gives its verdict to content with any of its words in it,
+// starring them out if it's clean.
+type wordFilter struct {
+	words   *regexp.Regexp
+	verdict string
+}
+
+func (f wordFilter) Moderate(item moderationItem) (moderationVerdict, error) {
+	found := f.words.FindString(item.Text)
+	if found == "" {
+		return moderationVerdict{Verdict: moderationAllow}, nil
+	}
+	if f.verdict == moderationClean {
+		cleaned := f.words.ReplaceAllStringFunc(item.Text, func(word string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(word))
+		})
+		return moderationVerdict{Verdict: moderationClean, Text: cleaned}, nil
+	}
+	return moderationVerdict{Verdict: f.verdict, Reason: fmt.Sprintf("has the word %q", strings.ToLower(found))}, nil
+}
+
+// --- Blocked link hosts ---
+
+// urlRegex finds links in text, up to whatever usually ends one.
+var urlRegex = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'()\[\]]+`)
+
+// linkFilter gives its verdict to content linking to any of its hosts,
+// taking the links out if it's clean.
+type linkFilter struct {
+	hosts   []string
+	verdict string
+}
+
+// blocked reports whether link goes to one of the hosts or a subdomain.
+func (f linkFilter) blocked(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for _, blocked := range f.hosts {
+		blocked = strings.ToLower(blocked)
+		if host == blocked || strings.HasSuffix(host, "."+blocked) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f linkFilter) Moderate(item moderationItem) (moderationVerdict, error) {
+	var found string
+	for _, link := range urlRegex.FindAllString(item.Text, -1) {
+		if f.blocked(link) {
+			found = link
+			break
+		}
+	}
+	if found == "" {
+		return moderationVerdict{Verdict: moderationAllow}, nil
+	}
+	if f.verdict == moderationClean {
+		cleaned := urlRegex.ReplaceAllStringFunc(item.Text, func(link string) string {
+			if f.blocked(link) {
+				return ""
+			}
+			return link
+		})
+		return moderationVerdict{Verdict: moderationClean, Text: cleaned}, nil
+	}
+	u, _ := url.Parse(found)
+	return moderationVerdict{Verdict: f.verdict, Reason: "links to " + u.Hostname()}, nil
+}
+
+// pluginModerator is a step of the moderation pipeline answered by a plugin,
+// with Plugin.Moderate taking a moderationItem and answering with a
+// moderationVerdict.
+type pluginModerator struct {
+	p *plugins.Plugin
+}
+
+func (m pluginModerator) Moderate(item moderationItem) (moderationVerdict, error) {
+	var reply moderationVerdict
+	if err := m.p.Call("Plugin.Moderate", item, &reply); err != nil {
+		return reply, fmt.Errorf("plugin %s: %w", m.p.Name(), err)
+	}
+	return reply, nil
+}
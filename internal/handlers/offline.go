@@ -0,0 +1,196 @@
+package handlers
+
+//What go-trailer's subcommands do to the main site's pages directory, with
+//nothing served. The command line itself is in the go-trailer command.
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+
+	"go-trailer/internal/config"
+	"go-trailer/internal/slugs"
+	"go-trailer/internal/storage"
+)
+
+// Offline is the main site with nothing running, to work on its pages
+// directory.
+type Offline struct {
+	ctx context.Context
+}
+
+// OfflinePage is a page, as Offline.Pages lists it.
+type OfflinePage struct {
+	Slug    string
+	Title   string
+	Created time.Time
+	Status  string // "published", "draft", "private" or "archived"
+}
+
+// NewOffline is the main site of cfg, ready to work on.
+func NewOffline(cfg config.Config) *Offline {
+	srv := newServer(cfg)
+	srv.main = srv.mainSite()
+	return &Offline{ctx: context.WithValue(context.Background(), siteKey{}, srv.main)}
+}
+
+// PagesDir is where the site's pages are kept.
+func (o *Offline) PagesDir() string {
+	return configOf(o.ctx).PagesDir
+}
+
+// CreatePage creates a page called name with the default text, as /create
+// does, answering with its slug.
+func (o *Offline) CreatePage(name string) (string, error) {
+	ctx := o.ctx
+	if err := slugs.CheckName(name); err != nil || strings.TrimSpace(name) == "" {
+		return "", errors.New("page names are letters, numbers, spaces, - and _")
+	}
+	slug := slugs.FromName(name)
+	if reservedSlug(ctx, slug) {
+		return "", errors.New("the name " + slug + " is reserved")
+	}
+	siteOf(ctx).createMu.Lock()
+	defer siteOf(ctx).createMu.Unlock()
+	slug, free := freeSlug(ctx, slug)
+	if !free {
+		return "", errors.New("there's a page called " + slug + " already")
+	}
+	if err := storeCtx(ctx).WriteFile(slug+".txt", []byte(defaultPageBody(name))); err != nil {
+		return "", err
+	}
+	return slug, recordPageCreated(ctx, slug, name, "", time.Time{})
+}
+
+// Pages is every page, drafts and archived ones too.
+func (o *Offline) Pages() ([]OfflinePage, error) {
+	ctx := o.ctx
+	slugs, err := storage.PageSlugs(storeCtx(ctx))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var pages []OfflinePage
+	for _, slug := range slugs {
+		meta, _ := loadPageMeta(ctx, slug)
+		status := "published"
+		switch {
+		case meta.hidden(now):
+			status = "draft"
+		case meta.Private:
+			status = "private"
+		case meta.archived(now):
+			status = "archived"
+		}
+		pages = append(pages, OfflinePage{Slug: slug, Title: pageTitle(ctx, slug), Created: pageCreated(ctx, slug), Status: status})
+	}
+	return pages, nil
+}
+
+// DeletePage moves the page to the trash, as by, and forgets its view count.
+func (o *Offline) DeletePage(slug, by string) error {
+	ctx := o.ctx
+	if err := loadViewCounts(ctx); err != nil {
+		return err
+	}
+	siteOf(ctx).createMu.Lock()
+	err := deletePage(ctx, slug, by)
+	siteOf(ctx).createMu.Unlock()
+	if errors.Is(err, fs.ErrNotExist) {
+		return errors.New("there's no page " + slug)
+	}
+	if err != nil {
+		return err
+	}
+	return saveViewCounts(ctx)
+}
+
+// Export writes the same zip as /admin/export to w, of the files as they
+// are or, with markdown set, of the pages as Markdown.
+func (o *Offline) Export(w io.Writer, markdown bool) error {
+	names, err := storeCtx(o.ctx).List()
+	if err != nil {
+		return err
+	}
+	slices.Sort(names)
+	zw := zip.NewWriter(w)
+	if markdown {
+		err = exportMarkdown(o.ctx, zw, names)
+	} else {
+		err = exportFiles(o.ctx, zw, names)
+	}
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Import imports the pages in files, as /admin/import does a zip, answering
+// with how each went.
+func (o *Offline) Import(files fs.FS) ([]ImportResult, error) {
+	return importPages(o.ctx, files, "")
+}
+
+// Check finds what's wrong with the site's files, as /admin/fsck does. With
+// fix set, orphans are removed and votes files compacted as they're found.
+func (o *Offline) Check(fix bool) ([]FsckProblem, error) {
+	return checkStore(o.ctx, fix)
+}
+
+// Reindex brings what's kept about the pages back in line with them:
+// migrates the files to this version's format, fills in when pages were
+// created where that's missing, drops votes for videos that are gone and
+// view counts for pages that are. It answers with how many pages there are,
+// and what it dropped.
+func (o *Offline) Reindex() (int, []string, error) {
+	ctx := o.ctx
+	if err := migrateSites(ctx, []*site{siteOf(ctx)}); err != nil {
+		return 0, nil, err
+	}
+	if err := migrateCreatedTimes(ctx); err != nil {
+		return 0, nil, err
+	}
+
+	slugs, err := storage.PageSlugs(storeCtx(ctx))
+	if err != nil {
+		return 0, nil, err
+	}
+	var dropped []string
+	for _, slug := range slugs {
+		videoIDs, err := compactVotes(ctx, slug)
+		if err != nil {
+			return 0, dropped, fmt.Errorf("page %s: %w", slug, err)
+		}
+		for _, videoID := range videoIDs {
+			dropped = append(dropped, "votes for "+videoID+" on "+slug)
+		}
+	}
+
+	if err := loadViewCounts(ctx); err != nil {
+		return 0, dropped, err
+	}
+	counts, err := viewCountsOf(ctx)
+	if err != nil {
+		return 0, dropped, err
+	}
+	var gone []string
+	for slug := range counts {
+		if !slices.Contains(slugs, slug) {
+			gone = append(gone, slug)
+		}
+	}
+	for _, slug := range gone {
+		forgetViewCount(ctx, slug)
+		dropped = append(dropped, "view count of "+slug)
+	}
+	if err := saveViewCounts(ctx); err != nil {
+		return 0, dropped, err
+	}
+	return len(slugs), dropped, nil
+}
@@ -0,0 +1,336 @@
+package handlers
+
+//An OpenAPI 3 description of the JSON API, at /api/openapi.json. Each
+//operation below names the Go types its handler decodes and encodes, and
+//their schemas are worked out from those types' fields and json tags, so a
+//field added to a response shows up here without anyone remembering to.
+//The operations are the routes NewServer registered, so one that's switched
+//off isn't described; what isn't in the types (parameters, who may call
+//what) is in apiDocs, by the route's pattern, so add to it when adding an
+//endpoint.
+
+import (
+	"cmp"
+	"html/template"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiOperation is one endpoint, as OpenAPI describes it. Its method and path
+// are the route's, see apiOperations.
+type apiOperation struct {
+	method, path string // Only set when the route's pattern doesn't say, e.g. for a prefix
+	summary      string
+	params       []apiParam
+	request      any            // A value of the JSON body's type, nil for none
+	response     any            // A value of the JSON answer's type, a string for a text one, or nil for a redirect
+	status       int            // What it answers with when it works, 200 if unset
+	errors       map[int]any    // Answers other than plain text errors, by status
+	login        bool           // Needs a login when an auth plugin is loaded
+	list         bool           // response is the item type of a paged list
+	extra        map[string]any // Anything else for the operation, e.g. a multipart body
+}
+
+// apiParam is a path or query parameter.
+type apiParam struct {
+	name, in, description string
+	required              bool
+}
+
+// Parameters many operations share.
+var (
+	slugParam   = apiParam{name: "slug", in: "path", description: "The page's slug", required: true}
+	limitParam  = apiParam{name: "limit", in: "query", description: "Most items to answer with, at most 200"}
+	cursorParam = apiParam{name: "cursor", in: "query", description: "next_cursor from the last answer, to carry on from there"}
+	pageParam   = apiParam{name: "page", in: "query", description: "The page's slug", required: true}
+
+	idempotencyParam = apiParam{name: "Idempotency-Key", in: "header", description: "Any unique string; the same request sent again with it gets the first answer again rather than being done twice"}
+)
+
+// Answers the handlers encode as maps, given types here to describe them.
+type (
+	commentResponse struct {
+		ID     string `json:"id"`
+		Status string `json:"status"` // "approved", or "pending" moderation
+	}
+	voteBatchResponse struct {
+		Applied bool              `json:"applied"`
+		Results []batchVoteResult `json:"results"`
+	}
+	editResponse struct {
+		Revision string `json:"revision"`
+	}
+	renameResponse struct {
+		Slug string `json:"slug"`
+		URL  string `json:"url"`
+	}
+)
+
+// apiDocs is every JSON endpoint, by the pattern its route is registered
+// with in NewServer. A pattern for several methods has an operation for
+// each. The paths described are the /api/v1/ ones, see apiversions.go.
+var apiDocs = map[string][]apiOperation{
+	"/api/pages":           {{method: "GET", summary: "List every page, in slug order", params: []apiParam{limitParam, cursorParam}, response: pageItem{}, list: true}},
+	"POST /api/pages":      {{summary: "Create a page, or be sent to the one of that name", request: createPageRequest{}, response: pageJSON{}, status: http.StatusCreated, login: true}},
+	"/create":              {{method: "POST", summary: "Create a page and be sent to it, or to the one of that name", request: createPageRequest{}, status: http.StatusSeeOther, login: true}},
+	"GET /api/page/{slug}": {{summary: "Get a page and its videos", params: []apiParam{slugParam}, response: pageJSON{}}},
+	"/api/changes":         {{method: "GET", summary: "List every page, least recently updated first", params: []apiParam{limitParam, cursorParam}, response: pageItem{}, list: true}},
+	"/api/videos":          {{method: "GET", summary: "List a page's videos, in the order they were added", params: []apiParam{pageParam, limitParam, cursorParam}, response: videoItem{}, list: true}},
+	"/api/comments":        {{method: "GET", summary: "List a page's published comments", params: []apiParam{pageParam, limitParam, cursorParam}, response: commentItem{}, list: true}},
+	"/api/comments/":       {{method: "POST", path: "/api/v1/comments/{slug}", summary: "Comment on a page", params: []apiParam{slugParam}, request: commentRequest{}, response: commentResponse{}, login: true}},
+	"POST /api/vote/{slug}/{videoID}/{action}": {{summary: "Vote a page's video up or down", params: []apiParam{slugParam, {name: "videoID", in: "path", description: "The video's YouTube ID", required: true}, {name: "action", in: "path", description: "upvote or downvote", required: true}}, response: "", login: true}},
+	"POST /api/vote/batch":                     {{summary: "Vote many times at once, all or nothing", request: voteBatchRequest{}, response: voteBatchResponse{}, errors: map[int]any{http.StatusUnprocessableEntity: voteBatchResponse{}}, login: true}},
+	"/api/search":                              {{method: "GET", summary: "Search pages", params: []apiParam{{name: "q", in: "query", description: "What to search for", required: true}}, response: searchResponse{}}},
+	"/api/popular":                             {{method: "GET", summary: "The most viewed pages", params: []apiParam{limitParam}, response: []PopularPage{}}},
+	"GET /api/challenge":                       {{summary: "A proof of work challenge, to answer when creating a page", response: challengeResponse{}}},
+	"/api/preview":                             {{method: "POST", summary: "Render page text as a page would show it", request: previewRequest{}, response: previewResponse{}, login: true}},
+	"POST /api/page/{slug}/save-youtube":       {{summary: "Add a YouTube video to a page", params: []apiParam{slugParam}, request: saveVideoRequest{}, response: "", login: true}},
+	"GET /api/page/{slug}/source":              {{summary: "A page's text and its revision, for editing", params: []apiParam{slugParam}, response: pageSource{}, login: true}},
+	"POST /api/page/{slug}/edit":               {{summary: "Save a page's text over the revision it was edited from", params: []apiParam{slugParam}, request: pageSource{}, response: editResponse{}, errors: map[int]any{http.StatusConflict: editConflict{}}, login: true}},
+	"POST /api/page/{slug}/rename":             {{summary: "Rename a page, redirecting its old slug", params: []apiParam{slugParam}, request: renameRequest{}, response: renameResponse{}, login: true}},
+	"POST /api/page/{slug}/publish":            {{summary: "Publish a draft", params: []apiParam{slugParam}, response: "", login: true}},
+	"POST /api/page/{slug}/unpublish":          {{summary: "Make a page a draft again", params: []apiParam{slugParam}, response: "", login: true}},
+	"POST /api/page/{slug}/schedule":           {{summary: "Publish a draft at a set time", params: []apiParam{slugParam}, request: scheduleRequest{}, response: "", login: true}},
+	"/api/page/{slug}/shares": {
+		{method: "GET", summary: "List the secret share links to a draft or private page", params: []apiParam{slugParam}, response: []shareLinkJSON{}, login: true},
+		{method: "POST", summary: "Make a secret /share/{token} link anyone can read a draft or private page with, until it expires", params: []apiParam{slugParam}, request: shareRequest{}, response: shareLinkJSON{}, status: http.StatusCreated, login: true},
+	},
+	"DELETE /api/page/{slug}/shares/{id}": {{summary: "Revoke a share link", params: []apiParam{slugParam, {name: "id", in: "path", required: true}}, response: "", login: true}},
+	"POST /api/page/{slug}/access":        {{summary: "Make a page private to a list of users and @roles, or public again", params: []apiParam{slugParam}, request: accessRequest{}, response: accessRequest{}, login: true}},
+	"POST /api/page/{slug}/expire":        {{summary: "Archive a page at a set time", params: []apiParam{slugParam}, request: expireRequest{}, response: "", login: true}},
+	"POST /api/page/{slug}/embed":         {{summary: "Change how a page's videos are embedded", params: []apiParam{slugParam}, request: pageEmbedSettings{}, response: "", login: true}},
+	"/api/page/{slug}/lock": {
+		{method: "GET", summary: "Who's editing a page, null if nobody", params: []apiParam{slugParam}, response: pageLock{}, login: true},
+		{method: "POST", summary: "Lock a page while editing it, or renew the lock", params: []apiParam{slugParam}, request: lockRequest{}, response: pageLock{}, errors: map[int]any{http.StatusConflict: pageLock{}}, login: true},
+	},
+	"POST /api/page/{slug}/unlock": {{summary: "Let go of a page's lock", params: []apiParam{slugParam}, request: lockRequest{}, response: "", login: true}},
+	"/api/page/{slug}/attachments": {
+		{method: "GET", summary: "List a page's attachments", params: []apiParam{slugParam}, response: []Attachment{}, login: true},
+		{method: "POST", summary: "Upload an attachment, as the file field of a multipart form", params: []apiParam{slugParam}, response: Attachment{}, status: http.StatusCreated, login: true, extra: map[string]any{
+			"requestBody": map[string]any{"required": true, "content": map[string]any{"multipart/form-data": map[string]any{"schema": map[string]any{
+				"type": "object", "required": []string{"file"}, "properties": map[string]any{"file": map[string]any{"type": "string", "format": "binary"}},
+			}}}},
+		}},
+	},
+	"DELETE /api/page/{slug}/attachments/{name}": {{summary: "Delete an attachment the page no longer uses", params: []apiParam{slugParam, {name: "name", in: "path", required: true}, {name: "force", in: "query", description: "true to delete it even if the page uses it"}}, response: "", login: true}},
+	"/page/": {
+		{method: "GET", path: "/page/{slug}", summary: "A page, asked for with Accept: application/json", params: []apiParam{slugParam}, response: pageJSON{}},
+		{method: "GET", path: "/page/{slug}/backlinks", summary: "The pages linking to a page", params: []apiParam{slugParam}, response: []Backlink{}},
+	},
+}
+
+// openAPIHandler serves GET /api/openapi.json.
+func (srv *Server) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(srv.openAPIDoc)
+}
+
+// apiOperations is the operations of the routes srv registered, in the
+// order it did, with their methods and /api/v1/ paths filled in.
+func (srv *Server) apiOperations() []apiOperation {
+	var ops []apiOperation
+	for _, pattern := range srv.routes {
+		method, path, ok := strings.Cut(pattern, " ")
+		if !ok {
+			method, path = "", pattern
+		}
+		if v1, ok := translateAPIPath(path, true); ok {
+			path = v1
+		}
+		for _, op := range apiDocs[pattern] {
+			op.method = cmp.Or(op.method, method)
+			op.path = cmp.Or(op.path, path)
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// buildOpenAPI is the OpenAPI document for the routes srv registered.
+func (srv *Server) buildOpenAPI() map[string]any {
+	schemas := make(map[string]any)
+	paths := make(map[string]map[string]any)
+	for _, op := range srv.apiOperations() {
+		if paths[op.path] == nil {
+			paths[op.path] = make(map[string]any)
+		}
+		paths[op.path][strings.ToLower(op.method)] = op.openAPI(schemas)
+	}
+	doc := map[string]any{
+		"openapi": "3.0.3",
+		"info":    map[string]any{"title": srv.config.SiteTitle + " API", "version": "v1"},
+		"servers": []map[string]any{{"url": srv.config.SiteURL}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"login": map[string]any{"type": "http", "scheme": "basic"},
+			},
+		},
+	}
+	if srv.config.SiteURL == "" {
+		doc["servers"] = []map[string]any{{"url": srv.config.BasePath + "/"}}
+	}
+	return doc
+}
+
+// openAPI is the operation as an OpenAPI operation object, adding the
+// schemas it uses to schemas.
+func (op apiOperation) openAPI(schemas map[string]any) map[string]any {
+	out := map[string]any{"summary": op.summary}
+	var params []map[string]any
+	opParams := op.params
+	if op.method != "GET" && (strings.HasPrefix(op.path+"/", "/api/v1/pages/") || op.path == "/create") {
+		opParams = append(slices.Clip(opParams), idempotencyParam)
+	}
+	for _, p := range opParams {
+		param := map[string]any{"name": p.name, "in": p.in, "required": p.required, "schema": map[string]any{"type": "string"}}
+		if p.description != "" {
+			param["description"] = p.description
+		}
+		params = append(params, param)
+	}
+	if params != nil {
+		out["parameters"] = params
+	}
+	if op.request != nil {
+		out["requestBody"] = map[string]any{"required": true, "content": jsonContent(schemaFor(reflect.TypeOf(op.request), schemas))}
+	}
+
+	var success map[string]any
+	switch response := op.response.(type) {
+	case nil:
+		success = map[string]any{"description": "Sent to what was made", "headers": map[string]any{"Location": map[string]any{"schema": map[string]any{"type": "string"}}}}
+	case string:
+		success = map[string]any{"description": "Done", "content": map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}}}
+	default:
+		schema := schemaFor(reflect.TypeOf(response), schemas)
+		if op.list {
+			schema = map[string]any{
+				"type":     "object",
+				"required": []string{"items", "has_more"},
+				"properties": map[string]any{
+					"items":       map[string]any{"type": "array", "items": schema},
+					"next_cursor": map[string]any{"type": "string", "description": "Pass back as ?cursor= to carry on"},
+					"has_more":    map[string]any{"type": "boolean", "description": "More items right now, fetch again straight away"},
+				},
+			}
+		}
+		success = map[string]any{"description": "OK", "content": jsonContent(schema)}
+	}
+	failure := map[string]any{"description": "What went wrong", "content": map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}}}
+	if op.answersProblems() {
+		failure = map[string]any{"description": "What went wrong", "content": map[string]any{"application/problem+json": map[string]any{"schema": schemaFor(reflect.TypeFor[problem](), schemas)}}}
+	}
+	responses := map[string]any{
+		strconv.Itoa(cmp.Or(op.status, http.StatusOK)): success,
+		"default": failure,
+	}
+	for status, body := range op.errors {
+		responses[strconv.Itoa(status)] = map[string]any{"description": http.StatusText(status), "content": jsonContent(schemaFor(reflect.TypeOf(body), schemas))}
+	}
+	out["responses"] = responses
+	if op.login {
+		out["security"] = []map[string]any{{"login": []string{}}}
+	}
+	for key, value := range op.extra {
+		out[key] = value
+	}
+	return out
+}
+
+// answersProblems reports whether the operation's errors are problems, see
+// problems.go.
+func (op apiOperation) answersProblems() bool {
+	return strings.HasPrefix(op.path, "/api/v1/pages/") || strings.HasPrefix(op.path, "/api/v1/votes/")
+}
+
+// jsonContent is an OpenAPI content object for JSON of schema.
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// schemaFor is the JSON schema of how encoding/json writes a t. Named
+// structs go in schemas and are referred to.
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	switch t {
+	case reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeFor[template.HTML]():
+		return map[string]any{"type": "string", "format": "html"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := schemaFor(t.Elem(), schemas)
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = nil // Taken, in case it refers to itself
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// structSchema is the schema of a struct's JSON fields. Fields that are
+// always written are required.
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := range t.NumField() {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+				addFields(field.Type) // Embedded, so its fields are ours
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaFor(field.Type, schemas)
+			if !strings.Contains(options, "omitempty") && !strings.Contains(options, "omitzero") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
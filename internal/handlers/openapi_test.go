@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAPIDocsMatchRoutes checks every JSON route NewServer registers is
+// described, and everything described is a route.
+func TestAPIDocsMatchRoutes(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.PageCreation.Challenge = "pow" // Every feature with routes of its own on
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close(context.Background()) })
+
+	registered := make(map[string]bool)
+	for _, pattern := range srv.routes {
+		registered[pattern] = true
+		_, path, ok := strings.Cut(pattern, " ")
+		if !ok {
+			path = pattern
+		}
+		undocumented := path == "/api/v1/" || path == "/api/openapi.json"
+		if strings.HasPrefix(path, "/api/") && !undocumented && apiDocs[pattern] == nil {
+			t.Errorf("route %q isn't in apiDocs", pattern)
+		}
+	}
+	for pattern := range apiDocs {
+		if !registered[pattern] {
+			t.Errorf("apiDocs has %q, which isn't a route", pattern)
+		}
+	}
+}
+
+func TestOpenAPIPerServer(t *testing.T) {
+	paths := func(ts *httptest.Server) map[string]map[string]any {
+		t.Helper()
+		resp, err := ts.Client().Get(ts.URL + "/api/openapi.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var doc struct {
+			Paths map[string]map[string]any `json:"paths"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+			t.Fatal(err)
+		}
+		return doc.Paths
+	}
+
+	withComments := NewTestServer(t)
+	if doc := paths(withComments); doc["/create"]["post"] == nil || doc["/api/v1/comments"]["get"] == nil {
+		t.Errorf("document is missing /create or comments: %v", doc)
+	}
+
+	cfg := testConfig(t)
+	cfg.Features.Comments = false
+	withoutComments := startTestServer(t, cfg)
+	if doc := paths(withoutComments); doc["/api/v1/comments"] != nil {
+		t.Errorf("document of a server without comments has them")
+	}
+	// The first server's document is its own still
+	if doc := paths(withComments); doc["/api/v1/comments"] == nil {
+		t.Errorf("document lost comments once another server was set up without them")
+	}
+}
@@ -0,0 +1,450 @@
+package handlers
+
+//Holds the page creation POST to generate a new text for a page template
+//Also has how we display our pages
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-trailer/internal/slugs"
+	"go-trailer/internal/tracing"
+	"go-trailer/internal/videos"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// createPageRequest is the body of POST /create and POST /api/v1/pages.
+type createPageRequest struct {
+	Name      string   `json:"name"`
+	Draft     bool     `json:"draft"`      // Keep it to its creator and admins until published
+	Private   bool     `json:"private"`    // Keep it to its viewers, its creator and admins
+	Viewers   []string `json:"viewers"`    // Users, and @roles
+	PublishAt string   `json:"publish_at"` // Then publish it at this time, makes it a draft
+	ExpiresAt string   `json:"expires_at"` // Move it to the archive at this time
+
+	// See createguard.go
+	Website   string `json:"website"`   // The honeypot, people leave it empty
+	Challenge string `json:"challenge"` // From /api/v1/challenge, with page_creation.challenge: pow
+	Nonce     string `json:"nonce"`     // Answering it
+	Captcha   string `json:"captcha"`   // The CAPTCHA's token, with page_creation.challenge: captcha
+}
+
+// createPageHandler handles the POST request to create a new page for the pages folder
+func (srv *Server) createPageHandler(w http.ResponseWriter, r *http.Request) {
+
+	// We only accept POST requests here
+	if r.Method != http.MethodPost {
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode the JSON request body: {"name": "My New Page"}
+	var reqBody createPageRequest
+
+	if err := readJSON(w, r, &reqBody); err != nil {
+		badJSON(w, err)
+		return
+	}
+	if guardCreate(w, r, reqBody) {
+		return
+	}
+	if err := slugs.CheckName(reqBody.Name); err != nil {
+		fieldError(w, "name", "invalid_name", "Bad name found, try again. Cannot use symbols, try words only.")
+		return
+	}
+
+	if reqBody.Name == "" || reqBody.Name == " " {
+		fieldError(w, "name", "required", "Page name is required")
+		return
+	}
+	publishAt, err := parsePublishAt(reqBody.PublishAt)
+	if err != nil {
+		fieldError(w, "publish_at", "invalid_time", err.Error())
+		return
+	}
+	if !publishAt.IsZero() {
+		reqBody.Draft = true
+	}
+	expiresAt, err := parseExpiresAt(reqBody.ExpiresAt)
+	if err != nil {
+		fieldError(w, "expires_at", "invalid_time", err.Error())
+		return
+	}
+	if reqBody.Draft && !srv.plugins.HasAuth() && siteOf(r.Context()).adminPassword == "" {
+		fieldError(w, "draft", "drafts_unavailable", "Drafts need someone who can see them, set admin_password or load an auth plugin")
+		return
+	}
+	viewers, err := cleanViewers(reqBody.Viewers)
+	if err != nil {
+		fieldError(w, "viewers", "invalid_viewer", err.Error())
+		return
+	}
+	if reqBody.Private && !privacyAvailable(r) {
+		fieldError(w, "private", "private_unavailable", "Private pages need someone who can see them, set admin_password or load an auth plugin")
+		return
+	}
+
+	// --- Create the page file ---
+
+	// 1. Sanitize the name into a URL-friendly "slug"
+	slug := slugs.FromName(reqBody.Name)
+	if reservedSlug(r.Context(), slug) {
+		fieldError(w, "name", "reserved_name", "The name "+slug+" is reserved, pick another one.")
+		return
+	}
+	siteOf(r.Context()).createMu.Lock()
+	defer siteOf(r.Context()).createMu.Unlock()
+	slug, free := freeSlug(r.Context(), slug)
+	setLogSlug(r, slug)
+
+	// 2. Define the file name
+	filename := slug + ".txt"
+
+	// 3. If the page already exists (and we don't number new ones), just redirect to it.
+	if !free {
+		slog.InfoContext(r.Context(), "Page already exists, redirecting")
+		if r.URL.Path == "/api/pages" {
+			http.Redirect(w, r, sitePath(r.Context(), "/api/v1/pages/"+slug), http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, sitePath(r.Context(), "/page/"+slug), http.StatusFound)
+		return
+	}
+	if overCreateQuota(w, r) {
+		return
+	}
+
+	// 4. Create the new file with default content. Drafts and private pages
+	// are marked as such first, so they're never listed even for a moment
+	if reqBody.Private {
+		if err := markPrivate(r.Context(), slug, viewers); err != nil {
+			if quotaError(w, err) {
+				return
+			}
+			slog.ErrorContext(r.Context(), "Error writing page meta", "err", err)
+			apiError(w, "Could not save page", http.StatusInternalServerError)
+			return
+		}
+	}
+	if reqBody.Draft {
+		if err := markDraft(r.Context(), slug, publishAt); err != nil {
+			if quotaError(w, err) {
+				return
+			}
+			slog.ErrorContext(r.Context(), "Error writing page meta", "err", err)
+			apiError(w, "Could not save page", http.StatusInternalServerError)
+			return
+		}
+	}
+	err = storeCtx(r.Context()).WriteFile(filename, []byte(defaultPageBody(reqBody.Name)))
+	if err != nil {
+		if quotaError(w, err) {
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error writing new page file", "err", err)
+		apiError(w, "Could not save page", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "New page created", "file", filename)
+	if err := recordPageCreated(r.Context(), slug, reqBody.Name, editorName(r), expiresAt); err != nil {
+		slog.WarnContext(r.Context(), "Error saving when the page was created", "err", err)
+	}
+	if !reqBody.Draft && !reqBody.Private {
+		queueSearchPing(r.Context(), slug)
+		purgePage(r.Context(), slug)
+		purgeListings(r.Context())
+		publishPageEvent(r.Context(), "page-created", slug)
+	}
+
+	// 5. Redirect the user to their new page, or give the API the page itself
+	if r.URL.Path == "/api/pages" {
+		page, err := loadPage(r.Context(), slug)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error loading new page", "err", err)
+			apiError(w, "Page created, but could not be read back", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Location", sitePath(r.Context(), "/api/v1/pages/"+slug))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newPageJSON(r, page))
+		return
+	}
+	http.Redirect(w, r, sitePath(r.Context(), "/page/"+slug), http.StatusSeeOther)
+}
+
+// defaultPageBody is the text a new page called name starts out with.
+func defaultPageBody(name string) string {
+	return "This is the new page for **" + name + "**"
+}
+
+// pageViewHandler serves a single page (page.html), plus the actions hanging
+// off it like /page/my-page/export
+func (srv *Server) pageViewHandler(w http.ResponseWriter, r *http.Request) {
+	servePage(w, r, "/page/")
+}
+
+// servePage serves a page under prefix, /page/ or /archive/ for archived
+// pages. Pages asked for under the wrong one are sent to the right one.
+func servePage(w http.ResponseWriter, r *http.Request, prefix string) {
+	config := configOf(r.Context())
+	// Extract the page title (slug) from the URL
+	// r.URL.Path will be "/page/my-new-page" or "/page/my-new-page/export"
+	slug, action, _ := strings.Cut(r.URL.Path[len(prefix):], "/")
+
+	// Security: Use filepath.Base to prevent directory traversal attacks
+	// e.g., prevents a request like /page/../../etc/passwd
+	safeSlug := filepath.Base(slug)
+	setLogSlug(r, safeSlug)
+
+	pageData, err := loadPage(r.Context(), safeSlug)
+	if err == nil && (pageData.Draft || pageData.Private) {
+		if !pageAllowed(w, r, safeSlug) {
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-store") // Never keep a draft or private page in the CDN
+	}
+	if err == nil && pageData.Archived != (prefix == "/archive/") {
+		redirectToPage(w, r, pageData.path(), action, http.StatusFound) // Not permanent, it may come back out
+		return
+	}
+	if err != nil {
+		// It may have been renamed
+		if target, ok := redirectTarget(r.Context(), safeSlug); ok {
+			redirectToPage(w, r, prefix+pagePath(target)[len("/page/"):], action, http.StatusMovedPermanently)
+			return
+		}
+		// Maybe it's there under its proper slug, e.g. /page/MyPage for my-page
+		if canonical, ok := canonicalSlug(r.Context(), safeSlug); ok {
+			redirectToPage(w, r, prefix+pagePath(canonical)[len("/page/"):], action, http.StatusMovedPermanently)
+			return
+		}
+		// If the file doesn't exist, send a 404
+		slog.InfoContext(r.Context(), "Page not found")
+		http.NotFound(w, r)
+		return
+	}
+
+	switch action {
+	case "":
+		w.Header().Add("Vary", "Accept") // HTML or JSON, see pagejson.go
+		if wantsJSON(r) {
+			pageJSONHandler(w, r, pageData)
+			return
+		}
+		var comments CommentList
+		if config.Features.Comments {
+			all, err := loadComments(r.Context(), safeSlug)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error reading comments", "err", err) // Still show the page
+			}
+			comments = approvedComments(all, commentPageNumber(r))
+		}
+
+		// Execute the 'page.html' template
+		var html bytes.Buffer
+		_, span := tracing.Start(r.Context(), "render page.html")
+		err = renderTemplate(r.Context(), &html, "page.html", buildPageView(r, pageData, comments))
+		tracing.End(span, err)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error executing page template", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		serveVersioned(w, r, "text/html; charset=utf-8", pageLastModified(r.Context(), safeSlug), html.Bytes())
+		if r.Method == http.MethodGet {
+			recordPageView(r.Context(), safeSlug)
+		}
+	case "export":
+		if !config.Features.Export {
+			http.NotFound(w, r)
+			return
+		}
+		exportPageHandler(w, r, pageData)
+	case "raw":
+		rawPageHandler(w, r, pageData)
+	case "backlinks":
+		backlinksHandler(w, r, pageData)
+	case "votes":
+		liveVotesHandler(w, r, pageData)
+	default:
+		if kind, _, _ := strings.Cut(action, "/"); kind == "files" || kind == "thumbs" {
+			serveAttachment(w, r, pageData, action)
+			return
+		}
+		http.NotFound(w, r)
+	}
+}
+
+// redirectToPage sends the request on to the page at path, keeping the
+// action and query string.
+func redirectToPage(w http.ResponseWriter, r *http.Request, path, action string, code int) {
+	target := path
+	if action != "" {
+		target += "/" + action
+	}
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, sitePath(r.Context(), target), code)
+}
+
+// path is where the page is served.
+func (p *Page) path() string {
+	if p.Archived {
+		return archivePath(p.Slug)
+	}
+	return pagePath(p.Slug)
+}
+
+// loadPage reads a page and its videos (with votes) from the store.
+func loadPage(ctx context.Context, safeSlug string) (page *Page, err error) {
+	ctx, span := tracing.Start(ctx, "loadPage", attribute.String("slug", safeSlug))
+	defer func() { tracing.End(span, err) }()
+	pages := storeCtx(ctx)
+
+	// Load the page content from the file, and split off its front matter
+	text, err := pages.ReadFile(safeSlug + ".txt")
+	if err != nil {
+		return nil, err
+	}
+	fm, body, err := splitFrontMatter(string(text))
+	if err != nil {
+		slog.Error("Error reading page front matter", "page", safeSlug, "err", err)
+	}
+	date, err := fm.date()
+	if err != nil {
+		slog.Error("Error reading page front matter", "page", safeSlug, "err", err)
+	}
+
+	// Page settings are optional, but a broken meta file keeps the page to
+	// admins, it may have been private
+	meta := accessMeta(ctx, safeSlug)
+	embed := withPageEmbed(configOf(ctx).YouTubeEmbed, meta.Embed)
+
+	// 1. Read the optional YouTube link file
+	youtubeURLs, err := pages.ReadFile(safeSlug + ".youtube.txt")
+	var pageVideos []YouTubeVideo
+	if err == nil { // File exists
+		// Split the file content by newline to get individual URLs
+		urls := strings.Split(string(youtubeURLs), "\n")
+		for i, url := range urls {
+			if url != "" { // Ignore empty lines
+				_, videoID := videos.Parse(url)
+				if videoID != "" {
+					pageVideos = append(pageVideos, YouTubeVideo{ID: videoID, URL: embedURL(embed, videoID), Votes: 0, Position: i})
+				}
+			}
+		}
+	}
+
+	// Read the votes file and apply votes to the videos
+	votesData, err := pages.ReadFile(safeSlug + ".votes.json")
+	if err == nil {
+		var votes map[string]int
+		if err := json.Unmarshal(votesData, &votes); err == nil {
+			for i := range pageVideos {
+				pageVideos[i].Votes = votes[pageVideos[i].ID]
+			}
+		}
+	}
+
+	// Sort videos by vote count in descending order
+	sort.Slice(pageVideos, func(i, j int) bool {
+		return pageVideos[i].Votes > pageVideos[j].Votes
+	})
+
+	// 2. Create a Page struct with the data, letting content plugins have a go at the body
+	page = &Page{
+		Title:        cmp.Or(fm.Title, meta.Title, safeSlug), // Pages from before titles were kept just have their slug
+		Slug:         safeSlug,
+		Body:         serverOf(ctx).plugins.ProcessContent(safeSlug, body),
+		YouTubeEmbed: pageVideos, // Will be nil if no links are found
+		Tags:         fm.Tags,
+		Author:       cmp.Or(fm.Author, meta.CreatedBy),
+		Date:         date,
+		Math:         fm.Math,
+		CreatedAt:    pageCreated(ctx, safeSlug),
+		UpdatedAt:    meta.Updated,
+		UpdatedBy:    meta.UpdatedBy,
+		Draft:        meta.hidden(time.Now()),
+		Private:      meta.Private,
+		Archived:     meta.archived(time.Now()),
+	}
+	if page.UpdatedAt.IsZero() {
+		page.UpdatedAt, _ = pages.ModTime(safeSlug + ".txt") // Last changed before we kept track
+	}
+
+	renderPage(ctx, page)
+
+	// 3. Fill in what search engines and link previews show
+	page.Description = cmp.Or(fm.Description, excerpt(page.Body, 160))
+	if len(pageVideos) > 0 {
+		page.ImageURL = videos.ThumbnailURL(pageVideos[0].ID)
+	}
+	return page, nil
+}
+
+// pagePath is where a page is served, escaped for slugs that aren't ASCII.
+func pagePath(slug string) string {
+	return "/page/" + url.PathEscape(slug)
+}
+
+// canonicalSlug finds the page someone meant by a slug that isn't quite
+// right, like MyPage, My_Page or my%20page for my-page.
+func canonicalSlug(ctx context.Context, slug string) (string, bool) {
+	for _, candidate := range slugs.Guesses(slug) {
+		if candidate != slug && pageExists(ctx, candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// reservedSlug reports whether slug is one pages can't have, because it
+// looks like one of our routes or one of a page's other files.
+func reservedSlug(ctx context.Context, slug string) bool {
+	for _, pattern := range configOf(ctx).Slugs.Reserved {
+		if ok, _ := path.Match(pattern, slug); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// freeSlug picks the slug for a new page. If slug is taken it returns slug
+// and false when we redirect to existing pages, otherwise the first of
+// slug-2, slug-3... that's free. Callers must hold createMu.
+func freeSlug(ctx context.Context, slug string) (string, bool) {
+	if !pageExists(ctx, slug) {
+		return slug, true
+	}
+	if configOf(ctx).Slugs.OnCollision != "suffix" {
+		return slug, false
+	}
+	for n := 2; ; n++ {
+		candidate := slug + "-" + strconv.Itoa(n)
+		if !pageExists(ctx, candidate) {
+			return candidate, true
+		}
+	}
+}
+
+// pageExists reports whether there's a page with this slug.
+func pageExists(ctx context.Context, slug string) bool {
+	_, err := storeCtx(ctx).ModTime(slug + ".txt")
+	return err == nil
+}
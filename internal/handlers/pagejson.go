@@ -0,0 +1,113 @@
+package handlers
+
+//Pages as JSON, for apps that show them their own way. /page/{slug} answers
+//with JSON instead of page.html when asked for it with Accept:
+//application/json, so the same URL works for people and programs, and
+///api/v1/pages/{slug} always does.
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pageJSON is a page as /page/{slug} sends it for Accept: application/json.
+type pageJSON struct {
+	Slug        string        `json:"slug"`
+	Title       string        `json:"title"`
+	URL         string        `json:"url"`
+	Body        string        `json:"body"` // As it was written
+	HTML        template.HTML `json:"html"` // As page.html shows it
+	Description string        `json:"description,omitempty"`
+	Tags        []string      `json:"tags"`
+	Author      string        `json:"author,omitempty"`
+	Date        time.Time     `json:"date,omitzero"`
+	Draft       bool          `json:"draft,omitempty"`
+	Private     bool          `json:"private,omitempty"`
+	Archived    bool          `json:"archived,omitempty"`
+	Created     time.Time     `json:"created,omitzero"`
+	Updated     time.Time     `json:"updated,omitzero"`
+	UpdatedBy   string        `json:"updated_by,omitempty"`
+	Videos      []videoJSON   `json:"videos"` // Most voted first, as on the page
+}
+
+// videoJSON is one of a page's videos in a pageJSON.
+type videoJSON struct {
+	ID       string `json:"id"`
+	WatchURL string `json:"watch_url"`
+	EmbedURL string `json:"embed_url"`
+	Votes    int    `json:"votes"`
+}
+
+// wantsJSON reports whether the client asked for JSON rather than HTML.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// pageJSONHandler sends a page as JSON, with an ETag like the HTML one.
+func pageJSONHandler(w http.ResponseWriter, r *http.Request, page *Page) {
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(newPageJSON(r, page))
+	serveVersioned(w, r, "application/json", pageLastModified(r.Context(), page.Slug), body.Bytes())
+}
+
+// newPageJSON is page as the JSON API shows it.
+func newPageJSON(r *http.Request, page *Page) pageJSON {
+	data := pageJSON{
+		Slug:        page.Slug,
+		Title:       page.Title,
+		URL:         siteBaseURL(r) + page.path(),
+		Body:        page.Body,
+		HTML:        page.HTML,
+		Description: page.Description,
+		Tags:        page.Tags,
+		Author:      page.Author,
+		Date:        page.Date,
+		Draft:       page.Draft,
+		Private:     page.Private,
+		Archived:    page.Archived,
+		Created:     page.CreatedAt,
+		Updated:     page.UpdatedAt,
+		UpdatedBy:   page.UpdatedBy,
+		Videos:      []videoJSON{}, // [] rather than null
+	}
+	if data.Tags == nil {
+		data.Tags = []string{}
+	}
+	for _, video := range page.YouTubeEmbed {
+		data.Videos = append(data.Videos, videoJSON{
+			ID:       video.ID,
+			WatchURL: "https://www.youtube.com/watch?v=" + video.ID,
+			EmbedURL: video.URL,
+			Votes:    video.Votes,
+		})
+	}
+	return data
+}
+
+// pageAPIHandler handles GET /api/page/{slug}, the page as JSON. Pages that
+// were renamed redirect to where they are now.
+func (srv *Server) pageAPIHandler(w http.ResponseWriter, r *http.Request) {
+	slug := filepath.Base(r.PathValue("slug"))
+	setLogSlug(r, slug)
+	page, err := loadPage(r.Context(), slug)
+	if err != nil {
+		if target, ok := redirectTarget(r.Context(), slug); ok {
+			http.Redirect(w, r, sitePath(r.Context(), "/api/v1/pages/"+target), http.StatusMovedPermanently)
+			return
+		}
+		apiError(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	if page.Draft || page.Private {
+		if restrictedPageError(w, r, accessMeta(r.Context(), slug)) {
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+	pageJSONHandler(w, r, page)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+//Per-page settings that aren't part of the page text. They live next to the
+//page in {slug}.meta.json, and a page without one just uses the site defaults.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// PageMeta is everything kept in a page's meta file.
+type PageMeta struct {
+	Embed     *pageEmbedSettings `json:"embed,omitempty"`      // Overrides the site's youtube_embed settings
+	Created   time.Time          `json:"created,omitzero"`     // When the page was created
+	CreatedBy string             `json:"created_by,omitempty"` // Who created it, if they were logged in
+	Updated   time.Time          `json:"updated,omitzero"`     // When it was last changed through the site
+	UpdatedBy string             `json:"updated_by,omitempty"`
+	Title     string             `json:"title,omitempty"`     // The name it was created with
+	Draft     bool               `json:"draft,omitempty"`     // Only its creator and admins can see it
+	PublishAt time.Time          `json:"publish_at,omitzero"` // When a draft publishes itself
+	Published time.Time          `json:"published,omitzero"`  // When it was last published, if it was ever a draft
+	ExpiresAt time.Time          `json:"expires_at,omitzero"` // When it moves to the archive
+	Archived  time.Time          `json:"archived,omitzero"`   // When it did
+	Flagged   string             `json:"flagged,omitempty"`   // Why moderation flagged it for an admin to look at
+	Private   bool               `json:"private,omitempty"`   // Only its viewers, its creator and admins can see it
+	Viewers   []string           `json:"viewers,omitempty"`   // Users, and @roles, who can see it if it's private
+}
+
+// loadPageMeta reads a page's settings. Having none is fine.
+func loadPageMeta(ctx context.Context, slug string) (PageMeta, error) {
+	var meta PageMeta
+	data, err := storeCtx(ctx).ReadFile(slug + ".meta.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, err
+}
+
+// pageTitle is what a page is called: the title in its front matter, or the
+// name it was created with. Pages from before titles were kept just go by
+// their slug.
+func pageTitle(ctx context.Context, slug string) string {
+	if fm, _, err := readPageText(ctx, slug); err == nil && fm.Title != "" {
+		return fm.Title
+	}
+	meta, err := loadPageMeta(ctx, slug)
+	if err != nil || meta.Title == "" {
+		return slug
+	}
+	return meta.Title
+}
+
+// recordPageCreated notes in a new page's meta file that it was created now
+// by editor, the name it was asked for, and when it expires.
+func recordPageCreated(ctx context.Context, slug, title, editor string, expiresAt time.Time) error {
+	siteOf(ctx).pageMetaMu.Lock()
+	defer siteOf(ctx).pageMetaMu.Unlock()
+	meta, err := loadPageMeta(ctx, slug)
+	if err != nil {
+		return err
+	}
+	meta.Created, meta.CreatedBy = time.Now().UTC(), editor
+	meta.Updated, meta.UpdatedBy = meta.Created, editor
+	meta.Title = title
+	meta.ExpiresAt = expiresAt
+	return savePageMeta(ctx, slug, meta)
+}
+
+// recordPageEdit notes in a page's meta file that editor changed it just now.
+func recordPageEdit(ctx context.Context, slug, editor string) error {
+	siteOf(ctx).pageMetaMu.Lock()
+	defer siteOf(ctx).pageMetaMu.Unlock()
+	meta, err := loadPageMeta(ctx, slug)
+	if err != nil {
+		return err
+	}
+	meta.edited(editor)
+	return savePageMeta(ctx, slug, meta)
+}
+
+// publishedAt is when the page went public, if it was ever a draft: by hand,
+// or by its publish_at passing. Zero for pages that are hidden or never were.
+func (m PageMeta) publishedAt(now time.Time) time.Time {
+	switch {
+	case m.hidden(now):
+		return time.Time{}
+	case m.Draft:
+		return m.PublishAt // Due, but the scheduler hasn't got to it yet
+	}
+	return m.Published
+}
+
+// edited marks the meta as changed by editor just now.
+func (m *PageMeta) edited(editor string) {
+	m.Updated, m.UpdatedBy = time.Now().UTC(), editor
+}
+
+// savePageMeta writes a page's settings back. Callers must hold pageMetaMu.
+func savePageMeta(ctx context.Context, slug string, meta PageMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return storeCtx(ctx).WriteFile(slug+".meta.json", data)
+}
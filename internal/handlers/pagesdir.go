@@ -0,0 +1,128 @@
+package handlers
+
+//Keeps an eye on the pages directory. If it goes missing or stops being
+//readable/writable (unmounted disk, bad permissions) we serve a status page
+//instead of erroring on every request, and keep trying to get it back.
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often we check the directory while it's fine.
+const pagesDirCheckInterval = 10 * time.Second
+
+// While it's broken we retry sooner, backing off up to this long.
+const maxPagesDirRetry = time.Minute
+
+// pagesDirState is the last thing a Server found out about its pages
+// directory.
+type pagesDirState struct {
+	sync.RWMutex
+	err   error // nil while usable
+	since time.Time
+}
+
+// Paths that keep working without the pages directory.
+var pagesIndependentPaths = []string{"/static/", "/robots.txt", "/healthz", "/readyz"}
+
+// pagesDirAvailable reports whether the pages directory was usable last we checked.
+func (srv *Server) pagesDirAvailable() bool {
+	srv.pagesDirState.RLock()
+	defer srv.pagesDirState.RUnlock()
+	return srv.pagesDirState.err == nil
+}
+
+// checkPagesDir makes sure dir exists (creating it if need be), can be listed,
+// and can be written to.
+func checkPagesDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if _, err := os.ReadDir(dir); err != nil {
+		return err
+	}
+	probe, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// updatePagesDirState records a check result, logging when things change.
+func (srv *Server) updatePagesDirState(dir string, err error) {
+	pagesDirState := &srv.pagesDirState
+	pagesDirState.Lock()
+	defer pagesDirState.Unlock()
+
+	wasOK := pagesDirState.err == nil
+	switch {
+	case wasOK && err != nil:
+		slog.Error("Pages directory is unavailable, serving status page", "dir", dir, "err", err)
+		pagesDirState.since = time.Now()
+	case !wasOK && err == nil:
+		slog.Info("Pages directory is available again", "dir", dir)
+		pagesDirState.since = time.Now()
+	}
+	pagesDirState.err = err
+}
+
+// watchPagesDir checks dir until ctx is cancelled, retrying with backoff
+// while it's unavailable.
+func (srv *Server) watchPagesDir(ctx context.Context, dir string) {
+	retry := time.Second
+	for {
+		wait := pagesDirCheckInterval
+		err := checkPagesDir(dir)
+		srv.updatePagesDirState(dir, err)
+		if err != nil {
+			wait = retry
+			retry = min(retry*2, maxPagesDirRetry)
+		} else {
+			retry = time.Second
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// degradeWithoutPages answers requests that need the pages directory with a
+// 503 status page while it's unavailable. Everything else goes through.
+func (srv *Server) degradeWithoutPages(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only the main site's pages directory is watched
+		if srv.pagesDirAvailable() || !siteOf(r.Context()).main || isPagesIndependent(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", "30")
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			http.Error(w, "Pages are temporarily unavailable, try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if err := renderTemplate(r.Context(), w, "unavailable.html", srv.buildUnavailableView()); err != nil {
+			slog.ErrorContext(r.Context(), "Error executing unavailable template", "err", err)
+		}
+	})
+}
+
+func isPagesIndependent(path string) bool {
+	for _, prefix := range pagesIndependentPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
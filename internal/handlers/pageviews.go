@@ -0,0 +1,270 @@
+package handlers
+
+//How often each page is viewed, for the list of popular pages. Counts are
+//kept in memory and written to view-counts.json in each site's store every
+//so often, like the search stats, so a busy page doesn't mean a write per
+//view.
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// View counts are saved this often, and this many pages make the popular list.
+const (
+	viewCountsFile         = "view-counts.json"
+	viewCountsSaveInterval = time.Minute
+	defaultPopularLimit    = 20
+	maxPopularLimit        = 100
+)
+
+// pageViews is how many times each of a site's pages was viewed, by slug.
+type pageViews struct {
+	counts map[string]int64
+	dirty  bool // Changed since we last saved
+}
+
+// viewCounts is a site's view counts, read from its store the first time
+// they're needed.
+type viewCounts struct {
+	sync.Mutex
+	views *pageViews // Nil until then
+}
+
+// PopularPage is a page in the popular list.
+type PopularPage struct {
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	Views int64  `json:"views"`
+}
+
+// siteViews is the site's view counts. Callers must hold their lock.
+func siteViews(ctx context.Context) (*pageViews, error) {
+	viewCounts := &siteOf(ctx).viewCounts
+	if viewCounts.views != nil {
+		return viewCounts.views, nil
+	}
+	views, err := readViewCounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	viewCounts.views = views
+	return views, nil
+}
+
+// readViewCounts reads the counts the site last saved.
+func readViewCounts(ctx context.Context) (*pageViews, error) {
+	views := &pageViews{counts: make(map[string]int64)}
+	data, err := storeCtx(ctx).ReadFile(viewCountsFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &views.counts); err != nil {
+			return nil, fmt.Errorf("%s: %w", viewCountsFile, err)
+		}
+	}
+	return views, nil
+}
+
+// changeViewCounts calls change with the site's counts, marking them to be
+// saved if it reports a change. If they can't be read the view isn't
+// counted, rather than saving over what's there.
+func changeViewCounts(ctx context.Context, change func(counts map[string]int64) bool) {
+	viewCounts := &siteOf(ctx).viewCounts
+	viewCounts.Lock()
+	defer viewCounts.Unlock()
+	views, err := siteViews(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading view counts", "err", err)
+		return
+	}
+	if change(views.counts) {
+		views.dirty = true
+	}
+}
+
+// recordPageView counts a view of a page.
+func recordPageView(ctx context.Context, slug string) {
+	changeViewCounts(ctx, func(counts map[string]int64) bool {
+		counts[slug]++
+		return true
+	})
+}
+
+// moveViewCount carries a renamed page's views over to its new slug.
+func moveViewCount(ctx context.Context, from, to string) {
+	changeViewCounts(ctx, func(counts map[string]int64) bool {
+		n, ok := counts[from]
+		if ok {
+			counts[to] += n
+			delete(counts, from)
+		}
+		return ok
+	})
+}
+
+// forgetViewCount drops a deleted page's views, returning how many it had.
+func forgetViewCount(ctx context.Context, slug string) int64 {
+	var n int64
+	changeViewCounts(ctx, func(counts map[string]int64) bool {
+		var ok bool
+		if n, ok = counts[slug]; ok {
+			delete(counts, slug)
+		}
+		return ok
+	})
+	return n
+}
+
+// restoreViewCount gives a page restored from the trash its views back.
+func restoreViewCount(ctx context.Context, slug string, n int64) {
+	if n == 0 {
+		return
+	}
+	changeViewCounts(ctx, func(counts map[string]int64) bool {
+		counts[slug] += n
+		return true
+	})
+}
+
+// viewCountsOf is a copy of the site's counts, by slug.
+func viewCountsOf(ctx context.Context) (map[string]int64, error) {
+	viewCounts := &siteOf(ctx).viewCounts
+	viewCounts.Lock()
+	defer viewCounts.Unlock()
+	views, err := siteViews(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return maps.Clone(views.counts), nil
+}
+
+// loadViewCounts picks up the counts the site saved on the last run, in
+// place of any we had.
+func loadViewCounts(ctx context.Context) error {
+	views, err := readViewCounts(ctx)
+	if err != nil {
+		return err
+	}
+	viewCounts := &siteOf(ctx).viewCounts
+	viewCounts.Lock()
+	defer viewCounts.Unlock()
+	viewCounts.views = views
+	return nil
+}
+
+// saveViewCounts writes the site's counts out if anything changed.
+func saveViewCounts(ctx context.Context) error {
+	viewCounts := &siteOf(ctx).viewCounts
+	viewCounts.Lock()
+	views := viewCounts.views
+	if views == nil || !views.dirty {
+		viewCounts.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(views.counts)
+	views.dirty = false
+	viewCounts.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return storeCtx(ctx).WriteFile(viewCountsFile, data)
+}
+
+// saveAllViewCounts saves every site's counts that changed.
+func (srv *Server) saveAllViewCounts(ctx context.Context) {
+	for _, s := range srv.servedSites() {
+		if err := saveViewCounts(context.WithValue(ctx, siteKey{}, s)); err != nil {
+			slog.Error("Error saving view counts", "site", s.title, "err", err)
+		}
+	}
+}
+
+// runViewCountsSaver saves the view counts every so often, and one last time
+// when ctx is cancelled.
+func (srv *Server) runViewCountsSaver(ctx context.Context) {
+	ticker := time.NewTicker(viewCountsSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			srv.saveAllViewCounts(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+			if srv.readOnly() {
+				continue // Kept until we're writable again
+			}
+			srv.saveAllViewCounts(ctx)
+		}
+	}
+}
+
+// popularPages is the most viewed pages that still exist, most viewed first.
+func popularPages(r *http.Request, limit int) []PopularPage {
+	counts, err := viewCountsOf(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading view counts", "err", err)
+	}
+	pages := make([]PopularPage, 0, len(counts))
+	for slug, n := range counts {
+		pages = append(pages, PopularPage{Slug: slug, Views: n})
+	}
+
+	slices.SortFunc(pages, func(a, b PopularPage) int {
+		return cmp.Or(cmp.Compare(b.Views, a.Views), strings.Compare(a.Slug, b.Slug))
+	})
+	base := siteBaseURL(r)
+	popular := make([]PopularPage, 0, limit)
+	for _, p := range pages {
+		if len(popular) == limit {
+			break
+		}
+		if !pageExists(r.Context(), p.Slug) || isRestricted(r.Context(), p.Slug) {
+			continue // Removed or unpublished since
+		}
+		p.Title = pageTitle(r.Context(), p.Slug)
+		p.URL = base + pagePath(p.Slug)
+		popular = append(popular, p)
+	}
+	return popular
+}
+
+// popularLimit reads ?limit=, how many pages to list.
+func popularLimit(r *http.Request) int {
+	n, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || n < 1 {
+		return defaultPopularLimit
+	}
+	return min(n, maxPopularLimit)
+}
+
+// popularHandler serves /popular, the most viewed pages.
+func (srv *Server) popularHandler(w http.ResponseWriter, r *http.Request) {
+	view := buildPopularView(popularPages(r, popularLimit(r)))
+	if err := renderTemplate(r.Context(), w, "popular.html", view); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing popular template", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// popularAPIHandler serves /api/popular?limit=N, the most viewed pages as
+// JSON: [{"slug": "...", "title": "...", "url": "...", "views": 42}, ...].
+func (srv *Server) popularAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(popularPages(r, popularLimit(r)))
+}
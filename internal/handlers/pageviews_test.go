@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-trailer/internal/config"
+)
+
+func TestViewCountsPerSite(t *testing.T) {
+	cfg := testConfig(t)
+	otherDir := t.TempDir()
+	cfg.Sites = []config.SiteSettings{{Hosts: []string{"other.test"}, PagesDir: otherDir}}
+	// Each site's counts are saved in its own pages directory once the
+	// server has stopped
+	t.Cleanup(func() {
+		for dir, want := range map[string]string{cfg.PagesDir: `{"home":2}`, otherDir: `{"home":4}`} {
+			data, err := os.ReadFile(filepath.Join(dir, viewCountsFile))
+			if err != nil || string(data) != want {
+				t.Errorf("%s = %s, %v; want %s", viewCountsFile, data, err, want)
+			}
+		}
+	})
+	ts := startTestServer(t, cfg)
+
+	do := func(host, method, path, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = host
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		wantStatus(t, resp, http.StatusOK)
+		return resp
+	}
+	popular := func(host string) []PopularPage {
+		t.Helper()
+		var pages []PopularPage
+		if err := json.NewDecoder(do(host, "GET", "/api/popular", "").Body).Decode(&pages); err != nil {
+			t.Fatal(err)
+		}
+		return pages
+	}
+
+	for _, host := range []string{"main.test", "other.test"} {
+		do(host, "POST", "/create", `{"name": "Home"}`)
+	}
+	for range 3 {
+		do("other.test", "GET", "/page/home", "")
+	}
+	do("main.test", "GET", "/page/home", "")
+
+	// Creating a page leads to it, which is a view too
+	if got := popular("main.test"); len(got) != 1 || got[0].Views != 2 {
+		t.Errorf("main site's popular pages = %+v, want home with 2 views", got)
+	}
+	if got := popular("other.test"); len(got) != 1 || got[0].Views != 4 {
+		t.Errorf("other site's popular pages = %+v, want home with 4 views", got)
+	}
+	do("other.test", "GET", "/popular", "")
+}
@@ -0,0 +1,176 @@
+package handlers
+
+//Just enough PDF to print a page: its title, its text and its videos, in
+//Helvetica on A4, one line after another. Written by hand so we need no PDF
+//library; anything fancier is what the HTML export and a browser's "Save as
+//PDF" are for. Text is in the PDF's built-in fonts, so characters they don't
+//have come out as ?.
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// A4 in points, and where text goes on it.
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 56
+)
+
+// pdfLine is a line of text and the font it's in.
+type pdfLine struct {
+	text string
+	font string  // "F1" for Helvetica, "F2" for Helvetica-Bold
+	size float64 // In points
+	y    float64 // Where its baseline is, down from the top margin
+}
+
+// pdfWriter lays lines out on pages and writes them as a PDF.
+type pdfWriter struct {
+	pages [][]pdfLine
+	y     float64 // Where the next line goes on the last page, from the top
+}
+
+// line adds text in font, wrapped to fit the page, then gap points of space.
+func (p *pdfWriter) line(text, font string, size, gap float64) {
+	// Helvetica averages about half its size wide, a bit more to be safe
+	perLine := int((pdfPageWidth - 2*pdfMargin) / (size * 0.55))
+	for _, wrapped := range wrapText(text, perLine) {
+		if p.pages == nil || p.y+size > pdfPageHeight-2*pdfMargin {
+			p.pages = append(p.pages, nil)
+			p.y = 0
+		}
+		last := len(p.pages) - 1
+		p.pages[last] = append(p.pages[last], pdfLine{text: wrapped, font: font, size: size, y: p.y + size})
+		p.y += size * 1.3
+	}
+	p.y += gap
+}
+
+// wrapText splits text into lines of at most width characters, on spaces
+// where it can. An empty text is one empty line.
+func wrapText(text string, width int) []string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return []string{""}
+	}
+	var lines []string
+	current := ""
+	for _, word := range words {
+		for len([]rune(word)) > width { // Too long for a line of its own
+			if current != "" {
+				lines = append(lines, current)
+				current = ""
+			}
+			runes := []rune(word)
+			lines = append(lines, string(runes[:width]))
+			word = string(runes[width:])
+		}
+		switch {
+		case current == "":
+			current = word
+		case len([]rune(current))+1+len([]rune(word)) <= width:
+			current += " " + word
+		default:
+			lines = append(lines, current)
+			current = word
+		}
+	}
+	return append(lines, current)
+}
+
+// bytes is the finished PDF.
+func (p *pdfWriter) bytes() []byte {
+	if p.pages == nil {
+		p.pages = [][]pdfLine{nil}
+	}
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// 1 is the catalog, 2 the page tree, 3 and 4 the fonts, then a page and
+	// its content for each page
+	kids := make([]string, len(p.pages))
+	for i := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, lines := range p.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+2*i))
+		var content strings.Builder
+		content.WriteString("BT\n")
+		for _, line := range lines {
+			fmt.Fprintf(&content, "1 0 0 1 %d %.2f Tm /%s %.1f Tf (%s) Tj\n",
+				pdfMargin, pdfPageHeight-pdfMargin-line.y, line.font, line.size, pdfString(line.text))
+		}
+		content.WriteString("ET")
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// pdfString is text as the inside of a PDF string, in the fonts' encoding.
+func pdfString(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		c, ok := charmap.Windows1252.EncodeRune(r)
+		switch {
+		case !ok:
+			b.WriteByte('?')
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ':
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// pagePDF is a page as a PDF: its title, its text, then its videos with
+// their votes.
+func pagePDF(page *Page) []byte {
+	var p pdfWriter
+	p.line(page.Title, "F2", 18, 10)
+	blank := false
+	for _, line := range strings.Split(htmlText(string(page.HTML)), "\n") {
+		if strings.TrimSpace(line) == "" {
+			if !blank {
+				p.line("", "F1", 11, 0)
+			}
+			blank = true
+			continue
+		}
+		blank = false
+		p.line(line, "F1", 11, 0)
+	}
+	if len(page.YouTubeEmbed) > 0 {
+		p.line("", "F1", 11, 0)
+		p.line("Videos", "F2", 14, 4)
+		for _, video := range page.YouTubeEmbed {
+			p.line(fmt.Sprintf("https://www.youtube.com/watch?v=%s (%d votes)", video.ID, video.Votes), "F1", 11, 0)
+		}
+	}
+	return p.bytes()
+}
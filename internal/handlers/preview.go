@@ -0,0 +1,65 @@
+package handlers
+
+//Previews for the editor: the text of a page rendered exactly as it would be
+//once saved, front matter, shortcodes, sanitizing and all, without saving it.
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+)
+
+// previewResponse is the body of /api/preview. Math and Diagrams say whether
+// KaTeX and Mermaid need loading to draw it.
+type previewResponse struct {
+	HTML     string     `json:"html"`
+	TOC      []TOCEntry `json:"toc"`
+	Math     bool       `json:"math"`
+	Diagrams bool       `json:"diagrams"`
+}
+
+// previewRequest is the body of POST /api/preview.
+type previewRequest struct {
+	Body string `json:"body"`
+	Slug string `json:"slug"`
+}
+
+// previewHandler handles POST /api/preview with a JSON body of
+// {"body": "the page text", "slug": "my-page"}. The slug is optional, and
+// is the page being edited, for shortcodes that use its settings.
+func (srv *Server) previewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	var reqBody previewRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxPageBytes)
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	fm, body, err := splitFrontMatter(reqBody.Body)
+	if err != nil {
+		slog.InfoContext(r.Context(), "Previewing a page with broken front matter", "err", err) // Rendered without it, as it would be
+	}
+	slug := reqBody.Slug
+	if slug != "" {
+		slug = filepath.Base(slug)
+	}
+	page := &Page{Slug: slug, Body: serverOf(r.Context()).plugins.ProcessContent(slug, body), Math: fm.Math}
+	renderPage(r.Context(), page)
+	if page.TOC == nil {
+		page.TOC = []TOCEntry{} // [] rather than null
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(previewResponse{
+		HTML:     string(page.HTML),
+		TOC:      page.TOC,
+		Math:     page.Math,
+		Diagrams: page.Diagrams,
+	})
+}
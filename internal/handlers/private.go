@@ -0,0 +1,220 @@
+package handlers
+
+//Private pages: only the users on a page's viewers list can see it, besides
+//admins and whoever created it. The list can name roles too, as @name, a
+//role being a group of users from roles: in config.yaml. Like drafts,
+//private pages are left out of the homepage, feeds, search, the sitemap, the
+//list APIs and /events; asking for one gets a 401 to log in, then a 403 for
+//anyone not on the list.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// cleanViewers trims a viewers list and drops repeats, making sure each one
+// is a user or an @role.
+func cleanViewers(viewers []string) ([]string, error) {
+	cleaned := []string{} // [] rather than null
+	for _, viewer := range viewers {
+		viewer = strings.TrimSpace(viewer)
+		if role, ok := strings.CutPrefix(viewer, "@"); viewer == "" || (ok && (role == "" || strings.ContainsAny(role, "@ ,"))) {
+			return nil, fmt.Errorf("%q must be a user, or a role like @staff", viewer)
+		}
+		if !slices.Contains(cleaned, viewer) {
+			cleaned = append(cleaned, viewer)
+		}
+	}
+	return cleaned, nil
+}
+
+// restricted reports whether only some may see the page: it's a draft, or
+// private.
+func (m PageMeta) restricted(now time.Time) bool {
+	return m.hidden(now) || m.Private
+}
+
+// accessMeta is a page's meta, for deciding who may see the page. A meta
+// file that can't be read might be hiding a private page or a draft, so
+// until it's fixed the page is kept to admins.
+func accessMeta(ctx context.Context, slug string) PageMeta {
+	meta, err := loadPageMeta(ctx, slug)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading page meta, keeping the page to admins", "page", slug, "err", err)
+		return PageMeta{Private: true}
+	}
+	return meta
+}
+
+// isRestricted reports whether a page is kept from visitors, as a draft or a
+// private page, or because its meta file is broken.
+func isRestricted(ctx context.Context, slug string) bool {
+	return accessMeta(ctx, slug).restricted(time.Now())
+}
+
+// allows reports whether user is on the page's viewers list, by name or
+// through one of their roles.
+func (m PageMeta) allows(user string, roles map[string][]string) bool {
+	if user == "" {
+		return false
+	}
+	for _, viewer := range m.Viewers {
+		if role, ok := strings.CutPrefix(viewer, "@"); ok {
+			if slices.Contains(roles[role], user) {
+				return true
+			}
+		} else if viewer == user {
+			return true
+		}
+	}
+	return false
+}
+
+// canSeePage reports whether this request may see the page: anyone may see
+// a published one, only those who may see its draft a draft, and a private
+// one those and its viewers too.
+func canSeePage(r *http.Request, meta PageMeta) bool {
+	now := time.Now()
+	switch {
+	case !meta.restricted(now):
+		return true
+	case canSeeDraft(r, meta):
+		return true
+	case meta.hidden(now):
+		return false
+	}
+	return meta.allows(loggedInName(r), configOf(r.Context()).Roles)
+}
+
+// pageAllowed lets a draft or private page through to those who may see it.
+// Anyone else is asked to log in. Once they have, they're told there's no
+// such page if it's a draft, and that it's not for them if it's private.
+func pageAllowed(w http.ResponseWriter, r *http.Request, slug string) bool {
+	meta := accessMeta(r.Context(), slug)
+	if canSeePage(r, meta) {
+		return true
+	}
+	if _, _, ok := r.BasicAuth(); !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+siteOf(r.Context()).title+`", charset="UTF-8"`)
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return false
+	}
+	if !meta.hidden(time.Now()) {
+		http.Error(w, "This page is private", http.StatusForbidden)
+		return false
+	}
+	http.NotFound(w, r)
+	return false
+}
+
+// restrictedPageError answers an API request for a page it may not see,
+// reporting whether it did: a 404 for a draft, as if it weren't there, and a
+// 403 for a private page.
+func restrictedPageError(w http.ResponseWriter, r *http.Request, meta PageMeta) bool {
+	if canSeePage(r, meta) {
+		return false
+	}
+	if meta.hidden(time.Now()) {
+		apiError(w, "Page not found", http.StatusNotFound)
+	} else {
+		apiError(w, "This page is private", http.StatusForbidden)
+	}
+	return true
+}
+
+// privacyAvailable reports whether anyone could log in to see a private
+// page.
+func privacyAvailable(r *http.Request) bool {
+	return serverOf(r.Context()).plugins.HasAuth() || siteOf(r.Context()).adminPassword != ""
+}
+
+// markPrivate marks a page that's about to be created as private, to the
+// viewers.
+func markPrivate(ctx context.Context, slug string, viewers []string) error {
+	siteOf(ctx).pageMetaMu.Lock()
+	defer siteOf(ctx).pageMetaMu.Unlock()
+	meta, err := loadPageMeta(ctx, slug)
+	if err != nil {
+		return err
+	}
+	meta.Private, meta.Viewers = true, viewers
+	return savePageMeta(ctx, slug, meta)
+}
+
+// accessRequest is the body of POST /api/page/{slug}/access.
+type accessRequest struct {
+	Private bool     `json:"private"`
+	Viewers []string `json:"viewers"` // Users, and @roles, who may see it
+}
+
+// accessHandler handles POST /api/page/{slug}/access with an accessRequest,
+// making a page private to its viewers, or public again. Only those who may
+// see the page's draft can, and it answers with the page's access as it is
+// now.
+func (srv *Server) accessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	slug, ok := editablePage(w, r, "access")
+	if !ok {
+		return
+	}
+	var reqBody accessRequest
+	if err := readJSON(w, r, &reqBody); err != nil {
+		badJSON(w, err)
+		return
+	}
+	viewers, err := cleanViewers(reqBody.Viewers)
+	if err != nil {
+		fieldError(w, "viewers", "invalid_viewer", err.Error())
+		return
+	}
+	if reqBody.Private && !privacyAvailable(r) {
+		fieldError(w, "private", "private_unavailable", "Private pages need someone who can see them, set admin_password or load an auth plugin")
+		return
+	}
+	if !reqBody.Private {
+		viewers = nil // Nothing to keep for a public page
+	}
+
+	siteOf(r.Context()).pageMetaMu.Lock()
+	defer siteOf(r.Context()).pageMetaMu.Unlock()
+	meta, err := loadPageMeta(r.Context(), slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading page meta", "err", err)
+		apiError(w, "Could not change who can see the page", http.StatusInternalServerError)
+		return
+	}
+	if !canSeeDraft(r, meta) {
+		apiError(w, "Only whoever created the page, or an admin, can do that", http.StatusForbidden)
+		return
+	}
+	wasRestricted := meta.restricted(time.Now())
+	meta.Private, meta.Viewers = reqBody.Private, viewers
+	meta.edited(editorName(r))
+	if err := savePageMeta(r.Context(), slug, meta); err != nil {
+		if quotaError(w, err) {
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error writing page meta", "err", err)
+		apiError(w, "Could not change who can see the page", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Page access changed", "private", meta.Private, "viewers", strings.Join(meta.Viewers, ","))
+	if wasRestricted && !meta.restricted(time.Now()) {
+		queueSearchPing(r.Context(), slug)
+		publishPageEvent(r.Context(), "page-created", slug)
+	}
+	purgePage(r.Context(), slug)
+	purgeListings(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accessRequest{Private: meta.Private, Viewers: tagsOrEmpty(meta.Viewers)})
+}
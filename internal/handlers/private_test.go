@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startPrivateTestServer starts a site with an admin password, so there
+// can be private pages, that pings search engines about the rest.
+func startPrivateTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	cfg := testConfig(t)
+	cfg.AdminPassword = "hunter2"
+	cfg.SiteURL = "https://wiki.example.com"
+	cfg.SearchPings.IndexNowKey = "test-indexnow-key"
+	cfg.SearchPings.IndexNowURL = "http://127.0.0.1:1/indexnow" // Never sent, see below
+	cfg.SearchPings.Interval = 24 * time.Hour                   // So the queue stays put
+	srv := newTestServer(t, cfg)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	t.Cleanup(func() { // Before the Server is closed, so there's nothing to send
+		srv.searchPingQueue.Lock()
+		srv.searchPingQueue.slugs = nil
+		srv.searchPingQueue.Unlock()
+	})
+	return srv, ts
+}
+
+// sendAsAdmin is send with the admin password.
+func sendAsAdmin(t *testing.T, ts *httptest.Server, method, path, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("admin", "hunter2")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// pingQueued reports whether a search engine ping for slug is waiting to go.
+func pingQueued(srv *Server, slug string) bool {
+	srv.searchPingQueue.Lock()
+	defer srv.searchPingQueue.Unlock()
+	return srv.searchPingQueue.slugs[slug]
+}
+
+func TestPrivatePageWritesNeedAccess(t *testing.T) {
+	srv, ts := startPrivateTestServer(t)
+	wantStatus(t, send(t, ts, "POST", "/api/v1/pages", `{"name": "Secret Plans", "private": true}`), http.StatusCreated)
+	wantStatus(t, send(t, ts, "POST", "/api/v1/pages", `{"name": "Rough Notes", "draft": true}`), http.StatusCreated)
+	const video = `{"youtube_url": "https://youtu.be/dQw4w9WgXcQ"}`
+	wantStatus(t, sendAsAdmin(t, ts, "POST", "/api/page/secret-plans/save-youtube", video), http.StatusOK)
+	wantStatus(t, sendAsAdmin(t, ts, "POST", "/api/vote/secret-plans/dQw4w9WgXcQ/upvote", ""), http.StatusOK)
+
+	for _, tt := range []struct {
+		method, path, body string
+	}{
+		{"POST", "/api/page/secret-plans/save-youtube", video},
+		{"POST", "/api/v1/pages/secret-plans/videos", video},
+		{"POST", "/api/vote/secret-plans/dQw4w9WgXcQ/upvote", ""},
+		{"POST", "/api/page/secret-plans/embed", `{"captions_lang": "en"}`},
+		{"POST", "/api/page/secret-plans/expire", `{"expires_at": "2099-01-01T00:00:00Z"}`},
+		{"POST", "/api/page/secret-plans/rename", `{"name": "Leaked"}`},
+	} {
+		wantStatus(t, send(t, ts, tt.method, tt.path, tt.body), http.StatusForbidden)
+	}
+	// A draft isn't even there, to those who can't see it
+	wantStatus(t, send(t, ts, "POST", "/api/page/rough-notes/save-youtube", video), http.StatusNotFound)
+	wantStatus(t, send(t, ts, "POST", "/api/page/rough-notes/rename", `{"name": "Leaked"}`), http.StatusNotFound)
+
+	resp := send(t, ts, "POST", "/api/vote/batch", `{"votes": [{"slug": "secret-plans", "video_id": "dQw4w9WgXcQ", "action": "upvote"}]}`)
+	wantStatus(t, resp, http.StatusUnprocessableEntity)
+	if body, _ := io.ReadAll(resp.Body); strings.Contains(string(body), `"votes":1`) {
+		t.Errorf("batch vote on a private page answered with its votes: %s", body)
+	}
+
+	// Nothing was changed
+	resp = sendAsAdmin(t, ts, "GET", "/api/v1/pages/secret-plans", "")
+	wantStatus(t, resp, http.StatusOK)
+	if body, _ := io.ReadAll(resp.Body); !strings.Contains(string(body), `"votes":1`) || strings.Contains(string(body), "expires") {
+		t.Errorf("private page changed by anonymous requests: %s", body)
+	}
+	wantStatus(t, sendAsAdmin(t, ts, "GET", "/page/leaked", ""), http.StatusNotFound)
+
+	// Nor are search engines told about private pages, even by those who
+	// can change them
+	wantStatus(t, sendAsAdmin(t, ts, "POST", "/api/page/secret-plans/rename", `{"name": "Hidden Plans"}`), http.StatusOK)
+	for _, slug := range []string{"secret-plans", "hidden-plans", "rough-notes"} {
+		if pingQueued(srv, slug) {
+			t.Errorf("search engines are to be told about %s", slug)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+//Errors from the JSON API for pages and votes (/create, /api/page/ and
+///api/vote/) come as application/problem+json (RFC 9457), so programs can
+//tell them apart without matching on the text:
+//
+//	{"type": "about:blank", "title": "Bad Request", "status": 400,
+//	 "code": "reserved_name", "detail": "The name admin is reserved, pick another one.",
+//	 "errors": [{"field": "name", "code": "reserved_name", "message": "..."}]}
+//
+//code is the thing to match on; detail is for people and may change.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// problem is an error as application/problem+json.
+type problem struct {
+	Type   string         `json:"type"`  // Always about:blank, code says what happened
+	Title  string         `json:"title"` // The status's text
+	Status int            `json:"status"`
+	Code   string         `json:"code"`   // Machine readable, e.g. "invalid_name"
+	Detail string         `json:"detail"` // What went wrong, for people
+	Errors []fieldProblem `json:"errors,omitempty"`
+}
+
+// fieldProblem is what's wrong with one field of a request's JSON body.
+type fieldProblem struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// statusCodes are the codes for errors with nothing more specific to say.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "login_required",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusInsufficientStorage:   "quota_exceeded",
+}
+
+// apiError is http.Error for the JSON API: detail as a problem, with the
+// code for its status.
+func apiError(w http.ResponseWriter, detail string, status int) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = "internal_error"
+	}
+	writeProblem(w, status, code, detail)
+}
+
+// fieldError answers that field of the request's body is wrong, code
+// saying how.
+func fieldError(w http.ResponseWriter, field, code, detail string) {
+	writeProblem(w, http.StatusBadRequest, code, detail, fieldProblem{Field: field, Code: code, Message: detail})
+}
+
+// badJSON answers a request whose body couldn't be decoded, err saying why.
+func badJSON(w http.ResponseWriter, err error) {
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		writeProblem(w, http.StatusRequestEntityTooLarge, "too_large", fmt.Sprintf("The body is too big, the most is %d bytes", tooBig.Limit))
+		return
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		writeProblem(w, http.StatusRequestTimeout, "timeout", "The body took too long to send")
+		return
+	}
+	writeProblem(w, http.StatusBadRequest, "invalid_json", "Bad request, the body must be JSON")
+}
+
+// writeProblem sends a problem.
+func writeProblem(w http.ResponseWriter, status int, code, detail string, fields ...fieldProblem) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/problem+json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+		Errors: fields,
+	})
+}
+
+// problemDetail is what went wrong, from a body a handler answered an error
+// with: a problem's detail, or the text of a plain one.
+func problemDetail(header http.Header, body []byte) string {
+	var p problem
+	if header.Get("Content-Type") == "application/problem+json" && json.Unmarshal(body, &p) == nil {
+		return p.Detail
+	}
+	return string(body)
+}
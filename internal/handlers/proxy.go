@@ -0,0 +1,70 @@
+package handlers
+
+//Finding the real client address behind a reverse proxy. Requests coming
+//from a trusted proxy carry the client's address in X-Forwarded-For (or
+//X-Real-IP); from anyone else those headers are ignored, since a client can
+//send whatever it likes in them.
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// isTrustedProxy reports whether ip belongs to a trusted proxy.
+func (srv *Server) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range srv.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP is the address of the client that sent the request, without the
+// port. Behind trusted proxies it's the first address in X-Forwarded-For,
+// from the right, that isn't one of them.
+func remoteIP(r *http.Request) string {
+	srv := serverOf(r.Context())
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	// Over the unix socket only a proxy on this host can be talking to us
+	viaSocket := srv.config.Socket.Path != "" && net.ParseIP(peer) == nil
+	if !viaSocket && !srv.isTrustedProxy(peer) {
+		return peer
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			// Garbage, so nothing further left can be believed either
+			if i+1 < len(hops) {
+				return hops[i+1]
+			}
+			break
+		}
+		if !srv.isTrustedProxy(hops[i]) || i == 0 {
+			return hops[i]
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); len(hops) == 0 && net.ParseIP(ip) != nil {
+		return ip
+	}
+	if viaSocket {
+		return "unix"
+	}
+	return peer
+}
@@ -0,0 +1,336 @@
+package handlers
+
+//Renaming pages, and aliases. A page is moved to its new slug along with all
+//its other files, and the old slug is kept in redirects.json so that links
+//to the old /page/{slug} still lead to it, with a 301. Admins can add aliases
+//to the same file by hand, so /page/golang can lead to /page/go.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go-trailer/internal/slugs"
+)
+
+// The file the redirects are kept in, next to the pages.
+const redirectsFile = "redirects.json"
+
+// A page's files other than {slug}.txt, all moved when it's renamed, along
+// with its attachments.
+var pageCompanionSuffixes = []string{".youtube.txt", ".votes.json", ".comments.json", ".meta.json"}
+
+// loadRedirects reads where each old slug now points. Having none is fine.
+func loadRedirects(ctx context.Context) (map[string]string, error) {
+	redirects := make(map[string]string)
+	data, err := storeCtx(ctx).ReadFile(redirectsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return redirects, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &redirects)
+	return redirects, err
+}
+
+// saveRedirects writes the redirects back. Callers must hold redirectsMu.
+func saveRedirects(ctx context.Context, redirects map[string]string) error {
+	data, err := json.Marshal(redirects)
+	if err != nil {
+		return err
+	}
+	return storeCtx(ctx).WriteFile(redirectsFile, data)
+}
+
+// redirectTarget is where an old slug now lives, if anywhere.
+func redirectTarget(ctx context.Context, slug string) (string, bool) {
+	redirects, err := loadRedirects(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading redirects", "err", err)
+		return "", false
+	}
+	target, ok := redirects[slug]
+	return target, ok
+}
+
+// addRedirect points from at to. Redirects that pointed at from are moved
+// along too, so a page renamed twice doesn't leave a chain behind.
+func addRedirect(ctx context.Context, from, to string) error {
+	siteOf(ctx).redirectsMu.Lock()
+	defer siteOf(ctx).redirectsMu.Unlock()
+	redirects, err := loadRedirects(ctx)
+	if err != nil {
+		return err
+	}
+	for old, target := range redirects {
+		if target == from {
+			redirects[old] = to
+		}
+	}
+	redirects[from] = to
+	delete(redirects, to) // The new slug is a page now, not a redirect
+	return saveRedirects(ctx, redirects)
+}
+
+// removeRedirect drops the redirect from a slug, reporting whether there was one.
+func removeRedirect(ctx context.Context, from string) (bool, error) {
+	siteOf(ctx).redirectsMu.Lock()
+	defer siteOf(ctx).redirectsMu.Unlock()
+	redirects, err := loadRedirects(ctx)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := redirects[from]; !ok {
+		return false, nil
+	}
+	delete(redirects, from)
+	return true, saveRedirects(ctx, redirects)
+}
+
+// removeRedirectsTo drops every redirect to a page, once it's gone,
+// returning the slugs they were from.
+func removeRedirectsTo(ctx context.Context, to string) ([]string, error) {
+	siteOf(ctx).redirectsMu.Lock()
+	defer siteOf(ctx).redirectsMu.Unlock()
+	redirects, err := loadRedirects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	maps.DeleteFunc(redirects, func(from, target string) bool {
+		if target == to {
+			removed = append(removed, from)
+		}
+		return target == to
+	})
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	slices.Sort(removed)
+	return removed, saveRedirects(ctx, redirects)
+}
+
+// adminAliasesHandler serves /admin/aliases. GET lists every alias (and
+// rename redirect) as {"golang": "go", ...}, POST adds one with a body of
+// {"alias": "golang", "target": "go"}, and DELETE ?alias=golang removes one.
+func (srv *Server) adminAliasesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		redirects, err := loadRedirects(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading redirects", "err", err)
+			http.Error(w, "Could not list aliases", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(redirects)
+
+	case http.MethodPost:
+		var reqBody struct {
+			Alias  string `json:"alias"`
+			Target string `json:"target"`
+		}
+		if err := readJSON(w, r, &reqBody); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		alias, target := reqBody.Alias, reqBody.Target
+		if alias == "" || alias != slugs.FromName(alias) {
+			http.Error(w, "The alias must be a slug, like "+slugs.FromName(alias), http.StatusBadRequest)
+			return
+		}
+		if final, ok := redirectTarget(r.Context(), target); ok {
+			target = final // Point straight at the page, not at another alias
+		}
+		switch {
+		case alias == target:
+			http.Error(w, "A page can't be an alias of itself", http.StatusBadRequest)
+			return
+		case pageExists(r.Context(), alias):
+			http.Error(w, "There's already a page called "+alias, http.StatusConflict)
+			return
+		case !pageExists(r.Context(), target):
+			http.Error(w, "There's no page called "+target, http.StatusBadRequest)
+			return
+		}
+		if err := addRedirect(r.Context(), alias, target); err != nil {
+			if quotaError(w, err) {
+				return
+			}
+			slog.ErrorContext(r.Context(), "Error saving redirect", "err", err)
+			http.Error(w, "Could not save alias", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "Alias added", "alias", alias, "target", target)
+		purgePage(r.Context(), alias)
+		w.Write([]byte("Alias saved!"))
+
+	case http.MethodDelete:
+		alias := r.URL.Query().Get("alias")
+		removed, err := removeRedirect(r.Context(), alias)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error saving redirects", "err", err)
+			http.Error(w, "Could not remove alias", http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			http.NotFound(w, r)
+			return
+		}
+		slog.InfoContext(r.Context(), "Alias removed", "alias", alias)
+		purgePage(r.Context(), alias)
+		w.Write([]byte("Alias removed!"))
+
+	default:
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+	}
+}
+
+// renamePage moves a page and its other files from one slug to another.
+// Everything is copied before anything is removed, so a failure part way
+// leaves the old page whole.
+func renamePage(ctx context.Context, from, to string) error {
+	pages := storeCtx(ctx)
+	names := []string{".txt"}
+	for _, suffix := range pageCompanionSuffixes {
+		if _, err := pages.ModTime(from + suffix); err == nil {
+			names = append(names, suffix)
+		}
+	}
+	attachments, err := attachmentFiles(ctx, from)
+	if err != nil {
+		return err
+	}
+	for _, file := range attachments {
+		names = append(names, strings.TrimPrefix(file, from)) // @name, like a suffix
+	}
+	// The page itself goes last, so it never shows up without its votes
+	for i := len(names) - 1; i >= 0; i-- {
+		data, err := pages.ReadFile(from + names[i])
+		if err != nil {
+			return err
+		}
+		if err := pages.WriteFile(to+names[i], data); err != nil {
+			return err
+		}
+	}
+	for _, suffix := range names {
+		if err := pages.Remove(from + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameRequest is the body of POST /api/page/{slug}/rename.
+type renameRequest struct {
+	Name string `json:"name"`
+}
+
+// renameHandler handles POST /api/page/{slug}/rename with a JSON body of
+// {"name": "New Name"}. The page moves to the slug for the new name, which
+// becomes its title, and the old URL redirects there.
+func (srv *Server) renameHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	from := filepath.Base(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/page/"), "/rename"))
+	setLogSlug(r, from)
+
+	var reqBody renameRequest
+	if err := readJSON(w, r, &reqBody); err != nil {
+		badJSON(w, err)
+		return
+	}
+	if strings.TrimSpace(reqBody.Name) == "" {
+		fieldError(w, "name", "required", "Page name is required")
+		return
+	}
+	if err := slugs.CheckName(reqBody.Name); err != nil {
+		fieldError(w, "name", "invalid_name", "Bad name found, try again. Cannot use symbols, try words only.")
+		return
+	}
+	to := slugs.FromName(reqBody.Name)
+	if reservedSlug(r.Context(), to) {
+		fieldError(w, "name", "reserved_name", "The name "+to+" is reserved, pick another one.")
+		return
+	}
+
+	// Nothing else may touch the page's files while they move
+	siteOf(r.Context()).createMu.Lock()
+	defer siteOf(r.Context()).createMu.Unlock()
+	siteOf(r.Context()).votesMu.Lock()
+	defer siteOf(r.Context()).votesMu.Unlock()
+	siteOf(r.Context()).commentsMu.Lock()
+	defer siteOf(r.Context()).commentsMu.Unlock()
+	siteOf(r.Context()).pageMetaMu.Lock()
+	defer siteOf(r.Context()).pageMetaMu.Unlock()
+
+	if !pageExists(r.Context(), from) {
+		apiError(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	if to != from && pageExists(r.Context(), to) {
+		writeProblem(w, http.StatusConflict, "page_exists", "There's already a page called "+to)
+		return
+	}
+
+	meta, err := loadPageMeta(r.Context(), from)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading page meta", "err", err)
+		apiError(w, "Could not rename page", http.StatusInternalServerError)
+		return
+	}
+	if restrictedPageError(w, r, meta) {
+		return
+	}
+	meta.Title = reqBody.Name
+	meta.edited(editorName(r))
+	if err := savePageMeta(r.Context(), from, meta); err != nil {
+		if quotaError(w, err) {
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error writing page meta", "err", err)
+		apiError(w, "Could not rename page", http.StatusInternalServerError)
+		return
+	}
+	if to != from {
+		if err := renamePage(r.Context(), from, to); err != nil {
+			if quotaError(w, err) {
+				return
+			}
+			slog.ErrorContext(r.Context(), "Error moving page files", "to", to, "err", err)
+			apiError(w, "Could not rename page", http.StatusInternalServerError)
+			return
+		}
+		if err := addRedirect(r.Context(), from, to); err != nil {
+			slog.ErrorContext(r.Context(), "Error saving redirect", "to", to, "err", err) // The page has moved, just without a redirect
+		}
+		moveViewCount(r.Context(), from, to)
+		if err := renameShareLinks(r.Context(), from, to); err != nil {
+			slog.ErrorContext(r.Context(), "Error moving share links", "to", to, "err", err) // Their links stop working
+		}
+	}
+
+	slog.InfoContext(r.Context(), "Page renamed", "to", to)
+	if !meta.restricted(time.Now()) {
+		queueSearchPing(r.Context(), from)
+		queueSearchPing(r.Context(), to)
+	}
+	purgePage(r.Context(), from)
+	purgePage(r.Context(), to)
+	purgeListings(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"slug": to, "url": sitePath(r.Context(), pagePath(to))})
+}
@@ -0,0 +1,182 @@
+package handlers
+
+//Turning a page's text into the HTML page.html shows. Pages can use some
+//HTML, and the result is sanitized so they can't use more (see sanitize.go).
+//[[Page Name]] wiki links are turned into links, and [[Page Name|some text]]
+//links with different text. Links to pages that don't exist yet are styled
+//differently and lead to creating them.
+//Lines starting with # are headings, as in Markdown, and get an id to link to
+//and an entry in the page's table of contents. Pages with math: true in their
+//front matter can have $inline$ and $$display$$ TeX, which KaTeX draws in the
+//browser, and any page can have ```mermaid blocks, which Mermaid draws as
+//diagrams, and {{shortcodes}} (see shortcodes.go).
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"html"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go-trailer/internal/slugs"
+)
+
+// A wiki link: [[Page Name]] or [[Page Name|the text to show]].
+var wikiLinkRegex = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]+))?\]\]`)
+
+// A heading: # Heading, down to ###### Heading. Trailing #s are dropped.
+var headingRegex = regexp.MustCompile(`(?m)^(#{1,6})[ \t]+(.+?)[ \t#\r]*$`)
+
+// A Mermaid diagram, fenced like Markdown code:
+//
+//	```mermaid
+//	graph LR; a --> b
+//	```
+var mermaidRegex = regexp.MustCompile("(?m)^```mermaid[ \t]*\r?\n((?s:.*?))^```[ \t]*\r?$")
+
+// TeX: $$display math$$, or $inline math$. Inline math can't start or end
+// with a space, so "$5 and $10" is left alone.
+var mathRegex = regexp.MustCompile(`\$\$([\s\S]+?)\$\$|\$([^\s$](?:[^$\n]*[^\s$])?)\$`)
+
+// A page with at least this many headings gets a table of contents.
+const minTOCHeadings = 3
+
+// TOCEntry is one heading in a page's table of contents.
+type TOCEntry struct {
+	Level int    `json:"level"` // 1 for #, 2 for ## and so on
+	Text  string `json:"text"`
+	ID    string `json:"id"` // Of the heading, for #fragment links
+}
+
+// ShowTOC reports whether the page is long enough for a table of contents.
+func (p *Page) ShowTOC() bool {
+	return len(p.TOC) >= minTOCHeadings && !p.inlineTOC
+}
+
+// renderPage makes page.HTML from its body, and fills in its table of
+// contents and whether it has diagrams.
+func renderPage(ctx context.Context, page *Page) {
+	body, calls := extractShortcodes(page.Body)
+	var b strings.Builder
+	ids := make(map[string]int)
+	last := 0
+	for _, m := range mermaidRegex.FindAllStringSubmatchIndex(body, -1) {
+		renderBlocks(ctx, &b, page, body[last:m[0]], ids)
+		b.WriteString(`<pre class="mermaid">` + html.EscapeString(body[m[2]:m[3]]) + `</pre>`)
+		page.Diagrams = true
+		last = m[1]
+	}
+	renderBlocks(ctx, &b, page, body[last:], ids)
+	for _, call := range calls {
+		page.inlineTOC = page.inlineTOC || call.name == "toc"
+	}
+	page.HTML = template.HTML(expandShortcodes(ctx, page, sanitizeHTML(b.String(), configOf(ctx).HTML.AllowedTags), calls))
+}
+
+// renderBlocks renders some of a page's text, its headings and the paragraphs
+// between them. The page's title is its <h1>, so # headings are <h2> and so on
+// down. ids are the heading ids used so far.
+func renderBlocks(ctx context.Context, b *strings.Builder, page *Page, body string, ids map[string]int) {
+	last := 0
+	for _, m := range headingRegex.FindAllStringSubmatchIndex(body, -1) {
+		writeParagraph(ctx, b, body[last:m[0]], page.Math)
+		level := m[3] - m[2]
+		text := body[m[4]:m[5]]
+		entry := TOCEntry{Level: level, Text: wikiLinkText(text), ID: headingID(wikiLinkText(text), ids)}
+		fmt.Fprintf(b, `<h%d id="%s">%s</h%d>`, min(level+1, 6), html.EscapeString(entry.ID), renderText(ctx, text, page.Math), min(level+1, 6))
+		page.TOC = append(page.TOC, entry)
+		last = m[1]
+	}
+	writeParagraph(ctx, b, body[last:], page.Math)
+}
+
+// writeParagraph writes a stretch of text between headings as a paragraph.
+func writeParagraph(ctx context.Context, b *strings.Builder, text string, math bool) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	if shortcodePlaceholderRegex.MatchString(text) && strings.TrimSpace(shortcodePlaceholderRegex.ReplaceAllString(text, "")) == "" {
+		b.WriteString(text) // Just shortcodes, which make their own blocks
+		return
+	}
+	b.WriteString("<p>")
+	b.WriteString(renderText(ctx, text, math))
+	b.WriteString("</p>")
+}
+
+// headingID makes an id for a heading, numbering repeats so each is unique.
+func headingID(text string, ids map[string]int) string {
+	id := cmp.Or(slugs.FromName(text), "section")
+	ids[id]++
+	if n := ids[id]; n > 1 {
+		id += "-" + strconv.Itoa(n)
+	}
+	return id
+}
+
+// wikiLinkText is text with its wiki links swapped for the text they show.
+func wikiLinkText(text string) string {
+	return wikiLinkRegex.ReplaceAllStringFunc(text, func(link string) string {
+		m := wikiLinkRegex.FindStringSubmatch(link)
+		if strings.TrimSpace(m[1]) == "" {
+			return link // Not a link, see renderText
+		}
+		return strings.TrimSpace(cmp.Or(m[2], m[1]))
+	})
+}
+
+// renderText turns the wiki links in some of a page's text into links. With
+// math, TeX is left for KaTeX, wiki links and all.
+func renderText(ctx context.Context, body string, math bool) string {
+	if !math {
+		return renderLinks(ctx, body)
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range mathRegex.FindAllStringSubmatchIndex(body, -1) {
+		b.WriteString(renderLinks(ctx, body[last:m[0]]))
+		if m[2] >= 0 {
+			b.WriteString(`<span class="math math-display">` + html.EscapeString(body[m[2]:m[3]]) + `</span>`)
+		} else {
+			b.WriteString(`<span class="math math-inline">` + html.EscapeString(body[m[4]:m[5]]) + `</span>`)
+		}
+		last = m[1]
+	}
+	b.WriteString(renderLinks(ctx, body[last:]))
+	return b.String()
+}
+
+// renderLinks turns the wiki links in some text into links.
+func renderLinks(ctx context.Context, body string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range wikiLinkRegex.FindAllStringSubmatchIndex(body, -1) {
+		name := strings.TrimSpace(body[m[2]:m[3]])
+		if name == "" {
+			continue // [[ ]] isn't a link, leave it be
+		}
+		b.WriteString(body[last:m[0]])
+		text := name
+		if m[4] >= 0 {
+			text = strings.TrimSpace(body[m[4]:m[5]])
+		}
+		b.WriteString(wikiLink(ctx, name, text))
+		last = m[1]
+	}
+	b.WriteString(body[last:])
+	return b.String()
+}
+
+// wikiLink is the <a> for a wiki link to the named page.
+func wikiLink(ctx context.Context, name, text string) string {
+	slug := slugs.FromName(name)
+	if _, renamed := redirectTarget(ctx, slug); renamed || pageExists(ctx, slug) {
+		return `<a class="wikilink" href="` + html.EscapeString(sitePath(ctx, pagePath(slug))) + `">` + html.EscapeString(text) + `</a>`
+	}
+	href := sitePath(ctx, "/?create="+url.QueryEscape(name))
+	return `<a class="wikilink missing" href="` + html.EscapeString(href) + `" title="No page yet, click to create it">` + html.EscapeString(text) + `</a>`
+}
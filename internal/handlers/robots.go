@@ -0,0 +1,36 @@
+package handlers
+
+//Serves /robots.txt built from a list of rules, so crawlers stay out of the API
+
+import (
+	"net/http"
+	"strings"
+)
+
+// robotsHandler serves /robots.txt from the configured rules.
+func (srv *Server) robotsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	for i, group := range srv.config.Robots {
+		if i > 0 {
+			b.WriteString("\n") // Groups are separated by a blank line
+		}
+		b.WriteString("User-agent: " + group.UserAgent + "\n")
+		for _, path := range group.Allow {
+			b.WriteString("Allow: " + sitePath(r.Context(), path) + "\n")
+		}
+		for _, path := range group.Disallow {
+			b.WriteString("Disallow: " + sitePath(r.Context(), path) + "\n")
+		}
+		// A group with no rules at all means "allow everything"
+		if len(group.Allow) == 0 && len(group.Disallow) == 0 {
+			b.WriteString("Disallow:\n")
+		}
+	}
+
+	if srv.config.Features.Feeds {
+		b.WriteString("\nSitemap: " + siteBaseURL(r) + "/sitemap.xml\n")
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,162 @@
+package handlers
+
+//Sanitizing the HTML pages are rendered to. Pages may use the tags listed in
+//html.allowed_tags, as well as the ones the renderer makes itself; any other
+//tag is dropped, keeping the text inside it, except for the likes of <script>
+//which go along with what's in them. Attributes that aren't on the short list
+//below are dropped too, which takes care of onclick= and style=, and links
+//may only go to http(s): and mailto: URLs.
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"go-trailer/internal/config"
+
+	"golang.org/x/net/html"
+)
+
+// The tags the renderer makes itself, for headings, links, diagrams and math.
+var renderedTags = []string{"a", "p", "h2", "h3", "h4", "h5", "h6", "pre", "span"}
+
+// Attributes kept, by tag. "" is for any allowed tag.
+var allowedAttributes = map[string][]string{
+	"":    {"class", "id", "title", "lang", "dir"},
+	"a":   {"href"},
+	"img": {"src", "alt", "width", "height"},
+	"ol":  {"start"},
+	"td":  {"colspan", "rowspan"},
+	"th":  {"colspan", "rowspan", "scope"},
+}
+
+// Tags with no end tag.
+var voidTags = map[string]bool{"br": true, "hr": true, "img": true, "wbr": true}
+
+// sanitizeHTML cleans up rendered HTML, keeping only the tags we render and
+// allowedTags. Tags left open are closed, so a page can't break the layout
+// around it.
+func sanitizeHTML(s string, allowedTags []string) string {
+	allowed := make(map[string]bool)
+	for _, tag := range slices.Concat(renderedTags, allowedTags) {
+		allowed[tag] = true
+	}
+
+	var b strings.Builder
+	var open []string // Allowed tags we're inside, innermost last
+	dropping, depth := "", 0
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break // Usually io.EOF, or else the rest can't be read anyway
+		}
+		t := z.Token()
+		if dropping != "" {
+			switch {
+			case tt == html.StartTagToken && t.Data == dropping:
+				depth++
+			case tt == html.EndTagToken && t.Data == dropping:
+				if depth--; depth == 0 {
+					dropping = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			b.WriteString(html.EscapeString(t.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if config.DroppedWithContent[t.Data] {
+				if tt == html.StartTagToken {
+					dropping, depth = t.Data, 1
+				}
+				continue
+			}
+			if !allowed[t.Data] {
+				continue
+			}
+			t.Attr = cleanAttributes(t.Data, t.Attr)
+			if voidTags[t.Data] {
+				t.Type = html.SelfClosingTagToken
+			} else {
+				t.Type = html.StartTagToken
+				open = append(open, t.Data)
+			}
+			b.WriteString(t.String())
+		case html.EndTagToken:
+			i := slices.Index(open, t.Data)
+			if i < 0 {
+				continue // Never opened, or not allowed
+			}
+			for len(open) > i {
+				b.WriteString("</" + open[len(open)-1] + ">")
+				open = open[:len(open)-1]
+			}
+		}
+		// Comments and doctypes are left out
+	}
+	for len(open) > 0 {
+		b.WriteString("</" + open[len(open)-1] + ">")
+		open = open[:len(open)-1]
+	}
+	return b.String()
+}
+
+// htmlText is just the text of some HTML, for excerpts and the like.
+func htmlText(s string) string {
+	if !strings.ContainsAny(s, "<&") {
+		return s
+	}
+	var b strings.Builder
+	dropping := false
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch tt := z.Next(); tt {
+		case html.ErrorToken:
+			return b.String()
+		case html.TextToken:
+			if !dropping {
+				b.Write(z.Text())
+			}
+		case html.StartTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			if config.DroppedWithContent[string(name)] {
+				dropping = tt == html.StartTagToken
+			}
+		}
+	}
+}
+
+// cleanAttributes keeps a tag's allowed attributes, and only safe URLs.
+func cleanAttributes(tag string, attrs []html.Attribute) []html.Attribute {
+	var kept []html.Attribute
+	for _, a := range attrs {
+		if a.Namespace != "" {
+			continue
+		}
+		if !slices.Contains(allowedAttributes[""], a.Key) && !slices.Contains(allowedAttributes[tag], a.Key) {
+			continue
+		}
+		if (a.Key == "href" || a.Key == "src") && !safeURL(a.Val) {
+			continue
+		}
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+// safeURL reports whether a link may go to u: relative URLs are fine, and
+// http:, https: and mailto: ones, but not javascript: and the like.
+func safeURL(u string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
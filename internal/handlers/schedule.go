@@ -0,0 +1,171 @@
+package handlers
+
+//Scheduled publishing: a draft can be given a publish_at time, and goes live
+//by itself once it's passed. Pages count as published from that moment
+//wherever they're listed; the scheduler then makes it stick in the meta file
+//and does what publishing by hand does, like pinging search engines and
+//purging the CDN, so the page turns up in feeds without anyone doing a thing.
+//The same scheduler archives pages that have expired (see archive.go).
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go-trailer/internal/storage"
+)
+
+// How often the scheduler looks for drafts and expired pages that are due.
+const schedulerInterval = time.Minute
+
+// hidden reports whether a page is still a draft at the given time.
+func (m PageMeta) hidden(now time.Time) bool {
+	return m.Draft && (m.PublishAt.IsZero() || now.Before(m.PublishAt))
+}
+
+// runPageScheduler publishes drafts and archives pages whose time has come,
+// every so often until ctx is cancelled.
+func (srv *Server) runPageScheduler(ctx context.Context) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+	for {
+		for _, s := range srv.servedSites() {
+			if srv.readOnly() {
+				break // They'll be done once we're writable again
+			}
+			siteCtx := context.WithValue(ctx, siteKey{}, s)
+			publishDue(siteCtx)
+			archiveDue(siteCtx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishDue publishes the site's drafts that are due.
+func publishDue(ctx context.Context) {
+	slugs, err := storage.PageSlugs(storeCtx(ctx))
+	if err != nil {
+		slog.Error("Error listing pages to publish", "err", err)
+		return
+	}
+	now := time.Now()
+	for _, slug := range slugs {
+		meta, err := loadPageMeta(ctx, slug)
+		if err != nil || !meta.Draft || meta.hidden(now) {
+			continue
+		}
+		if err := publishScheduled(ctx, slug); err != nil {
+			slog.Error("Error publishing scheduled page", "page", slug, "err", err)
+			continue
+		}
+		slog.Info("Scheduled page published", "page", slug)
+		if !meta.Private {
+			queueSearchPing(ctx, slug)
+		}
+		purgePage(ctx, slug)
+		purgeListings(ctx)
+		publishPageEvent(ctx, "page-created", slug)
+	}
+}
+
+// publishScheduled clears a due draft's draft flag in its meta file.
+func publishScheduled(ctx context.Context, slug string) error {
+	siteOf(ctx).pageMetaMu.Lock()
+	defer siteOf(ctx).pageMetaMu.Unlock()
+	meta, err := loadPageMeta(ctx, slug)
+	if err != nil {
+		return err
+	}
+	if meta.hidden(time.Now()) {
+		return nil // Rescheduled while we weren't looking
+	}
+	meta.Draft = false
+	meta.Published, meta.PublishAt = meta.PublishAt, time.Time{}
+	return savePageMeta(ctx, slug, meta)
+}
+
+// scheduleRequest is the body of POST /api/page/{slug}/schedule.
+type scheduleRequest struct {
+	PublishAt string `json:"publish_at"`
+}
+
+// scheduleHandler handles POST /api/page/{slug}/schedule with a JSON body of
+// {"publish_at": "2025-01-02T15:04:05Z"}, for a draft to publish itself then.
+// An empty publish_at leaves it a draft until published by hand.
+func (srv *Server) scheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	slug := filepath.Base(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/page/"), "/schedule"))
+	setLogSlug(r, slug)
+	if !pageExists(r.Context(), slug) {
+		apiError(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	var reqBody scheduleRequest
+	if err := readJSON(w, r, &reqBody); err != nil {
+		badJSON(w, err)
+		return
+	}
+	publishAt, err := parsePublishAt(reqBody.PublishAt)
+	if err != nil {
+		fieldError(w, "publish_at", "invalid_time", err.Error())
+		return
+	}
+
+	siteOf(r.Context()).pageMetaMu.Lock()
+	defer siteOf(r.Context()).pageMetaMu.Unlock()
+	meta, err := loadPageMeta(r.Context(), slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading page meta", "err", err)
+		apiError(w, "Could not schedule page", http.StatusInternalServerError)
+		return
+	}
+	if !canSeeDraft(r, meta) {
+		apiError(w, "Only whoever created the page, or an admin, can do that", http.StatusForbidden)
+		return
+	}
+	if !meta.hidden(time.Now()) {
+		writeProblem(w, http.StatusConflict, "already_published", "Page is already published")
+		return
+	}
+	meta.PublishAt = publishAt
+	meta.edited(editorName(r))
+	if err := savePageMeta(r.Context(), slug, meta); err != nil {
+		if quotaError(w, err) {
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error writing page meta", "err", err)
+		apiError(w, "Could not schedule page", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Page publishing scheduled", "publish_at", publishAt)
+	w.Write([]byte("Publishing scheduled!"))
+}
+
+// parsePublishAt reads a publish_at time, which must be in the future.
+// Empty is fine, and means no time at all.
+func parsePublishAt(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.New("publish_at must be a time like 2025-01-02T15:04:05Z")
+	}
+	if !t.After(time.Now()) {
+		return time.Time{}, errors.New("publish_at must be in the future")
+	}
+	return t.UTC(), nil
+}
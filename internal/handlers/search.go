@@ -0,0 +1,346 @@
+package handlers
+
+//Site search (/search and /api/search), and keeping count of what people
+//search for. Queries that found nothing are the best hint at which pages
+//to create next, see /admin/search.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go-trailer/internal/slugs"
+)
+
+// Limits on searching, so a long query can't turn into a lot of work.
+const (
+	maxSearchQueryLength = 100
+	maxSearchResults     = 50
+)
+
+// Search stats are kept in memory and written out every so often.
+const (
+	searchStatsFile          = "search-stats.json"
+	searchStatsSaveInterval  = time.Minute
+	maxTrackedSearchQueries  = 5000 // The least recently searched are forgotten first
+	searchReportQueriesLimit = 100
+)
+
+// SearchResult is one page matching a query.
+type SearchResult struct {
+	Slug    string `json:"slug"`
+	Title   string `json:"title"`
+	Snippet string `json:"snippet"`
+	score   int
+}
+
+// searchCreate suggests making the page a search didn't find. Name is ready
+// to POST to /create as {"name": "..."}.
+type searchCreate struct {
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+}
+
+// searchResponse is the body of /api/search.
+type searchResponse struct {
+	Query   string         `json:"query"`
+	Results []SearchResult `json:"results"`
+	Create  *searchCreate  `json:"create,omitempty"` // Only when nothing was found
+}
+
+// searchQueryStat is how often one (normalised) query was searched for.
+type searchQueryStat struct {
+	Query       string    `json:"query"`
+	Count       int       `json:"count"`
+	ZeroResults int       `json:"zero_results"` // How many of those searches found nothing
+	LastResults int       `json:"last_results"` // Pages found the last time, 0 means still missing
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// searchStats is every query we're keeping count of, by normalised query.
+// Only the main site keeps them.
+type searchStats struct {
+	sync.Mutex
+	queries map[string]*searchQueryStat
+	dirty   bool // Changed since we last saved
+}
+
+// normalizeSearchQuery lowercases a query and collapses its whitespace, so
+// "Dune  Trailer" and "dune trailer" are counted as the same search.
+func normalizeSearchQuery(q string) string {
+	q = strings.Join(strings.Fields(strings.ToLower(strings.ToValidUTF8(q, ""))), " ")
+	if len(q) > maxSearchQueryLength {
+		cut := maxSearchQueryLength
+		for cut > 0 && !utf8.RuneStart(q[cut]) {
+			cut--
+		}
+		q = strings.TrimSpace(q[:cut])
+	}
+	return q
+}
+
+// searchPages finds pages whose name or text has every word of the query.
+// Matches in the name count for more than matches in the text.
+func searchPages(ctx context.Context, query string) ([]SearchResult, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	slugs, err := publishedSlugs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	for _, slug := range slugs {
+		_, body, err := readPageText(ctx, slug)
+		if err != nil {
+			slog.Error("Error reading page for search", "page", slug, "err", err)
+			continue
+		}
+		title := pageTitle(ctx, slug)
+		name := strings.ReplaceAll(slug, "-", " ")
+		if title != slug {
+			name += " " + strings.ToLower(title)
+		}
+		text := strings.ToLower(string(body))
+
+		score := 0
+		for _, term := range terms {
+			inName, inText := strings.Count(name, term), strings.Count(text, term)
+			if inName == 0 && inText == 0 {
+				score = 0
+				break
+			}
+			score += inName*10 + inText
+		}
+		if score > 0 {
+			results = append(results, SearchResult{Slug: slug, Title: title, Snippet: searchSnippet(string(body), terms[0]), score: score})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].score > results[j].score
+	})
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+	return results, nil
+}
+
+// searchSnippet is a bit of the page text around the first match of term.
+func searchSnippet(body, term string) string {
+	body = strings.Join(strings.Fields(body), " ")
+	i := strings.Index(strings.ToLower(body), term)
+	if i <= 60 {
+		return excerpt(body, 160)
+	}
+	start := i - 60
+	for start < i && !utf8.RuneStart(body[start]) {
+		start++
+	}
+	return "…" + excerpt(body[start:], 160)
+}
+
+// suggestedPageName turns a query into a name /create accepts, e.g.
+// "Dune trailer!" becomes "dune-trailer". Empty if nothing usable is left.
+func suggestedPageName(query string) string {
+	name := strings.ReplaceAll(strings.ToLower(query), " ", "-")
+	return strings.Trim(slugs.CleanName(name), "-")
+}
+
+// createSuggestion offers to create the page for a query that found nothing.
+func createSuggestion(ctx context.Context, query string, results []SearchResult) *searchCreate {
+	name := suggestedPageName(query)
+	if len(results) > 0 || name == "" {
+		return nil
+	}
+	return &searchCreate{Name: name, Endpoint: sitePath(ctx, "/create")}
+}
+
+// recordSearch counts a search and how many pages it found. Stats are only
+// kept for the main site.
+func recordSearch(ctx context.Context, query string, results int) {
+	if query == "" || !siteOf(ctx).main {
+		return
+	}
+	searchStats := &siteOf(ctx).searchStats
+	searchStats.Lock()
+	defer searchStats.Unlock()
+
+	stat, ok := searchStats.queries[query]
+	if !ok {
+		if len(searchStats.queries) >= maxTrackedSearchQueries {
+			searchStats.forgetOldest()
+		}
+		if searchStats.queries == nil {
+			searchStats.queries = make(map[string]*searchQueryStat)
+		}
+		stat = &searchQueryStat{Query: query}
+		searchStats.queries[query] = stat
+	}
+	stat.Count++
+	if results == 0 {
+		stat.ZeroResults++
+	}
+	stat.LastResults = results
+	stat.LastSeen = time.Now().UTC()
+	searchStats.dirty = true
+}
+
+// forgetOldest drops the query searched for longest ago to make room.
+// Callers must hold searchStats.
+func (searchStats *searchStats) forgetOldest() {
+	var oldest *searchQueryStat
+	for _, stat := range searchStats.queries {
+		if oldest == nil || stat.LastSeen.Before(oldest.LastSeen) {
+			oldest = stat
+		}
+	}
+	if oldest != nil {
+		delete(searchStats.queries, oldest.Query)
+	}
+}
+
+// loadSearchStats picks up the counts saved by the last run, in place of any
+// we had.
+func loadSearchStats(ctx context.Context) error {
+	var stats []*searchQueryStat
+	data, err := storeCtx(ctx).ReadFile(searchStatsFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return err
+		}
+	}
+
+	searchStats := &siteOf(ctx).searchStats
+	searchStats.Lock()
+	defer searchStats.Unlock()
+	searchStats.queries = make(map[string]*searchQueryStat, len(stats))
+	searchStats.dirty = false
+	for _, stat := range stats {
+		searchStats.queries[stat.Query] = stat
+	}
+	return nil
+}
+
+// saveSearchStats writes the counts out if anything changed.
+func saveSearchStats(ctx context.Context) error {
+	searchStats := &siteOf(ctx).searchStats
+	searchStats.Lock()
+	if !searchStats.dirty {
+		searchStats.Unlock()
+		return nil
+	}
+	stats := make([]searchQueryStat, 0, len(searchStats.queries))
+	for _, stat := range searchStats.queries {
+		stats = append(stats, *stat)
+	}
+	searchStats.dirty = false
+	searchStats.Unlock()
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return storeCtx(ctx).WriteFile(searchStatsFile, data)
+}
+
+// runSearchStatsSaver saves the search counts every so often, and one last
+// time when ctx is cancelled.
+func (srv *Server) runSearchStatsSaver(ctx context.Context) {
+	ticker := time.NewTicker(searchStatsSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := saveSearchStats(ctx); err != nil {
+				slog.Error("Error saving search stats", "err", err)
+			}
+			return
+		case <-ticker.C:
+			if srv.readOnly() {
+				continue // Kept until we're writable again
+			}
+			if err := saveSearchStats(ctx); err != nil {
+				slog.Error("Error saving search stats", "err", err)
+			}
+		}
+	}
+}
+
+// searchReport is the most searched for queries that currently find
+// nothing, and the most searched for queries overall.
+func searchReport(ctx context.Context) (missing, top []searchQueryStat) {
+	searchStats := &siteOf(ctx).searchStats
+	searchStats.Lock()
+	for _, stat := range searchStats.queries {
+		if stat.LastResults == 0 {
+			missing = append(missing, *stat)
+		}
+		top = append(top, *stat)
+	}
+	searchStats.Unlock()
+
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].ZeroResults != missing[j].ZeroResults {
+			return missing[i].ZeroResults > missing[j].ZeroResults
+		}
+		return missing[i].LastSeen.After(missing[j].LastSeen)
+	})
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].LastSeen.After(top[j].LastSeen)
+	})
+	return missing[:min(len(missing), searchReportQueriesLimit)], top[:min(len(top), searchReportQueriesLimit)]
+}
+
+// searchHandler serves /search?q=..., the search results page.
+func (srv *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := normalizeSearchQuery(r.URL.Query().Get("q"))
+	results, err := searchPages(r.Context(), query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error searching pages", "err", err)
+		http.Error(w, "Could not search pages", http.StatusInternalServerError)
+		return
+	}
+	recordSearch(r.Context(), query, len(results))
+
+	if err := renderTemplate(r.Context(), w, "search.html", buildSearchView(r, query, results)); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing search template", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// searchAPIHandler serves /api/search?q=... as JSON: {"query": "...", "results": [...]},
+// plus a "create" suggestion when nothing was found.
+func (srv *Server) searchAPIHandler(w http.ResponseWriter, r *http.Request) {
+	query := normalizeSearchQuery(r.URL.Query().Get("q"))
+	results, err := searchPages(r.Context(), query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error searching pages", "err", err)
+		http.Error(w, "Could not search pages", http.StatusInternalServerError)
+		return
+	}
+	recordSearch(r.Context(), query, len(results))
+
+	resp := searchResponse{Query: query, Results: results, Create: createSuggestion(r.Context(), query, results)}
+	if resp.Results == nil {
+		resp.Results = []SearchResult{} // [] rather than null
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,177 @@
+package handlers
+
+//Tells search engines when pages are created or updated, via IndexNow and
+//sitemap pings. Changes are queued and sent in batches on a timer, so a burst
+//of edits turns into one request instead of dozens.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// IndexNow accepts at most this many URLs per submission.
+const indexNowBatchLimit = 10000
+
+// If search engines are failing or rate limiting us we back off up to this long.
+const maxSearchPingBackoff = time.Hour
+
+// The HTTP client for talking to other services.
+var outboundClient = &http.Client{Timeout: 10 * time.Second}
+
+// searchPingQueue is the slugs that changed since the last batch went out.
+type searchPingQueue struct {
+	sync.Mutex
+	slugs map[string]bool
+}
+
+// add queues slugs, for the next batch.
+func (q *searchPingQueue) add(slugs ...string) {
+	q.Lock()
+	defer q.Unlock()
+	if q.slugs == nil {
+		q.slugs = make(map[string]bool)
+	}
+	for _, slug := range slugs {
+		q.slugs[slug] = true
+	}
+}
+
+// queueSearchPing marks a page of the main site as changed. It goes out with
+// the next batch.
+func queueSearchPing(ctx context.Context, slug string) {
+	config := configOf(ctx)
+	if !config.SearchPings.Enabled(config.SiteURL) || !siteOf(ctx).main {
+		return
+	}
+	serverOf(ctx).searchPingQueue.add(slug)
+}
+
+// indexNowKeyHandler serves the key file IndexNow fetches to check the site is ours.
+func (srv *Server) indexNowKeyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(srv.config.SearchPings.IndexNowKey))
+}
+
+// runSearchPinger sends queued changes every Interval until ctx is cancelled,
+// then sends whatever is left one last time.
+func (srv *Server) runSearchPinger(ctx context.Context) {
+	interval := srv.config.SearchPings.Interval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := srv.sendSearchPings(); err != nil {
+				slog.Error("Error sending final search engine pings", "err", err)
+			}
+			return
+		case <-timer.C:
+		}
+
+		if err := srv.sendSearchPings(); err != nil {
+			interval = min(interval*2, maxSearchPingBackoff)
+			slog.Error("Error sending search engine pings", "retry_in", interval, "err", err)
+		} else {
+			interval = srv.config.SearchPings.Interval
+		}
+		timer.Reset(interval)
+	}
+}
+
+// sendSearchPings empties the queue and notifies everyone. If a search engine
+// is down or rate limiting us the pages go back on the queue for next time.
+func (srv *Server) sendSearchPings() error {
+	config := &srv.config
+	srv.searchPingQueue.Lock()
+	var slugs []string
+	for slug := range srv.searchPingQueue.slugs {
+		slugs = append(slugs, slug)
+	}
+	srv.searchPingQueue.slugs = nil
+	srv.searchPingQueue.Unlock()
+
+	if len(slugs) == 0 {
+		return nil
+	}
+
+	if config.SearchPings.IndexNowKey != "" {
+		for start := 0; start < len(slugs); start += indexNowBatchLimit {
+			batch := slugs[start:min(start+indexNowBatchLimit, len(slugs))]
+			if err := srv.submitIndexNow(batch); err != nil {
+				// Requeue this batch and everything after it
+				srv.searchPingQueue.add(slugs[start:]...)
+				return err
+			}
+		}
+	}
+
+	// A sitemap ping just says "something changed", one per batch is plenty
+	sitemapURL := config.SiteURL + "/sitemap.xml"
+	for _, endpoint := range config.SearchPings.SitemapPings {
+		resp, err := outboundClient.Get(endpoint + "?sitemap=" + url.QueryEscape(sitemapURL))
+		if err != nil {
+			slog.Warn("Error pinging search engine", "endpoint", endpoint, "err", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			slog.Warn("Sitemap ping failed", "endpoint", endpoint, "response", resp.Status)
+		}
+	}
+
+	slog.Info("Notified search engines about changed pages", "pages", len(slugs))
+	return nil
+}
+
+// submitIndexNow posts a batch of page URLs to IndexNow. Only failures worth
+// retrying are returned, anything else is logged and dropped, since sending
+// the same rejected request again won't help.
+func (srv *Server) submitIndexNow(slugs []string) error {
+	config := &srv.config
+	site, err := url.Parse(config.SiteURL)
+	if err != nil {
+		return fmt.Errorf("bad site URL: %w", err)
+	}
+
+	submission := struct {
+		Host        string   `json:"host"`
+		Key         string   `json:"key"`
+		KeyLocation string   `json:"keyLocation"`
+		URLList     []string `json:"urlList"`
+	}{
+		Host:        site.Host,
+		Key:         config.SearchPings.IndexNowKey,
+		KeyLocation: config.SiteURL + "/" + config.SearchPings.IndexNowKey + ".txt",
+	}
+	for _, slug := range slugs {
+		submission.URLList = append(submission.URLList, config.SiteURL+pagePath(slug))
+	}
+
+	data, err := json.Marshal(submission)
+	if err != nil {
+		return err
+	}
+	resp, err := outboundClient.Post(config.SearchPings.IndexNowURL, "application/json; charset=utf-8", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("IndexNow returned %s", resp.Status)
+	default:
+		slog.Warn("IndexNow rejected URLs", "urls", len(slugs), "response", resp.Status)
+		return nil
+	}
+}
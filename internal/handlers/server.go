@@ -0,0 +1,421 @@
+// Package handlers is the go-trailer site: the Server, whose methods are the
+// handlers for every route, and what they need, from settings and
+// middleware to background jobs. Pages are kept with package storage, their
+// slugs made with package slugs and their videos found with package videos.
+// Package trailer is how other programs get at it.
+package handlers
+
+//The site as an http.Handler. NewServer sets everything up from the
+//settings: sites and templates, plugins, background jobs and every route.
+//The go-trailer command serves it, with TLS, the debug and gRPC listeners
+//and shutdown on a signal around it; ExampleNewServer in package trailer
+//mounts it instead.
+//
+//The Server has its settings and the sites, with their templates, storage
+//and what they keep in memory, and each request's context carries the one
+//it's for. Nothing is shared between Servers, so a process can run several,
+//side by side, against different pages directories say.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-trailer/internal/backups"
+	"go-trailer/internal/config"
+	"go-trailer/internal/plugins"
+	"go-trailer/internal/storage"
+	"go-trailer/internal/tracing"
+)
+
+// Server is the site, ready to serve. Its methods are the handlers, which
+// find the site a request is for (templates, storage and all) in its
+// context.
+type Server struct {
+	config          config.Config
+	main            *site
+	sites           map[string]*site // The others, by host name
+	tenants         tenantSites      // Set up as they're visited
+	mux             *http.ServeMux   // Every route, without the middleware
+	routes          []string         // The patterns on mux, in the order they were registered
+	handler         http.Handler     // mux with it
+	openAPIDoc      []byte           // What the routes are, see openapi.go
+	jobs            sync.WaitGroup
+	stopJobs        context.CancelFunc
+	shutdownTracing func(context.Context) error
+
+	// Made from the settings, see the files of the same names
+	trustedProxies     []netip.Prefix
+	plugins            plugins.Set
+	moderationPipeline []moderator
+	spamFilter         spamScorer
+	challenges         challenges
+
+	// What's going on, see the files of the same names
+	readOnlyState   readOnlyState
+	pagesDirState   pagesDirState
+	accessLog       accessLog
+	backups         *backups.State
+	cdnPurgeQueue   cdnPurgeQueue
+	searchPingQueue searchPingQueue
+	streamsClosed   chan struct{} // Closed by StopStreams
+	shuttingDown    atomic.Bool   // Set once we start shutting down, so load balancers stop sending us traffic
+}
+
+// newServer is a Server with cfg, before anything is set up. Offline
+// commands use one too, for its main site.
+func newServer(cfg config.Config) *Server {
+	srv := &Server{
+		config:        cfg,
+		mux:           http.NewServeMux(),
+		challenges:    newChallenges(),
+		pagesDirState: pagesDirState{since: time.Now()},
+		streamsClosed: make(chan struct{}),
+	}
+	srv.backups = backups.New(&srv.config)
+	return srv
+}
+
+// NewServer sets the site up with cfg and starts its background jobs. Close
+// stops them again.
+func NewServer(cfg config.Config) (_ *Server, err error) {
+	srv := newServer(cfg)
+	// Should anything fail, what was started before it is stopped again
+	defer func() {
+		if err != nil {
+			srv.plugins.Stop()
+			srv.accessLog.close()
+		}
+	}()
+	srv.trustedProxies, _ = config.ParseTrustedProxies(cfg.TrustedProxies) // Already checked by validate
+
+	// Parse every site's templates on startup.
+	if err := srv.setupSites(); err != nil {
+		return nil, fmt.Errorf("parsing templates: %w", err)
+	}
+	if len(cfg.Sites) > 0 {
+		slog.Info("Serving more sites by host name", "sites", len(cfg.Sites))
+	}
+	if cfg.Dev {
+		slog.Info("Development mode: templates are reloaded on every request")
+	}
+
+	// Start any plugins before we take requests, since one may replace storage.
+	if cfg.Features.Plugins {
+		pages, err := srv.plugins.Load(cfg.PluginsDir)
+		if err != nil {
+			return nil, fmt.Errorf("loading plugins: %w", err)
+		}
+		if pages != nil {
+			srv.main.storage = pages
+		}
+	}
+	// Work outside of requests is on the main site.
+	ctx := context.WithValue(context.Background(), siteKey{}, srv.main)
+	// Bring older pages directories up to date before anything reads them.
+	if err := migrateSites(ctx, srv.ownSites()); err != nil {
+		return nil, fmt.Errorf("migrating pages: %w", err)
+	}
+	srv.loadSpamFilter()
+	srv.loadModeration()
+	if cfg.Features.Search {
+		if err := loadSearchStats(ctx); err != nil {
+			slog.Error("Error loading search stats, starting from scratch", "err", err)
+		}
+	}
+	for _, s := range srv.ownSites() {
+		if err := loadViewCounts(context.WithValue(ctx, siteKey{}, s)); err != nil {
+			slog.Error("Error loading view counts, they'll be read again when needed", "site", s.title, "err", err)
+		}
+	}
+	srv.setReadOnly(cfg.Maintenance.ReadOnly, "")
+	if cfg.Maintenance.ReadOnly {
+		slog.Info("Starting read-only")
+	}
+
+	if cfg.AccessLog.Enabled {
+		if err := srv.accessLog.open(cfg.AccessLog); err != nil {
+			return nil, fmt.Errorf("opening access log: %w", err)
+		}
+	}
+
+	if srv.shutdownTracing, err = tracing.Setup(ctx, cfg.Tracing); err != nil {
+		return nil, fmt.Errorf("setting up tracing: %w", err)
+	}
+
+	// Background jobs get their own context. They're only stopped once the
+	// server has finished with in-flight requests, which may still queue work.
+	jobsCtx, stopJobs := context.WithCancel(ctx)
+	srv.stopJobs = stopJobs
+	// Unless a plugin took over storage, keep an eye on the pages directory.
+	// A broken one at startup isn't fatal, we serve a status page until it's back.
+	if _, ok := srv.main.storage.(storage.Dir); ok {
+		srv.updatePagesDirState(cfg.PagesDir, checkPagesDir(cfg.PagesDir))
+		srv.jobs.Add(1)
+		go func() {
+			defer srv.jobs.Done()
+			srv.watchPagesDir(jobsCtx, cfg.PagesDir)
+		}()
+	}
+
+	if cfg.AccessLog.Enabled {
+		srv.jobs.Add(1)
+		go func() {
+			defer srv.jobs.Done()
+			srv.accessLog.reopenOnHUP(jobsCtx, cfg.AccessLog)
+		}()
+	}
+
+	if cfg.Features.Search {
+		srv.jobs.Add(1)
+		go func() {
+			defer srv.jobs.Done()
+			srv.runSearchStatsSaver(jobsCtx)
+		}()
+	}
+
+	srv.jobs.Add(1)
+	go func() {
+		defer srv.jobs.Done()
+		srv.runViewCountsSaver(jobsCtx)
+	}()
+
+	srv.jobs.Add(1)
+	go func() {
+		defer srv.jobs.Done()
+		srv.runPageScheduler(jobsCtx)
+	}()
+
+	srv.jobs.Add(1)
+	go func() {
+		defer srv.jobs.Done()
+		srv.runTrashPurger(jobsCtx)
+	}()
+
+	srv.jobs.Add(1)
+	go func() {
+		defer srv.jobs.Done()
+		srv.runVoteCompactor(jobsCtx)
+	}()
+
+	if cfg.Backups.Enabled() {
+		srv.jobs.Add(1)
+		go func() {
+			defer srv.jobs.Done()
+			srv.backups.Run(jobsCtx)
+		}()
+	}
+
+	if cfg.CDN.Purging() {
+		srv.jobs.Add(1)
+		go func() {
+			defer srv.jobs.Done()
+			srv.runCDNPurger(jobsCtx)
+		}()
+	}
+
+	if cfg.SearchPings.Enabled(cfg.SiteURL) {
+		srv.jobs.Add(1)
+		go func() {
+			defer srv.jobs.Done()
+			srv.runSearchPinger(jobsCtx)
+		}()
+	}
+
+	// --- Register our HTTP handlers ---
+	// On a mux of our own rather than http.DefaultServeMux, which anything
+	// (net/http/pprof for one) can add handlers to behind our back.
+	mux := srv.mux
+
+	// 1. The Homepage, only; anything no pattern matches is a 404, or a 405
+	// if it's there for another method:
+	srv.handle("/{$}", srv.indexHandler)
+
+	// 2. The dynamic page viewer. Note the trailing slash!
+	// This tells the router to send all requests starting with /page/ to this handler.
+	srv.handle("/page/", srv.pageViewHandler)
+	srv.handle("GET /share/{token}", srv.shareViewHandler)
+	srv.handle("/archive/", srv.archiveHandler)
+
+	// 3. The API endpoint to create a new page, and the challenge to answer
+	// first if there is one:
+	srv.handle("/create", requireLogin(withTimeout(withIdempotency(srv.createPageHandler))))
+	srv.handle("POST /api/pages", requireLogin(withTimeout(withIdempotency(srv.createPageHandler))))
+	if cfg.PageCreation.Challenge == "pow" {
+		srv.handle("GET /api/challenge", srv.challengeHandler)
+	}
+
+	// 4. A file server to serve our static CSS file (each site has its own)
+	srv.handle("/static/", srv.staticHandler)
+
+	// 5. The API endpoints to save a YouTube link for a page, its player
+	// settings, and the rest of what can be done to one. Paths without a
+	// method take several and check for themselves:
+	pageAPI := func(h http.HandlerFunc) http.HandlerFunc { return requireLogin(withTimeout(withIdempotency(h))) }
+	srv.handle("GET /api/page/{slug}", srv.pageAPIHandler)
+	srv.handle("POST /api/page/{slug}/save-youtube", pageAPI(srv.youtubeSaveHandler))
+	srv.handle("POST /api/page/{slug}/embed", pageAPI(srv.embedSettingsHandler))
+	srv.handle("POST /api/page/{slug}/publish", pageAPI(srv.publishHandler))
+	srv.handle("POST /api/page/{slug}/unpublish", pageAPI(srv.publishHandler))
+	srv.handle("POST /api/page/{slug}/schedule", pageAPI(srv.scheduleHandler))
+	srv.handle("POST /api/page/{slug}/expire", pageAPI(srv.expireHandler))
+	srv.handle("POST /api/page/{slug}/access", pageAPI(srv.accessHandler))
+	srv.handle("/api/page/{slug}/shares", pageAPI(srv.sharesHandler))
+	srv.handle("DELETE /api/page/{slug}/shares/{id}", pageAPI(srv.sharesHandler))
+	srv.handle("POST /api/page/{slug}/rename", pageAPI(srv.renameHandler))
+	srv.handle("GET /api/page/{slug}/source", pageAPI(srv.sourceHandler))
+	srv.handle("POST /api/page/{slug}/edit", pageAPI(srv.editHandler))
+	srv.handle("/api/page/{slug}/lock", pageAPI(srv.lockHandler))
+	srv.handle("POST /api/page/{slug}/unlock", pageAPI(srv.lockHandler))
+	srv.handle("/api/page/{slug}/attachments", pageAPI(srv.attachmentsHandler))
+	srv.handle("DELETE /api/page/{slug}/attachments/{name}", pageAPI(srv.attachmentsHandler))
+
+	// 6. The API endpoints for upvoting/downvoting YouTube videos, one or many at a time:
+	srv.handle("POST /api/vote/{slug}/{videoID}/{action}", requireLogin(withTimeout(srv.youtubeVoteHandler)))
+	srv.handle("POST /api/vote/batch", requireLogin(withTimeout(srv.voteBatchHandler)))
+
+	// 7. Crawler rules:
+	srv.handle("/robots.txt", srv.robotsHandler)
+
+	// 8. An Atom feed of recently created/updated pages, and the sitemap:
+	if cfg.Features.Feeds {
+		srv.handle("/feed.xml", srv.feedHandler)
+		srv.handle("/sitemap.xml", srv.sitemapHandler)
+	}
+
+	// 9. The key file IndexNow uses to verify us:
+	if cfg.SearchPings.IndexNowKey != "" {
+		srv.handle("/"+cfg.SearchPings.IndexNowKey+".txt", srv.indexNowKeyHandler)
+	}
+
+	// 10. Comments, and the admin page for moderating them:
+	if cfg.Features.Comments {
+		srv.handle("/api/comments/", requireLogin(withTimeout(srv.commentPostHandler)))
+		srv.handle("/admin/comments", requireAdmin(srv.adminCommentsHandler))
+	}
+
+	// 11. Search, and the report of what people searched for:
+	if cfg.Features.Search {
+		srv.handle("/search", srv.searchHandler)
+		srv.handle("/api/search", srv.searchAPIHandler)
+		srv.handle("/admin/search", requireAdmin(srv.adminSearchHandler))
+	}
+
+	// 12. JSON lists for apps, paged with cursors:
+	srv.handle("/api/pages", srv.pagesAPIHandler)
+	srv.handle("/api/changes", srv.changesAPIHandler)
+	srv.handle("/api/videos", srv.videosAPIHandler)
+	if cfg.Features.Comments {
+		srv.handle("/api/comments", srv.commentsAPIHandler)
+	}
+
+	// 13. Probes for load balancers:
+	srv.handle("/healthz", srv.healthzHandler)
+	srv.handle("/readyz", srv.readyzHandler)
+
+	// 14. Aliases and rename redirects, managed by hand:
+	srv.handle("/admin/aliases", requireAdmin(srv.adminAliasesHandler))
+
+	// 15. The most viewed pages:
+	srv.handle("/popular", srv.popularHandler)
+	srv.handle("/api/popular", srv.popularAPIHandler)
+
+	// 16. Purging the CDN by hand:
+	if cfg.CDN.Purging() {
+		srv.handle("/admin/cdn/purge", requireAdmin(srv.adminPurgeHandler))
+	}
+
+	// 17. Previews of page text for the editor:
+	srv.handle("/api/preview", requireLogin(withTimeout(srv.previewHandler)))
+
+	// 18. Who's editing what, and breaking their locks:
+	srv.handle("/admin/locks", requireAdmin(srv.adminLocksHandler))
+
+	// 19. A live stream of what's happening on the site:
+	srv.handle("/events", srv.eventsHandler)
+
+	// 20. Listing pages and bulk housekeeping:
+	srv.handle("/admin/pages", requireAdmin(srv.adminPagesHandler))
+
+	// 21. Switching read-only mode, for backups:
+	srv.handle("/admin/read-only", requireAdmin(srv.adminReadOnlyHandler))
+
+	// 22. Deleted pages, and putting them back:
+	srv.handle("/admin/trash", requireAdmin(srv.adminTrashHandler))
+	srv.handle("/admin/trash/", requireAdmin(srv.adminTrashHandler))
+
+	// 23. The whole site as a zip:
+	srv.handle("/admin/export", requireAdmin(srv.adminExportHandler))
+
+	// 24. Pages from a zip of Markdown, the other way:
+	srv.handle("/admin/import", requireAdmin(srv.adminImportHandler))
+
+	// 25. Backups, how they're going and taking one now:
+	srv.handle("/admin/backups", requireAdmin(srv.adminBackupsHandler))
+
+	// 26. Checking the pages' files hang together, and removing orphans:
+	srv.handle("/admin/fsck", requireAdmin(srv.adminFsckHandler))
+
+	// 27. Addresses that may not change anything:
+	srv.handle("/admin/blocklist", requireAdmin(srv.adminBlocklistHandler))
+
+	// 28. The JSON API under /api/v1/, the paths above it started out with
+	// still answering too:
+	srv.handle("/api/v1/", srv.apiV1Handler)
+
+	// 29. What the JSON API has, for tools and client generators:
+	srv.handle("/api/openapi.json", srv.openAPIHandler)
+
+	// 30. Pages, videos, votes and search as a graph, for apps fetching
+	// several at once:
+	srv.handle("/graphql", srv.graphqlHandler)
+
+	if srv.openAPIDoc, err = json.Marshal(srv.buildOpenAPI()); err != nil {
+		return nil, fmt.Errorf("describing the API: %w", err)
+	}
+	srv.handler = srv.withBasePath(srv.withSite(withTracing(withRequestLog(srv.withAccessLog(srv.withCORS(srv.withCDNHeaders(rejectBlockedWrites(srv.rejectWritesWhenReadOnly(srv.degradeWithoutPages(deprecateOldAPIPaths(withErrorPages(mux))))))))), mux)))
+	return srv, nil
+}
+
+// handle registers handler for pattern on the mux, noting the route for the
+// API document.
+func (srv *Server) handle(pattern string, handler http.HandlerFunc) {
+	srv.mux.HandleFunc(pattern, handler)
+	srv.routes = append(srv.routes, pattern)
+}
+
+// ServeHTTP serves a request for the site.
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.handler.ServeHTTP(w, r)
+}
+
+// Mux is every route, without the middleware that picks the site, logs and
+// so on. Handle more routes on it before serving to add to the site.
+func (srv *Server) Mux() *http.ServeMux {
+	return srv.mux
+}
+
+// StopStreams ends the /events and live vote streams, which would otherwise
+// keep an http.Server shutting down waiting, and has /readyz say we're
+// shutting down. Register it with RegisterOnShutdown.
+func (srv *Server) StopStreams() {
+	srv.shuttingDown.Store(true)
+	close(srv.streamsClosed)
+}
+
+// Close stops the background jobs, letting them save what they have, then
+// the plugins and tracing. Call it once requests are done with, after the
+// http.Server has shut down.
+func (srv *Server) Close(ctx context.Context) error {
+	srv.stopJobs()
+	srv.jobs.Wait()
+	srv.plugins.Stop()
+	srv.accessLog.close()
+	return srv.shutdownTracing(ctx)
+}
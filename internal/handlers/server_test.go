@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-trailer/internal/config"
+)
+
+// testConfig is the default config with a pages directory of its own, the
+// templates and static files at the top of the repo, and no plugins, so
+// anyone can change the site.
+func testConfig(t *testing.T) config.Config {
+	cfg := config.Default()
+	cfg.PagesDir = t.TempDir()
+	cfg.TemplatesDir = filepath.Join("../..", cfg.TemplatesDir)
+	cfg.StaticDir = filepath.Join("../..", cfg.StaticDir)
+	cfg.Features.Plugins = false
+	return cfg
+}
+
+// NewTestServer starts the site from testConfig, stopping it when the test
+// is done.
+func NewTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return startTestServer(t, testConfig(t))
+}
+
+// startTestServer starts the site with cfg, stopping it when the test is done.
+func startTestServer(t *testing.T, cfg config.Config) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(newTestServer(t, cfg))
+	t.Cleanup(ts.Close) // Before the Server is closed
+	return ts
+}
+
+// newTestServer sets the site up with cfg, closing it when the test is done.
+func newTestServer(t *testing.T, cfg config.Config) *Server {
+	t.Helper()
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := srv.Close(context.Background()); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+	return srv
+}
+
+// send makes a request to the test server, failing the test if it can't.
+// The body, if there is one, is sent as JSON.
+func send(t *testing.T, ts *httptest.Server, method, path, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// wantStatus fails the test unless resp has the status code.
+func wantStatus(t *testing.T, resp *http.Response, code int) {
+	t.Helper()
+	if resp.StatusCode != code {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("%s %s = %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, code, body)
+	}
+}
+
+// getPageJSON fetches a page from the JSON API.
+func getPageJSON(t *testing.T, ts *httptest.Server, slug string) pageJSON {
+	t.Helper()
+	resp := send(t, ts, "GET", "/api/v1/pages/"+slug, "")
+	wantStatus(t, resp, http.StatusOK)
+	var page pageJSON
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatalf("decoding page: %v", err)
+	}
+	return page
+}
+
+func TestCreatePage(t *testing.T) {
+	ts := NewTestServer(t)
+
+	resp := send(t, ts, "POST", "/create", `{"name": "Go Talks"}`)
+	wantStatus(t, resp, http.StatusOK) // After following the redirect
+	if got := resp.Request.URL.Path; got != "/page/go-talks" {
+		t.Errorf("created page is at %s, want /page/go-talks", got)
+	}
+
+	resp = send(t, ts, "POST", "/api/v1/pages", `{"name": "Go Tools"}`)
+	wantStatus(t, resp, http.StatusCreated)
+	if got := resp.Header.Get("Location"); got != "/api/v1/pages/go-tools" {
+		t.Errorf("Location = %q, want /api/v1/pages/go-tools", got)
+	}
+
+	resp = send(t, ts, "POST", "/create", `{"name": ""}`)
+	wantStatus(t, resp, http.StatusBadRequest)
+}
+
+func TestViewPage(t *testing.T) {
+	ts := NewTestServer(t)
+	wantStatus(t, send(t, ts, "POST", "/create", `{"name": "Go Talks"}`), http.StatusOK)
+
+	resp := send(t, ts, "GET", "/page/go-talks", "")
+	wantStatus(t, resp, http.StatusOK)
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "Go Talks") {
+		t.Errorf("page doesn't show its title:\n%s", body)
+	}
+
+	if page := getPageJSON(t, ts, "go-talks"); page.Title != "Go Talks" {
+		t.Errorf("title = %q, want Go Talks", page.Title)
+	}
+
+	resp = send(t, ts, "GET", "/", "")
+	wantStatus(t, resp, http.StatusOK)
+	body, _ = io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "/page/go-talks") {
+		t.Errorf("home page doesn't list the page:\n%s", body)
+	}
+
+	wantStatus(t, send(t, ts, "GET", "/page/no-such-page", ""), http.StatusNotFound)
+}
+
+func TestSaveVideo(t *testing.T) {
+	ts := NewTestServer(t)
+	wantStatus(t, send(t, ts, "POST", "/create", `{"name": "Go Talks"}`), http.StatusOK)
+
+	resp := send(t, ts, "POST", "/api/page/go-talks/save-youtube", `{"youtube_url": "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}`)
+	wantStatus(t, resp, http.StatusOK)
+	page := getPageJSON(t, ts, "go-talks")
+	if len(page.Videos) != 1 || page.Videos[0].ID != "dQw4w9WgXcQ" {
+		t.Fatalf("videos = %+v, want just dQw4w9WgXcQ", page.Videos)
+	}
+
+	resp = send(t, ts, "POST", "/api/page/go-talks/save-youtube", `{"youtube_url": "https://example.com/video"}`)
+	wantStatus(t, resp, http.StatusBadRequest)
+	if page := getPageJSON(t, ts, "go-talks"); len(page.Videos) != 1 {
+		t.Errorf("a link that isn't YouTube was saved: %+v", page.Videos)
+	}
+}
+
+func TestVote(t *testing.T) {
+	ts := NewTestServer(t)
+	wantStatus(t, send(t, ts, "POST", "/create", `{"name": "Go Talks"}`), http.StatusOK)
+	for _, id := range []string{"dQw4w9WgXcQ", "oHg5SJYRHA0"} {
+		resp := send(t, ts, "POST", "/api/page/go-talks/save-youtube", `{"youtube_url": "https://youtu.be/`+id+`"}`)
+		wantStatus(t, resp, http.StatusOK)
+	}
+
+	for _, action := range []string{"upvote", "upvote", "downvote"} {
+		wantStatus(t, send(t, ts, "POST", "/api/vote/go-talks/oHg5SJYRHA0/"+action, ""), http.StatusOK)
+	}
+	wantStatus(t, send(t, ts, "POST", "/api/vote/go-talks/dQw4w9WgXcQ/downvote", ""), http.StatusOK)
+
+	page := getPageJSON(t, ts, "go-talks")
+	if len(page.Videos) != 2 {
+		t.Fatalf("videos = %+v, want two", page.Videos)
+	}
+	// Most voted first
+	if v := page.Videos[0]; v.ID != "oHg5SJYRHA0" || v.Votes != 1 {
+		t.Errorf("first video = %+v, want oHg5SJYRHA0 with 1 vote", v)
+	}
+	if v := page.Videos[1]; v.ID != "dQw4w9WgXcQ" || v.Votes != -1 {
+		t.Errorf("second video = %+v, want dQw4w9WgXcQ with -1 votes", v)
+	}
+
+	wantStatus(t, send(t, ts, "POST", "/api/vote/go-talks/oHg5SJYRHA0/sideways", ""), http.StatusBadRequest)
+	wantStatus(t, send(t, ts, "POST", "/api/vote/go-talks/%3Cscript%3E/upvote", ""), http.StatusBadRequest)
+	wantStatus(t, send(t, ts, "POST", "/api/vote/no-such-page/oHg5SJYRHA0/upvote", ""), http.StatusNotFound)
+	if page := getPageJSON(t, ts, "go-talks"); len(page.Videos) != 2 {
+		t.Errorf("a vote for a video that can't be one was kept: %+v", page.Videos)
+	}
+}
+
+func TestServersKeepApart(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Maintenance.ReadOnly = true
+	readOnly := startTestServer(t, cfg)
+	ts := NewTestServer(t)
+
+	wantStatus(t, send(t, readOnly, "POST", "/create", `{"name": "Go Talks"}`), http.StatusServiceUnavailable)
+	wantStatus(t, send(t, ts, "POST", "/create", `{"name": "Go Talks"}`), http.StatusOK)
+	wantStatus(t, send(t, readOnly, "GET", "/page/go-talks", ""), http.StatusNotFound)
+}
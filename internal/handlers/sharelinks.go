@@ -0,0 +1,347 @@
+package handlers
+
+//Share links: secret /share/{token} links that let anyone holding one read a
+//draft or private page, without an account, so it can be reviewed before
+//it's published. Whoever created the page, or an admin, makes them with
+//POST /api/page/{slug}/shares, and each one lasts until its expires_at
+//(a week unless asked otherwise) or until it's revoked.
+//
+//Each site keeps its links in shares.json in its store. Only a hash of each
+//token is kept, so the token itself is only ever seen once, in the answer to
+//the POST; links are listed and revoked by their id.
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const sharesFile = "shares.json"
+
+// How long a share link lasts when no expires_at is asked for.
+const defaultShareLifetime = 7 * 24 * time.Hour
+
+// shareLink is a share link as kept in shares.json.
+type shareLink struct {
+	ID        string    `json:"id"`
+	Hash      string    `json:"hash"` // SHA-256 of the token, in hex
+	Slug      string    `json:"slug"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires_at"`
+}
+
+// shareLinkJSON is a share link as the API shows it. The token and URL are
+// only there when it's just been made.
+type shareLinkJSON struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires_at"`
+}
+
+// shareRequest is the body of POST /api/page/{slug}/shares.
+type shareRequest struct {
+	ExpiresAt string `json:"expires_at"` // When the link stops working, a week from now if empty
+}
+
+// shares is a site's share links, read from its store the first time
+// they're needed. Changes are written straight back.
+type shares struct {
+	sync.Mutex
+	links  []shareLink
+	loaded bool
+}
+
+// hashShareToken is how a token is kept in shares.json.
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// siteShares is the site's share links, without any that have expired.
+// Callers must hold its lock.
+func siteShares(ctx context.Context) ([]shareLink, error) {
+	shares := &siteOf(ctx).shares
+	links := shares.links
+	if !shares.loaded {
+		data, err := storeCtx(ctx).ReadFile(sharesFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &links); err != nil {
+				return nil, fmt.Errorf("%s: %w", sharesFile, err)
+			}
+		}
+	}
+	now := time.Now()
+	links = slices.DeleteFunc(links, func(l shareLink) bool { return !now.Before(l.Expires) })
+	shares.links, shares.loaded = links, true
+	return links, nil
+}
+
+// saveShares writes the site's share links out as links. Callers must hold
+// their lock.
+func saveShares(ctx context.Context, links []shareLink) error {
+	data, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storeCtx(ctx).WriteFile(sharesFile, data); err != nil {
+		return err
+	}
+	siteOf(ctx).shares.links = links
+	return nil
+}
+
+// createShareLink makes a new link to the page, answering with its token.
+func createShareLink(ctx context.Context, slug, createdBy string, expires time.Time) (shareLink, string, error) {
+	token := rand.Text()
+	link := shareLink{
+		ID:        rand.Text()[:10],
+		Hash:      hashShareToken(token),
+		Slug:      slug,
+		CreatedBy: createdBy,
+		Created:   time.Now().UTC().Truncate(time.Second),
+		Expires:   expires,
+	}
+	shares := &siteOf(ctx).shares
+	shares.Lock()
+	defer shares.Unlock()
+	links, err := siteShares(ctx)
+	if err != nil {
+		return shareLink{}, "", err
+	}
+	return link, token, saveShares(ctx, append(slices.Clone(links), link))
+}
+
+// pageShareLinks is the page's share links, oldest first.
+func pageShareLinks(ctx context.Context, slug string) ([]shareLink, error) {
+	shares := &siteOf(ctx).shares
+	shares.Lock()
+	defer shares.Unlock()
+	links, err := siteShares(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var found []shareLink
+	for _, l := range links {
+		if l.Slug == slug {
+			found = append(found, l)
+		}
+	}
+	return found, nil
+}
+
+// revokeShareLink takes the page's link with that id away, reporting whether
+// there was one.
+func revokeShareLink(ctx context.Context, slug, id string) (bool, error) {
+	shares := &siteOf(ctx).shares
+	shares.Lock()
+	defer shares.Unlock()
+	links, err := siteShares(ctx)
+	if err != nil {
+		return false, err
+	}
+	kept := slices.DeleteFunc(slices.Clone(links), func(l shareLink) bool { return l.Slug == slug && l.ID == id })
+	if len(kept) == len(links) {
+		return false, nil
+	}
+	return true, saveShares(ctx, kept)
+}
+
+// sharedSlug is the page a token is a link to, if it's one that still works.
+func sharedSlug(ctx context.Context, token string) (string, bool, error) {
+	hash := hashShareToken(token)
+	shares := &siteOf(ctx).shares
+	shares.Lock()
+	defer shares.Unlock()
+	links, err := siteShares(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	for _, l := range links {
+		if l.Hash == hash {
+			return l.Slug, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// renameShareLinks moves a page's share links over to its new slug.
+func renameShareLinks(ctx context.Context, from, to string) error {
+	shares := &siteOf(ctx).shares
+	shares.Lock()
+	defer shares.Unlock()
+	links, err := siteShares(ctx)
+	if err != nil || !slices.ContainsFunc(links, func(l shareLink) bool { return l.Slug == from }) {
+		return err
+	}
+	links = slices.Clone(links)
+	for i := range links {
+		if links[i].Slug == from {
+			links[i].Slug = to
+		}
+	}
+	return saveShares(ctx, links)
+}
+
+// toJSON is the link as the API shows it.
+func (l shareLink) toJSON() shareLinkJSON {
+	return shareLinkJSON{ID: l.ID, CreatedBy: l.CreatedBy, Created: l.Created, Expires: l.Expires}
+}
+
+// sharesHandler handles /api/page/{slug}/shares: GET lists the page's share
+// links, POST with a shareRequest makes one, and DELETE
+// /api/page/{slug}/shares/{id} revokes one. Only whoever created the page,
+// or an admin, can.
+func (srv *Server) sharesHandler(w http.ResponseWriter, r *http.Request) {
+	slug := filepath.Base(r.PathValue("slug"))
+	setLogSlug(r, slug)
+	if !pageExists(r.Context(), slug) {
+		apiError(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	meta, err := loadPageMeta(r.Context(), slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading page meta", "err", err)
+		apiError(w, "Could not read the page", http.StatusInternalServerError)
+		return
+	}
+	if restrictedPageError(w, r, meta) {
+		return
+	}
+	if !canSeeDraft(r, meta) {
+		apiError(w, "Only whoever created the page, or an admin, can do that", http.StatusForbidden)
+		return
+	}
+
+	id := r.PathValue("id")
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		links, err := pageShareLinks(r.Context(), slug)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading share links", "err", err)
+			apiError(w, "Could not list share links", http.StatusInternalServerError)
+			return
+		}
+		list := []shareLinkJSON{} // [] rather than null
+		for _, l := range links {
+			list = append(list, l.toJSON())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(list)
+
+	case r.Method == http.MethodPost && id == "":
+		var reqBody shareRequest
+		if err := readJSON(w, r, &reqBody); err != nil {
+			badJSON(w, err)
+			return
+		}
+		expires, err := parseExpiresAt(reqBody.ExpiresAt)
+		if err != nil {
+			fieldError(w, "expires_at", "invalid_time", err.Error())
+			return
+		}
+		if expires.IsZero() {
+			expires = time.Now().Add(defaultShareLifetime).UTC().Truncate(time.Second)
+		}
+		if !meta.restricted(time.Now()) {
+			writeProblem(w, http.StatusConflict, "not_restricted", "Page is public already, share its own link")
+			return
+		}
+		link, token, err := createShareLink(r.Context(), slug, editorName(r), expires)
+		if err != nil {
+			if quotaError(w, err) {
+				return
+			}
+			slog.ErrorContext(r.Context(), "Error saving share links", "err", err)
+			apiError(w, "Could not make a share link", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "Share link made", "id", link.ID, "until", expires)
+		created := link.toJSON()
+		created.Token = token
+		created.URL = siteBaseURL(r) + "/share/" + token
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+
+	case r.Method == http.MethodDelete && id != "":
+		revoked, err := revokeShareLink(r.Context(), slug, id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error saving share links", "err", err)
+			apiError(w, "Could not revoke the share link", http.StatusInternalServerError)
+			return
+		}
+		if !revoked {
+			apiError(w, "No such share link", http.StatusNotFound)
+			return
+		}
+		slog.InfoContext(r.Context(), "Share link revoked", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+	}
+}
+
+// shareViewHandler handles GET /share/{token}, showing the page the link is
+// for to whoever has it, read-only and without its comments. Once the page is public the link just
+// leads to it.
+func (srv *Server) shareViewHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	slug, ok, err := sharedSlug(r.Context(), token)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading share links", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "This link has expired or been revoked", http.StatusNotFound)
+		return
+	}
+	setLogSlug(r, slug)
+	page, err := loadPage(r.Context(), slug)
+	if err != nil {
+		slog.InfoContext(r.Context(), "Shared page not found", "err", err)
+		http.NotFound(w, r)
+		return
+	}
+	if !page.Draft && !page.Private {
+		http.Redirect(w, r, sitePath(r.Context(), page.path()), http.StatusFound)
+		return
+	}
+
+	view := buildPageView(r, page, CommentList{})
+	view.Shared = true
+	var html bytes.Buffer
+	if err := renderTemplate(r.Context(), &html, "page.html", view); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing page template", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Set("Cache-Control", "private, no-store") // Never keep it in the CDN
+	h.Set("X-Robots-Tag", "noindex, nofollow")  // Nor in a search engine, should the link get out
+	h.Set("Referrer-Policy", "no-referrer")     // Nor in the logs of sites it links to
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(html.Bytes())
+}
@@ -0,0 +1,250 @@
+package handlers
+
+//Shortcodes: {{name args...}} in a page's text, expanded when it's rendered.
+//They're for things pages can't do with the HTML they're allowed, like
+//
+//	{{youtube dQw4w9WgXcQ}}  a player, right there in the text
+//	{{gallery holidays}}     every picture in static/holidays
+//	{{toc}}                  the table of contents, instead of the sidebar one
+//	{{include other-page}}   another page's text, for bits many pages share
+//
+//What they make is trusted, so it's put in after the page is sanitized, but
+//only where the shortcode was in the text: one in an attribute stays as
+//written. Unknown shortcodes are left as they are.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go-trailer/internal/slugs"
+	"go-trailer/internal/videos"
+
+	"golang.org/x/net/html"
+)
+
+// A shortcode: {{name}} or {{name arg1 arg2}}.
+var shortcodeRegex = regexp.MustCompile(`\{\{\s*([a-z]+)((?:\s+[^\s{}]+)*)\s*\}\}`)
+
+// Where a shortcode goes until it's expanded: private use characters no page
+// has any reason to contain, around its number.
+const (
+	shortcodeOpen  = "\uE000"
+	shortcodeClose = "\uE001"
+)
+
+// shortcodeFunc makes the HTML for a shortcode on a page.
+type shortcodeFunc func(ctx context.Context, page *Page, args []string) (string, error)
+
+// shortcodes is every shortcode there is, by name.
+var shortcodes map[string]shortcodeFunc
+
+func init() {
+	// Set here, as include renders pages, which looks up shortcodes
+	shortcodes = map[string]shortcodeFunc{
+		"youtube": youtubeShortcode,
+		"gallery": galleryShortcode,
+		"toc":     tocShortcode,
+		"include": includeShortcode,
+	}
+}
+
+// How deep includes can go: a page including a page including a page.
+const maxIncludeDepth = 3
+
+// includeKey holds the pages being included into, outermost first.
+type includeKey struct{}
+
+// Gallery pictures are the files in the directory with these extensions.
+var galleryExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".avif"}
+
+// shortcodeCall is a shortcode found in a page, waiting to be expanded.
+type shortcodeCall struct {
+	source string // As written, to put back if it fails
+	name   string
+	fn     shortcodeFunc
+	args   []string
+}
+
+// extractShortcodes swaps a page's shortcodes for placeholders, returning
+// the text and the shortcodes in order. Whatever looks like a placeholder in
+// the text already is taken out first, so only real shortcodes expand.
+func extractShortcodes(body string) (string, []shortcodeCall) {
+	var calls []shortcodeCall
+	body = strings.NewReplacer(shortcodeOpen, "", shortcodeClose, "").Replace(body)
+	body = shortcodeRegex.ReplaceAllStringFunc(body, func(source string) string {
+		m := shortcodeRegex.FindStringSubmatch(source)
+		fn, ok := shortcodes[m[1]]
+		if !ok {
+			return source
+		}
+		calls = append(calls, shortcodeCall{source: source, name: m[1], fn: fn, args: strings.Fields(m[2])})
+		return shortcodeOpen + strconv.Itoa(len(calls)-1) + shortcodeClose
+	})
+	return body, calls
+}
+
+// A placeholder left by extractShortcodes.
+var shortcodePlaceholderRegex = regexp.MustCompile(shortcodeOpen + `(\d+)` + shortcodeClose)
+
+// expandShortcodes puts what each shortcode makes in place of its
+// placeholder, in the sanitized HTML. Only placeholders in text are
+// expanded: one in an attribute gets its shortcode back as plain text, as
+// HTML there would break out of the attribute.
+func expandShortcodes(ctx context.Context, page *Page, rendered string, calls []shortcodeCall) string {
+	if len(calls) == 0 {
+		return rendered
+	}
+	source := func(placeholder string) string {
+		n, _ := strconv.Atoi(shortcodePlaceholderRegex.FindStringSubmatch(placeholder)[1])
+		if n >= len(calls) {
+			return ""
+		}
+		return calls[n].source
+	}
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(rendered))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		raw := string(z.Raw())
+		switch {
+		case !strings.Contains(raw, shortcodeOpen):
+			b.WriteString(raw)
+		case tt == html.TextToken:
+			b.WriteString(shortcodePlaceholderRegex.ReplaceAllStringFunc(raw, func(placeholder string) string {
+				n, _ := strconv.Atoi(shortcodePlaceholderRegex.FindStringSubmatch(placeholder)[1])
+				if n >= len(calls) {
+					return ""
+				}
+				call := calls[n]
+				out, err := call.fn(ctx, page, call.args)
+				if err != nil {
+					slog.ErrorContext(ctx, "Error expanding shortcode", "page", page.Slug, "shortcode", call.source, "err", err)
+					return html.EscapeString(call.source)
+				}
+				return out
+			}))
+		default:
+			t := z.Token()
+			for i := range t.Attr {
+				t.Attr[i].Val = shortcodePlaceholderRegex.ReplaceAllStringFunc(t.Attr[i].Val, source)
+			}
+			b.WriteString(t.String())
+		}
+	}
+	return b.String()
+}
+
+// youtubeShortcode is {{youtube id}}, or {{youtube url}}: a player for the
+// video, with the page's embed settings.
+func youtubeShortcode(ctx context.Context, page *Page, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("expected a video ID or URL")
+	}
+	videoID := args[0]
+	if strings.Contains(videoID, "/") {
+		_, videoID = videos.Parse(videoID)
+	}
+	if !videos.ValidID(videoID) {
+		return "", fmt.Errorf("bad video ID %q", args[0])
+	}
+	embed := configOf(ctx).YouTubeEmbed
+	if page.Slug != "" { // Previews can be of no page in particular
+		meta, err := loadPageMeta(ctx, page.Slug)
+		if err != nil {
+			return "", err
+		}
+		embed = withPageEmbed(embed, meta.Embed)
+	}
+	src := embedURL(embed, videoID)
+	return `<div class="youtube-embed"><iframe width="560" height="315" src="` + html.EscapeString(src) +
+		`" title="YouTube video player" frameborder="0" allow="accelerometer; autoplay; clipboard-write; encrypted-media; gyroscope; picture-in-picture" allowfullscreen></iframe></div>`, nil
+}
+
+// galleryShortcode is {{gallery dir}}: thumbnails of every picture in the
+// site's static/dir, each linking to the full size one.
+func galleryShortcode(ctx context.Context, page *Page, args []string) (string, error) {
+	if len(args) != 1 || !fs.ValidPath(args[0]) || args[0] == "." {
+		return "", errors.New("expected a directory under static")
+	}
+	entries, err := os.ReadDir(filepath.Join(siteOf(ctx).staticDir, filepath.FromSlash(args[0])))
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(`<div class="gallery">`)
+	for _, entry := range entries {
+		ext := strings.ToLower(path.Ext(entry.Name()))
+		if entry.IsDir() || !slices.Contains(galleryExtensions, ext) {
+			continue
+		}
+		src := html.EscapeString(sitePath(ctx, "/static/"+urlPath(args[0])+"/"+url.PathEscape(entry.Name())))
+		fmt.Fprintf(&b, `<a href="%s"><img src="%s" alt="%s" loading="lazy"></a>`, src, src, html.EscapeString(entry.Name()))
+	}
+	b.WriteString(`</div>`)
+	return b.String(), nil
+}
+
+// urlPath escapes each part of a slash separated path.
+func urlPath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// includeShortcode is {{include other-page}}: the other page's rendered text.
+// A page can't end up including itself, and includes only go so deep.
+func includeShortcode(ctx context.Context, page *Page, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("expected a page to include")
+	}
+	slug := slugs.FromName(args[0])
+	if target, ok := redirectTarget(ctx, slug); ok {
+		slug = target
+	}
+	chain, _ := ctx.Value(includeKey{}).([]string)
+	chain = append(slices.Clip(chain), page.Slug)
+	if slices.Contains(chain, slug) {
+		return "", fmt.Errorf("%s includes itself, by way of %s", slug, strings.Join(chain, ", "))
+	}
+	if len(chain) > maxIncludeDepth {
+		return "", fmt.Errorf("includes go more than %d deep", maxIncludeDepth)
+	}
+	included, err := loadPage(context.WithValue(ctx, includeKey{}, chain), slug)
+	if err != nil {
+		return "", err
+	}
+	if included.Draft || included.Private || included.Archived {
+		return "", fmt.Errorf("%s isn't published", slug)
+	}
+	page.Math = page.Math || included.Math
+	page.Diagrams = page.Diagrams || included.Diagrams
+	return `<div class="include">` + string(included.HTML) + `</div>`, nil
+}
+
+// tocShortcode is {{toc}}: the page's table of contents, right there. The
+// sidebar one is left out then, see renderPage.
+func tocShortcode(ctx context.Context, page *Page, args []string) (string, error) {
+	var b strings.Builder
+	b.WriteString(`<nav class="toc toc-inline"><strong>Contents</strong><ul>`)
+	for _, entry := range page.TOC {
+		fmt.Fprintf(&b, `<li class="toc-level-%d"><a href="#%s">%s</a></li>`, entry.Level, html.EscapeString(entry.ID), html.EscapeString(entry.Text))
+	}
+	b.WriteString(`</ul></nav>`)
+	return b.String(), nil
+}
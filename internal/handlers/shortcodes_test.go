@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExpandShortcodesOnlyInText(t *testing.T) {
+	body, calls := extractShortcodes(`<b title="{{toc}}">hi</b> {{toc}}`)
+	if len(calls) != 2 {
+		t.Fatalf("extractShortcodes found %d shortcodes, want 2", len(calls))
+	}
+	for i := range calls {
+		calls[i].fn = func(context.Context, *Page, []string) (string, error) {
+			return `<div class="toc">"x"</div>`, nil
+		}
+	}
+	got := expandShortcodes(context.Background(), &Page{}, body, calls)
+	want := `<b title="{{toc}}">hi</b> <div class="toc">"x"</div>`
+	if got != want {
+		t.Errorf("expandShortcodes = %s, want %s", got, want)
+	}
+}
+
+func TestExtractShortcodesIgnoresPlaceholders(t *testing.T) {
+	body, calls := extractShortcodes("a " + shortcodeOpen + "0" + shortcodeClose + " b")
+	if len(calls) != 0 || strings.ContainsAny(body, shortcodeOpen+shortcodeClose) {
+		t.Errorf("extractShortcodes = %q with %d shortcodes, want the placeholder characters gone and none", body, len(calls))
+	}
+}
@@ -0,0 +1,226 @@
+package handlers
+
+//Exporting the whole site as a zip, for backups and moving elsewhere. By
+//default it's every file in the store as it is: every page, its video links,
+//votes, comments, settings and attachments, and the site's redirects. With
+//?format=markdown each page is a {slug}.md instead, its videos, votes and
+//settings in its front matter, with its attachments in a {slug}/ directory
+//next to it. The zip is written as it's made, one file at a time.
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go-trailer/internal/storage"
+	"go-trailer/internal/videos"
+
+	"gopkg.in/yaml.v3"
+)
+
+// markdownFrontMatter is a page's front matter in a Markdown export: what
+// its own front matter said, and what the site knows about it. Imports read
+// it back, see siteimport.go.
+type markdownFrontMatter struct {
+	Title       string          `yaml:"title,omitempty"`
+	Slug        string          `yaml:"slug,omitempty"`
+	Description string          `yaml:"description,omitempty"`
+	Tags        []string        `yaml:"tags,omitempty,flow"`
+	Author      string          `yaml:"author,omitempty"`
+	Date        string          `yaml:"date,omitempty"`
+	Math        bool            `yaml:"math,omitempty"`
+	Draft       bool            `yaml:"draft,omitempty"`
+	Private     bool            `yaml:"private,omitempty"`
+	Viewers     []string        `yaml:"viewers,omitempty,flow"`
+	Created     time.Time       `yaml:"created,omitempty"`
+	Updated     time.Time       `yaml:"updated,omitempty"`
+	Videos      []markdownVideo `yaml:"videos,omitempty"`
+}
+
+// markdownVideo is one of a page's videos in a Markdown export.
+type markdownVideo struct {
+	URL   string `yaml:"url"`
+	Votes int    `yaml:"votes"`
+}
+
+// addZipFile adds a file to a zip.
+func addZipFile(zw *zip.Writer, name string, data []byte, modTime time.Time) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// adminExportHandler serves GET /admin/export, the whole site as a zip.
+func (srv *Server) adminExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "files" && format != "markdown" {
+		http.Error(w, "format must be files or markdown", http.StatusBadRequest)
+		return
+	}
+	names, err := storeCtx(r.Context()).List()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading pages directory", "err", err)
+		http.Error(w, "Could not export site", http.StatusInternalServerError)
+		return
+	}
+	slices.Sort(names)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="site-%s.zip"`, time.Now().UTC().Format("2006-01-02")))
+	zw := zip.NewWriter(w)
+	if format == "markdown" {
+		err = exportMarkdown(r.Context(), zw, names)
+	} else {
+		err = exportFiles(r.Context(), zw, names)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error writing site export", "err", err) // Too late for a status
+		return
+	}
+	slog.InfoContext(r.Context(), "Site exported", "format", format, "files", len(names))
+}
+
+// exportFiles adds every file in the store to the zip, as it is. The trash
+// stays behind.
+func exportFiles(ctx context.Context, zw *zip.Writer, names []string) error {
+	pages := storeCtx(ctx)
+	for _, name := range names {
+		if strings.HasPrefix(name, "~") || name == trashFile {
+			continue
+		}
+		data, err := pages.ReadFile(name)
+		if err != nil {
+			continue // Removed while we were exporting
+		}
+		modTime, _ := pages.ModTime(name)
+		if err := addZipFile(zw, name, data, modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportMarkdown adds each page to the zip as Markdown with front matter,
+// followed by its attachments, then the site's redirects.
+func exportMarkdown(ctx context.Context, zw *zip.Writer, names []string) error {
+	pages := storeCtx(ctx)
+	for _, name := range names {
+		if !storage.IsPageFile(name) {
+			continue
+		}
+		slug := strings.TrimSuffix(name, ".txt")
+		text, err := markdownPage(ctx, slug)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error exporting page, leaving it out", "page", slug, "err", err)
+			continue
+		}
+		modTime, _ := pages.ModTime(name)
+		if err := addZipFile(zw, slug+".md", text, modTime); err != nil {
+			return err
+		}
+
+		attachments, err := attachmentFiles(ctx, slug)
+		if err != nil {
+			return err
+		}
+		for _, file := range attachments {
+			attachment := strings.TrimPrefix(file, slug+"@")
+			if strings.Contains(attachment, "@") {
+				continue // A thumbnail, the site makes those itself
+			}
+			data, err := pages.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			modTime, _ := pages.ModTime(file)
+			if err := addZipFile(zw, slug+"/"+attachment, data, modTime); err != nil {
+				return err
+			}
+		}
+	}
+
+	if data, err := pages.ReadFile(redirectsFile); err == nil {
+		modTime, _ := pages.ModTime(redirectsFile)
+		return addZipFile(zw, redirectsFile, data, modTime)
+	}
+	return nil
+}
+
+// markdownPage is a page as Markdown, with everything about it in its front
+// matter.
+func markdownPage(ctx context.Context, slug string) ([]byte, error) {
+	text, err := storeCtx(ctx).ReadFile(slug + ".txt")
+	if err != nil {
+		return nil, err
+	}
+	fm, body, err := splitFrontMatter(string(text))
+	if err != nil {
+		return nil, err
+	}
+	meta, err := loadPageMeta(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	modTime, err := pageModTime(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	out := markdownFrontMatter{
+		Title:       pageTitle(ctx, slug),
+		Slug:        slug,
+		Description: fm.Description,
+		Tags:        fm.Tags,
+		Author:      fm.Author,
+		Date:        fm.Date,
+		Math:        fm.Math,
+		Draft:       meta.hidden(time.Now()),
+		Private:     meta.Private,
+		Viewers:     meta.Viewers,
+		Created:     meta.Created,
+		Updated:     modTime.UTC(),
+	}
+
+	links, err := storeCtx(ctx).ReadFile(slug + ".youtube.txt")
+	if err == nil {
+		votes, err := readVotes(ctx, slug)
+		if err != nil {
+			return nil, err
+		}
+		for line := range strings.Lines(string(links)) {
+			url := strings.TrimSpace(line)
+			if _, videoID := videos.Parse(url); videoID != "" {
+				out.Videos = append(out.Videos, markdownVideo{URL: url, Votes: votes[videoID]})
+			}
+		}
+	}
+
+	return withFrontMatter(out, body)
+}
+
+// withFrontMatter is body with fm before it as YAML front matter.
+func withFrontMatter(fm markdownFrontMatter, body string) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("---\n")
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(fm); err != nil {
+		return nil, err
+	}
+	b.WriteString("---\n" + body)
+	return []byte(b.String()), nil
+}
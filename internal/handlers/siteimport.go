@@ -0,0 +1,392 @@
+package handlers
+
+//Importing pages, the other way from a Markdown export: a zip posted to
+///admin/import, or a zip or directory given to `go-trailer import`. Every
+//.md or .markdown file in it is a page, slugged from its front matter's slug
+//or else its file name, with the attachments in the directory of the same
+//name next to it. Videos, votes, drafts and when it was created come from the
+//front matter, as an export writes them, and a redirects.json at the top
+//brings the redirects to the pages it brought. Pages whose slug is taken are
+//skipped, or numbered with slugs.on_collision: suffix, the same as new pages.
+
+import (
+	"archive/zip"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go-trailer/internal/slugs"
+	"go-trailer/internal/videos"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Biggest zip /admin/import takes.
+const maxImportBytes = 512 << 20
+
+// ImportResult is how one file in an import went.
+type ImportResult struct {
+	File   string `json:"file"`
+	Slug   string `json:"slug,omitempty"`
+	Status string `json:"status"`           // "created", "skipped" or "error"
+	Reason string `json:"reason,omitempty"` // Why it was skipped, or what went wrong
+}
+
+// importedPage is a page read from an import, ready to save.
+type importedPage struct {
+	title   string // What the page is called, for its meta
+	text    []byte // The page file, front matter and all
+	draft   bool
+	private bool
+	viewers []string
+	created time.Time
+	videos  []markdownVideo
+}
+
+// adminImportHandler serves POST /admin/import, taking a zip as the body and
+// answering with {"created": n, "skipped": n, "results": [...]}, one result
+// for each page in the zip.
+func (srv *Server) adminImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	// A zip needs reading from anywhere, so it goes to a file first
+	tmp, err := os.CreateTemp("", "go-trailer-import-*.zip")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error making a file for the import", "err", err)
+		http.Error(w, "Could not import pages", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			http.Error(w, fmt.Sprintf("Zip is too big, the most is %d bytes", maxImportBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Could not read the zip", http.StatusBadRequest)
+		return
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		http.Error(w, "Bad request, send a zip of Markdown files", http.StatusBadRequest)
+		return
+	}
+
+	results, err := importPages(r.Context(), zr, editorName(r))
+	if err != nil {
+		if quotaError(w, err) {
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error importing pages", "err", err)
+		http.Error(w, "Could not import pages", http.StatusInternalServerError)
+		return
+	}
+	created, skipped := 0, 0
+	for _, result := range results {
+		switch result.Status {
+		case "created":
+			created++
+			if !isRestricted(r.Context(), result.Slug) {
+				queueSearchPing(r.Context(), result.Slug)
+				purgePage(r.Context(), result.Slug)
+				publishPageEvent(r.Context(), "page-created", result.Slug)
+			}
+		case "skipped":
+			skipped++
+		}
+	}
+	if created > 0 {
+		purgeListings(r.Context())
+	}
+	slog.InfoContext(r.Context(), "Pages imported", "files", len(results), "created", created, "skipped", skipped)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"created": created, "skipped": skipped, "results": results})
+}
+
+// importPages imports every page in files, then the redirects to them. Each
+// page is imported if it can be; err is only for the redirects.
+func importPages(ctx context.Context, files fs.FS, editor string) ([]ImportResult, error) {
+	var names []string
+	err := fs.WalkDir(files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && (strings.HasPrefix(d.Name(), ".") || d.Name() == "__MACOSX") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && isMarkdownFile(name) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// A Markdown file in a page's attachments is one of its attachments
+	stems := make(map[string]bool, len(names))
+	for _, name := range names {
+		stems[strings.TrimSuffix(name, path.Ext(name))] = true
+	}
+
+	results := []ImportResult{}      // [] rather than null
+	slugs := make(map[string]string) // What each page's slug was, to what it is now
+	for _, name := range names {
+		if stems[path.Dir(name)] {
+			continue
+		}
+		result, was := importPage(ctx, files, name, editor)
+		if result.Status == "error" {
+			slog.ErrorContext(ctx, "Error importing page", "file", name, "err", result.Reason)
+		}
+		if result.Status == "created" {
+			slugs[was] = result.Slug
+		}
+		results = append(results, result)
+	}
+	return results, importRedirects(ctx, files, slugs)
+}
+
+// isMarkdownFile reports whether name is a page to import.
+func isMarkdownFile(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// importPage imports the page in file and its attachments, returning how it
+// went and the slug it had before. The page file goes last, so the page is
+// never shown without its videos.
+func importPage(ctx context.Context, files fs.FS, file, editor string) (ImportResult, string) {
+	result := ImportResult{File: file, Status: "error"}
+	data, err := readFileAtMost(files, file, maxPageBytes)
+	if errors.Is(err, errImportTooBig) {
+		result.Reason = fmt.Sprintf("page is too big, the most is %d bytes", maxPageBytes)
+		return result, ""
+	}
+	if err != nil {
+		result.Reason = "could not read file"
+		return result, ""
+	}
+	stem := strings.TrimSuffix(file, path.Ext(file))
+	page, was, err := readImportedPage(string(data), path.Base(stem))
+	if err != nil {
+		result.Reason = err.Error()
+		return result, ""
+	}
+	result.Status = "skipped"
+	if reservedSlug(ctx, was) {
+		result.Slug, result.Reason = was, "the name "+was+" is reserved"
+		return result, was
+	}
+
+	siteOf(ctx).createMu.Lock()
+	defer siteOf(ctx).createMu.Unlock()
+	slug, free := freeSlug(ctx, was)
+	result.Slug = slug
+	if !free {
+		result.Reason = "there's a page called " + slug + " already"
+		return result, was
+	}
+	result.Status = "error"
+	if err := saveImportedPage(ctx, slug, page, editor); err != nil {
+		result.Reason = err.Error()
+		return result, was
+	}
+	for _, name := range importAttachments(ctx, files, stem, slug) {
+		slog.WarnContext(ctx, "Attachment left out of the import", "page", slug, "file", name)
+	}
+	if err := storeCtx(ctx).WriteFile(slug+".txt", page.text); err != nil {
+		result.Reason = "could not save page: " + err.Error()
+		return result, was
+	}
+	result.Status = "created"
+	slog.InfoContext(ctx, "Page imported", "page", slug, "file", file)
+	return result, was
+}
+
+// errImportTooBig is readFileAtMost's answer for a file over its limit.
+var errImportTooBig = errors.New("file is too big")
+
+// readFileAtMost reads a file of the import, unless it's more than max
+// bytes. The size a zip says a file is goes first, so a zip bomb isn't
+// unpacked to find out, but it's never read past max either way.
+func readFileAtMost(files fs.FS, name string, max int64) ([]byte, error) {
+	f, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil {
+		return nil, err
+	} else if info.Size() > max {
+		return nil, errImportTooBig
+	}
+	data, err := io.ReadAll(io.LimitReader(f, max+1))
+	if err == nil && int64(len(data)) > max {
+		return nil, errImportTooBig
+	}
+	return data, err
+}
+
+// readImportedPage reads a page to import, named name if it doesn't say, and
+// the slug it asks for. Its front matter is kept, less what's kept elsewhere
+// on our side.
+func readImportedPage(text, name string) (importedPage, string, error) {
+	fm, body, err := splitFrontMatter(text)
+	if err != nil {
+		return importedPage{}, "", err
+	}
+	var imported markdownFrontMatter
+	if delim, source, _, ok := cutFrontMatter(text); ok && delim == "---" {
+		if err := yaml.Unmarshal([]byte(source), &imported); err != nil {
+			return importedPage{}, "", fmt.Errorf("front matter: %w", err)
+		}
+	}
+	viewers, err := cleanViewers(imported.Viewers)
+	if err != nil {
+		return importedPage{}, "", fmt.Errorf("viewers: %w", err)
+	}
+	page := importedPage{
+		title:   cmp.Or(fm.Title, name),
+		text:    []byte(body),
+		draft:   imported.Draft,
+		private: imported.Private,
+		viewers: viewers,
+		created: imported.Created,
+		videos:  imported.Videos,
+	}
+	kept := markdownFrontMatter{Title: fm.Title, Description: fm.Description, Tags: fm.Tags, Author: fm.Author, Date: fm.Date, Math: fm.Math}
+	if kept.Title != "" || kept.Description != "" || len(kept.Tags) > 0 || kept.Author != "" || kept.Date != "" || kept.Math {
+		if page.text, err = withFrontMatter(kept, body); err != nil {
+			return importedPage{}, "", err
+		}
+	}
+	return page, slugs.FromName(cmp.Or(imported.Slug, name)), nil
+}
+
+// saveImportedPage writes everything about a page but the page itself: its
+// meta, first so drafts and private pages are never listed, then its videos and votes.
+// Callers must hold createMu.
+func saveImportedPage(ctx context.Context, slug string, page importedPage, editor string) error {
+	siteOf(ctx).pageMetaMu.Lock()
+	meta, err := loadPageMeta(ctx, slug)
+	if err == nil {
+		now := time.Now().UTC()
+		meta.Created, meta.CreatedBy = now, editor
+		if !page.created.IsZero() {
+			meta.Created = page.created.UTC()
+		}
+		meta.Updated, meta.UpdatedBy = now, editor
+		meta.Title = page.title
+		meta.Draft = page.draft
+		meta.Private, meta.Viewers = page.private, page.viewers
+		err = savePageMeta(ctx, slug, meta)
+	}
+	siteOf(ctx).pageMetaMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("could not save page meta: %w", err)
+	}
+	if len(page.videos) == 0 {
+		return nil
+	}
+
+	var links strings.Builder
+	votes := make(map[string]int)
+	for _, video := range page.videos {
+		if _, videoID := videos.Parse(video.URL); videoID != "" {
+			links.WriteString(video.URL + "\n")
+			if video.Votes != 0 {
+				votes[videoID] = video.Votes
+			}
+		}
+	}
+	if len(votes) > 0 {
+		siteOf(ctx).votesMu.Lock()
+		err := writeVotes(ctx, slug, votes)
+		siteOf(ctx).votesMu.Unlock()
+		if err != nil {
+			return fmt.Errorf("could not save votes: %w", err)
+		}
+	}
+	if err := storeCtx(ctx).WriteFile(slug+".youtube.txt", []byte(links.String())); err != nil {
+		return fmt.Errorf("could not save videos: %w", err)
+	}
+	return nil
+}
+
+// importAttachments attaches the files in dir to the page, making
+// thumbnails of the pictures, and returns the ones it had to leave out.
+func importAttachments(ctx context.Context, files fs.FS, dir, slug string) []string {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil // No attachments
+	}
+	var left []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue // .DS_Store and the like
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || !attachmentNameRegex.MatchString(name) || info.Size() > configOf(ctx).Attachments.MaxBytes {
+			left = append(left, path.Join(dir, name))
+			continue
+		}
+		data, err := readFileAtMost(files, path.Join(dir, name), configOf(ctx).Attachments.MaxBytes)
+		if err == nil {
+			err = storeCtx(ctx).WriteFile(attachmentFile(slug, name), data)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Error importing attachment", "page", slug, "name", name, "err", err)
+			left = append(left, path.Join(dir, name))
+			continue
+		}
+		if _, err := makeThumbnails(ctx, slug, name, data); err != nil {
+			slog.ErrorContext(ctx, "Error making thumbnails", "page", slug, "name", name, "err", err) // The file itself is saved
+		}
+	}
+	return left
+}
+
+// importRedirects adds the redirects in the import's redirects.json that go
+// to pages it brought, to wherever they went, unless their old slug is taken.
+func importRedirects(ctx context.Context, files fs.FS, slugs map[string]string) error {
+	data, err := readFileAtMost(files, redirectsFile, maxPageBytes)
+	if err != nil || len(slugs) == 0 {
+		return nil // Nothing to bring
+	}
+	var redirects map[string]string
+	if err := json.Unmarshal(data, &redirects); err != nil {
+		slog.WarnContext(ctx, "Import's redirects.json isn't a redirects file, leaving it out", "err", err)
+		return nil
+	}
+	for from, to := range redirects {
+		to, ok := slugs[to]
+		if !ok || pageExists(ctx, from) {
+			continue
+		}
+		if _, taken := redirectTarget(ctx, from); taken {
+			continue
+		}
+		if err := addRedirect(ctx, from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
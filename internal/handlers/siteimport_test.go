@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestImportLimits(t *testing.T) {
+	ctx := context.WithValue(context.Background(), siteKey{}, testSite(t))
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, text := range map[string]string{
+		"bomb.md":   strings.Repeat("0", maxPageBytes+1), // Squashes down to next to nothing
+		"crowd.md":  "---\nprivate: true\nviewers: [\"@\"]\n---\nFor whom?\n",
+		"notes.md":  "---\nprivate: true\nviewers: [\" ann \", \"@staff\"]\n---\nJust for us.\n",
+		"public.md": "# Anyone's\n",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(text))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := importPages(ctx, zr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"bomb.md": "error", "crowd.md": "error", "notes.md": "created", "public.md": "created"}
+	for _, result := range results {
+		if result.Status != want[result.File] {
+			t.Errorf("%s was %s (%s), want %s", result.File, result.Status, result.Reason, want[result.File])
+		}
+	}
+	if pageExists(ctx, "bomb") || pageExists(ctx, "crowd") {
+		t.Errorf("a page that failed to import is there anyway")
+	}
+	if meta := accessMeta(ctx, "notes"); !meta.Private || strings.Join(meta.Viewers, ",") != "ann,@staff" {
+		t.Errorf("notes is private to %q, want ann and @staff", meta.Viewers)
+	}
+}
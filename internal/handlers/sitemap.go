@@ -0,0 +1,52 @@
+package handlers
+
+//Serves /sitemap.xml so search engines can find every page
+
+import (
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// sitemapURLSet is the root of a sitemaps.org sitemap.
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// sitemapHandler serves /sitemap.xml listing the homepage and every page.
+func (srv *Server) sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	slugs, err := publishedSlugs(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading pages directory", "err", err)
+		http.Error(w, "Could not build sitemap", http.StatusInternalServerError)
+		return
+	}
+
+	base := siteBaseURL(r)
+	urlSet := sitemapURLSet{URLs: []sitemapURL{{Loc: base + "/"}}}
+	for _, slug := range slugs {
+		entry := sitemapURL{Loc: base + pagePath(slug)}
+		if modTime, err := pageModTime(r.Context(), slug); err == nil {
+			entry.LastMod = modTime.UTC().Format(time.RFC3339)
+		}
+		urlSet.URLs = append(urlSet.URLs, entry)
+	}
+
+	output, err := xml.MarshalIndent(urlSet, "", "  ")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error marshalling sitemap", "err", err)
+		http.Error(w, "Could not build sitemap", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(output)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+//Several small wikis from one process. Each entry under sites: in
+//config.yaml is picked by the Host header and has its own pages directory,
+//templates, static files, title and admin password. Requests for any other
+//host go to the main site, the one set up by the rest of config.yaml.
+//
+//Search engine pings, CDN purges, search stats and storage plugins are only
+//for the main site.
+
+import (
+	"cmp"
+	"context"
+	"html/template"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go-trailer/internal/config"
+	"go-trailer/internal/storage"
+)
+
+// site is one of the sites we serve, with the settings it left out filled
+// in from the main site. Handlers get theirs from the request's context.
+type site struct {
+	srv           *Server // Serving it, with the rest of the settings
+	title         string
+	url           string
+	adminPassword string
+	templatesDir  string
+	staticDir     string
+	storage       storage.Storage
+	templates     *template.Template
+	static        http.Handler
+	fingerprints  map[string]string // Of the static files, see assets.go
+	basePath      string            // Prefix of every path on the site
+	main          bool
+
+	// What the site keeps in memory while it's served, each with its own
+	// lock. See the files of the same names.
+	blocklist          blocklist
+	abuseStrikes       abuseStrikes
+	shares             shares
+	viewCounts         viewCounts
+	idempotentRequests idempotentRequests
+	pageLocks          pageLocks
+	linkIndex          linkIndex
+	searchStats        searchStats
+	createQuota        createQuota
+	events             siteEvents
+	voteWatchers       voteWatchers
+
+	// Its files are read, changed and written back, so writers take turns.
+	// Creates check for a free slug and then write it, and edits check the
+	// page hasn't changed and then write it, so they take createMu, and
+	// trashMu after it.
+	votesMu, commentsMu, pageMetaMu, redirectsMu, createMu, trashMu sync.Mutex
+}
+
+type siteKey struct{}
+
+// mainSite is the main site as config.yaml has it, storing its pages in
+// pages_dir and not yet ready to serve.
+func (srv *Server) mainSite() *site {
+	return &site{
+		srv:           srv,
+		title:         srv.config.SiteTitle,
+		url:           srv.config.SiteURL,
+		adminPassword: srv.config.AdminPassword,
+		templatesDir:  srv.config.TemplatesDir,
+		staticDir:     srv.config.StaticDir,
+		storage:       storage.Dir{Path: srv.config.PagesDir},
+		basePath:      srv.config.BasePath,
+		main:          true,
+	}
+}
+
+// setupSites sets up the main site and those under sites:, parsing their
+// templates and getting them ready to serve.
+func (srv *Server) setupSites() error {
+	srv.main = srv.mainSite()
+	srv.sites = make(map[string]*site)
+	for _, settings := range srv.config.Sites {
+		s := &site{
+			srv:           srv,
+			title:         cmp.Or(settings.SiteTitle, srv.config.SiteTitle),
+			url:           strings.TrimSuffix(settings.SiteURL, "/"),
+			adminPassword: cmp.Or(settings.AdminPassword, srv.config.AdminPassword),
+			templatesDir:  cmp.Or(settings.TemplatesDir, srv.config.TemplatesDir),
+			staticDir:     cmp.Or(settings.StaticDir, srv.config.StaticDir),
+			storage:       storage.Dir{Path: settings.PagesDir},
+			basePath:      srv.config.BasePath,
+		}
+		for _, host := range settings.Hosts {
+			srv.sites[strings.ToLower(host)] = s
+		}
+	}
+
+	for _, s := range srv.ownSites() {
+		t, err := s.parseTemplates()
+		if err != nil {
+			return err
+		}
+		s.templates = t
+		s.static = http.StripPrefix("/static/", http.FileServer(http.Dir(s.staticDir)))
+		if s.fingerprints, err = hashStatic(s.staticDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownSites is the main site and those under sites:, once each.
+func (srv *Server) ownSites() []*site {
+	all := []*site{srv.main}
+	seen := map[*site]bool{srv.main: true}
+	for _, s := range srv.sites {
+		if !seen[s] { // A site is there once for each of its hosts
+			seen[s] = true
+			all = append(all, s)
+		}
+	}
+	return all
+}
+
+// servedSites is every site we have pages for: the main one, those under
+// sites:, and the tenants visited so far.
+func (srv *Server) servedSites() []*site {
+	all := srv.ownSites()
+	srv.tenants.Lock()
+	for _, s := range srv.tenants.sites {
+		all = append(all, s)
+	}
+	srv.tenants.Unlock()
+	return all
+}
+
+// parseTemplates parses every template in the site's templates directory,
+// with functions to get at the site's settings.
+func (s *site) parseTemplates() (*template.Template, error) {
+	funcs := template.FuncMap{
+		"siteTitle":    func() string { return s.title },
+		"base":         func() string { return s.basePath },
+		"static":       s.staticPath,
+		"feature":      s.srv.config.Features.On,
+		"readOnly":     s.srv.readOnlyMessage, // "" unless we're read-only
+		"pageCreation": s.srv.buildPageCreationView,
+	}
+	return template.New("").Funcs(funcs).ParseGlob(filepath.Join(s.templatesDir, "*.html"))
+}
+
+// store is where the site's pages are kept.
+func (s *site) store() storage.Storage {
+	return s.storage
+}
+
+// serverOf is the Server of the site ctx is for.
+func serverOf(ctx context.Context) *Server {
+	return siteOf(ctx).srv
+}
+
+// configOf is the settings of the Server ctx is for.
+func configOf(ctx context.Context) *config.Config {
+	return &siteOf(ctx).srv.config
+}
+
+// siteOf is the site a request, background job or command is for. Every
+// context they start from has one, so not finding it is a bug.
+func siteOf(ctx context.Context) *site {
+	s, ok := ctx.Value(siteKey{}).(*site)
+	if !ok {
+		panic("siteOf: no site in context")
+	}
+	return s
+}
+
+// withSite picks the site for each request by its Host header, or the
+// tenant it's for, going to the main site for anything else.
+func (srv *Server) withSite(next http.Handler) http.Handler {
+	if srv.config.Tenants.Enabled {
+		next = srv.withTenant(next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := srv.sites[strings.ToLower(stripHostPort(r.Host))]
+		if !ok {
+			s = srv.main
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), siteKey{}, s)))
+	})
+}
+
+// stripHostPort is host without the port, if it has one.
+func stripHostPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
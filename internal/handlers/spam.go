@@ -0,0 +1,150 @@
+package handlers
+
+//Spam scoring for comments. The scorer is swappable: a simple built-in
+//heuristic by default, or Akismet (or anything speaking its API) when a key
+//is configured.
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Scores from 0 (surely fine) to 1 (surely spam). At spamHoldScore a comment
+// waits for a moderator, at spamRejectScore it goes straight to the spam folder.
+const (
+	spamHoldScore   = 0.5
+	spamRejectScore = 0.9
+)
+
+// spamScorer rates how likely a comment is to be spam. It gets the same
+// details Akismet's comment-check does, so an Akismet client fits right in.
+type spamScorer interface {
+	Score(c Comment, permalink string) (float64, error)
+}
+
+// spamReporter is implemented by scorers that learn from moderators. When an
+// admin marks a comment as spam (or rescues it) we pass that on.
+type spamReporter interface {
+	Report(c Comment, permalink string, isSpam bool) error
+}
+
+// loadSpamFilter picks the scorer new comments go through, Akismet when an
+// akismet_key is configured.
+func (srv *Server) loadSpamFilter() {
+	srv.spamFilter = heuristicScorer{}
+	if srv.config.AkismetKey != "" {
+		srv.spamFilter = akismetScorer{key: srv.config.AkismetKey, endpoint: "https://rest.akismet.com/1.1/", blog: srv.config.SiteURL}
+	}
+}
+
+// --- Built-in heuristic ---
+
+var linkRegex = regexp.MustCompile(`(?i)https?://|www\.`)
+
+// Words that almost never show up in a genuine comment about a trailer.
+var spamWords = []string{"viagra", "casino", "crypto giveaway", "free money", "loan", "seo services", "click here"}
+
+// heuristicScorer is a cheap rule of thumb: lots of links, known spam
+// phrases and shouting all push the score up.
+type heuristicScorer struct{}
+
+func (heuristicScorer) Score(c Comment, permalink string) (float64, error) {
+	text := strings.ToLower(c.Author + " " + c.Body)
+	score := 0.0
+
+	switch links := len(linkRegex.FindAllStringIndex(c.Body, -1)); {
+	case links >= 3:
+		score += 0.6
+	case links > 0:
+		score += 0.2
+	}
+	if linkRegex.MatchString(c.Author) {
+		score += 0.4 // Nobody's name is a URL
+	}
+	for _, word := range spamWords {
+		if strings.Contains(text, word) {
+			score += 0.4
+		}
+	}
+	if len(c.Body) > 20 && c.Body == strings.ToUpper(c.Body) && c.Body != strings.ToLower(c.Body) {
+		score += 0.2 // ALL CAPS
+	}
+	return min(score, 1), nil
+}
+
+// --- Akismet ---
+
+// akismetScorer asks Akismet's REST API (or a compatible service at endpoint).
+type akismetScorer struct {
+	key      string
+	endpoint string // e.g. https://rest.akismet.com/1.1/
+	blog     string // site_url, if set
+}
+
+// form builds the fields every Akismet call takes.
+func (a akismetScorer) form(c Comment, permalink string) url.Values {
+	blog := a.blog
+	if blog == "" {
+		blog = permalink
+	}
+	return url.Values{
+		"api_key":         {a.key},
+		"blog":            {blog},
+		"user_ip":         {c.IP},
+		"user_agent":      {c.UserAgent},
+		"referrer":        {c.Referrer},
+		"permalink":       {permalink},
+		"comment_type":    {"comment"},
+		"comment_author":  {c.Author},
+		"comment_content": {c.Body},
+	}
+}
+
+// call posts to one of Akismet's methods and returns the response.
+func (a akismetScorer) call(method string, form url.Values) (*http.Response, string, error) {
+	resp, err := outboundClient.PostForm(a.endpoint+method, form)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("akismet %s returned %s", method, resp.Status)
+	}
+	return resp, strings.TrimSpace(string(body)), nil
+}
+
+func (a akismetScorer) Score(c Comment, permalink string) (float64, error) {
+	resp, answer, err := a.call("comment-check", a.form(c, permalink))
+	if err != nil {
+		return 0, err
+	}
+	switch answer {
+	case "true":
+		// "discard" means Akismet is certain, anything else is just likely spam
+		if resp.Header.Get("X-akismet-pro-tip") == "discard" {
+			return 1, nil
+		}
+		return spamRejectScore, nil
+	case "false":
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("akismet: %s (%s)", answer, resp.Header.Get("X-akismet-debug-help"))
+	}
+}
+
+func (a akismetScorer) Report(c Comment, permalink string, isSpam bool) error {
+	method := "submit-ham"
+	if isSpam {
+		method = "submit-spam"
+	}
+	_, _, err := a.call(method, a.form(c, permalink))
+	return err
+}
@@ -0,0 +1,124 @@
+package handlers
+
+//Serving tenants, see internal/tenants: a tenant is picked by path
+//(/t/{name}/...) or by host ({name}.example.com), and gets a site of its own
+//the first time it's visited. Tenants don't get the main site's admin
+//password: they share their own, tenants.admin_password, or have no admin
+//pages if that's empty.
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go-trailer/internal/tenants"
+)
+
+// tenantSites is the tenants a Server has served, by name.
+type tenantSites struct {
+	sync.Mutex
+	sites map[string]*site
+}
+
+// tenantSite is the site for the named tenant, or nil if there's no such tenant.
+func (srv *Server) tenantSite(name string) (*site, error) {
+	if !tenants.ValidName(name) {
+		return nil, nil
+	}
+	srv.tenants.Lock()
+	defer srv.tenants.Unlock()
+	if s, ok := srv.tenants.sites[name]; ok {
+		return s, nil
+	}
+
+	config := &srv.config
+	dir := filepath.Join(config.Tenants.Dir, name)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, nil
+	}
+	s := &site{
+		srv:           srv,
+		title:         config.SiteTitle,
+		url:           tenants.URL(config, name),
+		adminPassword: config.Tenants.AdminPassword,
+		templatesDir:  config.TemplatesDir,
+		staticDir:     config.StaticDir,
+		storage:       tenants.NewStorage(dir, config.Tenants),
+		static:        http.StripPrefix("/static/", http.FileServer(http.Dir(config.StaticDir))),
+		basePath:      config.BasePath,
+	}
+	if config.Tenants.By == "path" {
+		s.basePath += "/t/" + name
+	}
+	t, err := s.parseTemplates()
+	if err != nil {
+		return nil, err
+	}
+	s.templates = t
+	if s.fingerprints, err = hashStatic(s.staticDir); err != nil {
+		return nil, err
+	}
+	if srv.tenants.sites == nil {
+		srv.tenants.sites = make(map[string]*site)
+	}
+	srv.tenants.sites[name] = s
+	return s, nil
+}
+
+// tenantName is the name of the tenant a request is for, going by its host
+// or path, and the prefix to strip from the path (for path tenants).
+func (srv *Server) tenantName(r *http.Request) (name, prefix string, ok bool) {
+	if settings := srv.config.Tenants; settings.By == "host" {
+		name, ok = strings.CutSuffix(strings.ToLower(stripHostPort(r.Host)), "."+strings.ToLower(settings.Domain))
+		return name, "", ok
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, "/t/")
+	if !ok {
+		return "", "", false
+	}
+	name, _, _ = strings.Cut(rest, "/")
+	return name, "/t/" + name, true
+}
+
+// withTenant sends requests for a tenant to its site. Requests for a tenant
+// that doesn't exist get a 404, anything else goes to the main site.
+func (srv *Server) withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, prefix, ok := srv.tenantName(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s, err := srv.tenantSite(name)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error setting up tenant", "tenant", name, "err", err)
+			http.Error(w, "Could not load this wiki", http.StatusInternalServerError)
+			return
+		}
+		if s == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if prefix != "" && r.URL.Path == prefix {
+			http.Redirect(w, r, s.basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), siteKey{}, s))
+		http.StripPrefix(prefix, next).ServeHTTP(w, r)
+	})
+}
+
+// quotaError answers a write that failed because of the tenant's quota, and
+// reports whether it did.
+func quotaError(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, tenants.ErrQuotaExceeded) {
+		return false
+	}
+	apiError(w, "This wiki is full: "+err.Error(), http.StatusInsufficientStorage)
+	return true
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-trailer/internal/config"
+)
+
+func TestTenantSite(t *testing.T) {
+	for _, tt := range []struct {
+		by, url string
+	}{
+		{"path", "https://wiki.example.com/wiki/t/acme"},
+		{"host", "https://acme.wikis.example.com/wiki"},
+	} {
+		cfg := testConfig(t)
+		cfg.SiteURL = "https://wiki.example.com/wiki"
+		cfg.BasePath = "/wiki"
+		cfg.AdminPassword = "hunter2"
+		cfg.Tenants = config.TenantSettings{Enabled: true, By: tt.by, Domain: "wikis.example.com", Dir: t.TempDir(), AdminPassword: "swordfish"}
+		if err := os.Mkdir(filepath.Join(cfg.Tenants.Dir, "acme"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		srv := newTestServer(t, cfg)
+
+		s, err := srv.tenantSite("acme")
+		if err != nil || s == nil {
+			t.Fatalf("by %s: tenantSite = %v, %v", tt.by, s, err)
+		}
+		if s.url != tt.url {
+			t.Errorf("by %s: url = %q, want %q", tt.by, s.url, tt.url)
+		}
+		if s.fingerprints["styles.css"] == "" {
+			t.Errorf("by %s: static files not fingerprinted", tt.by)
+		}
+		if s.adminPassword != "swordfish" {
+			t.Errorf("by %s: tenant has admin password %q, not its own", tt.by, s.adminPassword)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+//Request spans, and tracing what the site's store is asked to do. The
+//tracing itself is set up by internal/tracing.
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go-trailer/internal/storage"
+	"go-trailer/internal/tracing"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// withTracing starts a span for every request, named after the route it
+// matched, e.g. "GET /page/" or "POST /api/vote/{slug}/{videoID}/{action}".
+func withTracing(next http.Handler, mux *http.ServeMux) http.Handler {
+	return otelhttp.NewHandler(next, "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			_, pattern := mux.Handler(r)
+			if pattern == "" {
+				pattern = "unmatched"
+			}
+			if strings.Contains(pattern, " ") {
+				return pattern // It has its method already
+			}
+			return r.Method + " " + pattern
+		}),
+	)
+}
+
+// storeCtx is the store of the site ctx is for, traced as part of ctx.
+func storeCtx(ctx context.Context) storage.Storage {
+	return tracing.Storage{Ctx: ctx, Storage: siteOf(ctx).store()}
+}
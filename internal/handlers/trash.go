@@ -0,0 +1,328 @@
+package handlers
+
+//The trash. Deleting a page moves its files aside rather than removing them,
+//to ~{id}~{name} in the store (no slug has a ~ in it, so they never pass for
+//pages), and notes it in trash.json. Admins can see what's there at
+///admin/trash and put pages back, and pages that have been in the trash for
+//trash.purge_after_days are removed for good.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Where the list of trashed pages is kept, in each site's store.
+const trashFile = "trash.json"
+
+// How often the trash is checked for pages to purge.
+const trashPurgeInterval = time.Hour
+
+// trashedPage is a page in the trash.
+type trashedPage struct {
+	ID        string    `json:"id"`
+	Slug      string    `json:"slug"`
+	Title     string    `json:"title"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+	Files     []string  `json:"files"`               // Its files' names as they were
+	Redirects []string  `json:"redirects,omitempty"` // Old slugs and aliases that pointed at it
+	Views     int64     `json:"views,omitempty"`
+}
+
+// purgeAt is when the page goes for good, trash.purge_after_days after it
+// was deleted, zero if it's kept.
+func (t trashedPage) purgeAt(purgeAfterDays int) time.Time {
+	if purgeAfterDays == 0 {
+		return time.Time{}
+	}
+	return t.DeletedAt.AddDate(0, 0, purgeAfterDays)
+}
+
+// A page of that name exists again, so a trashed one can't be restored.
+var errPageExists = errors.New("a page with that name exists")
+
+// trashName is where a trashed file is kept.
+func trashName(id, name string) string {
+	return "~" + id + "~" + name
+}
+
+// loadTrash reads the site's trash, newest first. An empty trash is fine.
+func loadTrash(ctx context.Context) ([]trashedPage, error) {
+	var trash []trashedPage
+	data, err := storeCtx(ctx).ReadFile(trashFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return trash, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &trash)
+	return trash, err
+}
+
+// saveTrash writes the site's trash. Callers must hold trashMu.
+func saveTrash(ctx context.Context, trash []trashedPage) error {
+	data, err := json.MarshalIndent(trash, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storeCtx(ctx).WriteFile(trashFile, data)
+}
+
+// deletePage moves a page and everything that goes with it to the trash, and
+// drops the redirects to it. The page goes first, so it's never shown half
+// gone. Callers must hold createMu.
+func deletePage(ctx context.Context, slug, by string) error {
+	pages := storeCtx(ctx)
+	if !pageExists(ctx, slug) {
+		return fs.ErrNotExist
+	}
+	names := []string{slug + ".txt"}
+	for _, name := range suffixed(slug, pageCompanionSuffixes) {
+		if _, err := pages.ModTime(name); err == nil {
+			names = append(names, name)
+		}
+	}
+	attachments, err := attachmentFiles(ctx, slug)
+	if err != nil {
+		return err
+	}
+	names = append(names, attachments...)
+
+	b := make([]byte, 8)
+	rand.Read(b)
+	trashed := trashedPage{ID: hex.EncodeToString(b), Slug: slug, Title: pageTitle(ctx, slug), DeletedAt: time.Now().UTC(), DeletedBy: by, Files: names}
+	for _, name := range names {
+		data, err := pages.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := pages.WriteFile(trashName(trashed.ID, name), data); err != nil {
+			return err
+		}
+	}
+	if trashed.Redirects, err = removeRedirectsTo(ctx, slug); err != nil {
+		return err
+	}
+	trashed.Views = forgetViewCount(ctx, slug)
+
+	siteOf(ctx).trashMu.Lock()
+	defer siteOf(ctx).trashMu.Unlock()
+	trash, err := loadTrash(ctx)
+	if err != nil {
+		return err
+	}
+	if err := saveTrash(ctx, slices.Insert(trash, 0, trashed)); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := pages.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// restorePage puts a trashed page back where it was, with its redirects.
+// Callers must hold createMu.
+func restorePage(ctx context.Context, id string) (trashedPage, error) {
+	siteOf(ctx).trashMu.Lock()
+	defer siteOf(ctx).trashMu.Unlock()
+	trash, err := loadTrash(ctx)
+	if err != nil {
+		return trashedPage{}, err
+	}
+	i := slices.IndexFunc(trash, func(t trashedPage) bool { return t.ID == id })
+	if i < 0 {
+		return trashedPage{}, fs.ErrNotExist
+	}
+	trashed := trash[i]
+	if pageExists(ctx, trashed.Slug) {
+		return trashed, errPageExists
+	}
+
+	// The page itself goes last, so it never shows up without its votes
+	pages := storeCtx(ctx)
+	for _, name := range slices.Backward(trashed.Files) {
+		data, err := pages.ReadFile(trashName(id, name))
+		if err != nil {
+			return trashed, err
+		}
+		if err := pages.WriteFile(name, data); err != nil {
+			return trashed, err
+		}
+	}
+	if err := saveTrash(ctx, slices.Delete(trash, i, i+1)); err != nil {
+		return trashed, err
+	}
+	removeTrashedFiles(ctx, trashed)
+	for _, from := range trashed.Redirects {
+		if pageExists(ctx, from) {
+			continue // Taken by a page of its own meanwhile
+		}
+		if err := addRedirect(ctx, from, trashed.Slug); err != nil {
+			slog.ErrorContext(ctx, "Error restoring redirect", "from", from, "err", err)
+		}
+	}
+	restoreViewCount(ctx, trashed.Slug, trashed.Views)
+	return trashed, nil
+}
+
+// purgeTrashed removes pages from the trash for good. Callers must hold
+// trashMu.
+func purgeTrashed(ctx context.Context, purge func(trashedPage) bool) ([]trashedPage, error) {
+	trash, err := loadTrash(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var purged []trashedPage
+	kept := slices.DeleteFunc(slices.Clone(trash), func(t trashedPage) bool {
+		if purge(t) {
+			purged = append(purged, t)
+			return true
+		}
+		return false
+	})
+	if len(purged) == 0 {
+		return nil, nil
+	}
+	if err := saveTrash(ctx, kept); err != nil {
+		return nil, err
+	}
+	for _, t := range purged {
+		removeTrashedFiles(ctx, t)
+	}
+	return purged, nil
+}
+
+// removeTrashedFiles removes a trashed page's files from the store. One
+// that won't go is only left taking up space, so it's just logged.
+func removeTrashedFiles(ctx context.Context, t trashedPage) {
+	for _, name := range t.Files {
+		if err := storeCtx(ctx).Remove(trashName(t.ID, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.ErrorContext(ctx, "Error removing trashed file", "file", trashName(t.ID, name), "err", err)
+		}
+	}
+}
+
+// runTrashPurger purges every site's pages that have been in the trash long
+// enough, until ctx is done.
+func (srv *Server) runTrashPurger(ctx context.Context) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		for _, s := range srv.servedSites() {
+			if srv.readOnly() {
+				break // They'll be purged once we're writable again
+			}
+			siteCtx := context.WithValue(ctx, siteKey{}, s)
+			now := time.Now()
+			s.trashMu.Lock()
+			purged, err := purgeTrashed(siteCtx, func(t trashedPage) bool {
+				purgeAt := t.purgeAt(srv.config.Trash.PurgeAfterDays)
+				return !purgeAt.IsZero() && !purgeAt.After(now)
+			})
+			s.trashMu.Unlock()
+			if err != nil {
+				slog.Error("Error purging the trash", "err", err)
+			}
+			for _, t := range purged {
+				slog.Info("Trashed page purged", "page", t.Slug, "deleted_at", t.DeletedAt)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// adminTrashHandler serves /admin/trash: GET lists what's in the trash,
+// as admin_trash.html or, asked for with Accept: application/json, as JSON.
+// POST /admin/trash/{id}/restore puts a page back, answering with
+// {"slug": "...", "url": "..."}, and DELETE /admin/trash/{id} purges it now.
+func (srv *Server) adminTrashHandler(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/admin/trash"), "/"), "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		trash, err := loadTrash(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading the trash", "err", err)
+			http.Error(w, "Could not read the trash", http.StatusInternalServerError)
+			return
+		}
+		if wantsJSON(r) {
+			if trash == nil {
+				trash = []trashedPage{} // [] rather than null
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(trash)
+			return
+		}
+		if err := renderTemplate(r.Context(), w, "admin_trash.html", buildAdminTrashView(trash, srv.config.Trash.PurgeAfterDays)); err != nil {
+			slog.ErrorContext(r.Context(), "Error executing admin trash template", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+
+	case id != "" && action == "restore" && r.Method == http.MethodPost:
+		siteOf(r.Context()).createMu.Lock()
+		trashed, err := restorePage(r.Context(), id)
+		siteOf(r.Context()).createMu.Unlock()
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			http.NotFound(w, r)
+			return
+		case errors.Is(err, errPageExists):
+			http.Error(w, fmt.Sprintf("There's a page called %s again, rename it first", trashed.Slug), http.StatusConflict)
+			return
+		case err != nil:
+			if quotaError(w, err) {
+				return
+			}
+			slog.ErrorContext(r.Context(), "Error restoring page", "err", err)
+			http.Error(w, "Could not restore page", http.StatusInternalServerError)
+			return
+		}
+		setLogSlug(r, trashed.Slug)
+		slog.InfoContext(r.Context(), "Page restored from the trash")
+		if !isRestricted(r.Context(), trashed.Slug) {
+			queueSearchPing(r.Context(), trashed.Slug)
+		}
+		purgePage(r.Context(), trashed.Slug)
+		purgeListings(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"slug": trashed.Slug, "url": sitePath(r.Context(), pagePath(trashed.Slug))})
+
+	case id != "" && action == "" && r.Method == http.MethodDelete:
+		siteOf(r.Context()).trashMu.Lock()
+		purged, err := purgeTrashed(r.Context(), func(t trashedPage) bool { return t.ID == id })
+		siteOf(r.Context()).trashMu.Unlock()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error purging the trash", "err", err)
+			http.Error(w, "Could not purge page", http.StatusInternalServerError)
+			return
+		}
+		if len(purged) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		slog.InfoContext(r.Context(), "Trashed page purged", "page", purged[0].Slug)
+		w.Write([]byte("Page purged!"))
+
+	case action == "" || action == "restore":
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,214 @@
+package handlers
+
+//The data each template renders. Every template gets its own view struct,
+//built by its own function, so what a template can use is spelled out in
+//one place instead of being whatever a handler happened to fill in.
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// IndexView is what index.html renders.
+type IndexView struct {
+	pagination
+	PerPage int
+	Sort    string
+	Sorts   []string // What ?sort= can be
+	Pages   []IndexEntry
+	Year    int
+}
+
+// IndexEntry is one page in the homepage list.
+type IndexEntry struct {
+	Slug     string
+	Title    string
+	Modified time.Time
+	Created  time.Time
+	Excerpt  string // The start of the page's text
+}
+
+// PageView is what page.html renders.
+type PageView struct {
+	*Page
+	CanonicalURL string
+	Comments     CommentList // The page of approved comments being shown
+	Backlinks    []Backlink  // Pages that link here
+	Shared       bool        // Seen through a share link, so only to read
+	Year         int
+}
+
+// AdminView is what admin_comments.html renders.
+type AdminView struct {
+	pagination
+	Status   string   // Which comments we're looking at
+	Statuses []string // The tabs to switch between
+	Comments []moderatedComment
+	Year     int
+}
+
+// ExportView is what export.html renders.
+type ExportView struct {
+	*Page
+	CSS    template.CSS
+	Videos []exportVideo
+	Year   int
+}
+
+// SearchView is what search.html renders.
+type SearchView struct {
+	Query   string
+	Results []SearchResult
+	Create  *searchCreate // Offered when nothing was found
+	Year    int
+}
+
+// AdminSearchView is what admin_search.html renders.
+type AdminSearchView struct {
+	Missing []searchQueryStat // Searched for, but nothing found last time
+	Top     []searchQueryStat
+	Year    int
+}
+
+// PopularView is what popular.html renders.
+type PopularView struct {
+	Pages []PopularPage
+	Year  int
+}
+
+// ArchiveView is what archive.html renders.
+type ArchiveView struct {
+	Pages []ArchivedPage // Most recently archived first
+	Year  int
+}
+
+// AdminTrashView is what admin_trash.html renders.
+type AdminTrashView struct {
+	Pages          []trashedPage // Most recently deleted first
+	PurgeAfterDays int
+	Year           int
+}
+
+// PurgeAt is when a page in the trash goes for good, zero if it's kept.
+func (v AdminTrashView) PurgeAt(t trashedPage) time.Time {
+	return t.purgeAt(v.PurgeAfterDays)
+}
+
+// UnavailableView is what unavailable.html renders.
+type UnavailableView struct {
+	Since time.Time // When the pages directory went away
+	Year  int
+}
+
+// NotFoundView is what not-found.html renders.
+type NotFoundView struct {
+	Path   string // What was asked for
+	Create string // The name of the missing page, to offer to create it
+	Year   int
+}
+
+// ErrorView is what error.html renders.
+type ErrorView struct {
+	Status    int
+	RequestID string // To quote when reporting it, it's in the logs
+	Year      int
+}
+
+// buildIndexView lists one page of the given pages on the homepage.
+func buildIndexView(ctx context.Context, entries []IndexEntry, params indexParams) IndexView {
+	view := IndexView{PerPage: params.PerPage, Sort: params.Sort, Sorts: indexSorts, Year: time.Now().Year()}
+	var start, end int
+	view.pagination, start, end = paginate(len(entries), params.PerPage, params.Number)
+	view.Pages = entries[start:end]
+	addIndexExcerpts(ctx, view.Pages)
+	return view
+}
+
+// buildPageView wraps a loaded page with what's specific to this request.
+func buildPageView(r *http.Request, page *Page, comments CommentList) PageView {
+	return PageView{
+		Page:         page,
+		CanonicalURL: siteBaseURL(r) + page.path(),
+		Comments:     comments,
+		Backlinks:    pageBacklinks(r.Context(), page.Slug),
+		Year:         time.Now().Year(),
+	}
+}
+
+// buildAdminView shows one page of comments with the given status.
+func buildAdminView(status string, statuses []string, matches []moderatedComment, number int) AdminView {
+	view := AdminView{Status: status, Statuses: statuses, Year: time.Now().Year()}
+	var start, end int
+	view.pagination, start, end = paginate(len(matches), adminCommentsPerPage, number)
+	view.Comments = matches[start:end]
+	return view
+}
+
+// buildExportView swaps a page's players for links and embedded thumbnails.
+func buildExportView(page *Page, css template.CSS) ExportView {
+	view := ExportView{Page: page, CSS: css, Year: time.Now().Year()}
+	for _, video := range page.YouTubeEmbed {
+		view.Videos = append(view.Videos, exportVideo{
+			YouTubeVideo: video,
+			WatchURL:     "https://www.youtube.com/watch?v=" + video.ID,
+			Thumbnail:    fetchThumbnail(video.ID),
+		})
+	}
+	return view
+}
+
+// buildSearchView shows the results for a query.
+func buildSearchView(r *http.Request, query string, results []SearchResult) SearchView {
+	return SearchView{
+		Query:   query,
+		Results: results,
+		Create:  createSuggestion(r.Context(), query, results),
+		Year:    time.Now().Year(),
+	}
+}
+
+// buildAdminSearchView shows what visitors have been searching for.
+func buildAdminSearchView(ctx context.Context) AdminSearchView {
+	view := AdminSearchView{Year: time.Now().Year()}
+	view.Missing, view.Top = searchReport(ctx)
+	return view
+}
+
+// buildPopularView lists the most viewed pages.
+func buildPopularView(pages []PopularPage) PopularView {
+	return PopularView{Pages: pages, Year: time.Now().Year()}
+}
+
+// buildArchiveView lists the archived pages.
+func buildArchiveView(pages []ArchivedPage) ArchiveView {
+	return ArchiveView{Pages: pages, Year: time.Now().Year()}
+}
+
+// buildAdminTrashView lists the pages in the trash.
+func buildAdminTrashView(trash []trashedPage, purgeAfterDays int) AdminTrashView {
+	return AdminTrashView{Pages: trash, PurgeAfterDays: purgeAfterDays, Year: time.Now().Year()}
+}
+
+// buildUnavailableView explains that pages can't be shown right now.
+func (srv *Server) buildUnavailableView() UnavailableView {
+	srv.pagesDirState.RLock()
+	defer srv.pagesDirState.RUnlock()
+	return UnavailableView{Since: srv.pagesDirState.since, Year: time.Now().Year()}
+}
+
+// buildNotFoundView offers to create the page a /page/ path was for.
+func buildNotFoundView(r *http.Request) NotFoundView {
+	view := NotFoundView{Path: r.URL.Path, Year: time.Now().Year()}
+	if slug, ok := strings.CutPrefix(r.URL.Path, "/page/"); ok && slug != "" && !strings.Contains(slug, "/") {
+		view.Create = strings.ReplaceAll(slug, "-", " ")
+	}
+	return view
+}
+
+// buildErrorView describes a failure the request ran into.
+func buildErrorView(r *http.Request, status int) ErrorView {
+	return ErrorView{Status: status, RequestID: requestID(r.Context()), Year: time.Now().Year()}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+//Votes on a page's videos, kept in {slug}.votes.json as video ID -> score.
+//Besides single votes there's a batch API, for clients that queue votes up
+//while offline and send them all once they're back. Votes for videos taken
+//off a page are dropped once a day, see compactVotes.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"go-trailer/internal/slugs"
+	"go-trailer/internal/storage"
+	"go-trailer/internal/videos"
+)
+
+// Most votes one batch can carry.
+const maxBatchVotes = 500
+
+// How often votes for videos that are gone are dropped.
+const voteCompactInterval = 24 * time.Hour
+
+// batchVote is one vote in a batch. ID is the client's own reference for
+// it, echoed back in the result.
+type batchVote struct {
+	ID      string `json:"id,omitempty"`
+	Slug    string `json:"slug"`
+	VideoID string `json:"video_id"`
+	Action  string `json:"action"` // "upvote" or "downvote"
+}
+
+// batchVoteResult is how one vote in a batch went.
+type batchVoteResult struct {
+	ID      string `json:"id,omitempty"`
+	Slug    string `json:"slug"`
+	VideoID string `json:"video_id"`
+	Status  string `json:"status"`          // "ok", "invalid", or "skipped" when another vote was invalid
+	Error   string `json:"error,omitempty"` // Why it was invalid
+	Votes   int    `json:"votes"`           // The video's score afterwards
+}
+
+// readVotes reads a page's votes. A page nobody has voted on has none.
+func readVotes(ctx context.Context, slug string) (map[string]int, error) {
+	votes := make(map[string]int)
+	data, err := storeCtx(ctx).ReadFile(slug + ".votes.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return votes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &votes); err != nil {
+		return nil, err
+	}
+	return votes, nil
+}
+
+// writeVotes saves a page's votes. Callers must hold votesMu.
+func writeVotes(ctx context.Context, slug string, votes map[string]int) error {
+	data, err := json.Marshal(votes)
+	if err != nil {
+		return err
+	}
+	return storeCtx(ctx).WriteFile(slug+".votes.json", data)
+}
+
+// compactVotes drops the votes in a page's votes file for videos its link
+// file no longer has, answering with their IDs. A page left with no votes
+// has its votes file removed.
+func compactVotes(ctx context.Context, slug string) ([]string, error) {
+	siteOf(ctx).votesMu.Lock()
+	defer siteOf(ctx).votesMu.Unlock()
+	votes, err := readVotes(ctx, slug)
+	if err != nil || len(votes) == 0 {
+		return nil, err
+	}
+	videos, err := pageVideoIDs(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	var dropped []string
+	for videoID := range votes {
+		if !videos[videoID] {
+			dropped = append(dropped, videoID)
+			delete(votes, videoID)
+		}
+	}
+	if len(dropped) == 0 {
+		return nil, nil
+	}
+	slices.Sort(dropped)
+	if len(votes) == 0 {
+		err = storeCtx(ctx).Remove(slug + ".votes.json")
+	} else {
+		err = writeVotes(ctx, slug, votes)
+	}
+	return dropped, err
+}
+
+// runVoteCompactor compacts every site's votes files, once a day until ctx
+// is done.
+func (srv *Server) runVoteCompactor(ctx context.Context) {
+	ticker := time.NewTicker(voteCompactInterval)
+	defer ticker.Stop()
+	for {
+		for _, s := range srv.servedSites() {
+			if srv.readOnly() {
+				break // They'll be compacted once we're writable again
+			}
+			siteCtx := context.WithValue(ctx, siteKey{}, s)
+			slugs, err := storage.PageSlugs(storeCtx(siteCtx))
+			if err != nil {
+				slog.Error("Error listing pages to compact votes", "err", err)
+				continue
+			}
+			for _, slug := range slugs {
+				dropped, err := compactVotes(siteCtx, slug)
+				if err != nil {
+					slog.Error("Error compacting votes", "page", slug, "err", err)
+					continue
+				}
+				if len(dropped) > 0 {
+					slog.Info("Votes for removed videos dropped", "page", slug, "videos", dropped)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// errVotePageNotFound is checkBatchVote's answer for a vote on a page
+// that isn't there.
+var errVotePageNotFound = errors.New("page not found")
+
+// checkBatchVote says what's wrong with a vote, if anything. A page the
+// request can't see is as good as not there.
+func checkBatchVote(r *http.Request, v batchVote) error {
+	switch {
+	case v.Action != "upvote" && v.Action != "downvote":
+		return errors.New("action must be upvote or downvote")
+	case !videos.ValidID(v.VideoID):
+		return errors.New("invalid video ID")
+	case !slugs.Valid(v.Slug):
+		return errors.New("invalid page")
+	}
+	if _, err := storeCtx(r.Context()).ModTime(v.Slug + ".txt"); err != nil {
+		return errVotePageNotFound
+	}
+	if !canSeePage(r, accessMeta(r.Context(), v.Slug)) {
+		return errVotePageNotFound // Not saying there's a page there
+	}
+	return nil
+}
+
+// voteBatchRequest is the body of POST /api/vote/batch.
+type voteBatchRequest struct {
+	Votes []batchVote `json:"votes"`
+}
+
+// voteBatchHandler handles POST /api/vote/batch with a JSON body of
+// {"votes": [{"id": "...", "slug": "...", "video_id": "...", "action": "upvote"}, ...]}.
+//
+// The batch is all or nothing: if any vote is invalid none are applied, and
+// the results (in the same order as the votes) say which ones were the problem.
+func (srv *Server) voteBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var reqBody voteBatchRequest
+	if err := readJSON(w, r, &reqBody); err != nil {
+		badJSON(w, err)
+		return
+	}
+	if len(reqBody.Votes) == 0 {
+		fieldError(w, "votes", "required", "No votes given")
+		return
+	}
+	if len(reqBody.Votes) > maxBatchVotes {
+		writeProblem(w, http.StatusRequestEntityTooLarge, "too_many_votes", fmt.Sprintf("Too many votes, send at most %d at a time", maxBatchVotes))
+		return
+	}
+
+	results := make([]batchVoteResult, len(reqBody.Votes))
+	valid := true
+	for i, v := range reqBody.Votes {
+		results[i] = batchVoteResult{ID: v.ID, Slug: v.Slug, VideoID: v.VideoID, Status: "ok"}
+		if err := checkBatchVote(r, v); err != nil {
+			results[i].Status, results[i].Error = "invalid", err.Error()
+			valid = false
+		}
+	}
+	if !valid {
+		for i := range results {
+			if results[i].Status == "ok" {
+				results[i].Status = "skipped"
+			}
+		}
+		writeBatchVoteResults(w, http.StatusUnprocessableEntity, false, results)
+		return
+	}
+
+	siteOf(r.Context()).votesMu.Lock()
+	defer siteOf(r.Context()).votesMu.Unlock()
+
+	// Read every page involved before changing anything
+	before := make(map[string]map[string]int)
+	after := make(map[string]map[string]int)
+	var order []string // Pages in the order we first saw them, so writes are predictable
+	for _, v := range reqBody.Votes {
+		if _, ok := before[v.Slug]; ok {
+			continue
+		}
+		votes, err := readVotes(r.Context(), v.Slug)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading votes", "page", v.Slug, "err", err)
+			apiError(w, "Could not process votes", http.StatusInternalServerError)
+			return
+		}
+		before[v.Slug], after[v.Slug] = votes, maps.Clone(votes)
+		order = append(order, v.Slug)
+	}
+
+	for i, v := range reqBody.Votes {
+		if v.Action == "upvote" {
+			after[v.Slug][v.VideoID]++
+		} else {
+			after[v.Slug][v.VideoID]--
+		}
+		results[i].Votes = after[v.Slug][v.VideoID]
+	}
+
+	// Each file is written atomically. If one fails, put back the ones we
+	// already wrote so the batch still counts for nothing.
+	for n, slug := range order {
+		if err := writeVotes(r.Context(), slug, after[slug]); err != nil {
+			slog.ErrorContext(r.Context(), "Error writing votes file, rolling back batch", "page", slug, "err", err)
+			for _, written := range order[:n] {
+				if err := writeVotes(r.Context(), written, before[written]); err != nil {
+					slog.ErrorContext(r.Context(), "Error rolling back votes", "page", written, "err", err)
+				}
+			}
+			if quotaError(w, err) {
+				return
+			}
+			apiError(w, "Could not save votes", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeBatchVoteResults(w, http.StatusOK, true, results)
+	slog.InfoContext(r.Context(), "Vote batch saved", "votes", len(reqBody.Votes), "pages", len(order))
+	changed := make(map[string]map[string]int)
+	for _, v := range reqBody.Votes {
+		if changed[v.Slug] == nil {
+			changed[v.Slug] = make(map[string]int)
+		}
+		changed[v.Slug][v.VideoID] = after[v.Slug][v.VideoID]
+	}
+	for _, slug := range order {
+		publishVotes(r.Context(), slug, changed[slug])
+		purgePage(r.Context(), slug)
+	}
+}
+
+// writeBatchVoteResults sends {"applied": ..., "results": [...]}.
+func writeBatchVoteResults(w http.ResponseWriter, status int, applied bool, results []batchVoteResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"applied": applied, "results": results})
+}
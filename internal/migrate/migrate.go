@@ -0,0 +1,113 @@
+// Package migrate upgrades what's kept on disk when its format changes, so a
+// pages directory from an older version keeps working after an upgrade.
+// Each store records the format it's in, in format.json, and at startup
+// every migration newer than that is run on it in order. A pages directory
+// is backed up next to itself first, as {dir}-format{n}-{time}.tar.gz, in
+// case one goes wrong.
+//
+// To change a format, add a migration to the end of the list the server
+// runs (see internal/handlers/migrate.go) that brings the old files up to
+// date. Never change or remove one that's shipped.
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go-trailer/internal/backups"
+	"go-trailer/internal/storage"
+)
+
+// Where the format a store is in is kept, in each site's store.
+const formatFile = "format.json"
+
+// Migration upgrades a store from the format before it to its own.
+type Migration struct {
+	Name string
+	Run  func(ctx context.Context) error // Given the ctx passed to Run, to find the store by
+}
+
+// dataFormat is what's in formatFile.
+type dataFormat struct {
+	Version  int       `json:"version"`
+	Migrated time.Time `json:"migrated,omitzero"` // When it was last upgraded
+}
+
+// readDataFormat reads the format store is in. Stores from before formats
+// were recorded are format 0.
+func readDataFormat(store storage.Storage) (dataFormat, error) {
+	var format dataFormat
+	data, err := store.ReadFile(formatFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return format, nil
+	}
+	if err != nil {
+		return format, err
+	}
+	err = json.Unmarshal(data, &format)
+	return format, err
+}
+
+// Run runs the migrations, oldest first, that store hasn't had: a store in
+// format n has had the first n. The format is recorded after each, so one
+// that fails is the one retried. dir is the directory store keeps its files
+// in, or "" if it's a storage plugin's.
+func Run(ctx context.Context, store storage.Storage, dir string, migrations []Migration) error {
+	where := dir
+	if dir == "" {
+		where = "storage plugin"
+	}
+	var files []os.DirEntry
+	if dir != "" {
+		var err error
+		if files, err = os.ReadDir(dir); errors.Is(err, fs.ErrNotExist) {
+			return nil // Nothing to migrate yet, the server deals with it missing
+		}
+	}
+
+	format, err := readDataFormat(store)
+	if err != nil {
+		return fmt.Errorf("%s: reading %s: %w", where, formatFile, err)
+	}
+	if format.Version > len(migrations) {
+		return fmt.Errorf("%s is in format %d, but this version only knows up to %d; upgrade instead", where, format.Version, len(migrations))
+	}
+	if format.Version == len(migrations) {
+		return nil
+	}
+
+	if len(files) > 0 {
+		name := fmt.Sprintf("%s-format%d-%s.tar.gz", filepath.Base(dir), format.Version, time.Now().UTC().Format("20060102T150405Z"))
+		if _, err := backups.WriteTarGz(dir, filepath.Dir(dir), name); err != nil {
+			return fmt.Errorf("%s: backing up before migrating: %w", where, err)
+		}
+		slog.Info("Backed up pages before migrating", "dir", dir, "backup", name)
+	} else if dir == "" {
+		slog.Warn("Migrating without a backup, the storage plugin keeps its own files", "from", format.Version)
+	}
+
+	for format.Version < len(migrations) {
+		m := migrations[format.Version]
+		if err := m.Run(ctx); err != nil {
+			return fmt.Errorf("%s: migrating to format %d (%s): %w", where, format.Version+1, m.Name, err)
+		}
+		format.Version++
+		format.Migrated = time.Now().UTC()
+		data, err := json.Marshal(format)
+		if err != nil {
+			return err
+		}
+		if err := store.WriteFile(formatFile, data); err != nil {
+			return fmt.Errorf("%s: writing %s: %w", where, formatFile, err)
+		}
+		slog.Info("Pages migrated", "dir", where, "format", format.Version, "migration", m.Name)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+// Package plugins runs external plugin processes and talks to them over RPC.
+//
+// Plugins are executables dropped into the plugins/ folder. On startup the server
+// runs each one and speaks JSON-RPC 1.0 (the net/rpc/jsonrpc wire format) with it
+// over the plugin's stdin/stdout. Whatever the plugin prints to stderr ends up in
+// our log. The plugin is started with WEBSITE_PLUGIN=1 in its environment so it
+// can tell it's being run by the server and not by hand.
+//
+// Every plugin must answer Plugin.Info. Depending on the kinds it reports back it
+// must also answer:
+//
+//	content:    Plugin.ProcessContent  (rewrite a page body before it's rendered)
+//	auth:       Plugin.Authenticate    (check a username/password for write access)
+//	moderation: Plugin.Moderate        (pass, clean up, flag or reject what's
+//	            sent in before it's saved, see internal/handlers/moderation.go)
+//	storage:    Plugin.ReadFile, Plugin.WriteFile, Plugin.AppendFile,
+//	            Plugin.ModTime, Plugin.List (replace the pages folder entirely),
+//	            and Plugin.Remove for renaming pages
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go-trailer/internal/storage"
+)
+
+// Bump this whenever the messages below change in an incompatible way.
+const pluginProtocolVersion = 1
+
+// How long we wait on a plugin before giving up on a call.
+const pluginCallTimeout = 5 * time.Second
+
+// The kinds of plugin we know how to use.
+const (
+	pluginKindContent    = "content"
+	pluginKindAuth       = "auth"
+	pluginKindStorage    = "storage"
+	pluginKindModeration = "moderation"
+)
+
+// --- Wire messages ---
+
+// pluginInfo is the reply to Plugin.Info.
+type pluginInfo struct {
+	Name            string   `json:"name"`
+	Kinds           []string `json:"kinds"`
+	ProtocolVersion int      `json:"protocol_version"`
+}
+
+type contentArgs struct {
+	Slug string `json:"slug"`
+	Body string `json:"body"`
+}
+
+type contentReply struct {
+	Body string `json:"body"`
+}
+
+type authArgs struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type authReply struct {
+	OK bool `json:"ok"`
+}
+
+type storageArgs struct {
+	Name string `json:"name"`
+	Data []byte `json:"data,omitempty"` // base64 in JSON
+}
+
+type storageReply struct {
+	Data     []byte    `json:"data,omitempty"`
+	ModTime  time.Time `json:"mod_time,omitempty"`
+	Names    []string  `json:"names,omitempty"`
+	NotFound bool      `json:"not_found,omitempty"` // Lets us hand back fs.ErrNotExist
+}
+
+// --- Plugin processes ---
+
+// Plugin is one running plugin process.
+type Plugin struct {
+	name   string
+	kinds  []string
+	cmd    *exec.Cmd
+	client *rpc.Client
+}
+
+// Set is the plugins a Server started, and those that registered for each
+// kind, in load order.
+type Set struct {
+	loaded     []*Plugin
+	content    []*Plugin
+	auth       []*Plugin
+	moderation []*Plugin
+}
+
+// pluginConn glues the plugin's stdout and stdin into the one stream net/rpc wants.
+type pluginConn struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (c pluginConn) Close() error {
+	return errors.Join(c.WriteCloser.Close(), c.ReadCloser.Close())
+}
+
+// Load starts every executable in dir and registers it for the kinds it
+// reports, returning the storage one if there is one. A missing directory
+// just means no plugins.
+func (ps *Set) Load(dir string) (_ storage.Storage, err error) {
+	// If one fails to start, those that did are stopped again
+	defer func() {
+		if err != nil {
+			ps.Stop()
+			*ps = Set{}
+		}
+	}()
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pages storage.Storage
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		p, err := startPlugin(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", entry.Name(), err)
+		}
+		ps.loaded = append(ps.loaded, p)
+
+		if p.has(pluginKindContent) {
+			ps.content = append(ps.content, p)
+		}
+		if p.has(pluginKindAuth) {
+			ps.auth = append(ps.auth, p)
+		}
+		if p.has(pluginKindModeration) {
+			ps.moderation = append(ps.moderation, p)
+		}
+		if p.has(pluginKindStorage) {
+			if pages != nil {
+				return nil, fmt.Errorf("plugin %s: another plugin already provides storage", p.name)
+			}
+			pages = pluginStorage{p}
+		}
+		slog.Info("Loaded plugin", "plugin", p.name, "kinds", strings.Join(p.kinds, ", "))
+	}
+	return pages, nil
+}
+
+// startPlugin runs the executable at path and does the Plugin.Info handshake.
+func startPlugin(path string) (*Plugin, error) {
+	cmd := exec.Command(path)
+	cmd.Env = append(os.Environ(), "WEBSITE_PLUGIN=1")
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p := &Plugin{
+		name:   filepath.Base(path),
+		cmd:    cmd,
+		client: jsonrpc.NewClient(pluginConn{ReadCloser: stdout, WriteCloser: stdin}),
+	}
+
+	var info pluginInfo
+	if err := p.Call("Plugin.Info", struct{}{}, &info); err != nil {
+		p.stop()
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+	if info.ProtocolVersion != pluginProtocolVersion {
+		p.stop()
+		return nil, fmt.Errorf("speaks protocol version %d, we need %d", info.ProtocolVersion, pluginProtocolVersion)
+	}
+	if info.Name != "" {
+		p.name = info.Name
+	}
+	p.kinds = info.Kinds
+	return p, nil
+}
+
+// Call makes an RPC to the plugin, but won't hang forever on a stuck process.
+func (p *Plugin) Call(method string, args, reply any) error {
+	pending := p.client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case call := <-pending.Done:
+		return call.Error
+	case <-time.After(pluginCallTimeout):
+		return fmt.Errorf("plugin %s: %s timed out", p.name, method)
+	}
+}
+
+func (p *Plugin) has(kind string) bool {
+	return slices.Contains(p.kinds, kind)
+}
+
+// Name is what the plugin calls itself, or its file's name.
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+// stop closes the connection, which tells the plugin to exit, and reaps it.
+// Plugins that don't take the hint are killed.
+func (p *Plugin) stop() {
+	p.client.Close()
+	exited := make(chan struct{})
+	go func() {
+		p.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(pluginCallTimeout):
+		slog.Warn("Plugin didn't exit, killing it", "plugin", p.name)
+		p.cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// Stop shuts down every plugin we started.
+func (ps *Set) Stop() {
+	for _, p := range ps.loaded {
+		p.stop()
+	}
+}
+
+// --- Using plugins ---
+
+// ProcessContent runs a page body through every content plugin in turn. A
+// plugin that fails is skipped rather than taking the page down with it.
+func (ps *Set) ProcessContent(slug, body string) string {
+	for _, p := range ps.content {
+		var reply contentReply
+		if err := p.Call("Plugin.ProcessContent", contentArgs{Slug: slug, Body: body}, &reply); err != nil {
+			slog.Error("Content plugin failed", "plugin", p.name, "page", slug, "err", err)
+			continue
+		}
+		body = reply.Body
+	}
+	return body
+}
+
+// HasAuth reports whether there are auth plugins to log in with.
+func (ps *Set) HasAuth() bool {
+	return len(ps.auth) > 0
+}
+
+// Authenticate asks each auth plugin about the credentials until one accepts them.
+func (ps *Set) Authenticate(username, password string) bool {
+	for _, p := range ps.auth {
+		var reply authReply
+		if err := p.Call("Plugin.Authenticate", authArgs{Username: username, Password: password}, &reply); err != nil {
+			slog.Error("Auth plugin failed", "plugin", p.name, "err", err)
+			continue
+		}
+		if reply.OK {
+			return true
+		}
+	}
+	return false
+}
+
+// Moderators is the moderation plugins, which answer Plugin.Moderate.
+func (ps *Set) Moderators() []*Plugin {
+	return ps.moderation
+}
+
+// pluginStorage is a storage.Storage backed by a plugin process.
+type pluginStorage struct {
+	p *Plugin
+}
+
+// do makes a storage call and turns a NotFound reply back into fs.ErrNotExist.
+func (s pluginStorage) do(method, name string, data []byte) (storageReply, error) {
+	var reply storageReply
+	if err := s.p.Call("Plugin."+method, storageArgs{Name: name, Data: data}, &reply); err != nil {
+		return reply, err
+	}
+	if reply.NotFound {
+		return reply, &fs.PathError{Op: method, Path: name, Err: fs.ErrNotExist}
+	}
+	return reply, nil
+}
+
+func (s pluginStorage) ReadFile(name string) ([]byte, error) {
+	reply, err := s.do("ReadFile", name, nil)
+	return reply.Data, err
+}
+
+func (s pluginStorage) WriteFile(name string, data []byte) error {
+	_, err := s.do("WriteFile", name, data)
+	return err
+}
+
+func (s pluginStorage) AppendFile(name string, data []byte) error {
+	_, err := s.do("AppendFile", name, data)
+	return err
+}
+
+func (s pluginStorage) ModTime(name string) (time.Time, error) {
+	reply, err := s.do("ModTime", name, nil)
+	return reply.ModTime, err
+}
+
+func (s pluginStorage) List() ([]string, error) {
+	reply, err := s.do("List", "", nil)
+	return reply.Names, err
+}
+
+func (s pluginStorage) Remove(name string) error {
+	_, err := s.do("Remove", name, nil)
+	return err
+}
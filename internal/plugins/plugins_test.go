@@ -0,0 +1,71 @@
+package plugins
+
+import (
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// With TEST_PLUGIN_STOPPED set the test binary is a storage plugin instead,
+// that writes that file once it's told to exit.
+func TestMain(m *testing.M) {
+	if stopped := os.Getenv("TEST_PLUGIN_STOPPED"); stopped != "" {
+		servePlugin(stopped)
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// testPlugin answers the handshake of a storage plugin.
+type testPlugin struct{}
+
+// TestPluginInfo is pluginInfo, exported as net/rpc needs.
+type TestPluginInfo pluginInfo
+
+func (testPlugin) Info(args struct{}, reply *TestPluginInfo) error {
+	*reply = TestPluginInfo{Kinds: []string{pluginKindStorage}, ProtocolVersion: pluginProtocolVersion}
+	return nil
+}
+
+// servePlugin serves testPlugin on stdin and stdout until the connection is
+// closed, then writes stopped.
+func servePlugin(stopped string) {
+	server := rpc.NewServer()
+	server.RegisterName("Plugin", testPlugin{})
+	server.ServeCodec(jsonrpc.NewServerCodec(struct {
+		io.Reader
+		io.WriteCloser
+	}{os.Stdin, os.Stdout}))
+	os.WriteFile(stopped, nil, 0644)
+}
+
+func TestPluginsStoppedWhenOneFails(t *testing.T) {
+	dir := t.TempDir()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two storage plugins, so the second one fails to load
+	for _, name := range []string{"a", "b"} {
+		script := "#!/bin/sh\nTEST_PLUGIN_STOPPED=" + filepath.Join(dir, name+".stopped") + " exec " + exe + "\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ps Set
+	if _, err := ps.Load(dir); err == nil {
+		t.Fatal("two storage plugins loaded")
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := os.Stat(filepath.Join(dir, name+".stopped")); err != nil {
+			t.Errorf("plugin %s is still running", name)
+		}
+	}
+	if len(ps.loaded) != 0 {
+		t.Errorf("%d plugins left loaded", len(ps.loaded))
+	}
+}
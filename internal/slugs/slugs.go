@@ -0,0 +1,91 @@
+// Package slugs turns the name someone types into the slug used for a
+// page's URL and files. Accented latin letters are spelled out in plain ASCII
+// ("Café Münch" is cafe-munch), other scripts are kept as they are so a Greek
+// or Japanese title still reads.
+package slugs
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// What a slug can't have: anything but letters, numbers and hyphens.
+var notSlugRegex = regexp.MustCompile(`[^\p{L}\p{N}-]+`)
+
+// What a page's name can't have, letters in any language being fine.
+var notNameRegex = regexp.MustCompile(`[^\p{L}\p{M}\p{N} _-]`)
+
+// Letters that don't come apart into a plain letter and an accent.
+var transliterations = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "đ", "d", "ð", "d",
+	"þ", "th", "ł", "l", "ı", "i", "ŀ", "l", "ħ", "h",
+)
+
+// FromName makes a URL-friendly slug from a page name, e.g. "My Page" to
+// "my-page".
+func FromName(name string) string {
+	slug := transliterate(strings.ToLower(name))
+	slug = strings.ReplaceAll(slug, " ", "-")      // Replace spaces with hyphens
+	slug = notSlugRegex.ReplaceAllString(slug, "") // Remove all other weird characters
+	if slug == "" {
+		slug = "untitled" // Fallback for empty/invalid names
+	}
+	return slug
+}
+
+// Valid reports whether slug is one a page could have: letters, numbers and
+// hyphens only.
+func Valid(slug string) bool {
+	return slug != "" && !notSlugRegex.MatchString(slug)
+}
+
+// CheckName returns an error if name has characters page names can't.
+func CheckName(name string) error {
+	if notNameRegex.MatchString(name) {
+		return errors.New("name contains bad characters")
+	}
+	return nil
+}
+
+// CleanName drops the characters page names can't have.
+func CleanName(name string) string {
+	return notNameRegex.ReplaceAllString(name, "")
+}
+
+// Guesses is what someone may have meant by a slug that isn't quite right,
+// like MyPage, My_Page or my%20page for my-page: what FromName makes of it,
+// or of it split into words.
+func Guesses(slug string) []string {
+	var words []rune
+	for i, r := range []rune(slug) {
+		if unicode.IsUpper(r) && i > 0 && unicode.IsLower(words[len(words)-1]) {
+			words = append(words, '-')
+		}
+		if r == '_' {
+			r = '-'
+		}
+		words = append(words, r)
+	}
+	return []string{FromName(slug), FromName(string(words))}
+}
+
+// transliterate drops the accents from latin letters, é to e and ü to u.
+// Marks on other scripts (like the dakuten in が) are part of the letter, so
+// they stay.
+func transliterate(s string) string {
+	var b strings.Builder
+	latin := false // Whether the marks we're looking at sit on a latin letter
+	for _, r := range norm.NFD.String(transliterations.Replace(s)) {
+		if !unicode.Is(unicode.Mn, r) {
+			latin = unicode.Is(unicode.Latin, r)
+		} else if latin {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return norm.NFC.String(b.String())
+}
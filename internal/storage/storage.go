@@ -0,0 +1,144 @@
+// Package storage is where page files, YouTube link files and vote files
+// actually live. Handlers go through a Storage instead of touching the pages
+// folder directly, so a plugin can provide somewhere else to keep them.
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage is a flat collection of named files, e.g. "my-page.txt",
+// "my-page.youtube.txt" and "my-page.votes.json".
+type Storage interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte) error
+	AppendFile(name string, data []byte) error
+	ModTime(name string) (time.Time, error) // Also doubles as an "does it exist" check
+	List() ([]string, error)
+	Remove(name string) error
+}
+
+// Dir keeps files in a single directory on disk.
+type Dir struct {
+	Path string
+}
+
+// file maps a file name to its place on disk, refusing anything that would
+// escape the directory.
+func (d Dir) file(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	return filepath.Join(d.Path, name), nil
+}
+
+func (d Dir) ReadFile(name string) ([]byte, error) {
+	path, err := d.file(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// WriteFile writes to a temporary file and renames it into place, so a crash
+// or shutdown mid-write never leaves a half written votes file behind.
+func (d Dir) WriteFile(name string, data []byte) error {
+	path, err := d.file(name)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(d.Path, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil { // 0644 = rw-r--r--
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (d Dir) AppendFile(name string, data []byte) error {
+	path, err := d.file(name)
+	if err != nil {
+		return err
+	}
+	// Open the file in append mode, with create-if-not-exist flag
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (d Dir) ModTime(name string) (time.Time, error) {
+	path, err := d.file(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func (d Dir) List() ([]string, error) {
+	entries, err := os.ReadDir(d.Path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (d Dir) Remove(name string) error {
+	path, err := d.file(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// PageSlugs lists the slug of every page in s. Companion files like
+// my-page.youtube.txt are skipped.
+func PageSlugs(s Storage) ([]string, error) {
+	names, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var slugs []string
+	for _, name := range names {
+		if IsPageFile(name) {
+			slugs = append(slugs, strings.TrimSuffix(name, ".txt"))
+		}
+	}
+	return slugs, nil
+}
+
+// IsPageFile reports whether name is a page, not one of its companion files,
+// attachments or pages in the trash.
+func IsPageFile(name string) bool {
+	return strings.HasSuffix(name, ".txt") && !strings.HasSuffix(name, ".youtube.txt") && !strings.ContainsAny(name, "@~")
+}
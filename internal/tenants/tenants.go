@@ -0,0 +1,141 @@
+// Package tenants is many small wikis sharing one site's settings, each
+// with its own directory of pages, votes and comments under tenants.dir. A
+// tenant exists once its directory does, so adding one is a mkdir. Quotas on
+// pages and disk use are enforced by the storage, so everything that writes
+// obeys them.
+package tenants
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"regexp"
+	"sync"
+
+	"go-trailer/internal/config"
+	"go-trailer/internal/storage"
+)
+
+// What a tenant name looks like. It has to work as a directory, a path
+// segment and a DNS label.
+var nameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ErrQuotaExceeded is returned by writes that would take a tenant over quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ValidName reports whether name can be a tenant's.
+func ValidName(name string) bool {
+	return nameRegex.MatchString(name)
+}
+
+// URL is the public URL of the named tenant, worked out from the main
+// site's, or "" if that isn't set.
+func URL(cfg *config.Config, name string) string {
+	if cfg.SiteURL == "" {
+		return ""
+	}
+	if cfg.Tenants.By == "path" {
+		return cfg.SiteURL + "/t/" + name
+	}
+	u, err := url.Parse(cfg.SiteURL)
+	if err != nil {
+		return ""
+	}
+	u.Host = name + "." + cfg.Tenants.Domain
+	return u.String()
+}
+
+// Storage is a tenant's directory, refusing writes that would take it
+// past its limits. Usage is worked out from the directory on every write,
+// which is fine at the size tenants are limited to.
+type Storage struct {
+	storage.Dir
+	maxPages int
+	maxBytes int64
+	mu       *sync.Mutex // Checks and writes take turns, so two can't both fit the last space
+}
+
+// NewStorage is the tenant directory dir, held to the quotas in s.
+func NewStorage(dir string, s config.TenantSettings) Storage {
+	return Storage{Dir: storage.Dir{Path: dir}, maxPages: s.MaxPages, maxBytes: s.MaxBytes, mu: new(sync.Mutex)}
+}
+
+// quotaUsage is what a tenant's directory holds, and how big the file
+// about to be written already is.
+type quotaUsage struct {
+	pages    int
+	bytes    int64
+	exists   bool
+	existing int64
+}
+
+func (q Storage) usage(name string) (quotaUsage, error) {
+	var u quotaUsage
+	entries, err := os.ReadDir(q.Path)
+	if err != nil {
+		return u, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // Removed since we listed it
+		}
+		if err != nil {
+			return u, err
+		}
+		if storage.IsPageFile(entry.Name()) {
+			u.pages++
+		}
+		u.bytes += info.Size()
+		if entry.Name() == name {
+			u.exists, u.existing = true, info.Size()
+		}
+	}
+	return u, nil
+}
+
+// check fails if writing size bytes to name (replacing it, or appending to
+// it) would go over quota.
+func (q Storage) check(name string, size int64, appending bool) error {
+	if q.maxPages == 0 && q.maxBytes == 0 {
+		return nil
+	}
+	u, err := q.usage(name)
+	if err != nil {
+		return err
+	}
+	if q.maxPages > 0 && storage.IsPageFile(name) && !u.exists && u.pages >= q.maxPages {
+		return fmt.Errorf("%w: at most %d pages", ErrQuotaExceeded, q.maxPages)
+	}
+	bytes := u.bytes
+	if !appending {
+		bytes -= u.existing
+	}
+	if q.maxBytes > 0 && bytes+size > q.maxBytes {
+		return fmt.Errorf("%w: at most %d bytes", ErrQuotaExceeded, q.maxBytes)
+	}
+	return nil
+}
+
+func (q Storage) WriteFile(name string, data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.check(name, int64(len(data)), false); err != nil {
+		return err
+	}
+	return q.Dir.WriteFile(name, data)
+}
+
+func (q Storage) AppendFile(name string, data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.check(name, int64(len(data)), true); err != nil {
+		return err
+	}
+	return q.Dir.AppendFile(name, data)
+}
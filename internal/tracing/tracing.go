@@ -0,0 +1,143 @@
+// Package tracing is OpenTelemetry tracing. When enabled every request gets
+// a span, with child spans for the slow parts (storage reads and writes,
+// template rendering), exported over OTLP/HTTP to a collector.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"time"
+
+	"go-trailer/internal/config"
+	"go-trailer/internal/storage"
+	"go-trailer/internal/update"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans we start ourselves come from here. Until Setup runs (or if
+// tracing is off) it's a no-op.
+var tracer = otel.Tracer("go-trailer")
+
+// Setup starts exporting spans. The returned function flushes and stops the
+// exporter, call it on the way out.
+func Setup(ctx context.Context, settings config.TracingSettings) (func(context.Context) error, error) {
+	if !settings.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var opts []otlptracehttp.Option
+	if settings.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpoint(settings.Endpoint))
+	}
+	if settings.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("go-trailer"),
+		semconv.ServiceVersion(update.Version),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// Follow the caller's decision if it already sampled the request
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(settings.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	tracer = provider.Tracer("go-trailer")
+	return provider.Shutdown, nil
+}
+
+// Start starts a child span of whatever ctx is part of. End it with End.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err (if any) on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Storage is a store with a span around every call, as part of Ctx's trace.
+type Storage struct {
+	Ctx context.Context
+	storage.Storage
+}
+
+func (t Storage) span(op, name string) trace.Span {
+	_, span := Start(t.Ctx, "storage."+op, attribute.String("file", name))
+	return span
+}
+
+func (t Storage) ReadFile(name string) ([]byte, error) {
+	span := t.span("ReadFile", name)
+	data, err := t.Storage.ReadFile(name)
+	End(span, ignoreNotExist(err))
+	return data, err
+}
+
+func (t Storage) WriteFile(name string, data []byte) error {
+	span := t.span("WriteFile", name)
+	err := t.Storage.WriteFile(name, data)
+	End(span, err)
+	return err
+}
+
+func (t Storage) AppendFile(name string, data []byte) error {
+	span := t.span("AppendFile", name)
+	err := t.Storage.AppendFile(name, data)
+	End(span, err)
+	return err
+}
+
+func (t Storage) ModTime(name string) (time.Time, error) {
+	span := t.span("ModTime", name)
+	modTime, err := t.Storage.ModTime(name)
+	End(span, ignoreNotExist(err))
+	return modTime, err
+}
+
+func (t Storage) List() ([]string, error) {
+	_, span := Start(t.Ctx, "storage.List")
+	names, err := t.Storage.List()
+	End(span, err)
+	return names, err
+}
+
+func (t Storage) Remove(name string) error {
+	span := t.span("Remove", name)
+	err := t.Storage.Remove(name)
+	End(span, err)
+	return err
+}
+
+// ignoreNotExist leaves out "no such file", which is how we check whether
+// optional files exist, not a failure.
+func ignoreNotExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,285 @@
+// Package update is the go-trailer update subcommand: it fetches a signed
+// release for this platform and swaps it in for the running binary.
+package update
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// These are set at build time, e.g.
+//
+//	go build -ldflags "-X go-trailer/internal/update.Version=v1.2.0 -X go-trailer/internal/update.releaseURL=https://example.com/manifest.json -X go-trailer/internal/update.updatePublicKey=<base64>"
+var (
+	Version         = "dev" // Of this build, also reported with traces
+	releaseURL      = ""
+	updatePublicKey = "" // base64 encoded ed25519 public key that release binaries are signed with
+)
+
+// releaseManifest is the JSON document served at the release endpoint. Binaries
+// are keyed by "GOOS/GOARCH", e.g. "linux/amd64".
+type releaseManifest struct {
+	Version  string                   `json:"version"`
+	Binaries map[string]releaseBinary `json:"binaries"`
+}
+
+// releaseBinary describes one platform build. Signature is a base64 ed25519
+// signature over releaseMessage for it, so a signed binary can't be passed
+// off as another version or for another platform.
+type releaseBinary struct {
+	URL       string `json:"url"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"`
+}
+
+// Downloads can be big, but they shouldn't take forever.
+var updateClient = &http.Client{Timeout: 5 * time.Minute}
+
+// The most we'll download of a manifest and of a binary.
+const (
+	maxManifestBytes = 1 << 20
+	maxReleaseBytes  = 512 << 20
+)
+
+// Run is the entry point for `go-trailer update [flags]`.
+func Run(args []string) error {
+	flags := flag.NewFlagSet("update", flag.ExitOnError)
+	manifestURL := flags.String("url", releaseURL, "release manifest URL")
+	force := flags.Bool("force", false, "reinstall the version that's running")
+	pid := flags.Int("restart-pid", 0, "PID of the running server to signal for a restart once the new binary is in place")
+	flags.Parse(args)
+
+	if *manifestURL == "" {
+		return errors.New("no release URL configured, pass -url")
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(updatePublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("this build has no valid update public key, refusing to install unverified binaries")
+	}
+
+	manifest, err := fetchManifest(*manifestURL)
+	if err != nil {
+		return err
+	}
+	switch newer, err := newerVersion(manifest.Version, Version); {
+	case err != nil:
+		return err
+	case manifest.Version == Version && !*force:
+		slog.Info("Already running the latest version", "version", Version)
+		return nil
+	case !newer && manifest.Version != Version:
+		// Never go back: an old release, signed and all, may be one with a
+		// hole in it that's been fixed since
+		return fmt.Errorf("release %s is older than the running %s, refusing to downgrade", manifest.Version, Version)
+	}
+
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	release, ok := manifest.Binaries[platform]
+	if !ok {
+		return fmt.Errorf("release %s has no binary for %s", manifest.Version, platform)
+	}
+
+	slog.Info("Updating", "from", Version, "to", manifest.Version, "platform", platform)
+	binary, err := downloadRelease(release.URL)
+	if err != nil {
+		return err
+	}
+	if err := verifyRelease(binary, manifest.Version, platform, release, publicKey); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not find the running binary: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("could not resolve the running binary: %w", err)
+	}
+	if err := swapBinary(exe, binary); err != nil {
+		return err
+	}
+	slog.Info("Installed update", "version", manifest.Version, "path", exe)
+
+	// The server process keeps running the old binary until it restarts. We ask
+	// it to stop and leave starting it back up to the process manager.
+	if *pid != 0 {
+		proc, err := os.FindProcess(*pid)
+		if err != nil {
+			return fmt.Errorf("could not find server process %d: %w", *pid, err)
+		}
+		if err := proc.Signal(syscall.SIGTERM); err != nil {
+			return fmt.Errorf("could not signal server process %d: %w", *pid, err)
+		}
+		slog.Info("Asked server process to restart", "pid", *pid)
+	}
+	return nil
+}
+
+// fetchManifest downloads and decodes the release manifest.
+func fetchManifest(url string) (*releaseManifest, error) {
+	resp, err := updateClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch release manifest: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("release manifest: unexpected status %s", resp.Status)
+	}
+
+	var manifest releaseManifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestBytes)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("could not decode release manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// downloadRelease fetches the new binary into memory so it can be verified
+// before anything touches the disk.
+func downloadRelease(url string) ([]byte, error) {
+	resp, err := updateClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("could not download release: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("release download: unexpected status %s", resp.Status)
+	}
+	binary, err := io.ReadAll(io.LimitReader(resp.Body, maxReleaseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not download release: %w", err)
+	}
+	if len(binary) > maxReleaseBytes {
+		return nil, fmt.Errorf("release download is over %d MB, refusing it", maxReleaseBytes>>20)
+	}
+	return binary, nil
+}
+
+// releaseMessage is what a release binary's signature is over: the version,
+// the platform ("GOOS/GOARCH") and the binary's SHA-256 digest in hex, a line
+// each after a header line.
+func releaseMessage(version, platform string, digest []byte) []byte {
+	return []byte("go-trailer release\n" + version + "\n" + platform + "\n" + hex.EncodeToString(digest) + "\n")
+}
+
+// verifyRelease checks the binary against both the manifest checksum and the
+// ed25519 signature, which must be for this version and platform.
+func verifyRelease(binary []byte, version, platform string, release releaseBinary, publicKey ed25519.PublicKey) error {
+	digest := sha256.Sum256(binary)
+
+	want, err := hex.DecodeString(release.SHA256)
+	if err != nil || !bytes.Equal(want, digest[:]) {
+		return errors.New("checksum mismatch, the download is corrupt or has been tampered with")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(release.Signature)
+	if err != nil || !ed25519.Verify(publicKey, releaseMessage(version, platform, digest[:]), signature) {
+		return errors.New("signature verification failed, refusing to install")
+	}
+	return nil
+}
+
+// swapBinary replaces exe with the new binary. The new file is written next to
+// the old one and renamed straight over it, so there's always a binary at exe
+// and a crash half way never leaves a broken one behind. The old binary is
+// kept as exe+".old", a hard link made first, so it can be rolled back.
+func swapBinary(exe string, binary []byte) error {
+	dir := filepath.Dir(exe)
+	tmp, err := os.CreateTemp(dir, ".update-*")
+	if err != nil {
+		return fmt.Errorf("could not stage new binary: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write new binary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write new binary: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return fmt.Errorf("could not make new binary executable: %w", err)
+	}
+
+	backup := exe + ".old"
+	os.Remove(backup)
+	if err := os.Link(exe, backup); err != nil {
+		slog.Warn("Could not keep the old binary to roll back to", "err", err)
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return fmt.Errorf("could not install new binary: %w", err)
+	}
+	return nil
+}
+
+// newerVersion reports whether release is a newer version than running, both
+// being like v1.2.3 or v1.2.3-rc.1. A running version that isn't one, a dev
+// build say, is older than any release.
+func newerVersion(release, running string) (bool, error) {
+	r, ok := parseVersion(release)
+	if !ok {
+		return false, fmt.Errorf("release version %q isn't like v1.2.3", release)
+	}
+	cur, ok := parseVersion(running)
+	if !ok {
+		return true, nil
+	}
+	for i := range 3 {
+		if r.parts[i] != cur.parts[i] {
+			return r.parts[i] > cur.parts[i], nil
+		}
+	}
+	// A pre-release comes before the release itself
+	switch {
+	case r.pre == cur.pre:
+		return false, nil
+	case r.pre == "":
+		return true, nil
+	case cur.pre == "":
+		return false, nil
+	}
+	return r.pre > cur.pre, nil
+}
+
+// semVersion is a version split up by parseVersion.
+type semVersion struct {
+	parts [3]int // Major, minor and patch
+	pre   string // What's after the -, if it's a pre-release
+}
+
+// parseVersion reads a version like v1.2.3, v1.2.3-rc.1 or 1.2. Build
+// metadata after a + is ignored.
+func parseVersion(s string) (semVersion, bool) {
+	var v semVersion
+	s, _, _ = strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	s, v.pre, _ = strings.Cut(s, "-")
+	numbers := strings.Split(s, ".")
+	if len(numbers) > 3 {
+		return v, false
+	}
+	for i, n := range numbers {
+		part, err := strconv.Atoi(n)
+		if err != nil || part < 0 {
+			return v, false
+		}
+		v.parts[i] = part
+	}
+	return v, true
+}
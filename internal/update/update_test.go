@@ -0,0 +1,68 @@
+package update
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewerVersion(t *testing.T) {
+	for _, tt := range []struct {
+		release, running string
+		want             bool
+	}{
+		{"v1.2.0", "v1.1.9", true},
+		{"v1.10.0", "v1.9.0", true},
+		{"v1.2.0", "v1.2.0", false},
+		{"v1.1.0", "v1.2.0", false},
+		{"v1.2.0", "v1.2.0-rc.1", true},
+		{"v1.2.0-rc.1", "v1.2.0", false},
+		{"v1.2.0", "dev", true},
+	} {
+		got, err := newerVersion(tt.release, tt.running)
+		if err != nil || got != tt.want {
+			t.Errorf("newerVersion(%q, %q) = %v, %v, want %v", tt.release, tt.running, got, err, tt.want)
+		}
+	}
+	if _, err := newerVersion("latest", "v1.0.0"); err == nil {
+		t.Error("newerVersion accepted a release version that isn't one")
+	}
+}
+
+func TestVerifyReleaseIsForItsVersionAndPlatform(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	binary := []byte("new binary")
+	digest := sha256.Sum256(binary)
+	release := releaseBinary{
+		SHA256:    hex.EncodeToString(digest[:]),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, releaseMessage("v1.0.0", "linux/amd64", digest[:]))),
+	}
+	if err := verifyRelease(binary, "v1.0.0", "linux/amd64", release, publicKey); err != nil {
+		t.Fatalf("verifyRelease of a good release: %v", err)
+	}
+	if err := verifyRelease(binary, "v2.0.0", "linux/amd64", release, publicKey); err == nil {
+		t.Error("verifyRelease accepted v1.0.0's signature for v2.0.0")
+	}
+	if err := verifyRelease(binary, "v1.0.0", "darwin/arm64", release, publicKey); err == nil {
+		t.Error("verifyRelease accepted linux/amd64's signature for darwin/arm64")
+	}
+}
+
+func TestSwapBinary(t *testing.T) {
+	exe := filepath.Join(t.TempDir(), "go-trailer")
+	if err := os.WriteFile(exe, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := swapBinary(exe, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{exe: "new", exe + ".old": "old"} {
+		if got, err := os.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(path), got, err, want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Package videos is what we know about YouTube links: finding the video in
+// one, and the URLs YouTube serves a video's player and thumbnail at.
+package videos
+
+import "regexp"
+
+// Regex to find a YouTube video ID from various URL formats.
+var youtubeRegex = regexp.MustCompile(`(?:https?:\/\/)?(?:www\.)?(?:youtube\.com\/(?:watch\?v=|embed\/)|youtu\.be\/)([a-zA-Z0-9\-_]+)`)
+
+// What a video ID on its own looks like.
+var idRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Parse finds a YouTube video ID from various URL formats and returns the
+// embeddable URL and the video ID. If no URL is found, it returns empty
+// strings.
+func Parse(text string) (embedURL, id string) {
+	matches := youtubeRegex.FindStringSubmatch(text)
+
+	// matches[0] is the full matched URL, matches[1] is the video ID (the capturing group)
+	if len(matches) > 1 {
+		return EmbedURL(matches[1]), matches[1]
+	}
+	return "", ""
+}
+
+// ValidID reports whether id looks like a YouTube video ID.
+func ValidID(id string) bool {
+	return idRegex.MatchString(id)
+}
+
+// EmbedURL is where YouTube serves the player for a video.
+func EmbedURL(id string) string {
+	return "https://www.youtube.com/embed/" + id
+}
+
+// ThumbnailURL is the large thumbnail YouTube generates for every video.
+func ThumbnailURL(id string) string {
+	return "https://img.youtube.com/vi/" + id + "/hqdefault.jpg"
+}
@@ -0,0 +1,88 @@
+package main
+
+//Where the server listens: a TCP address, or a unix socket when nginx or
+//caddy on the same host sits in front. The socket file gets the configured
+//permissions so the proxy can connect, and is removed again on shutdown.
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+
+	"go-trailer/trailer"
+)
+
+// listen opens the socket the server with cfg accepts connections on. The
+// address is for the log.
+func listen(config *trailer.Config) (net.Listener, string, error) {
+	if config.Socket.Path == "" {
+		ln, err := net.Listen("tcp", config.Addr)
+		return ln, config.Addr, err
+	}
+	ln, err := listenUnix(config)
+	return ln, "unix:" + config.Socket.Path, err
+}
+
+// listenUnix listens on a unix socket. The listener removes the file when
+// it's closed, which Shutdown does.
+func listenUnix(config *trailer.Config) (net.Listener, error) {
+	settings := config.Socket
+	if err := removeStaleSocket(settings.Path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", settings.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := settings.FileMode()
+	if err == nil {
+		err = os.Chmod(settings.Path, mode)
+	}
+	if err == nil && settings.Group != "" {
+		err = chownGroup(settings.Path, settings.Group)
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes a socket file left behind by a server that
+// didn't shut down cleanly. If something is still listening on it, or it
+// isn't a socket at all, it stays and we refuse to start.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+	return os.Remove(path)
+}
+
+// chownGroup hands path to the named group, keeping its owner.
+func chownGroup(path, group string) error {
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, -1, gid)
+}
@@ -0,0 +1,64 @@
+package main
+
+//Serving HTTPS ourselves, without a reverse proxy in front. Certificates for
+//the configured domains come from Let's Encrypt (autocert), are cached on
+//disk and renewed before they expire. A second listener on port 80 answers
+//the ACME challenges and sends everyone else to the https:// address.
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"go-trailer/trailer"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// setupTLS makes server serve HTTPS with certificates from Let's Encrypt, as
+// config's tls: says, and returns the plain HTTP server answering challenges
+// and redirecting.
+func setupTLS(server *http.Server, config *trailer.Config) *http.Server {
+	settings := config.TLS
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(settings.Domains...),
+		Cache:      autocert.DirCache(settings.CacheDir),
+		Email:      settings.Email,
+	}
+	server.TLSConfig = manager.TLSConfig()
+
+	if settings.RedirectAddr == "" {
+		return nil // Certificates can still come through the TLS-ALPN challenge
+	}
+	redirect := &http.Server{
+		Addr:              settings.RedirectAddr,
+		Handler:           manager.HTTPHandler(redirectToHTTPS(server.Addr)),
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          server.ErrorLog,
+	}
+	go func() {
+		slog.Info("Starting HTTP to HTTPS redirect", "addr", settings.RedirectAddr)
+		if err := redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Redirect server failed", "err", err)
+		}
+	}()
+	return redirect
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL over HTTPS, on
+// whatever port the HTTPS listener at addr is on.
+func redirectToHTTPS(addr string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if _, port, err := net.SplitHostPort(addr); err == nil && port != "443" && port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
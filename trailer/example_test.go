@@ -0,0 +1,44 @@
+package trailer_test
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"go-trailer/trailer"
+)
+
+// A program of its own serving the site under /wiki/, next to its own routes.
+func ExampleNewServer() {
+	cfg := trailer.DefaultConfig()
+	cfg.BasePath = "/wiki"
+	cfg.PagesDir = "/var/lib/wiki/pages"
+	cfg.TemplatesDir = "/usr/share/go-trailer/templates"
+	cfg.StaticDir = "/usr/share/go-trailer/static"
+	srv, err := trailer.NewServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/wiki/", srv)
+	mux.HandleFunc("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("The wiki is at /wiki/\n"))
+	})
+	httpServer := &http.Server{Addr: ":8080", Handler: mux}
+	httpServer.RegisterOnShutdown(srv.StopStreams)
+	go httpServer.ListenAndServe()
+
+	// Until Ctrl+C
+	interrupted, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	<-interrupted.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	httpServer.Shutdown(ctx)
+	srv.Close(ctx)
+}
@@ -0,0 +1,51 @@
+// Package trailer is the go-trailer site, pages of YouTube videos voted on,
+// as an http.Handler. The go-trailer command serves it; a program of its own
+// can mount it too, see the example for NewServer.
+//
+// The site itself is in go-trailer/internal/handlers. This is the part of it
+// other programs can use.
+package trailer
+
+import (
+	"go-trailer/internal/config"
+	"go-trailer/internal/handlers"
+
+	"google.golang.org/grpc"
+)
+
+// Server is the site, ready to serve. Register its StopStreams with the
+// http.Server's RegisterOnShutdown, and Close it once that has shut down.
+type Server = handlers.Server
+
+// Config is everything that can be set in config.yaml. See
+// config.example.yaml for what there is.
+type Config = config.Config
+
+// NewServer sets the site up with cfg and starts its background jobs. Close
+// stops them again.
+func NewServer(cfg Config) (*Server, error) {
+	return handlers.NewServer(cfg)
+}
+
+// DefaultConfig is how the site runs with no config file at all.
+func DefaultConfig() Config {
+	return config.Default()
+}
+
+// LoadSettings reads the settings the way go-trailer does, from the flags
+// in args, environment variables and the config file.
+func LoadSettings(args []string) (Config, error) {
+	return config.Load(args)
+}
+
+// SetupLogging points slog at stderr in the format and level, with the ID
+// and page of the request a record was logged for added to it.
+func SetupLogging(format, level string) {
+	handlers.SetupLogging(format, level)
+}
+
+// NewGRPCServer is a gRPC server with the Pages service, which it serves by
+// calling srv.
+func NewGRPCServer(srv *Server) *grpc.Server {
+	return handlers.NewGRPCServer(srv)
+}
@@ -0,0 +1,791 @@
+// The pages and votes API over gRPC, for internal services that would
+// rather make calls than HTTP requests. It's served on grpc_addr, and does
+// what the JSON API does: the same logins (HTTP basic auth, in the
+// authorization metadata), the same errors, the same events.
+//
+// Regenerate the Go code after changing this file with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative trailerpb/trailer.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: trailerpb/trailer.proto
+
+package trailerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Page struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Slug          string                 `protobuf:"bytes,1,opt,name=slug,proto3" json:"slug,omitempty"`
+	Title         string                 `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+	Url           string                 `protobuf:"bytes,3,opt,name=url,proto3" json:"url,omitempty"`
+	Body          string                 `protobuf:"bytes,4,opt,name=body,proto3" json:"body,omitempty"` // As it was written
+	Html          string                 `protobuf:"bytes,5,opt,name=html,proto3" json:"html,omitempty"` // As the page shows it
+	Description   string                 `protobuf:"bytes,6,opt,name=description,proto3" json:"description,omitempty"`
+	Tags          []string               `protobuf:"bytes,7,rep,name=tags,proto3" json:"tags,omitempty"`
+	Author        string                 `protobuf:"bytes,8,opt,name=author,proto3" json:"author,omitempty"`
+	Created       *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=created,proto3" json:"created,omitempty"`
+	Updated       *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=updated,proto3" json:"updated,omitempty"`
+	UpdatedBy     string                 `protobuf:"bytes,11,opt,name=updated_by,json=updatedBy,proto3" json:"updated_by,omitempty"`
+	Draft         bool                   `protobuf:"varint,12,opt,name=draft,proto3" json:"draft,omitempty"`
+	Archived      bool                   `protobuf:"varint,13,opt,name=archived,proto3" json:"archived,omitempty"`
+	Videos        []*Video               `protobuf:"bytes,14,rep,name=videos,proto3" json:"videos,omitempty"` // Most voted first
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Page) Reset() {
+	*x = Page{}
+	mi := &file_trailerpb_trailer_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Page) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Page) ProtoMessage() {}
+
+func (x *Page) ProtoReflect() protoreflect.Message {
+	mi := &file_trailerpb_trailer_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Page.ProtoReflect.Descriptor instead.
+func (*Page) Descriptor() ([]byte, []int) {
+	return file_trailerpb_trailer_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Page) GetSlug() string {
+	if x != nil {
+		return x.Slug
+	}
+	return ""
+}
+
+func (x *Page) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Page) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Page) GetBody() string {
+	if x != nil {
+		return x.Body
+	}
+	return ""
+}
+
+func (x *Page) GetHtml() string {
+	if x != nil {
+		return x.Html
+	}
+	return ""
+}
+
+func (x *Page) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *Page) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Page) GetAuthor() string {
+	if x != nil {
+		return x.Author
+	}
+	return ""
+}
+
+func (x *Page) GetCreated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *Page) GetUpdated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Updated
+	}
+	return nil
+}
+
+func (x *Page) GetUpdatedBy() string {
+	if x != nil {
+		return x.UpdatedBy
+	}
+	return ""
+}
+
+func (x *Page) GetDraft() bool {
+	if x != nil {
+		return x.Draft
+	}
+	return false
+}
+
+func (x *Page) GetArchived() bool {
+	if x != nil {
+		return x.Archived
+	}
+	return false
+}
+
+func (x *Page) GetVideos() []*Video {
+	if x != nil {
+		return x.Videos
+	}
+	return nil
+}
+
+type Video struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	WatchUrl      string                 `protobuf:"bytes,2,opt,name=watch_url,json=watchUrl,proto3" json:"watch_url,omitempty"`
+	EmbedUrl      string                 `protobuf:"bytes,3,opt,name=embed_url,json=embedUrl,proto3" json:"embed_url,omitempty"`
+	Votes         int32                  `protobuf:"varint,4,opt,name=votes,proto3" json:"votes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Video) Reset() {
+	*x = Video{}
+	mi := &file_trailerpb_trailer_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Video) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Video) ProtoMessage() {}
+
+func (x *Video) ProtoReflect() protoreflect.Message {
+	mi := &file_trailerpb_trailer_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Video.ProtoReflect.Descriptor instead.
+func (*Video) Descriptor() ([]byte, []int) {
+	return file_trailerpb_trailer_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Video) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Video) GetWatchUrl() string {
+	if x != nil {
+		return x.WatchUrl
+	}
+	return ""
+}
+
+func (x *Video) GetEmbedUrl() string {
+	if x != nil {
+		return x.EmbedUrl
+	}
+	return ""
+}
+
+func (x *Video) GetVotes() int32 {
+	if x != nil {
+		return x.Votes
+	}
+	return 0
+}
+
+// PageSummary is a page as ListPages lists it.
+type PageSummary struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Slug          string                 `protobuf:"bytes,1,opt,name=slug,proto3" json:"slug,omitempty"`
+	Title         string                 `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+	Url           string                 `protobuf:"bytes,3,opt,name=url,proto3" json:"url,omitempty"`
+	Created       *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=created,proto3" json:"created,omitempty"`
+	Updated       *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=updated,proto3" json:"updated,omitempty"`
+	Tags          []string               `protobuf:"bytes,6,rep,name=tags,proto3" json:"tags,omitempty"`
+	Videos        int32                  `protobuf:"varint,7,opt,name=videos,proto3" json:"videos,omitempty"` // How many it has
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PageSummary) Reset() {
+	*x = PageSummary{}
+	mi := &file_trailerpb_trailer_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PageSummary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PageSummary) ProtoMessage() {}
+
+func (x *PageSummary) ProtoReflect() protoreflect.Message {
+	mi := &file_trailerpb_trailer_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PageSummary.ProtoReflect.Descriptor instead.
+func (*PageSummary) Descriptor() ([]byte, []int) {
+	return file_trailerpb_trailer_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PageSummary) GetSlug() string {
+	if x != nil {
+		return x.Slug
+	}
+	return ""
+}
+
+func (x *PageSummary) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *PageSummary) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *PageSummary) GetCreated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *PageSummary) GetUpdated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Updated
+	}
+	return nil
+}
+
+func (x *PageSummary) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *PageSummary) GetVideos() int32 {
+	if x != nil {
+		return x.Videos
+	}
+	return 0
+}
+
+type GetPageRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Slug          string                 `protobuf:"bytes,1,opt,name=slug,proto3" json:"slug,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPageRequest) Reset() {
+	*x = GetPageRequest{}
+	mi := &file_trailerpb_trailer_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPageRequest) ProtoMessage() {}
+
+func (x *GetPageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trailerpb_trailer_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPageRequest.ProtoReflect.Descriptor instead.
+func (*GetPageRequest) Descriptor() ([]byte, []int) {
+	return file_trailerpb_trailer_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetPageRequest) GetSlug() string {
+	if x != nil {
+		return x.Slug
+	}
+	return ""
+}
+
+type ListPagesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PageSize      int32                  `protobuf:"varint,1,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`   // 50 if unset, at most 200
+	PageToken     string                 `protobuf:"bytes,2,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"` // next_page_token from the last call, to carry on
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPagesRequest) Reset() {
+	*x = ListPagesRequest{}
+	mi := &file_trailerpb_trailer_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPagesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPagesRequest) ProtoMessage() {}
+
+func (x *ListPagesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trailerpb_trailer_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPagesRequest.ProtoReflect.Descriptor instead.
+func (*ListPagesRequest) Descriptor() ([]byte, []int) {
+	return file_trailerpb_trailer_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListPagesRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListPagesRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+type ListPagesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pages         []*PageSummary         `protobuf:"bytes,1,rep,name=pages,proto3" json:"pages,omitempty"`
+	NextPageToken string                 `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	HasMore       bool                   `protobuf:"varint,3,opt,name=has_more,json=hasMore,proto3" json:"has_more,omitempty"` // More pages right now, call again straight away
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPagesResponse) Reset() {
+	*x = ListPagesResponse{}
+	mi := &file_trailerpb_trailer_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPagesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPagesResponse) ProtoMessage() {}
+
+func (x *ListPagesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_trailerpb_trailer_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPagesResponse.ProtoReflect.Descriptor instead.
+func (*ListPagesResponse) Descriptor() ([]byte, []int) {
+	return file_trailerpb_trailer_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListPagesResponse) GetPages() []*PageSummary {
+	if x != nil {
+		return x.Pages
+	}
+	return nil
+}
+
+func (x *ListPagesResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+func (x *ListPagesResponse) GetHasMore() bool {
+	if x != nil {
+		return x.HasMore
+	}
+	return false
+}
+
+type CreatePageRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreatePageRequest) Reset() {
+	*x = CreatePageRequest{}
+	mi := &file_trailerpb_trailer_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreatePageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreatePageRequest) ProtoMessage() {}
+
+func (x *CreatePageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trailerpb_trailer_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreatePageRequest.ProtoReflect.Descriptor instead.
+func (*CreatePageRequest) Descriptor() ([]byte, []int) {
+	return file_trailerpb_trailer_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CreatePageRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type SaveVideoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Slug          string                 `protobuf:"bytes,1,opt,name=slug,proto3" json:"slug,omitempty"`
+	YoutubeUrl    string                 `protobuf:"bytes,2,opt,name=youtube_url,json=youtubeUrl,proto3" json:"youtube_url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SaveVideoRequest) Reset() {
+	*x = SaveVideoRequest{}
+	mi := &file_trailerpb_trailer_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SaveVideoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SaveVideoRequest) ProtoMessage() {}
+
+func (x *SaveVideoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trailerpb_trailer_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SaveVideoRequest.ProtoReflect.Descriptor instead.
+func (*SaveVideoRequest) Descriptor() ([]byte, []int) {
+	return file_trailerpb_trailer_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SaveVideoRequest) GetSlug() string {
+	if x != nil {
+		return x.Slug
+	}
+	return ""
+}
+
+func (x *SaveVideoRequest) GetYoutubeUrl() string {
+	if x != nil {
+		return x.YoutubeUrl
+	}
+	return ""
+}
+
+type VoteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Slug          string                 `protobuf:"bytes,1,opt,name=slug,proto3" json:"slug,omitempty"`
+	VideoId       string                 `protobuf:"bytes,2,opt,name=video_id,json=videoId,proto3" json:"video_id,omitempty"`
+	Up            bool                   `protobuf:"varint,3,opt,name=up,proto3" json:"up,omitempty"` // Up, or down if false
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VoteRequest) Reset() {
+	*x = VoteRequest{}
+	mi := &file_trailerpb_trailer_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VoteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VoteRequest) ProtoMessage() {}
+
+func (x *VoteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trailerpb_trailer_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VoteRequest.ProtoReflect.Descriptor instead.
+func (*VoteRequest) Descriptor() ([]byte, []int) {
+	return file_trailerpb_trailer_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *VoteRequest) GetSlug() string {
+	if x != nil {
+		return x.Slug
+	}
+	return ""
+}
+
+func (x *VoteRequest) GetVideoId() string {
+	if x != nil {
+		return x.VideoId
+	}
+	return ""
+}
+
+func (x *VoteRequest) GetUp() bool {
+	if x != nil {
+		return x.Up
+	}
+	return false
+}
+
+var File_trailerpb_trailer_proto protoreflect.FileDescriptor
+
+const file_trailerpb_trailer_proto_rawDesc = "" +
+	"\n" +
+	"\x17trailerpb/trailer.proto\x12\n" +
+	"trailer.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xa0\x03\n" +
+	"\x04Page\x12\x12\n" +
+	"\x04slug\x18\x01 \x01(\tR\x04slug\x12\x14\n" +
+	"\x05title\x18\x02 \x01(\tR\x05title\x12\x10\n" +
+	"\x03url\x18\x03 \x01(\tR\x03url\x12\x12\n" +
+	"\x04body\x18\x04 \x01(\tR\x04body\x12\x12\n" +
+	"\x04html\x18\x05 \x01(\tR\x04html\x12 \n" +
+	"\vdescription\x18\x06 \x01(\tR\vdescription\x12\x12\n" +
+	"\x04tags\x18\a \x03(\tR\x04tags\x12\x16\n" +
+	"\x06author\x18\b \x01(\tR\x06author\x124\n" +
+	"\acreated\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\acreated\x124\n" +
+	"\aupdated\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\aupdated\x12\x1d\n" +
+	"\n" +
+	"updated_by\x18\v \x01(\tR\tupdatedBy\x12\x14\n" +
+	"\x05draft\x18\f \x01(\bR\x05draft\x12\x1a\n" +
+	"\barchived\x18\r \x01(\bR\barchived\x12)\n" +
+	"\x06videos\x18\x0e \x03(\v2\x11.trailer.v1.VideoR\x06videos\"g\n" +
+	"\x05Video\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
+	"\twatch_url\x18\x02 \x01(\tR\bwatchUrl\x12\x1b\n" +
+	"\tembed_url\x18\x03 \x01(\tR\bembedUrl\x12\x14\n" +
+	"\x05votes\x18\x04 \x01(\x05R\x05votes\"\xe1\x01\n" +
+	"\vPageSummary\x12\x12\n" +
+	"\x04slug\x18\x01 \x01(\tR\x04slug\x12\x14\n" +
+	"\x05title\x18\x02 \x01(\tR\x05title\x12\x10\n" +
+	"\x03url\x18\x03 \x01(\tR\x03url\x124\n" +
+	"\acreated\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\acreated\x124\n" +
+	"\aupdated\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\aupdated\x12\x12\n" +
+	"\x04tags\x18\x06 \x03(\tR\x04tags\x12\x16\n" +
+	"\x06videos\x18\a \x01(\x05R\x06videos\"$\n" +
+	"\x0eGetPageRequest\x12\x12\n" +
+	"\x04slug\x18\x01 \x01(\tR\x04slug\"N\n" +
+	"\x10ListPagesRequest\x12\x1b\n" +
+	"\tpage_size\x18\x01 \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x02 \x01(\tR\tpageToken\"\x85\x01\n" +
+	"\x11ListPagesResponse\x12-\n" +
+	"\x05pages\x18\x01 \x03(\v2\x17.trailer.v1.PageSummaryR\x05pages\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\x12\x19\n" +
+	"\bhas_more\x18\x03 \x01(\bR\ahasMore\"'\n" +
+	"\x11CreatePageRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\"G\n" +
+	"\x10SaveVideoRequest\x12\x12\n" +
+	"\x04slug\x18\x01 \x01(\tR\x04slug\x12\x1f\n" +
+	"\vyoutube_url\x18\x02 \x01(\tR\n" +
+	"youtubeUrl\"L\n" +
+	"\vVoteRequest\x12\x12\n" +
+	"\x04slug\x18\x01 \x01(\tR\x04slug\x12\x19\n" +
+	"\bvideo_id\x18\x02 \x01(\tR\avideoId\x12\x0e\n" +
+	"\x02up\x18\x03 \x01(\bR\x02up2\xbb\x02\n" +
+	"\x05Pages\x127\n" +
+	"\aGetPage\x12\x1a.trailer.v1.GetPageRequest\x1a\x10.trailer.v1.Page\x12H\n" +
+	"\tListPages\x12\x1c.trailer.v1.ListPagesRequest\x1a\x1d.trailer.v1.ListPagesResponse\x12=\n" +
+	"\n" +
+	"CreatePage\x12\x1d.trailer.v1.CreatePageRequest\x1a\x10.trailer.v1.Page\x12<\n" +
+	"\tSaveVideo\x12\x1c.trailer.v1.SaveVideoRequest\x1a\x11.trailer.v1.Video\x122\n" +
+	"\x04Vote\x12\x17.trailer.v1.VoteRequest\x1a\x11.trailer.v1.VideoB\x16Z\x14go-trailer/trailerpbb\x06proto3"
+
+var (
+	file_trailerpb_trailer_proto_rawDescOnce sync.Once
+	file_trailerpb_trailer_proto_rawDescData []byte
+)
+
+func file_trailerpb_trailer_proto_rawDescGZIP() []byte {
+	file_trailerpb_trailer_proto_rawDescOnce.Do(func() {
+		file_trailerpb_trailer_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_trailerpb_trailer_proto_rawDesc), len(file_trailerpb_trailer_proto_rawDesc)))
+	})
+	return file_trailerpb_trailer_proto_rawDescData
+}
+
+var file_trailerpb_trailer_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_trailerpb_trailer_proto_goTypes = []any{
+	(*Page)(nil),                  // 0: trailer.v1.Page
+	(*Video)(nil),                 // 1: trailer.v1.Video
+	(*PageSummary)(nil),           // 2: trailer.v1.PageSummary
+	(*GetPageRequest)(nil),        // 3: trailer.v1.GetPageRequest
+	(*ListPagesRequest)(nil),      // 4: trailer.v1.ListPagesRequest
+	(*ListPagesResponse)(nil),     // 5: trailer.v1.ListPagesResponse
+	(*CreatePageRequest)(nil),     // 6: trailer.v1.CreatePageRequest
+	(*SaveVideoRequest)(nil),      // 7: trailer.v1.SaveVideoRequest
+	(*VoteRequest)(nil),           // 8: trailer.v1.VoteRequest
+	(*timestamppb.Timestamp)(nil), // 9: google.protobuf.Timestamp
+}
+var file_trailerpb_trailer_proto_depIdxs = []int32{
+	9,  // 0: trailer.v1.Page.created:type_name -> google.protobuf.Timestamp
+	9,  // 1: trailer.v1.Page.updated:type_name -> google.protobuf.Timestamp
+	1,  // 2: trailer.v1.Page.videos:type_name -> trailer.v1.Video
+	9,  // 3: trailer.v1.PageSummary.created:type_name -> google.protobuf.Timestamp
+	9,  // 4: trailer.v1.PageSummary.updated:type_name -> google.protobuf.Timestamp
+	2,  // 5: trailer.v1.ListPagesResponse.pages:type_name -> trailer.v1.PageSummary
+	3,  // 6: trailer.v1.Pages.GetPage:input_type -> trailer.v1.GetPageRequest
+	4,  // 7: trailer.v1.Pages.ListPages:input_type -> trailer.v1.ListPagesRequest
+	6,  // 8: trailer.v1.Pages.CreatePage:input_type -> trailer.v1.CreatePageRequest
+	7,  // 9: trailer.v1.Pages.SaveVideo:input_type -> trailer.v1.SaveVideoRequest
+	8,  // 10: trailer.v1.Pages.Vote:input_type -> trailer.v1.VoteRequest
+	0,  // 11: trailer.v1.Pages.GetPage:output_type -> trailer.v1.Page
+	5,  // 12: trailer.v1.Pages.ListPages:output_type -> trailer.v1.ListPagesResponse
+	0,  // 13: trailer.v1.Pages.CreatePage:output_type -> trailer.v1.Page
+	1,  // 14: trailer.v1.Pages.SaveVideo:output_type -> trailer.v1.Video
+	1,  // 15: trailer.v1.Pages.Vote:output_type -> trailer.v1.Video
+	11, // [11:16] is the sub-list for method output_type
+	6,  // [6:11] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_trailerpb_trailer_proto_init() }
+func file_trailerpb_trailer_proto_init() {
+	if File_trailerpb_trailer_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_trailerpb_trailer_proto_rawDesc), len(file_trailerpb_trailer_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_trailerpb_trailer_proto_goTypes,
+		DependencyIndexes: file_trailerpb_trailer_proto_depIdxs,
+		MessageInfos:      file_trailerpb_trailer_proto_msgTypes,
+	}.Build()
+	File_trailerpb_trailer_proto = out.File
+	file_trailerpb_trailer_proto_goTypes = nil
+	file_trailerpb_trailer_proto_depIdxs = nil
+}